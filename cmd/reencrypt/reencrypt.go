@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sbasestarter/user/internal/user/controller"
+	"github.com/sbasestarter/user/internal/user/controller/keyring"
+	"github.com/sbasestarter/user/internal/user/helper"
+	"github.com/sbasestarter/user/internal/user/model"
+	"github.com/sgostarter/i/l"
+	"github.com/sgostarter/liblogrus"
+	"github.com/sgostarter/libservicetoolset/dbtoolset"
+)
+
+// reencrypt seals the stored 2fa secrets with SecretEncryption.ActiveKeyID. To rotate the master key, add
+// the new key, make it active, restart the service, run this, and then remove the old key
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only count the secrets to reencrypt")
+	flag.Parse()
+
+	loggerChain := l.NewLoggerChain()
+	loggerChain.AppendLogger(liblogrus.NewLogrus())
+
+	logger := l.NewWrapper(loggerChain)
+
+	cfg := config.Get()
+	cfg.DbToolset = dbtoolset.NewToolset(&cfg.DbConfig, logger)
+
+	secrets, err := keyring.New(&cfg.SecretEncryption)
+	if err != nil {
+		logger.Fatalf("load secret keys failed: %v", err)
+
+		return
+	}
+
+	m := model.NewModel(cfg.DbToolset.GetXOrm(), helper.NewUtilsImpl())
+
+	resealed, err := controller.ResealUser2FaKeys(m, secrets, logger, *dryRun)
+	if err != nil {
+		logger.Fatalf("reencrypt failed after %v secrets: %v", resealed, err)
+
+		return
+	}
+
+	logger.Infof("%v secrets reencrypted with key %v, dry run: %v", resealed, cfg.SecretEncryption.ActiveKeyID, *dryRun)
+}
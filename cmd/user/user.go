@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
@@ -40,11 +41,47 @@ func main() {
 
 	serviceToolset := servicetoolset.NewServerToolset(context.Background(), logger)
 
+	userServer := server.NewUserServer(context.Background(), cfg, logger)
+
 	_ = serviceToolset.CreateGRpcServer(&cfg.GRpcServerConfig, nil, func(s *grpc.Server) error {
-		userpb.RegisterUserServiceServer(s, server.NewUserServer(context.Background(), cfg, logger))
+		userpb.RegisterUserServiceServer(s, userServer)
 
 		return nil
 	})
 
+	if cfg.OIDC.Enable {
+		go func() {
+			logger.Infof("oidc provider listens on %v", cfg.OIDC.Listen)
+
+			httpServer := &http.Server{
+				Addr:              cfg.OIDC.Listen,
+				Handler:           userServer.OIDCHandler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			err := httpServer.ListenAndServe()
+			if err != nil {
+				logger.Fatalf("oidc provider failed: %v", err)
+			}
+		}()
+	}
+
+	if cfg.SAML.Enable {
+		go func() {
+			logger.Infof("saml identity provider listens on %v", cfg.SAML.Listen)
+
+			httpServer := &http.Server{
+				Addr:              cfg.SAML.Listen,
+				Handler:           userServer.SAMLHandler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			err := httpServer.ListenAndServe()
+			if err != nil {
+				logger.Fatalf("saml identity provider failed: %v", err)
+			}
+		}()
+	}
+
 	serviceToolset.Wait()
 }
@@ -3,12 +3,19 @@ module github.com/sbasestarter/user
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.22.0
+	github.com/beevik/etree v1.1.0
 	github.com/caixw/lib.go v0.0.0-20141220110639-1781da9139e0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/go-asn1-ber/asn1-ber v1.5.4
+	github.com/go-ldap/ldap/v3 v3.4.4
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/issue9/identicon v1.0.1
+	github.com/mattn/go-sqlite3 v1.14.9
+	github.com/russellhaering/goxmldsig v1.4.0
 	github.com/satori/go.uuid v1.2.0
 	github.com/sbasestarter/db-orm v0.0.0-20220714065752-c3a7a5a5d4b4
 	github.com/sbasestarter/proto-repo v0.0.8
@@ -20,7 +27,7 @@ require (
 	github.com/sgostarter/libservicetoolset v0.0.24
 	github.com/ttacon/builder v0.0.0-20170518171403-c099f663e1c2 // indirect
 	github.com/ttacon/libphonenumber v1.1.0
-	golang.org/x/crypto v0.0.0-20220321153916-2c7772ba3064
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
 	google.golang.org/grpc v1.47.0
 	xorm.io/xorm v1.3.1
 )
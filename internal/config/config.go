@@ -16,14 +16,26 @@ type Config struct {
 	GRpcClientConfigTpl         clienttoolset.GRPCClientConfig  `yaml:"grpc_client_config_tpl" json:"grpc_client_config_tpl"`
 	DbConfig                    dbtoolset.Config                `yaml:"db_config"`
 	GoogleAuthenticator         googleAuthenticatorOption       `yaml:"google_authenticator" json:"google_authenticator"`
+	WebAuthn                    WebAuthnConfig                  `yaml:"web_authn" json:"web_authn"`
+	SecretEncryption            SecretEncryptionConfig          `yaml:"secret_encryption" json:"secret_encryption"`
 	DefaultUserAvatar           string                          `yaml:"default_user_avatar" json:"default_user_avatar"`
 	PwdSecret                   string                          `yaml:"pwd_secret" json:"pwd_secret"`
+	PasswordHash                PasswordHashConfig              `yaml:"password_hash" json:"password_hash"`
+	PasswordPolicy              PasswordPolicyConfig            `yaml:"password_policy" json:"password_policy"`
+	PasswordHistory             PasswordHistoryConfig           `yaml:"password_history" json:"password_history"`
+	LoginLockout                LoginLockoutConfig              `yaml:"login_lockout" json:"login_lockout"`
+	SessionRevocation           SessionRevocationConfig         `yaml:"session_revocation" json:"session_revocation"`
 	Token                       tokenConfig                     `yaml:"token" json:"token"`
+	PersonalAccessToken         PersonalAccessTokenConfig       `yaml:"personal_access_token" json:"personal_access_token"`
+	OIDC                        OIDCConfig                      `yaml:"oidc" json:"oidc"`
+	SocialLogins                []SocialLoginConfig             `yaml:"social_logins" json:"social_logins"`
+	WxMinA                      WxMinAConfig                    `yaml:"wx_mina" json:"wx_mina"`
+	LDAP                        LDAPConfig                      `yaml:"ldap" json:"ldap"`
+	SAML                        SAMLConfig                      `yaml:"saml" json:"saml"`
 	DummyVerifyCode             string                          `yaml:"dummy_verify_code" json:"dummy_verify_code"`
 	EmailConfig                 VEConfig                        `yaml:"email_config" json:"email_config"`
 	PhoneConfig                 VEConfig                        `yaml:"phone_config" json:"phone_config"`
 	CsrfExpire                  time.Duration                   `yaml:"csrf_expire" json:"csrf_expire"`
-	WhiteListTokens             []string                        `yaml:"white_list_tokens" json:"white_list_tokens"`
 	WhiteListSSOJumpDomain      []string                        `yaml:"white_list_sso_jump_domain" json:"white_list_sso_jump_domain"`
 	WhiteListSSOJumpDomainMap   map[string]interface{}          `yaml:"-" ignored:"true"`
 	WhiteListSSOJumpDomainMatch []string                        `yaml:"-" ignored:"true"`
@@ -36,6 +48,7 @@ type Config struct {
 type VEConfig struct {
 	SendDelayDuration  time.Duration `yaml:"send_delay_duration"`
 	ValidDelayDuration time.Duration `yaml:"valid_delay_duration"`
+	MaxAttempts        int           `yaml:"max_attempts"`
 }
 
 type UserAuthentication struct {
@@ -45,11 +58,210 @@ type UserAuthentication struct {
 	SendLock         time.Duration `yaml:"send_lock"`
 }
 
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+type PasswordHashConfig struct {
+	Algorithm     string `yaml:"algorithm"`
+	Argon2Time    uint32 `yaml:"argon2_time"`
+	Argon2Memory  uint32 `yaml:"argon2_memory"`
+	Argon2Threads uint8  `yaml:"argon2_threads"`
+	Argon2KeyLen  uint32 `yaml:"argon2_key_len"`
+	Argon2SaltLen uint32 `yaml:"argon2_salt_len"`
+	BcryptCost    int    `yaml:"bcrypt_cost"`
+}
+
+type PasswordPolicyConfig struct {
+	MinLength         int    `yaml:"min_length"`
+	MaxLength         int    `yaml:"max_length"`
+	RequireLower      bool   `yaml:"require_lower"`
+	RequireUpper      bool   `yaml:"require_upper"`
+	RequireDigit      bool   `yaml:"require_digit"`
+	RequireSymbol     bool   `yaml:"require_symbol"`
+	MinCharClasses    int    `yaml:"min_char_classes"`
+	MaxRepeat         int    `yaml:"max_repeat"`
+	RejectUserInfo    bool   `yaml:"reject_user_info"`
+	BreachedListFile  string `yaml:"breached_list_file"`
+	UserInfoMinLength int    `yaml:"user_info_min_length"`
+}
+
+type PasswordHistoryConfig struct {
+	Count     int           `yaml:"count"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+type LoginLockoutConfig struct {
+	Enable          bool          `yaml:"enable"`
+	UserMaxFailures int64         `yaml:"user_max_failures"`
+	IPMaxFailures   int64         `yaml:"ip_max_failures"`
+	FailureWindow   time.Duration `yaml:"failure_window"`
+	BaseLock        time.Duration `yaml:"base_lock"`
+	MaxLock         time.Duration `yaml:"max_lock"`
+}
+
+// SessionRevocationConfig controls killing the sessions of a user after its password is changed or reset,
+// or its 2fa is disabled
+type SessionRevocationConfig struct {
+	Enable             bool `yaml:"enable"`
+	KeepCurrentSession bool `yaml:"keep_current_session"`
+}
+
+// PersonalAccessTokenConfig a user can have MaxCount active tokens, each lives MaxLifetime at most
+type PersonalAccessTokenConfig struct {
+	MaxCount    int           `yaml:"max_count"`
+	MaxLifetime time.Duration `yaml:"max_lifetime"`
+}
+
+// OIDCConfig serves an OpenID Connect provider over http on Listen. Issuer is the public url of it, which
+// needs asymmetric token signing keys. Users without a session are sent to LoginURL with the url to go
+// back to in return_to. An authorization code lives CodeExpire, an id token lives IDTokenExpire
+type OIDCConfig struct {
+	Enable        bool               `yaml:"enable"`
+	Listen        string             `yaml:"listen"`
+	Issuer        string             `yaml:"issuer"`
+	LoginURL      string             `yaml:"login_url"`
+	CodeExpire    time.Duration      `yaml:"code_expire"`
+	IDTokenExpire time.Duration      `yaml:"id_token_expire"`
+	Clients       []OIDCClientConfig `yaml:"clients"`
+}
+
+// OIDCClientConfig is a registered client, redirect uris must match exactly. A client without
+// ClientSecret is a public one
+type OIDCClientConfig struct {
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURIs []string `yaml:"redirect_uris"`
+}
+
+// SAMLConfig serves a SAML 2.0 identity provider over http on Listen. BaseURL is the public url of it, and
+// EntityID defaults to the url of the metadata. Assertions are signed by the PEM RSA key of KeyFile, which
+// CertFile certifies. Users without a session are sent to LoginURL with the url to go back to in return_to.
+// An assertion is valid for AssertionExpire, the session of a service provider lasts SessionExpire
+type SAMLConfig struct {
+	Enable           bool                        `yaml:"enable"`
+	Listen           string                      `yaml:"listen"`
+	BaseURL          string                      `yaml:"base_url"`
+	EntityID         string                      `yaml:"entity_id"`
+	KeyFile          string                      `yaml:"key_file"`
+	CertFile         string                      `yaml:"cert_file"`
+	LoginURL         string                      `yaml:"login_url"`
+	AssertionExpire  time.Duration               `yaml:"assertion_expire"`
+	SessionExpire    time.Duration               `yaml:"session_expire"`
+	ServiceProviders []SAMLServiceProviderConfig `yaml:"service_providers"`
+}
+
+const (
+	SAMLNameIDUserID = "user_id"
+	SAMLNameIDEmail  = "email"
+)
+
+// SAMLServiceProviderConfig is a registered service provider, ACS urls must match exactly and the first one
+// is the default. NameID is user_id or email
+type SAMLServiceProviderConfig struct {
+	EntityID string   `yaml:"entity_id"`
+	ACSURLs  []string `yaml:"acs_urls"`
+	NameID   string   `yaml:"name_id"`
+}
+
+const (
+	SocialLoginOIDC   = "oidc"
+	SocialLoginOAuth2 = "oauth2"
+	SocialLoginGitHub = "github"
+	SocialLoginGoogle = "google"
+	SocialLoginGitLab = "gitlab"
+)
+
+// SocialLoginConfig signs users in with an OAuth2 or OpenID Connect provider, Name is the user ve of its
+// users. An oidc provider finds TokenURL and UserInfoURL by the discovery of Issuer if they're empty, an
+// oauth2 one needs both. The user info fields SubjectField, NickNameField and AvatarField default to the
+// standard claims. github, google and gitlab are presets of Type
+type SocialLoginConfig struct {
+	Name          string `yaml:"name"`
+	Type          string `yaml:"type"`
+	Issuer        string `yaml:"issuer"`
+	TokenURL      string `yaml:"token_url"`
+	UserInfoURL   string `yaml:"user_info_url"`
+	ClientID      string `yaml:"client_id"`
+	ClientSecret  string `yaml:"client_secret"`
+	RedirectURL   string `yaml:"redirect_url"`
+	SubjectField  string `yaml:"subject_field"`
+	NickNameField string `yaml:"nick_name_field"`
+	AvatarField   string `yaml:"avatar_field"`
+}
+
+const (
+	WxMinAIdentityUnionID = "unionid"
+	WxMinAIdentityOpenID  = "openid"
+)
+
+// WxMinAConfig signs users of the WeChat mini program AppID in, BaseURL is the one of the WeChat api.
+// IdentityField is unionid or openid, the user name of them. The unionid is the same in all the apps of
+// an open platform account, users without it are refused. The openid is of this mini program only
+type WxMinAConfig struct {
+	Enable        bool   `yaml:"enable"`
+	AppID         string `yaml:"app_id"`
+	AppSecret     string `yaml:"app_secret"`
+	BaseURL       string `yaml:"base_url"`
+	IdentityField string `yaml:"identity_field"`
+}
+
+// LDAPConfig signs staff in with the password of the directory at URL, Name is the user ve of them.
+// A user binds as BindDNTemplate with the user name, or as the entry found by UserFilter under BaseDN with
+// BindDN first. Users are provisioned on the first login, and their nickname, email and admin privilege
+// are synced on every login. A member of one of AdminGroups is an admin, privileges aren't touched if
+// it's empty
+type LDAPConfig struct {
+	Enable            bool          `yaml:"enable"`
+	Name              string        `yaml:"name"`
+	URL               string        `yaml:"url"`
+	StartTLS          bool          `yaml:"start_tls"`
+	Timeout           time.Duration `yaml:"timeout"`
+	BindDN            string        `yaml:"bind_dn"`
+	BindPassword      string        `yaml:"bind_password"`
+	BaseDN            string        `yaml:"base_dn"`
+	BindDNTemplate    string        `yaml:"bind_dn_template"`
+	UserFilter        string        `yaml:"user_filter"`
+	NickNameAttribute string        `yaml:"nick_name_attribute"`
+	EmailAttribute    string        `yaml:"email_attribute"`
+	GroupAttribute    string        `yaml:"group_attribute"`
+	AdminGroups       []string      `yaml:"admin_groups"`
+}
+
+// tokenConfig: a session ends once it's not used for IdleTimeout, or MaxLifetime after it's signed in.
+// Expire is the default of both. AccessExpire is the lifetime of an access token, SSOExpire is the one
+// of a sso token.
+// Tokens carry Issuer and Audiences, a token is accepted only if its iss is Issuer and its aud has one of
+// Audiences. ClockSkew is allowed on exp, nbf and iat.
+// Tokens are signed by SigningKeyID of SigningKeys, or by HS256 with Secret if it's empty. All the keys
+// verify tokens, so a key can be kept for a while after it's rotated out.
+// In Stateless mode access tokens carry the session and are verified without redis, sessions killed
+// before their access tokens expire are checked in a revocation list reloaded every RevocationRefresh
 type tokenConfig struct {
-	Secret    string        `yaml:"secret"`
-	Domain    string        `yaml:"domain"`
-	Expire    time.Duration `yaml:"expire"`
-	SSOExpire time.Duration `yaml:"sso_expire"`
+	Secret            string                  `yaml:"secret"`
+	Domain            string                  `yaml:"domain"`
+	Expire            time.Duration           `yaml:"expire"`
+	IdleTimeout       time.Duration           `yaml:"idle_timeout"`
+	MaxLifetime       time.Duration           `yaml:"max_lifetime"`
+	AccessExpire      time.Duration           `yaml:"access_expire"`
+	SSOExpire         time.Duration           `yaml:"sso_expire"`
+	Issuer            string                  `yaml:"issuer"`
+	Audiences         []string                `yaml:"audiences"`
+	ClockSkew         time.Duration           `yaml:"clock_skew"`
+	SigningKeyID      string                  `yaml:"signing_key_id"`
+	SigningKeys       []TokenSigningKeyConfig `yaml:"signing_keys"`
+	Stateless         bool                    `yaml:"stateless"`
+	RevocationRefresh time.Duration           `yaml:"revocation_refresh"`
+	IPBinding         IPBindingConfig         `yaml:"ip_binding"`
+}
+
+// TokenSigningKeyConfig Algorithm is one of RS256, ES256 and EdDSA. KeyFile is a PEM private key, or a PEM
+// public key for a key which only verifies
+type TokenSigningKeyConfig struct {
+	KeyID     string `yaml:"key_id"`
+	Algorithm string `yaml:"algorithm"`
+	KeyFile   string `yaml:"key_file"`
 }
 
 type googleAuthenticatorOption struct {
@@ -58,6 +270,54 @@ type googleAuthenticatorOption struct {
 	Issuer      string        `yaml:"issuer"`
 	KeyExpire   time.Duration `yaml:"key_expire"`
 	TokenExpire time.Duration `yaml:"token_expire"`
+	Digits      int           `yaml:"digits"`
+	Period      time.Duration `yaml:"period"`
+	Algorithm   string        `yaml:"algorithm"`
+	Skew        int           `yaml:"skew"`
+
+	RecoveryCodeCount int `yaml:"recovery_code_count"`
+}
+
+type WebAuthnConfig struct {
+	Enable  bool          `yaml:"enable"`
+	RPID    string        `yaml:"rp_id"`
+	RPName  string        `yaml:"rp_name"`
+	Origins []string      `yaml:"origins"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// SecretEncryptionConfig holds the master keys for 2FA secrets, Keys and KeyFile map key id to a
+// base64 encoded 32 bytes key. Keys other than the active one are only used to decrypt
+type SecretEncryptionConfig struct {
+	ActiveKeyID string            `yaml:"active_key_id"`
+	Keys        map[string]string `yaml:"keys"`
+	KeyFile     string            `yaml:"key_file"`
+}
+
+const (
+	IPBindingOff    = "off"
+	IPBindingSubnet = "subnet"
+	IPBindingASN    = "asn"
+	IPBindingStrict = "strict"
+)
+
+// IPBindingRule Mode is one of off, subnet, asn and strict. A token used from an ip out of the rule is
+// rejected, or only logged if AuditOnly
+type IPBindingRule struct {
+	Mode      string `yaml:"mode"`
+	AuditOnly bool   `yaml:"audit_only"`
+}
+
+// IPBindingConfig binds a session to the ip it's signed in from. Clients overrides the rule by the client
+// type a session is signed in with. Subnet mode compares the IPv4Prefix or IPv6Prefix bits, asn mode
+// accepts the same subnet or the same AS in ASNDatabaseFile, a tsv of "range start, range end, AS number"
+type IPBindingConfig struct {
+	Mode            string                   `yaml:"mode"`
+	AuditOnly       bool                     `yaml:"audit_only"`
+	Clients         map[string]IPBindingRule `yaml:"clients"`
+	IPv4Prefix      int                      `yaml:"ipv4_prefix"`
+	IPv6Prefix      int                      `yaml:"ipv6_prefix"`
+	ASNDatabaseFile string                   `yaml:"asn_database_file"`
 }
 
 func (cfg *Config) fixConfig() {
@@ -89,6 +349,14 @@ func (cfg *Config) fixConfig() {
 		cfg.PhoneConfig.ValidDelayDuration = time.Minute
 	}
 
+	if cfg.EmailConfig.MaxAttempts <= 0 {
+		cfg.EmailConfig.MaxAttempts = 5
+	}
+
+	if cfg.PhoneConfig.MaxAttempts <= 0 {
+		cfg.PhoneConfig.MaxAttempts = 5
+	}
+
 	cfg.WhiteListSSOJumpDomainMap = make(map[string]interface{})
 
 	for _, s := range cfg.WhiteListSSOJumpDomain {
@@ -106,6 +374,287 @@ func (cfg *Config) fixConfig() {
 	if cfg.GoogleAuthenticator.TokenExpire <= 0 {
 		cfg.GoogleAuthenticator.TokenExpire = 5 * time.Minute
 	}
+
+	if cfg.GoogleAuthenticator.Digits <= 0 {
+		cfg.GoogleAuthenticator.Digits = 6
+	}
+
+	if cfg.GoogleAuthenticator.Period <= 0 {
+		cfg.GoogleAuthenticator.Period = 30 * time.Second
+	}
+
+	if cfg.GoogleAuthenticator.Algorithm == "" {
+		cfg.GoogleAuthenticator.Algorithm = "SHA1"
+	}
+
+	if cfg.GoogleAuthenticator.Skew < 0 {
+		cfg.GoogleAuthenticator.Skew = 0
+	}
+
+	if cfg.GoogleAuthenticator.RecoveryCodeCount <= 0 {
+		cfg.GoogleAuthenticator.RecoveryCodeCount = 10
+	}
+
+	if cfg.WebAuthn.RPName == "" {
+		cfg.WebAuthn.RPName = cfg.WebAuthn.RPID
+	}
+
+	if cfg.WebAuthn.Timeout <= 0 {
+		cfg.WebAuthn.Timeout = 5 * time.Minute
+	}
+
+	if cfg.Token.AccessExpire <= 0 {
+		cfg.Token.AccessExpire = 15 * time.Minute
+	}
+
+	if cfg.Token.Expire < cfg.Token.AccessExpire {
+		cfg.Token.Expire = cfg.Token.AccessExpire
+	}
+
+	if cfg.Token.IdleTimeout <= 0 {
+		cfg.Token.IdleTimeout = cfg.Token.Expire
+	}
+
+	if cfg.Token.MaxLifetime <= 0 {
+		cfg.Token.MaxLifetime = cfg.Token.Expire
+	}
+
+	if cfg.Token.IdleTimeout > cfg.Token.MaxLifetime {
+		cfg.Token.IdleTimeout = cfg.Token.MaxLifetime
+	}
+
+	if cfg.Token.SSOExpire <= 0 {
+		cfg.Token.SSOExpire = time.Minute
+	}
+
+	if cfg.Token.ClockSkew < 0 {
+		cfg.Token.ClockSkew = 0
+	}
+
+	if cfg.Token.RevocationRefresh <= 0 {
+		cfg.Token.RevocationRefresh = 10 * time.Second
+	}
+
+	if cfg.Token.IPBinding.Mode == "" {
+		cfg.Token.IPBinding.Mode = IPBindingStrict
+	}
+
+	if cfg.Token.IPBinding.IPv4Prefix <= 0 || cfg.Token.IPBinding.IPv4Prefix > 32 {
+		cfg.Token.IPBinding.IPv4Prefix = 24
+	}
+
+	if cfg.Token.IPBinding.IPv6Prefix <= 0 || cfg.Token.IPBinding.IPv6Prefix > 128 {
+		cfg.Token.IPBinding.IPv6Prefix = 64
+	}
+
+	if cfg.PersonalAccessToken.MaxCount <= 0 {
+		cfg.PersonalAccessToken.MaxCount = 20
+	}
+
+	if cfg.PersonalAccessToken.MaxLifetime <= 0 {
+		cfg.PersonalAccessToken.MaxLifetime = 365 * 24 * time.Hour
+	}
+
+	cfg.OIDC.Issuer = strings.TrimSuffix(cfg.OIDC.Issuer, "/")
+
+	if cfg.OIDC.Listen == "" {
+		cfg.OIDC.Listen = ":8089"
+	}
+
+	if cfg.OIDC.CodeExpire <= 0 {
+		cfg.OIDC.CodeExpire = time.Minute
+	}
+
+	if cfg.OIDC.IDTokenExpire <= 0 {
+		cfg.OIDC.IDTokenExpire = time.Hour
+	}
+
+	cfg.fixSocialLoginConfig()
+
+	cfg.WxMinA.BaseURL = strings.TrimSuffix(cfg.WxMinA.BaseURL, "/")
+
+	cfg.fixLDAPConfig()
+
+	cfg.fixSAMLConfig()
+
+	if cfg.WxMinA.BaseURL == "" {
+		cfg.WxMinA.BaseURL = "https://api.weixin.qq.com"
+	}
+
+	if cfg.WxMinA.IdentityField == "" {
+		cfg.WxMinA.IdentityField = WxMinAIdentityUnionID
+	}
+
+	cfg.fixPasswordHashConfig()
+
+	if cfg.PasswordPolicy.MinLength <= 0 {
+		cfg.PasswordPolicy.MinLength = 8
+	}
+
+	if cfg.PasswordPolicy.MaxLength <= 0 {
+		cfg.PasswordPolicy.MaxLength = 128
+	}
+
+	if cfg.PasswordPolicy.UserInfoMinLength <= 0 {
+		cfg.PasswordPolicy.UserInfoMinLength = 3
+	}
+
+	cfg.fixLoginLockoutConfig()
+}
+
+func (cfg *Config) fixSocialLoginConfig() {
+	for idx := range cfg.SocialLogins {
+		social := &cfg.SocialLogins[idx]
+
+		switch social.Type {
+		case SocialLoginGitHub:
+			social.Type = SocialLoginOAuth2
+
+			if social.TokenURL == "" {
+				social.TokenURL = "https://github.com/login/oauth/access_token"
+			}
+
+			if social.UserInfoURL == "" {
+				social.UserInfoURL = "https://api.github.com/user"
+			}
+
+			if social.SubjectField == "" {
+				social.SubjectField = "id"
+			}
+
+			if social.NickNameField == "" {
+				social.NickNameField = "login"
+			}
+
+			if social.AvatarField == "" {
+				social.AvatarField = "avatar_url"
+			}
+		case SocialLoginGoogle:
+			social.Type = SocialLoginOIDC
+
+			if social.Issuer == "" {
+				social.Issuer = "https://accounts.google.com"
+			}
+		case SocialLoginGitLab:
+			social.Type = SocialLoginOIDC
+
+			if social.Issuer == "" {
+				social.Issuer = "https://gitlab.com"
+			}
+		}
+
+		if social.SubjectField == "" {
+			social.SubjectField = "sub"
+		}
+
+		if social.NickNameField == "" {
+			social.NickNameField = "name"
+		}
+
+		if social.AvatarField == "" {
+			social.AvatarField = "picture"
+		}
+	}
+}
+
+func (cfg *Config) fixLDAPConfig() {
+	if cfg.LDAP.Name == "" {
+		cfg.LDAP.Name = "ldap"
+	}
+
+	if cfg.LDAP.Timeout <= 0 {
+		cfg.LDAP.Timeout = 10 * time.Second
+	}
+
+	if cfg.LDAP.NickNameAttribute == "" {
+		cfg.LDAP.NickNameAttribute = "displayName"
+	}
+
+	if cfg.LDAP.EmailAttribute == "" {
+		cfg.LDAP.EmailAttribute = "mail"
+	}
+
+	if cfg.LDAP.GroupAttribute == "" {
+		cfg.LDAP.GroupAttribute = "memberOf"
+	}
+}
+
+func (cfg *Config) fixSAMLConfig() {
+	cfg.SAML.BaseURL = strings.TrimSuffix(cfg.SAML.BaseURL, "/")
+
+	if cfg.SAML.Listen == "" {
+		cfg.SAML.Listen = ":8090"
+	}
+
+	if cfg.SAML.EntityID == "" && cfg.SAML.BaseURL != "" {
+		cfg.SAML.EntityID = cfg.SAML.BaseURL + "/saml/metadata"
+	}
+
+	if cfg.SAML.AssertionExpire <= 0 {
+		cfg.SAML.AssertionExpire = 5 * time.Minute
+	}
+
+	if cfg.SAML.SessionExpire <= 0 {
+		cfg.SAML.SessionExpire = 8 * time.Hour
+	}
+
+	for idx := range cfg.SAML.ServiceProviders {
+		if cfg.SAML.ServiceProviders[idx].NameID == "" {
+			cfg.SAML.ServiceProviders[idx].NameID = SAMLNameIDUserID
+		}
+	}
+}
+
+func (cfg *Config) fixLoginLockoutConfig() {
+	if cfg.LoginLockout.UserMaxFailures <= 0 {
+		cfg.LoginLockout.UserMaxFailures = 5
+	}
+
+	if cfg.LoginLockout.IPMaxFailures <= 0 {
+		cfg.LoginLockout.IPMaxFailures = 50
+	}
+
+	if cfg.LoginLockout.FailureWindow <= 0 {
+		cfg.LoginLockout.FailureWindow = 15 * time.Minute
+	}
+
+	if cfg.LoginLockout.BaseLock <= 0 {
+		cfg.LoginLockout.BaseLock = time.Minute
+	}
+
+	if cfg.LoginLockout.MaxLock < cfg.LoginLockout.BaseLock {
+		cfg.LoginLockout.MaxLock = 24 * time.Hour
+	}
+}
+
+func (cfg *Config) fixPasswordHashConfig() {
+	if cfg.PasswordHash.Algorithm != PasswordHashBcrypt {
+		cfg.PasswordHash.Algorithm = PasswordHashArgon2id
+	}
+
+	if cfg.PasswordHash.Argon2Time == 0 {
+		cfg.PasswordHash.Argon2Time = 3
+	}
+
+	if cfg.PasswordHash.Argon2Memory == 0 {
+		cfg.PasswordHash.Argon2Memory = 64 * 1024
+	}
+
+	if cfg.PasswordHash.Argon2Threads == 0 {
+		cfg.PasswordHash.Argon2Threads = 2
+	}
+
+	if cfg.PasswordHash.Argon2KeyLen == 0 {
+		cfg.PasswordHash.Argon2KeyLen = 32
+	}
+
+	if cfg.PasswordHash.Argon2SaltLen == 0 {
+		cfg.PasswordHash.Argon2SaltLen = 16
+	}
+
+	if cfg.PasswordHash.BcryptCost <= 0 {
+		cfg.PasswordHash.BcryptCost = 12
+	}
 }
 
 func (cfg *Config) init() {
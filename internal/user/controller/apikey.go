@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// api keys look like <kind>_<key id>_<secret>, the key id finds the key and only the hash of the secret
+// is stored
+const (
+	apiKeyIDBytes     = 8
+	apiKeySecretBytes = 32
+
+	// the last used time and ip of a key are written at most once in the interval
+	apiKeyUsedInterval = time.Minute
+)
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKey(kind string) (key, keyID, keyHash string, err error) {
+	keyID, err = randomHex(apiKeyIDBytes)
+	if err != nil {
+		return
+	}
+
+	secret, err := randomHex(apiKeySecretBytes)
+	if err != nil {
+		return
+	}
+
+	key = strings.Join([]string{kind, keyID, secret}, "_")
+	keyHash = hashAPIKeySecret(secret)
+
+	return
+}
+
+// parseAPIKey returns the key id and the secret of key if it's an api key of kind
+func parseAPIKey(kind, key string) (keyID, secret string, ok bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != kind || parts[1] == "" || parts[2] == "" {
+		return
+	}
+
+	return parts[1], parts[2], true
+}
+
+func apiKeySecretMatch(secret, keyHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(keyHash)) == 1
+}
+
+// apiKeyUsedRecently tells if the last use of a key needn't be written again
+func apiKeyUsedRecently(lastUsedAt *time.Time, lastUsedIP, ip string) bool {
+	return lastUsedAt != nil && time.Since(*lastUsedAt) < apiKeyUsedInterval && lastUsedIP == ip
+}
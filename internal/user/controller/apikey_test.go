@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	key, keyID, keyHash, err := newAPIKey(apiKeyKindServiceAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, apiKeyKindServiceAccount+"_"+keyID+"_") || strings.Contains(keyHash, keyID) {
+		t.Fatalf("unexpected key %v, %v", key, keyHash)
+	}
+
+	parsedKeyID, secret, ok := parseAPIKey(apiKeyKindServiceAccount, key)
+	if !ok || parsedKeyID != keyID || !apiKeySecretMatch(secret, keyHash) {
+		t.Fatalf("parse %v failed", key)
+	}
+
+	if apiKeySecretMatch(secret+"0", keyHash) {
+		t.Fatal("wrong secret matched")
+	}
+
+	for _, badKey := range []string{"", "sa", "sa_" + keyID, "sa__" + secret, "pat_" + keyID + "_" + secret} {
+		if _, _, ok = parseAPIKey(apiKeyKindServiceAccount, badKey); ok {
+			t.Errorf("bad key %q parsed", badKey)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// an access token authenticates the calls, a sso token signs a site on once and a saml token is never
+// handed out, it keeps the session of a service provider
+const (
+	tokenKindAccess  = "access"
+	tokenKindRefresh = "refresh"
+	tokenKindSSO     = "sso"
+	tokenKindSAML    = "saml"
+)
+
+var (
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotValidYet = errors.New("token not valid yet")
+	errTokenNoID        = errors.New("token has no jti")
+	errTokenIssuer      = errors.New("token issuer mismatch")
+	errTokenAudience    = errors.New("token audience mismatch")
+	errTokenClient      = errors.New("token client mismatch")
+)
+
+// Audience is the aud claim, which is a string or an array of strings
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+
+	if json.Unmarshal(data, &single) == nil {
+		*a = Audience{single}
+
+		return nil
+	}
+
+	var multi []string
+
+	err := json.Unmarshal(data, &multi)
+	if err != nil {
+		return err
+	}
+
+	*a = multi
+
+	return nil
+}
+
+// TokenClaims of the tokens of every kind. Access tokens carry Session in stateless mode. The tokens of an
+// oidc client have ClientID, and the client is the audience of them as RFC 9068
+type TokenClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	ID        string   `json:"jti"`
+	ClientID  string   `json:"client_id,omitempty"`
+
+	UserID     int64
+	SessionID  string
+	Kind       string    `json:",omitempty"`
+	Generation int64     `json:",omitempty"`
+	Session    *AuthInfo `json:",omitempty"`
+}
+
+// kindOf is the kind of tc, access tokens issued before the kind claim have none
+func kindOf(tc *TokenClaims) string {
+	if tc.Kind == "" {
+		return tokenKindAccess
+	}
+
+	return tc.Kind
+}
+
+// Valid checks the time claims without skew, the controller checks all the claims by validateClaims
+func (tc *TokenClaims) Valid() error {
+	return validateClaims(tc, "", nil, 0, time.Now())
+}
+
+// validateClaims requires exp, iat and jti. iss must be issuer and aud must have one of audiences if they
+// are set, skew is allowed on exp, nbf and iat
+func validateClaims(tc *TokenClaims, issuer string, audiences []string, skew time.Duration, now time.Time) error {
+	if tc.ExpiresAt == 0 || now.Add(-skew).Unix() > tc.ExpiresAt {
+		return errTokenExpired
+	}
+
+	if tc.IssuedAt == 0 || now.Add(skew).Unix() < tc.IssuedAt {
+		return fmt.Errorf("%w: issued at %v", errTokenNotValidYet, tc.IssuedAt)
+	}
+
+	if tc.NotBefore != 0 && now.Add(skew).Unix() < tc.NotBefore {
+		return fmt.Errorf("%w: not before %v", errTokenNotValidYet, tc.NotBefore)
+	}
+
+	if tc.ID == "" {
+		return errTokenNoID
+	}
+
+	if issuer != "" && tc.Issuer != issuer {
+		return fmt.Errorf("%w: %v", errTokenIssuer, tc.Issuer)
+	}
+
+	if len(audiences) == 0 {
+		return nil
+	}
+
+	for _, aud := range tc.Audience {
+		for _, audience := range audiences {
+			if aud == audience {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %v", errTokenAudience, tc.Audience)
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestClaims(now time.Time) *TokenClaims {
+	return &TokenClaims{
+		Issuer:    "https://a.example.com",
+		Audience:  Audience{"a.example.com", "b.example.com"},
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ID:        "jti",
+		UserID:    1,
+		SessionID: "sid",
+		Kind:      tokenKindAccess,
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	now := time.Now()
+	audiences := []string{"b.example.com"}
+
+	cases := []struct {
+		name   string
+		modify func(tc *TokenClaims)
+		skew   time.Duration
+		err    error
+	}{
+		{"valid", func(tc *TokenClaims) {}, 0, nil},
+		{"expired", func(tc *TokenClaims) { tc.ExpiresAt = now.Add(-10 * time.Second).Unix() }, 0, errTokenExpired},
+		{"expired in skew", func(tc *TokenClaims) { tc.ExpiresAt = now.Add(-10 * time.Second).Unix() }, time.Minute, nil},
+		{"no exp", func(tc *TokenClaims) { tc.ExpiresAt = 0 }, time.Minute, errTokenExpired},
+		{"not before", func(tc *TokenClaims) { tc.NotBefore = now.Add(10 * time.Second).Unix() }, 0, errTokenNotValidYet},
+		{"not before in skew", func(tc *TokenClaims) { tc.NotBefore = now.Add(10 * time.Second).Unix() }, time.Minute, nil},
+		{"issued in future", func(tc *TokenClaims) { tc.IssuedAt = now.Add(10 * time.Second).Unix() }, 0, errTokenNotValidYet},
+		{"no iat", func(tc *TokenClaims) { tc.IssuedAt = 0 }, 0, errTokenNotValidYet},
+		{"no jti", func(tc *TokenClaims) { tc.ID = "" }, 0, errTokenNoID},
+		{"other issuer", func(tc *TokenClaims) { tc.Issuer = "https://other.example.com" }, 0, errTokenIssuer},
+		{"other audience", func(tc *TokenClaims) { tc.Audience = Audience{"other.example.com"} }, 0, errTokenAudience},
+		{"no audience", func(tc *TokenClaims) { tc.Audience = nil }, 0, errTokenAudience},
+	}
+
+	for _, c := range cases {
+		tc := newTestClaims(now)
+		c.modify(tc)
+
+		err := validateClaims(tc, "https://a.example.com", audiences, c.skew, now)
+		if !errors.Is(err, c.err) || (c.err == nil && err != nil) {
+			t.Errorf("%v: expect %v, got %v", c.name, c.err, err)
+		}
+	}
+
+	// issuer and audiences are not checked if not configured
+	tc := newTestClaims(now)
+	tc.Issuer = ""
+	tc.Audience = nil
+
+	if err := validateClaims(tc, "", nil, 0, now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenClaimsJSON(t *testing.T) {
+	tc := newTestClaims(time.Now())
+	tc.Audience = Audience{"a.example.com"}
+
+	data, err := json.Marshal(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m map[string]interface{}
+
+	if err = json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, claim := range []string{"iss", "exp", "iat", "nbf", "jti"} {
+		if _, ok := m[claim]; !ok {
+			t.Fatalf("no %v in %s", claim, data)
+		}
+	}
+
+	if m["aud"] != "a.example.com" {
+		t.Fatalf("single audience should be a string: %s", data)
+	}
+
+	var parsed TokenClaims
+
+	if err = json.Unmarshal([]byte(`{"aud":["x","y"],"exp":1}`), &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.Audience) != 2 || parsed.Audience[1] != "y" {
+		t.Fatalf("unexpected audience %v", parsed.Audience)
+	}
+
+	if err = parsed.Valid(); !errors.Is(err, errTokenExpired) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
@@ -2,8 +2,6 @@ package controller
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"time"
 
@@ -13,7 +11,15 @@ import (
 	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
 	"github.com/sbasestarter/user/internal/config"
 	"github.com/sbasestarter/user/internal/user/controller/factory"
+	"github.com/sbasestarter/user/internal/user/controller/ipbind"
+	"github.com/sbasestarter/user/internal/user/controller/jwtkey"
+	"github.com/sbasestarter/user/internal/user/controller/keyring"
+	"github.com/sbasestarter/user/internal/user/controller/oidc"
 	"github.com/sbasestarter/user/internal/user/controller/plugins"
+	"github.com/sbasestarter/user/internal/user/controller/pwdpolicy"
+	"github.com/sbasestarter/user/internal/user/controller/saml"
+	"github.com/sbasestarter/user/internal/user/controller/totp"
+	"github.com/sbasestarter/user/internal/user/controller/webauthn"
 	"github.com/sbasestarter/user/internal/user/model"
 	"github.com/sgostarter/i/l"
 	"github.com/sgostarter/libeasygo/helper"
@@ -21,16 +27,24 @@ import (
 )
 
 type Controller struct {
-	cfg             *config.Config
-	logger          l.WrapperWithContext
-	redis           *redis.Client
-	m               *model.Model
-	fileCli         filecenterpb.FileServiceClient
-	authPlugins     *plugins.Plugins
-	cliFactory      factory.GRPCClientFactory
-	utils           factory.Utils
-	httpToken       factory.HTTPToken
-	whiteListTokens map[string]*AuthInfo
+	cfg         *config.Config
+	logger      l.WrapperWithContext
+	redis       *redis.Client
+	m           *model.Model
+	fileCli     filecenterpb.FileServiceClient
+	authPlugins *plugins.Plugins
+	cliFactory  factory.GRPCClientFactory
+	utils       factory.Utils
+	httpToken   factory.HTTPToken
+	pwdPolicy   *pwdpolicy.Policy
+	totp        *totp.TOTP
+	webAuthn    *webauthn.WebAuthn
+	secrets     *keyring.Keyring
+	tokenKeys   *jwtkey.Set
+	revoked     *revocationList
+	ipBinder    *ipbind.Binder
+	oidcClients *oidc.Clients
+	samlIdP     *saml.IdentityProvider
 }
 
 func NewController(cfg *config.Config, logger l.Wrapper, redis *redis.Client, db *xorm.Engine, allFactory factory.Factory) *Controller {
@@ -42,35 +56,89 @@ func NewController(cfg *config.Config, logger l.Wrapper, redis *redis.Client, db
 
 	uUtils := allFactory.GetUtils()
 	cliFactory := allFactory.GetGRPCClientFactory()
-	whiteListTokens := make(map[string]*AuthInfo)
 
-	for _, token := range cfg.WhiteListTokens {
-		decodeBytes, err := base64.StdEncoding.DecodeString(token)
-		if err != nil {
-			loggerWithContext.Fatalf(context.Background(), "parse white list token failed:", err, token)
-		}
+	pwdPolicy, err := pwdpolicy.NewPolicy(&cfg.PasswordPolicy)
+	if err != nil {
+		loggerWithContext.Fatalf(context.Background(), "load password policy failed: %v", err)
+	}
 
-		var ai AuthInfo
+	gaOTP, err := totp.New(cfg.GoogleAuthenticator.Digits, cfg.GoogleAuthenticator.Period,
+		cfg.GoogleAuthenticator.Algorithm, cfg.GoogleAuthenticator.Skew)
+	if err != nil {
+		loggerWithContext.Fatalf(context.Background(), "init totp failed: %v", err)
+	}
 
-		err = json.Unmarshal(decodeBytes, &ai)
+	secrets, err := keyring.New(&cfg.SecretEncryption)
+	if err != nil {
+		loggerWithContext.Fatalf(context.Background(), "load secret keys failed: %v", err)
+	}
+
+	if !secrets.Enabled() {
+		loggerWithContext.Warn(context.Background(), "no active secret key, 2fa secrets are stored in plaintext")
+	}
+
+	tokenKeys, err := jwtkey.New(cfg.Token.Secret, cfg.Token.SigningKeyID, cfg.Token.SigningKeys)
+	if err != nil {
+		loggerWithContext.Fatalf(context.Background(), "load token signing keys failed: %v", err)
+	}
+
+	ipBinder, err := ipbind.New(&cfg.Token.IPBinding)
+	if err != nil {
+		loggerWithContext.Fatalf(context.Background(), "init ip binding failed: %v", err)
+	}
+
+	oidcClients, err := oidc.NewClients(cfg.OIDC.Clients)
+	if err != nil {
+		loggerWithContext.Fatalf(context.Background(), "load oidc clients failed: %v", err)
+	}
+
+	if cfg.OIDC.Enable && (cfg.OIDC.Issuer == "" || cfg.OIDC.LoginURL == "" || !tokenKeys.Asymmetric()) {
+		loggerWithContext.Fatalf(context.Background(), "oidc needs issuer, login url and asymmetric token signing keys")
+	}
+
+	var samlIdP *saml.IdentityProvider
+	if cfg.SAML.Enable {
+		samlIdP, err = saml.New(&cfg.SAML)
 		if err != nil {
-			loggerWithContext.Fatalf(context.Background(), "parse white list token failed:", err, token)
+			loggerWithContext.Fatalf(context.Background(), "load saml identity provider failed: %v", err)
 		}
 
-		whiteListTokens[token] = &ai
+		if cfg.SAML.LoginURL == "" {
+			loggerWithContext.Fatalf(context.Background(), "saml needs login url")
+		}
+	}
+
+	var webAuthn *webauthn.WebAuthn
+	if cfg.WebAuthn.Enable {
+		webAuthn = webauthn.New(cfg.WebAuthn.RPID, cfg.WebAuthn.RPName, cfg.WebAuthn.Origins, cfg.WebAuthn.Timeout)
+	}
+
+	m := model.NewModel(db, uUtils)
+
+	err = m.SyncTables()
+	if err != nil {
+		loggerWithContext.Fatalf(context.Background(), "sync tables failed: %v", err)
 	}
 
 	return &Controller{
-		cfg:             cfg,
-		logger:          loggerWithContext.WithFields(l.StringField(l.ClsKey, "Controller")),
-		redis:           redis,
-		m:               model.NewModel(db, uUtils),
-		fileCli:         cliFactory.GetFileCenterClient(),
-		authPlugins:     plugins.NewPlugins(cfg, cliFactory, logger),
-		cliFactory:      cliFactory,
-		utils:           uUtils,
-		httpToken:       allFactory.GetHTTPToken(),
-		whiteListTokens: whiteListTokens,
+		cfg:         cfg,
+		logger:      loggerWithContext.WithFields(l.StringField(l.ClsKey, "Controller")),
+		redis:       redis,
+		m:           m,
+		fileCli:     cliFactory.GetFileCenterClient(),
+		authPlugins: plugins.NewPlugins(cfg, cliFactory, logger),
+		cliFactory:  cliFactory,
+		utils:       uUtils,
+		httpToken:   allFactory.GetHTTPToken(),
+		pwdPolicy:   pwdPolicy,
+		totp:        gaOTP,
+		webAuthn:    webAuthn,
+		secrets:     secrets,
+		tokenKeys:   tokenKeys,
+		revoked:     newRevocationList(),
+		ipBinder:    ipBinder,
+		oidcClients: oidcClients,
+		samlIdP:     samlIdP,
 	}
 }
 
@@ -122,13 +190,7 @@ func (c *Controller) TriggerAuth(ctx context.Context, user *userpb.UserId, purpo
 	}
 
 	// save verify code
-	key = redisKeyForVeAuth(redisUsername(user), keyCatAuthCode)
-
-	// nolint: contextcheck
-	helper.DoWithTimeout(context.Background(), time.Second, func(ctx context.Context) {
-		_, err = c.redis.Set(ctx, key, code, c.authPlugins.ValidDelayDuration(ctx, user)).Result()
-	})
-
+	err = c.saveVe(ctx, user, purpose, code)
 	if err != nil {
 		c.logger.Errorf(ctx, "save verify code error: %v", err)
 
@@ -139,7 +201,7 @@ func (c *Controller) TriggerAuth(ctx context.Context, user *userpb.UserId, purpo
 }
 
 func (c *Controller) Register(ctx context.Context, user *userpb.UserId, codeForVe, newPassword string,
-	attachSsoToken bool, ssoJumpURL string) (status userpb.UserStatus, token string, info *userpb.UserInfo, ssoToken string, err error) {
+	attachSsoToken bool, ssoJumpURL string) (status userpb.UserStatus, token *SignedTokens, info *userpb.UserInfo, ssoToken string, err error) {
 	if user == nil || user.UserVe == "" || newPassword == "" {
 		c.logger.Errorf(ctx, "invalid input: %+v, %v", user, newPassword)
 
@@ -161,7 +223,12 @@ func (c *Controller) Register(ctx context.Context, user *userpb.UserId, codeForV
 
 	user = fixedUser
 
-	status, err = c.checkVe(user, codeForVe)
+	status, err = c.checkPasswordPolicy(ctx, 0, newPassword, user.UserName)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.checkVe(user, codeForVe, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_REGISTER)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		c.logger.Errorf(ctx, "checkVe failed: %v", err)
 
@@ -208,7 +275,7 @@ func (c *Controller) Register(ctx context.Context, user *userpb.UserId, codeForV
 		return
 	}
 
-	c.removeVe(user)
+	c.removeVe(user, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_REGISTER)
 
 	if c.cfg.GoogleAuthenticator.Force {
 		status = userpb.UserStatus_USER_STATUS_NEED_2FA_SETUP
@@ -220,8 +287,8 @@ func (c *Controller) Register(ctx context.Context, user *userpb.UserId, codeForV
 }
 
 // nolint: funlen, gocognit, cyclop
-func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password, codeForVe, codeForGa string,
-	attachSsoToken bool, ssoJumpURL string) (status userpb.UserStatus, token string, info *userpb.UserInfo, ssoToken string, err error) {
+func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password, codeForVe, codeForGa,
+	webAuthnAssertion string, attachSsoToken bool, ssoJumpURL string) (status userpb.UserStatus, token *SignedTokens, info *userpb.UserInfo, ssoToken string, err error) {
 	if userID == nil || userID.UserVe == "" {
 		c.logger.Errorf(ctx, "invalid input: %+v", userID)
 
@@ -236,6 +303,13 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 
 	status, fixedUser, nickName, avatar := c.authPlugins.TryAutoLogin(ctx, userID, codeForVe)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		// a ve which signs in by the code only has nothing to fall back to
+		if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT && status != userpb.UserStatus_USER_STATUS_NOT_IMPLEMENT {
+			c.logger.Errorf(ctx, "auto login failed: %v", status)
+
+			return
+		}
+
 		c.logger.Warnf(ctx, "auto login failed: %v", status)
 	} else {
 		c.logger.Info(ctx, "auto login success")
@@ -258,6 +332,12 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 		if userSource.UserId > 0 {
 			userID = userSource.UserId
 			userSourceIDFlag = false
+
+			// the identity is linked to a registered user, which keeps its second factor
+			status, err = c.verifyLinkedLogin(ctx, userID, codeForGa, webAuthnAssertion)
+			if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+				return
+			}
 		}
 
 		authInfo = &AuthInfo{
@@ -279,6 +359,15 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 
 		userID = fixedUser
 
+		// users of a directory sign in with the password of it, which replaces the local password and the ve code
+		directory := c.authPlugins.IsPasswordVe(userID)
+		if directory {
+			status, err = c.directoryLogin(ctx, userID, password)
+			if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+				return
+			}
+		}
+
 		var uid int64
 
 		uid, err = c.m.GetUserIDBySource(userID.UserName, userID.UserVe)
@@ -311,7 +400,21 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 			return
 		}
 
-		if password == "" {
+		// a verified assertion is the second factor, or replaces the password if the authenticator verified the user
+		var assertionOk, assertionUserVerified bool
+
+		if webAuthnAssertion != "" {
+			status, assertionUserVerified, err = c.webAuthnVerifyLogin(ctx, uid, webAuthnAssertion)
+			if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+				c.logger.Errorf(ctx, "check webauthn assertion failed: %v, %v", status, err)
+
+				return
+			}
+
+			assertionOk = true
+		}
+
+		if password == "" && !assertionUserVerified {
 			c.logger.Errorf(ctx, "IsUserTrust check failed, need password verify")
 
 			status = userpb.UserStatus_USER_STATUS_NEED_PASSWORD_AUTH
@@ -319,7 +422,7 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 			return
 		}
 
-		if !trust {
+		if !trust && !directory {
 			if codeForVe == "" {
 				c.logger.Errorf(ctx, "IsUserTrust check failed, need code verify")
 
@@ -329,7 +432,7 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 			}
 		}
 
-		if c.cfg.GoogleAuthenticator.Enable {
+		if c.cfg.GoogleAuthenticator.Enable && !assertionOk {
 			var key string
 
 			key, err = c.m.GetUser2FaKey(uid)
@@ -352,15 +455,25 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 			}
 		}
 
-		status, err = c.verifyPassword(ctx, uid, password)
-		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
-			c.logger.Errorf(ctx, "check password failed: %v, %v", status, err)
+		if codeForGa == "" && !assertionOk && c.webAuthnEnabled(ctx, uid) {
+			c.logger.Errorf(ctx, "should use webauthn: %v", uid)
+
+			status = userpb.UserStatus_USER_STATUS_NEED_2FA_AUTH
 
 			return
 		}
 
-		if codeForVe != "" {
-			status, err = c.checkVe(userID, codeForVe)
+		if password != "" && !directory {
+			status, err = c.verifyPasswordWithLockout(ctx, uid, password)
+			if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+				c.logger.Errorf(ctx, "check password failed: %v, %v", status, err)
+
+				return
+			}
+		}
+
+		if codeForVe != "" && !directory {
+			status, err = c.checkVe(userID, codeForVe, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LOGIN)
 			if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 				c.logger.Errorf(ctx, "check ve failed: %v, %v", status, err)
 
@@ -406,10 +519,10 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 	}
 
 	if codeForVe != "" {
-		c.removeVe(userID)
+		c.removeVe(userID, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LOGIN)
 	}
 
-	if c.cfg.GoogleAuthenticator.Force && !c.gaEnabled(ctx, authInfo.UserID) {
+	if c.cfg.GoogleAuthenticator.Force && !c.gaEnabled(ctx, authInfo.UserID) && !c.webAuthnEnabled(ctx, authInfo.UserID) {
 		status = userpb.UserStatus_USER_STATUS_NEED_2FA_SETUP
 	} else {
 		status = userpb.UserStatus_USER_STATUS_SUCCESS
@@ -419,7 +532,7 @@ func (c *Controller) Login(ctx context.Context, userID *userpb.UserId, password,
 }
 
 func (c *Controller) SSOLogin(ctx context.Context, ssoToken string) (status userpb.UserStatus,
-	token string, info *userpb.UserInfo, err error) {
+	token *SignedTokens, info *userpb.UserInfo, err error) {
 	authInfo, err := c.verifySSOToken(ctx, ssoToken)
 	if err != nil {
 		c.logger.Errorf(ctx, "sso login failed: %v", err)
@@ -444,7 +557,7 @@ func (c *Controller) SSOLogin(ctx context.Context, ssoToken string) (status user
 }
 
 func (c *Controller) Logout(ctx context.Context, token string) (status userpb.UserStatus, err error) {
-	status, fixedToken, _, err := c.fixAndVerifyToken(ctx, token)
+	status, fixedToken, authInfo, err := c.fixAndVerifyToken(ctx, token)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		c.logger.Errorf(ctx, "fixAndVerifyToken failed: %v, %v", err, token)
 
@@ -453,6 +566,11 @@ func (c *Controller) Logout(ctx context.Context, token string) (status userpb.Us
 		return
 	}
 
+	status, err = c.checkSessionToken(ctx, authInfo)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
 	_ = c.removeToken(ctx, fixedToken)
 
 	err = c.httpToken.UnsetUserTokenCookie(ctx, fixedToken)
@@ -469,12 +587,8 @@ func (c *Controller) Logout(ctx context.Context, token string) (status userpb.Us
 
 func (c *Controller) GoogleAuthGetSetupInfo(ctx context.Context, token string) (status userpb.UserStatus,
 	secretKey string, err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "fixAndVerifyToken failed: %v, %v", err, token)
-
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
-
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
 
@@ -494,12 +608,8 @@ func (c *Controller) GoogleAuthGetSetupInfo(ctx context.Context, token string) (
 
 func (c *Controller) GoogleAuthVerify(ctx context.Context, token, code string) (status userpb.UserStatus, gaToken string,
 	err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
-
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
-
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
 
@@ -525,13 +635,9 @@ func (c *Controller) GoogleAuthVerify(ctx context.Context, token, code string) (
 }
 
 func (c *Controller) GoogleAuthSet(ctx context.Context, token, code, tokenGaOld string) (status userpb.UserStatus,
-	err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
-
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
-
+	recoveryCodes []string, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
 
@@ -564,9 +670,17 @@ func (c *Controller) GoogleAuthSet(ctx context.Context, token, code, tokenGaOld
 			status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
 
 			c.logger.Errorf(ctx, "db set google auth key failed: %v", err)
+		} else {
+			if err = c.m.DeleteUserRecoveryCodes(authInfo.UserID); err != nil {
+				c.logger.Errorf(ctx, "delete recovery codes failed: %v", err)
+
+				err = nil
+			}
+
+			c.revokeSessionsOnCredentialChange(ctx, authInfo.UserID, authInfo.SessionID)
 		}
 	} else {
-		status, err = c.gaSetupWithCode(ctx, authInfo.UserID, code)
+		status, recoveryCodes, err = c.gaSetupWithCode(ctx, authInfo.UserID, code)
 	}
 
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
@@ -585,7 +699,7 @@ func (c *Controller) GoogleAuthSet(ctx context.Context, token, code, tokenGaOld
 }
 
 func (c *Controller) Profile(ctx context.Context, token string, attachSsoToken bool, ssoJumpURL string) (status userpb.UserStatus,
-	userInfo *userpb.UserInfo, ssoToken string, err error) {
+	userInfo *userpb.UserInfo, ssoToken string, expiry *userpb.SessionExpiry, err error) {
 	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
 		c.logger.Errorf(ctx, "verify token failed: %v", err)
@@ -594,6 +708,11 @@ func (c *Controller) Profile(ctx context.Context, token string, attachSsoToken b
 	}
 
 	if attachSsoToken {
+		status, err = c.checkSessionToken(ctx, authInfo)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			return
+		}
+
 		ssoToken, err = c.newSSOToken(ctx, authInfo.SessionID, authInfo, ssoJumpURL)
 		if err != nil {
 			c.logger.Errorf(ctx, "new sso token failed: %v", err)
@@ -609,6 +728,7 @@ func (c *Controller) Profile(ctx context.Context, token string, attachSsoToken b
 	}
 
 	userInfo = c.authInfo2PbUserInfo(authInfo, gaEnabled)
+	expiry = c.sessionExpiry(ctx, authInfo)
 
 	status = userpb.UserStatus_USER_STATUS_SUCCESS
 
@@ -617,7 +737,7 @@ func (c *Controller) Profile(ctx context.Context, token string, attachSsoToken b
 
 // nolint: funlen
 func (c *Controller) ResetPassword(ctx context.Context, user *userpb.UserId, newPassword, codeForVe,
-	codeForGa string) (status userpb.UserStatus, token string, info *userpb.UserInfo, err error) {
+	codeForGa string) (status userpb.UserStatus, token *SignedTokens, info *userpb.UserInfo, err error) {
 	if user == nil || user.UserVe == "" {
 		status = userpb.UserStatus_USER_STATUS_FAILED
 
@@ -631,7 +751,7 @@ func (c *Controller) ResetPassword(ctx context.Context, user *userpb.UserId, new
 
 	user = fixedUser
 
-	status, err = c.checkVe(user, codeForVe)
+	status, err = c.checkVe(user, codeForVe, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_RESET_PASSWORD)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		c.logger.Errorf(ctx, "check ve failed: %v, %v", status, err)
 
@@ -687,6 +807,17 @@ func (c *Controller) ResetPassword(ctx context.Context, user *userpb.UserId, new
 		}
 	}
 
+	// checked after the second factor, so the ve code alone doesn't tell which passwords were used
+	status, err = c.checkPasswordPolicy(ctx, userID, newPassword, user.UserName)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.checkPasswordReuse(ctx, userID, newPassword)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
 	password, err := c.passEncrypt(newPassword)
 	if err != nil {
 		c.logger.Errorf(ctx, "pass encrypt failed: %v", err)
@@ -696,7 +827,7 @@ func (c *Controller) ResetPassword(ctx context.Context, user *userpb.UserId, new
 		return
 	}
 
-	err = c.m.UpdateUserPassword(userID, password)
+	err = c.updateUserPassword(userID, password)
 	if err != nil {
 		c.logger.Errorf(ctx, "update user password failed: %v", err)
 
@@ -705,6 +836,9 @@ func (c *Controller) ResetPassword(ctx context.Context, user *userpb.UserId, new
 		return
 	}
 
+	// the caller is not signed in, no session to keep
+	c.revokeSessionsOnCredentialChange(ctx, userID, "")
+
 	status, token, info, err = c.signResponseInfoAfterCheckPass(ctx, userID, nil, 1)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		c.logger.Errorf(ctx, "signResponseInfoAfterCheckPass failed: %v, %v", status, err)
@@ -712,7 +846,7 @@ func (c *Controller) ResetPassword(ctx context.Context, user *userpb.UserId, new
 		return
 	}
 
-	c.removeVe(user)
+	c.removeVe(user, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_RESET_PASSWORD)
 
 	status = userpb.UserStatus_USER_STATUS_SUCCESS
 
@@ -720,17 +854,13 @@ func (c *Controller) ResetPassword(ctx context.Context, user *userpb.UserId, new
 }
 
 func (c *Controller) ChangePassword(ctx context.Context, token, csrfToken,
-	password, newPassword string) (status userpb.UserStatus, newToken string, info *userpb.UserInfo, err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
-
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
-
+	password, newPassword string) (status userpb.UserStatus, newToken *SignedTokens, info *userpb.UserInfo, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
 
-	status, err = c.verifyPassword(ctx, authInfo.UserID, password)
+	status, err = c.verifyPasswordWithLockout(ctx, authInfo.UserID, password)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		c.logger.Errorf(ctx, "check password failed: %v, %v", status, err)
 
@@ -744,6 +874,16 @@ func (c *Controller) ChangePassword(ctx context.Context, token, csrfToken,
 		return
 	}
 
+	status, err = c.checkPasswordPolicy(ctx, authInfo.UserID, newPassword)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.checkPasswordReuse(ctx, authInfo.UserID, newPassword)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
 	encryptPassword, err := c.passEncrypt(newPassword)
 	if err != nil {
 		c.logger.Errorf(ctx, "pass encrypt failed: %v", err)
@@ -753,7 +893,7 @@ func (c *Controller) ChangePassword(ctx context.Context, token, csrfToken,
 		return
 	}
 
-	err = c.m.UpdateUserPassword(authInfo.UserID, encryptPassword)
+	err = c.updateUserPassword(authInfo.UserID, encryptPassword)
 	if err != nil {
 		c.logger.Errorf(ctx, "update user password failed: %v", err)
 
@@ -762,12 +902,20 @@ func (c *Controller) ChangePassword(ctx context.Context, token, csrfToken,
 		return
 	}
 
+	c.revokeSessionsOnCredentialChange(ctx, authInfo.UserID, authInfo.SessionID)
+
 	return c.signResponseInfoAfterCheckPass(ctx, authInfo.UserID, nil, 1)
 }
 
 func (c *Controller) GetCsrfToken(ctx context.Context, token string) (
 	status userpb.UserStatus, csrfToken string, err error) {
-	status, token, _, err = c.fixAndVerifyToken(ctx, token)
+	status, token, authInfo, err := c.fixAndVerifyToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	// only the calls of sessions take csrf tokens
+	status, err = c.checkSessionToken(ctx, authInfo)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
@@ -788,12 +936,8 @@ func (c *Controller) GetCsrfToken(ctx context.Context, token string) (
 
 func (c *Controller) GetDetailInfo(ctx context.Context, token string) (status userpb.UserStatus,
 	info *userpb.UserDetailInfo, err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
-
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
-
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
 
@@ -813,12 +957,8 @@ func (c *Controller) GetDetailInfo(ctx context.Context, token string) (status us
 }
 
 func (c *Controller) UpdateDetailInfo(ctx context.Context, token, csrfToken, avatar, nickName, phone, email, wechat string) (status userpb.UserStatus, err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
-
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
-
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
 
@@ -904,12 +1044,8 @@ func (c *Controller) fixAndVerifyToken(ctx context.Context, token string) (
 
 func (c *Controller) GetUserList(ctx context.Context, token, csrfToken string, offset int64, limit int32,
 	keyword string) (status userpb.UserStatus, cnt int64, users []*userpb.UserListItem, err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
-
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
-
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		return
 	}
 
@@ -957,16 +1093,47 @@ func (c *Controller) GetUserList(ctx context.Context, token, csrfToken string, o
 	return
 }
 
-func (c *Controller) ManagerUser(ctx context.Context, req *userpb.ManagerUserRequest) (status userpb.UserStatus, err error) {
-	status, _, authInfo, err := c.fixAndVerifyToken(ctx, req.Token)
-	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
+// adminResetPassword sets the password of userID for an admin, whose session adminSessionID is kept if
+// it's of userID
+func (c *Controller) adminResetPassword(ctx context.Context, userID int64, newPassword, adminSessionID string) (
+	status userpb.UserStatus, err error) {
+	status, err = c.checkPasswordPolicy(ctx, userID, newPassword)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
 
-		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+	password, err := c.passEncrypt(newPassword)
+	if err != nil {
+		c.logger.Errorf(ctx, "pass encrypt failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	err = c.updateUserPassword(userID, password)
+	if err != nil {
+		c.logger.Errorf(ctx, "update user password failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
 
 		return
 	}
 
+	c.revokeSessionsOnCredentialChange(ctx, userID, adminSessionID)
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+func (c *Controller) ManagerUser(ctx context.Context, req *userpb.ManagerUserRequest) (status userpb.UserStatus,
+	sessions []*userpb.SessionInfo, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, req.Token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
 	status, err = c.verifyCsrfToken(ctx, req.CsrfToken)
 	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
 		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
@@ -1031,15 +1198,36 @@ func (c *Controller) ManagerUser(ctx context.Context, req *userpb.ManagerUserReq
 
 			return
 		}
-		var password string
-		password, err = c.passEncrypt(req.GetResetPassword().NewPassword)
-		if err != nil {
-			c.logger.Errorf(ctx, "pass encrypt failed: %v", err)
-			status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+		status, err = c.adminResetPassword(ctx, req.Uid, req.GetResetPassword().NewPassword, authInfo.SessionID)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			return
+		}
+	} else if req.Type == userpb.ManagerUserType_MANAGER_USER_TYPE_UNLOCK {
+		err = c.unlockUser(ctx, req.Uid)
+	} else if req.Type == userpb.ManagerUserType_MANAGER_USER_TYPE_LIST_SESSIONS {
+		sessions, err = c.listSessions(ctx, req.Uid, authInfo.SessionID)
+	} else if req.Type == userpb.ManagerUserType_MANAGER_USER_TYPE_REVOKE_SESSION {
+		if req.GetRevokeSession() == nil {
+			status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+			return
+		}
+		status = c.revokeUserSession(ctx, req.Uid, req.GetRevokeSession().SessionId)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			return
+		}
+	} else if req.Type == userpb.ManagerUserType_MANAGER_USER_TYPE_REVOKE_ALL_SESSIONS {
+		_, err = c.removeAllSessions(ctx, req.Uid, authInfo.SessionID)
+	} else if req.Type == userpb.ManagerUserType_MANAGER_USER_TYPE_MERGE {
+		if req.GetMerge() == nil {
+			status = userpb.UserStatus_USER_STATUS_BAD_INPUT
 
 			return
 		}
-		err = c.m.UpdateUserPassword(req.Uid, password)
+		status, err = c.mergeUsers(ctx, req.Uid, req.GetMerge().FromUid, adminUserInfo.UserId)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			return
+		}
 	}
 
 	if err != nil {
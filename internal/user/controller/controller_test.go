@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sbasestarter/db-orm/go/user"
+	filecenterpb "github.com/sbasestarter/proto-repo/gen/protorepo-file-go"
+	postsbspb "github.com/sbasestarter/proto-repo/gen/protorepo-postsbs-go"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sbasestarter/user/internal/user/controller/ipbind"
+	"github.com/sbasestarter/user/internal/user/controller/jwtkey"
+	"github.com/sbasestarter/user/internal/user/controller/keyring"
+	"github.com/sbasestarter/user/internal/user/controller/plugins"
+	"github.com/sbasestarter/user/internal/user/controller/pwdpolicy"
+	"github.com/sbasestarter/user/internal/user/controller/totp"
+	"github.com/sbasestarter/user/internal/user/helper"
+	"github.com/sbasestarter/user/internal/user/model"
+	"github.com/sgostarter/i/l"
+	"google.golang.org/grpc"
+	"xorm.io/xorm"
+)
+
+const testClientIP = "127.0.0.1"
+
+// testPostClient keeps the codes posted to each user name instead of sending them
+type testPostClient struct {
+	postsbspb.PostSBSServiceClient
+
+	sync.Mutex
+	codes map[string]string
+}
+
+func (cli *testPostClient) PostCode(_ context.Context, in *postsbspb.PostCodeRequest, _ ...grpc.CallOption) (
+	*postsbspb.PostCodeResponse, error) {
+	cli.Lock()
+	defer cli.Unlock()
+
+	cli.codes[in.To] = in.Code
+
+	return &postsbspb.PostCodeResponse{}, nil
+}
+
+func (cli *testPostClient) code(userName string) string {
+	cli.Lock()
+	defer cli.Unlock()
+
+	return cli.codes[userName]
+}
+
+type testClientFactory struct {
+	postClient *testPostClient
+}
+
+func (f *testClientFactory) GetFileCenterClient() filecenterpb.FileServiceClient {
+	return nil
+}
+
+func (f *testClientFactory) GetPostCenterClient() postsbspb.PostSBSServiceClient {
+	return f.postClient
+}
+
+// testUtils serves every call from ip, testClientIP if it's empty
+type testUtils struct {
+	*helper.UtilsImpl
+	ip string
+}
+
+func (u *testUtils) GetPeerIP(_ context.Context) string {
+	if u.ip != "" {
+		return u.ip
+	}
+
+	return testClientIP
+}
+
+// testHTTPToken keeps the last cookies set
+type testHTTPToken struct {
+	sync.Mutex
+	token        string
+	refreshToken string
+}
+
+func (h *testHTTPToken) SetUserTokenCookie(_ context.Context, token, refreshToken string) error {
+	h.Lock()
+	defer h.Unlock()
+
+	h.token, h.refreshToken = token, refreshToken
+
+	return nil
+}
+
+func (h *testHTTPToken) UnsetUserTokenCookie(_ context.Context, _ string) error {
+	h.Lock()
+	defer h.Unlock()
+
+	h.token, h.refreshToken = "", ""
+
+	return nil
+}
+
+func (h *testHTTPToken) cookies() (token, refreshToken string) {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.token, h.refreshToken
+}
+
+func newTestConfig() *config.Config {
+	veCfg := config.VEConfig{
+		SendDelayDuration:  time.Second,
+		ValidDelayDuration: time.Minute,
+		MaxAttempts:        3,
+	}
+
+	cfg := &config.Config{
+		PwdSecret: "secret",
+		PasswordHash: config.PasswordHashConfig{
+			Algorithm:  config.PasswordHashBcrypt,
+			BcryptCost: 4,
+		},
+		EmailConfig: veCfg,
+		PhoneConfig: veCfg,
+		CsrfExpire:  time.Minute,
+
+		WhiteListSSOJumpDomainMap: map[string]interface{}{"a.example.com": true},
+	}
+
+	cfg.Token.Secret = "secret"
+	cfg.Token.Expire = time.Hour
+	cfg.Token.IdleTimeout = time.Hour
+	cfg.Token.MaxLifetime = 24 * time.Hour
+	cfg.Token.AccessExpire = 15 * time.Minute
+	cfg.Token.SSOExpire = time.Minute
+	cfg.Token.RevocationRefresh = time.Minute
+	cfg.Token.IPBinding = config.IPBindingConfig{
+		Mode:       config.IPBindingStrict,
+		IPv4Prefix: 24,
+		IPv6Prefix: 64,
+	}
+
+	cfg.GoogleAuthenticator.Digits = 6
+	cfg.GoogleAuthenticator.Period = 30 * time.Second
+	cfg.GoogleAuthenticator.Algorithm = totp.AlgorithmSHA1
+	cfg.GoogleAuthenticator.RecoveryCodeCount = 4
+
+	return cfg
+}
+
+// newTestDB is a sqlite file of the test, with the tables of db-orm
+func newTestDB(t *testing.T) *xorm.Engine {
+	t.Helper()
+
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(t.TempDir(), "user.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	err = db.Sync2(new(user.UserInfo), new(user.UserSource), new(user.UserAuthentication), new(user.UserExt),
+		new(user.UserTrust))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newTestModel is on db, with the tables of the model
+func newTestModel(t *testing.T, db *xorm.Engine, utils *testUtils) *model.Model {
+	t.Helper()
+
+	m := model.NewModel(db, utils)
+
+	err := m.SyncTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// newTestController runs on a miniredis, returned to move the clock of it, and on a sqlite
+func newTestController(t *testing.T) (*Controller, *miniredis.Miniredis, *testPostClient) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+
+	redisCli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = redisCli.Close()
+	})
+
+	cfg := newTestConfig()
+
+	tokenKeys, err := jwtkey.New(cfg.Token.Secret, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipBinder, err := ipbind.New(&cfg.Token.IPBinding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gaOTP, err := totp.New(cfg.GoogleAuthenticator.Digits, cfg.GoogleAuthenticator.Period,
+		cfg.GoogleAuthenticator.Algorithm, cfg.GoogleAuthenticator.Skew)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := keyring.New(&cfg.SecretEncryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pwdPolicy, err := pwdpolicy.NewPolicy(&cfg.PasswordPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postClient := &testPostClient{codes: make(map[string]string)}
+	cliFactory := &testClientFactory{postClient: postClient}
+	uUtils := &testUtils{UtilsImpl: helper.NewUtilsImpl()}
+
+	c := &Controller{
+		cfg:         cfg,
+		logger:      l.NewNopLoggerWrapper().GetWrapperWithContext(),
+		redis:       redisCli,
+		m:           newTestModel(t, newTestDB(t), uUtils),
+		authPlugins: plugins.NewPlugins(cfg, cliFactory, nil),
+		cliFactory:  cliFactory,
+		utils:       uUtils,
+		pwdPolicy:   pwdPolicy,
+		totp:        gaOTP,
+		secrets:     secrets,
+		httpToken:   &testHTTPToken{},
+		tokenKeys:   tokenKeys,
+		revoked:     newRevocationList(),
+		ipBinder:    ipBinder,
+	}
+
+	return c, mr, postClient
+}
+
+// newTestUser registers a user by mail with password
+func newTestUser(t *testing.T, c *Controller, mail, password string) int64 {
+	t.Helper()
+
+	passwordHash, err := c.passEncrypt(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, userInfo, err := c.m.NewUser(mail, userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String(),
+		passwordHash, mail, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("new user %v failed: %v, %v", mail, status, err)
+	}
+
+	return userInfo.UserId
+}
+
+func newTestSession(t *testing.T, c *Controller, userID int64, parentSessionID string) (sessionID string,
+	tokens *SignedTokens) {
+	t.Helper()
+
+	sessionID, tokens, err := c.generateToken(context.Background(), &AuthInfo{
+		UserID:          userID,
+		NickName:        "abc",
+		ParentSessionID: parentSessionID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
@@ -1,7 +1,172 @@
 package controller
 
-import "github.com/sgostarter/libeasygo/crypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sgostarter/libeasygo/crypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passHashPrefixArgon2id = "$argon2id$"
+	passHashPrefixBcrypt   = "$2"
+)
+
+var errInvalidPassHash = errors.New("invalid password hash")
+
+// passEncrypt hashes the password with the configured algorithm and a random salt
 func (c *Controller) passEncrypt(content string) (string, error) {
+	if c.cfg.PasswordHash.Algorithm == config.PasswordHashBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(content), c.cfg.PasswordHash.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hashed), nil
+	}
+
+	return argon2idHash(content, &c.cfg.PasswordHash)
+}
+
+// passEncryptLegacy is the unsalted hash used before versioned hashes, only kept for verifying old records
+func (c *Controller) passEncryptLegacy(content string) (string, error) {
 	return crypt.HMacSHa256(c.cfg.PwdSecret, content)
 }
+
+// passVerify checks content against a stored hash, needRehash is set if the stored hash
+// is legacy or was generated with other parameters than the configured ones
+func (c *Controller) passVerify(hashed, content string) (ok, needRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hashed, passHashPrefixArgon2id):
+		var params *argon2idParams
+
+		ok, params, err = argon2idVerify(hashed, content)
+		if err != nil || !ok {
+			return
+		}
+
+		needRehash = c.cfg.PasswordHash.Algorithm != config.PasswordHashArgon2id || !params.match(&c.cfg.PasswordHash)
+	case strings.HasPrefix(hashed, passHashPrefixBcrypt):
+		err = bcrypt.CompareHashAndPassword([]byte(hashed), []byte(content))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			err = nil
+
+			return
+		}
+
+		if err != nil {
+			return
+		}
+
+		ok = true
+
+		cost, _ := bcrypt.Cost([]byte(hashed))
+		needRehash = c.cfg.PasswordHash.Algorithm != config.PasswordHashBcrypt || cost != c.cfg.PasswordHash.BcryptCost
+	default:
+		var legacy string
+
+		legacy, err = c.passEncryptLegacy(content)
+		if err != nil {
+			return
+		}
+
+		ok = subtle.ConstantTimeCompare([]byte(hashed), []byte(legacy)) == 1
+		needRehash = true
+	}
+
+	return
+}
+
+//
+//
+//
+
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	keyLen  uint32
+	saltLen uint32
+}
+
+func (p *argon2idParams) match(cfg *config.PasswordHashConfig) bool {
+	return p.memory == cfg.Argon2Memory && p.time == cfg.Argon2Time && p.threads == cfg.Argon2Threads &&
+		p.keyLen == cfg.Argon2KeyLen && p.saltLen == cfg.Argon2SaltLen
+}
+
+func argon2idHash(content string, cfg *config.PasswordHashConfig) (string, error) {
+	salt := make([]byte, cfg.Argon2SaltLen)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(content), salt, cfg.Argon2Time, cfg.Argon2Memory, cfg.Argon2Threads, cfg.Argon2KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", passHashPrefixArgon2id, argon2.Version,
+		cfg.Argon2Memory, cfg.Argon2Time, cfg.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func argon2idVerify(hashed, content string) (ok bool, params *argon2idParams, err error) {
+	// $argon2id$v=19$m=65536,t=3,p=2$salt$key
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 {
+		err = errInvalidPassHash
+
+		return
+	}
+
+	var version int
+
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return
+	}
+
+	if version != argon2.Version {
+		err = fmt.Errorf("unsupported argon2 version: %v", version)
+
+		return
+	}
+
+	params = &argon2idParams{}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return
+	}
+
+	if len(salt) == 0 || len(key) == 0 {
+		err = errInvalidPassHash
+
+		return
+	}
+
+	params.saltLen = uint32(len(salt))
+	params.keyLen = uint32(len(key))
+
+	otherKey := argon2.IDKey([]byte(content), salt, params.time, params.memory, params.threads, params.keyLen)
+
+	ok = subtle.ConstantTimeCompare(key, otherKey) == 1
+
+	return
+}
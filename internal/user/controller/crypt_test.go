@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+func newCryptTestController(algorithm string) *Controller {
+	cfg := &config.Config{
+		PwdSecret: "secret",
+		PasswordHash: config.PasswordHashConfig{
+			Algorithm:     algorithm,
+			Argon2Time:    1,
+			Argon2Memory:  1024,
+			Argon2Threads: 1,
+			Argon2KeyLen:  32,
+			Argon2SaltLen: 16,
+			BcryptCost:    4,
+		},
+	}
+
+	return &Controller{cfg: cfg}
+}
+
+func TestPassEncrypt_Argon2id(t *testing.T) {
+	c := newCryptTestController(config.PasswordHashArgon2id)
+
+	h1, err := c.passEncrypt("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2, err := c.passEncrypt("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(h1, passHashPrefixArgon2id) || h1 == h2 {
+		t.Fatalf("unexpected hashes: %v, %v", h1, h2)
+	}
+
+	ok, needRehash, err := c.passVerify(h1, "pass")
+	if err != nil || !ok || needRehash {
+		t.Fatalf("verify failed: %v, %v, %v", ok, needRehash, err)
+	}
+
+	ok, _, err = c.passVerify(h1, "pass2")
+	if err != nil || ok {
+		t.Fatalf("wrong password accepted: %v, %v", ok, err)
+	}
+
+	c.cfg.PasswordHash.Argon2Time = 2
+
+	_, needRehash, _ = c.passVerify(h1, "pass")
+	if !needRehash {
+		t.Fatal("changed params should need rehash")
+	}
+}
+
+func TestPassEncrypt_Bcrypt(t *testing.T) {
+	c := newCryptTestController(config.PasswordHashBcrypt)
+
+	h, err := c.passEncrypt("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, needRehash, err := c.passVerify(h, "pass")
+	if err != nil || !ok || needRehash {
+		t.Fatalf("verify failed: %v, %v, %v", ok, needRehash, err)
+	}
+
+	ok, _, err = c.passVerify(h, "pass2")
+	if err != nil || ok {
+		t.Fatalf("wrong password accepted: %v, %v", ok, err)
+	}
+
+	c.cfg.PasswordHash.Algorithm = config.PasswordHashArgon2id
+
+	_, needRehash, _ = c.passVerify(h, "pass")
+	if !needRehash {
+		t.Fatal("changed algorithm should need rehash")
+	}
+}
+
+func TestPassVerify_Legacy(t *testing.T) {
+	c := newCryptTestController(config.PasswordHashArgon2id)
+
+	legacy, err := c.passEncryptLegacy("pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, needRehash, err := c.passVerify(legacy, "pass")
+	if err != nil || !ok || !needRehash {
+		t.Fatalf("legacy verify failed: %v, %v, %v", ok, needRehash, err)
+	}
+
+	ok, _, err = c.passVerify(legacy, "pass2")
+	if err != nil || ok {
+		t.Fatalf("wrong password accepted: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/controller/plugins"
+)
+
+// directoryLogin verifies the password of userID by the external directory of the ve, and creates the
+// local user on the first login. The nickname, email and admin privilege follow the directory on every login
+func (c *Controller) directoryLogin(ctx context.Context, userID *userpb.UserId, password string) (
+	status userpb.UserStatus, err error) {
+	if password == "" {
+		c.logger.Errorf(ctx, "directory user %v without password", userID.UserName)
+
+		status = userpb.UserStatus_USER_STATUS_NEED_PASSWORD_AUTH
+
+		return
+	}
+
+	uid, err := c.m.GetUserIDBySource(userID.UserName, userID.UserVe)
+	if err != nil {
+		c.logger.Errorf(ctx, "GetUserIDBySource failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	ip := c.utils.GetPeerIP(ctx)
+
+	var targets []string
+
+	if c.cfg.LoginLockout.Enable {
+		if ip != "" {
+			targets = append(targets, lockoutIPTarget(ip))
+		}
+
+		if uid > 0 {
+			targets = append(targets, lockoutUserTarget(uid))
+		}
+
+		if remain := c.loginLockRemain(ctx, targets); remain > 0 {
+			c.logger.Warnf(ctx, "directory user %v from %v locked, remain %v", userID.UserName, ip, remain)
+
+			status = userpb.UserStatus_USER_STATUS_LOCKED
+			err = &lockedError{remain: remain}
+
+			return
+		}
+	}
+
+	status, directoryUser := c.authPlugins.VerifyPassword(ctx, userID, password)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "verify password of directory user %v failed: %v", userID.UserName, status)
+
+		if status == userpb.UserStatus_USER_STATUS_WRONG_PASSWORD && c.cfg.LoginLockout.Enable {
+			if remain := c.onDirectoryLoginFailed(ctx, uid, ip); remain > 0 {
+				status = userpb.UserStatus_USER_STATUS_LOCKED
+				err = &lockedError{remain: remain}
+			}
+		}
+
+		return
+	}
+
+	if uid > 0 && c.cfg.LoginLockout.Enable {
+		c.clearLoginFailures(ctx, lockoutUserTarget(uid))
+	}
+
+	if uid <= 0 {
+		uid, status, err = c.newDirectoryUser(ctx, userID, directoryUser)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			return
+		}
+	}
+
+	c.syncDirectoryUser(ctx, uid, directoryUser)
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+func (c *Controller) onDirectoryLoginFailed(ctx context.Context, uid int64, ip string) (remain time.Duration) {
+	if uid > 0 {
+		remain = c.onLoginFailed(ctx, lockoutUserTarget(uid), c.cfg.LoginLockout.UserMaxFailures)
+	}
+
+	if ip != "" {
+		if ipRemain := c.onLoginFailed(ctx, lockoutIPTarget(ip), c.cfg.LoginLockout.IPMaxFailures); ipRemain > remain {
+			remain = ipRemain
+		}
+	}
+
+	return
+}
+
+// newDirectoryUser creates the local user of a directory user, which has no local password
+func (c *Controller) newDirectoryUser(ctx context.Context, userID *userpb.UserId,
+	directoryUser *plugins.DirectoryUser) (uid int64, status userpb.UserStatus, err error) {
+	avatar, err := c.newAvatar(ctx, userID.UserName)
+	if err != nil {
+		c.logger.Warnf(ctx, "new avatar failed: %v", err)
+
+		avatar = ""
+	}
+
+	nickName := directoryUser.NickName
+	if nickName == "" {
+		nickName = userID.UserName
+	}
+
+	status, userInfo, err := c.m.NewUser(userID.UserName, userID.UserVe, "", nickName, avatar)
+	if err != nil {
+		c.logger.Errorf(ctx, "new directory user failed: %v-%v", status, err)
+
+		return
+	}
+
+	c.logger.Infof(ctx, "directory user %v of %v created: %v", userID.UserName, userID.UserVe, userInfo.UserId)
+
+	uid = userInfo.UserId
+
+	return
+}
+
+// syncDirectoryUser copies what the directory tells to the local user. The directory is reachable, so a
+// failure here doesn't fail the login
+func (c *Controller) syncDirectoryUser(ctx context.Context, uid int64, directoryUser *plugins.DirectoryUser) {
+	err := c.m.UpdateUserInfo(uid, "", directoryUser.NickName)
+	if err != nil {
+		c.logger.Errorf(ctx, "sync nickname of %v failed: %v", uid, err)
+	}
+
+	err = c.m.UpdateUserExt(uid, "", directoryUser.Email, "")
+	if err != nil {
+		c.logger.Errorf(ctx, "sync email of %v failed: %v", uid, err)
+	}
+
+	if !directoryUser.SyncAdmin {
+		return
+	}
+
+	privileges := 0
+	if directoryUser.Admin {
+		privileges = 1
+	}
+
+	err = c.m.SetUserPrivileges(uid, privileges)
+	if err != nil {
+		c.logger.Errorf(ctx, "sync privileges of %v failed: %v", uid, err)
+	}
+}
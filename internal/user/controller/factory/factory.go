@@ -13,10 +13,13 @@ import (
 type Utils interface {
 	RandomString(n int, allowedChars ...[]rune) string
 	GetPeerIP(ctx context.Context) string
+	GetUserAgent(ctx context.Context) string
+	GetClientType(ctx context.Context) string
 }
 
+// HTTPToken sets the access token and refresh token cookies, refreshToken can be empty
 type HTTPToken interface {
-	SetUserTokenCookie(ctx context.Context, token string) error
+	SetUserTokenCookie(ctx context.Context, token, refreshToken string) error
 	UnsetUserTokenCookie(ctx context.Context, token string) error
 }
 
@@ -51,5 +54,6 @@ func (impl *factoryImpl) GetUtils() Utils {
 }
 
 func (impl *factoryImpl) GetHTTPToken() HTTPToken {
-	return NewHTTPToken(impl.cfg.Token.Domain, int(impl.cfg.Token.Expire/time.Second))
+	return NewHTTPToken(impl.cfg.Token.Domain, int(impl.cfg.Token.AccessExpire/time.Second),
+		int(impl.cfg.Token.MaxLifetime/time.Second))
 }
@@ -12,43 +12,46 @@ import (
 )
 
 type httpTokenImpl struct {
-	domain       string
-	cookieMaxAge int
+	domain              string
+	cookieMaxAge        int
+	refreshCookieMaxAge int
 }
 
-func NewHTTPToken(domain string, cookieMaxAge int) HTTPToken {
+func NewHTTPToken(domain string, cookieMaxAge, refreshCookieMaxAge int) HTTPToken {
 	return &httpTokenImpl{
-		domain:       domain,
-		cookieMaxAge: cookieMaxAge,
+		domain:              domain,
+		cookieMaxAge:        cookieMaxAge,
+		refreshCookieMaxAge: refreshCookieMaxAge,
 	}
 }
 
-func (impl *httpTokenImpl) SetUserTokenCookie(ctx context.Context, token string) error {
-	domain := impl.domainFromContext(ctx)
-	domain = strings.Trim(domain, " \r\n\t")
-
-	if domain == "" {
-		domain = impl.domain
-	}
+func (impl *httpTokenImpl) SetUserTokenCookie(ctx context.Context, token, refreshToken string) error {
+	domain := impl.cookieDomain(ctx)
 
-	cookie := http.Cookie{
+	cookies := []string{"Set-Cookie", (&http.Cookie{
 		Domain:   domain,
 		Name:     user.SignCookieName,
 		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
-		MaxAge:   impl.cookieMaxAge}
+		MaxAge:   impl.cookieMaxAge}).String()}
+
+	if refreshToken != "" {
+		cookies = append(cookies, "Set-Cookie", (&http.Cookie{
+			Domain:   domain,
+			Name:     user.RefreshCookieName,
+			Value:    refreshToken,
+			Path:     "/",
+			HttpOnly: true,
+			MaxAge:   impl.refreshCookieMaxAge}).String())
+	}
 
-	return grpc.SendHeader(ctx, metadata.Pairs("Set-Cookie", cookie.String()))
+	// headers can be sent only once, so both cookies go together
+	return grpc.SendHeader(ctx, metadata.Pairs(cookies...))
 }
 
 func (impl *httpTokenImpl) UnsetUserTokenCookie(ctx context.Context, token string) error {
-	domain := impl.domainFromContext(ctx)
-	domain = strings.Trim(domain, " \r\n\t")
-
-	if domain == "" {
-		domain = impl.domain
-	}
+	domain := impl.cookieDomain(ctx)
 
 	cookie := http.Cookie{
 		Domain:   domain,
@@ -58,7 +61,25 @@ func (impl *httpTokenImpl) UnsetUserTokenCookie(ctx context.Context, token strin
 		HttpOnly: true,
 		Expires:  time.Now().AddDate(-1, 0, 0)}
 
-	return grpc.SendHeader(ctx, metadata.Pairs("Set-Cookie", cookie.String()))
+	refreshCookie := http.Cookie{
+		Domain:   domain,
+		Name:     user.RefreshCookieName,
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  time.Now().AddDate(-1, 0, 0)}
+
+	return grpc.SendHeader(ctx, metadata.Pairs("Set-Cookie", cookie.String(), "Set-Cookie", refreshCookie.String()))
+}
+
+func (impl *httpTokenImpl) cookieDomain(ctx context.Context) string {
+	domain := impl.domainFromContext(ctx)
+	domain = strings.Trim(domain, " \r\n\t")
+
+	if domain == "" {
+		domain = impl.domain
+	}
+
+	return domain
 }
 
 func (impl *httpTokenImpl) domainFromContext(ctx context.Context) string {
@@ -2,24 +2,38 @@ package controller
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base32"
 	"fmt"
+	"time"
 
 	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/controller/totp"
 	"github.com/sbasestarter/user/internal/utils"
-	"github.com/sgostarter/libeasygo/authenticator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func gaSecretKeyRedisKey(userID int64) string {
 	return fmt.Sprintf("ga_key_%v", userID)
 }
 
+func gaUsedCodeRedisKey(userID int64, counter int64) string {
+	return fmt.Sprintf("ga_used_%v_%v", userID, counter)
+}
+
+// gaSecretContext binds a sealed secret to its owner, so it can't be copied to another user
+func gaSecretContext(userID int64) string {
+	return fmt.Sprintf("ga_key_%v", userID)
+}
+
 func (c *Controller) gaNewSecretQRCode(ctx context.Context, userID int64, userName string) (qrCode string, err error) {
-	key, err := genGoogleAuthKey()
+	key, err := totp.GenerateSecret()
 	if err != nil {
-		c.logger.Errorf(ctx, "genGoogleAuthKey failed: %v", err)
+		c.logger.Errorf(ctx, "generate totp secret failed: %v", err)
+
+		return
+	}
+
+	sealedKey, err := c.secrets.Seal(key, gaSecretContext(userID))
+	if err != nil {
+		c.logger.Errorf(ctx, "seal totp secret failed: %v", err)
 
 		return
 	}
@@ -29,7 +43,7 @@ func (c *Controller) gaNewSecretQRCode(ctx context.Context, userID int64, userNa
 	c.logger.Infof(ctx, "key expire %v", keyExpire)
 
 	utils.DefRedisTimeoutOp(func(ctx context.Context) {
-		_, err = c.redis.Set(ctx, gaSecretKeyRedisKey(userID), key, keyExpire).Result()
+		_, err = c.redis.Set(ctx, gaSecretKeyRedisKey(userID), sealedKey, keyExpire).Result()
 	})
 
 	if err != nil {
@@ -38,16 +52,17 @@ func (c *Controller) gaNewSecretQRCode(ctx context.Context, userID int64, userNa
 		return
 	}
 
-	qrCode = authenticator.CreateGoogleAuthQRCodeData(key, userName, c.cfg.GoogleAuthenticator.Issuer)
+	qrCode = c.totp.URI(key, userName, c.cfg.GoogleAuthenticator.Issuer)
 
 	return
 }
 
-func (c *Controller) gaSetupWithCode(ctx context.Context, userID int64, code string) (status userpb.UserStatus, err error) {
-	var key string
+func (c *Controller) gaSetupWithCode(ctx context.Context, userID int64, code string) (status userpb.UserStatus,
+	recoveryCodes []string, err error) {
+	var sealedKey string
 
 	utils.DefRedisTimeoutOp(func(ctx context.Context) {
-		key, err = c.redis.Get(ctx, gaSecretKeyRedisKey(userID)).Result()
+		sealedKey, err = c.redis.Get(ctx, gaSecretKeyRedisKey(userID)).Result()
 	})
 
 	if err != nil {
@@ -58,7 +73,16 @@ func (c *Controller) gaSetupWithCode(ctx context.Context, userID int64, code str
 		return
 	}
 
-	ok, err := validateUser2FaCode(key, code)
+	key, err := c.secrets.Open(sealedKey, gaSecretContext(userID))
+	if err != nil {
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		c.logger.Errorf(ctx, "open totp secret failed: %v", err)
+
+		return
+	}
+
+	ok, err := c.validateUser2FaCode(ctx, userID, key, code)
 	if err != nil {
 		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
 
@@ -76,25 +100,45 @@ func (c *Controller) gaSetupWithCode(ctx context.Context, userID int64, code str
 		return
 	}
 
-	err = c.m.SetUser2FaKey(userID, key)
+	// the active key may be rotated after the pending secret was sealed
+	if c.secrets.NeedReseal(sealedKey) {
+		sealedKey, err = c.secrets.Seal(key, gaSecretContext(userID))
+		if err != nil {
+			status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+			c.logger.Errorf(ctx, "seal totp secret failed: %v", err)
+
+			return
+		}
+	}
+
+	recoveryCodes, hashes, err := c.newRecoveryCodes(ctx)
 	if err != nil {
 		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
 
+		return
+	}
+
+	// the key and the codes are set at once, a failure keeps the old key with its codes
+	err = c.m.SetUser2FaKeyWithRecoveryCodes(userID, sealedKey, hashes)
+	if err != nil {
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+		recoveryCodes = nil
+
 		c.logger.Errorf(ctx, "db set google auth key failed: %v", err)
 
 		return
 	}
 
+	// the key is set already, the pending one expires by itself
 	utils.DefRedisTimeoutOp(func(ctx context.Context) {
 		_, err = c.redis.Del(ctx, gaSecretKeyRedisKey(userID)).Result()
 	})
 
 	if err != nil {
-		c.logger.Errorf(ctx, "del %v failed: %v", gaSecretKeyRedisKey(userID), err)
-
-		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+		c.logger.Warnf(ctx, "del %v failed: %v", gaSecretKeyRedisKey(userID), err)
 
-		return
+		err = nil
 	}
 
 	status = userpb.UserStatus_USER_STATUS_SUCCESS
@@ -103,18 +147,25 @@ func (c *Controller) gaSetupWithCode(ctx context.Context, userID int64, code str
 }
 
 func (c *Controller) gaVerify(ctx context.Context, userID int64, code string) userpb.UserStatus {
-	key, err := c.m.GetUser2FaKey(userID)
+	sealedKey, err := c.m.GetUser2FaKey(userID)
 	if err != nil {
 		c.logger.Errorf(ctx, "user %v: %v", userID, err)
 
 		return userpb.UserStatus_USER_STATUS_FAILED
 	}
 
-	if key == "" {
+	if sealedKey == "" {
 		return userpb.UserStatus_USER_STATUS_NEED_2FA_SETUP
 	}
 
-	ok, err := validateUser2FaCode(key, code)
+	key, err := c.secrets.Open(sealedKey, gaSecretContext(userID))
+	if err != nil {
+		c.logger.Errorf(ctx, "open totp secret of %v failed: %v", userID, err)
+
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+	}
+
+	ok, err := c.validateUser2FaCode(ctx, userID, key, code)
 	if err != nil {
 		c.logger.Errorf(ctx, "validateUser2FaCode failed: %v", err)
 
@@ -122,6 +173,10 @@ func (c *Controller) gaVerify(ctx context.Context, userID int64, code string) us
 	}
 
 	if !ok {
+		if c.useRecoveryCode(ctx, userID, code) {
+			return userpb.UserStatus_USER_STATUS_SUCCESS
+		}
+
 		return userpb.UserStatus_USER_STATUS_WRONG_CODE
 	}
 
@@ -143,33 +198,27 @@ func (c *Controller) gaEnabled(ctx context.Context, userID int64) bool {
 	return userAuth.Token2fa != ""
 }
 
-//
-//
-//
-
-func genGoogleAuthKey() (key string, err error) {
-	b := make([]byte, 6)
-
-	_, err = rand.Read(b)
-	if err != nil {
+// validateUser2FaCode accepts code within the configured skew, and only once
+func (c *Controller) validateUser2FaCode(ctx context.Context, userID int64, key string, code string) (ok bool, err error) {
+	ok, counter, err := c.totp.Validate(key, code, time.Now())
+	if err != nil || !ok {
 		return
 	}
 
-	keyBytes, err := bcrypt.GenerateFromPassword(b, bcrypt.DefaultCost)
-	if err != nil {
-		return
-	}
+	// the used code can't match again after all steps around it passed
+	usedExpire := time.Duration(2*c.cfg.GoogleAuthenticator.Skew+1) * c.cfg.GoogleAuthenticator.Period
 
-	key = base32.StdEncoding.EncodeToString(keyBytes)
-
-	return
-}
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		ok, err = c.redis.SetNX(ctx, gaUsedCodeRedisKey(userID, counter), time.Now().String(), usedExpire).Result()
+	})
 
-func validateUser2FaCode(key string, code string) (ok bool, err error) {
-	expect, err := authenticator.MakeGoogleAuthenticatorForNow(key)
 	if err != nil {
 		return
 	}
 
-	return expect == code, nil
+	if !ok {
+		c.logger.Warnf(ctx, "user %v replayed 2fa code of step %v", userID, counter)
+	}
+
+	return
 }
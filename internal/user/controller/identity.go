@@ -0,0 +1,280 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+var errAutoLoginUser = errors.New("user of auto login")
+
+// fixAndVerifyIdentityToken is fixAndVerifySessionToken for the calls on the identities of a user, users of
+// auto login have the id of user source, which isn't a user id
+func (c *Controller) fixAndVerifyIdentityToken(ctx context.Context, token string) (
+	status userpb.UserStatus, authInfo *AuthInfo, err error) {
+	status, authInfo, err = c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	if authInfo.UserSourceIDFlag {
+		c.logger.Warnf(ctx, "user source %v is not registered", authInfo.UserID)
+
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+		err = errAutoLoginUser
+	}
+
+	return
+}
+
+// verifyLinkedLogin checks a user signing in by a linked identity of auto login as the password path does,
+// the user mustn't be locked and signs in with the second factor of it
+func (c *Controller) verifyLinkedLogin(ctx context.Context, userID int64, codeForGa, webAuthnAssertion string) (
+	status userpb.UserStatus, err error) {
+	if c.cfg.LoginLockout.Enable {
+		ip := c.utils.GetPeerIP(ctx)
+
+		remain := c.loginLockRemain(ctx, lockoutTargets(userID, ip))
+		if remain > 0 {
+			c.logger.Warnf(ctx, "user %v from %v locked, remain %v", userID, ip, remain)
+
+			status = userpb.UserStatus_USER_STATUS_LOCKED
+			err = &lockedError{remain: remain}
+
+			return
+		}
+	}
+
+	if webAuthnAssertion != "" {
+		status, _, err = c.webAuthnVerifyLogin(ctx, userID, webAuthnAssertion)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			c.logger.Errorf(ctx, "check webauthn assertion failed: %v, %v", status, err)
+		}
+
+		return
+	}
+
+	if codeForGa != "" {
+		status = c.gaVerify(ctx, userID, codeForGa)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			c.logger.Errorf(ctx, "check 2fa failed: %v", status)
+		}
+
+		return
+	}
+
+	if c.cfg.GoogleAuthenticator.Enable {
+		var key string
+
+		key, err = c.m.GetUser2FaKey(userID)
+		if err != nil {
+			c.logger.Errorf(ctx, "GetUser2FaKey failed: %v", err)
+
+			status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+			return
+		}
+
+		if key != "" {
+			c.logger.Errorf(ctx, "should use 2fa: %v", userID)
+
+			status = userpb.UserStatus_USER_STATUS_NEED_2FA_AUTH
+
+			return
+		}
+	}
+
+	if c.webAuthnEnabled(ctx, userID) {
+		c.logger.Errorf(ctx, "should use webauthn: %v", userID)
+
+		status = userpb.UserStatus_USER_STATUS_NEED_2FA_AUTH
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// ListIdentities returns the user ids the user signs in by
+func (c *Controller) ListIdentities(ctx context.Context, token string) (status userpb.UserStatus,
+	identities []*userpb.UserId, err error) {
+	status, authInfo, err := c.fixAndVerifyIdentityToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	userSources, err := c.m.GetUserSources(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get user sources of %v failed: %v", authInfo.UserID, err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	for _, userSource := range userSources {
+		identities = append(identities, &userpb.UserId{
+			UserName: userSource.UserName,
+			UserVe:   userSource.UserVe,
+		})
+	}
+
+	return
+}
+
+// LinkIdentity lets the user sign in by identity too. codeForVe is the code sent by TriggerAuth with the link
+// purpose, or the code of auto login for the ves which sign in by it. The user is checked again by password
+// or codeForGa
+func (c *Controller) LinkIdentity(ctx context.Context, token, csrfToken string, identity *userpb.UserId,
+	codeForVe, password, codeForGa string) (status userpb.UserStatus, err error) {
+	if identity == nil || identity.UserVe == "" || codeForVe == "" {
+		c.logger.Errorf(ctx, "invalid input: %+v", identity)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	status, authInfo, err := c.fixAndVerifyIdentityToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	status, err = c.verifyReauth(ctx, authInfo.UserID, password, codeForGa)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "reauth failed: %v, %v", status, err)
+
+		return
+	}
+
+	// users of a directory are created by their first login and follow the directory
+	if c.authPlugins.IsPasswordVe(identity) {
+		c.logger.Warnf(ctx, "link directory user %v refused", identity.UserName)
+
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+
+		return
+	}
+
+	status, fixedUser, _, _ := c.authPlugins.TryAutoLogin(ctx, identity, codeForVe)
+	autoLogin := status == userpb.UserStatus_USER_STATUS_SUCCESS
+
+	if !autoLogin {
+		if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT && status != userpb.UserStatus_USER_STATUS_NOT_IMPLEMENT {
+			c.logger.Errorf(ctx, "auto login failed: %v", status)
+
+			return
+		}
+
+		status, fixedUser, err = c.authPlugins.FixUserID(ctx, identity)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			return
+		}
+
+		status, err = c.checkVe(fixedUser, codeForVe, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LINK)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			c.logger.Errorf(ctx, "check ve failed: %v, %v", status, err)
+
+			return
+		}
+	}
+
+	status, err = c.m.LinkUserSource(authInfo.UserID, fixedUser.UserName, fixedUser.UserVe)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "link %v of %v to %v failed: %v, %v", fixedUser.UserName, fixedUser.UserVe,
+			authInfo.UserID, status, err)
+
+		return
+	}
+
+	if !autoLogin {
+		c.removeVe(fixedUser, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LINK)
+	}
+
+	return
+}
+
+// UnlinkIdentity stops the user signing in by identity, the last identity of the user can't be unlinked
+func (c *Controller) UnlinkIdentity(ctx context.Context, token, csrfToken string, identity *userpb.UserId) (
+	status userpb.UserStatus, err error) {
+	if identity == nil || identity.UserVe == "" || identity.UserName == "" {
+		c.logger.Errorf(ctx, "invalid input: %+v", identity)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	status, authInfo, err := c.fixAndVerifyIdentityToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	// the identities are listed as they are stored, so they are unlinked as they are given
+	status, err = c.m.UnlinkUserSource(authInfo.UserID, identity.UserName, identity.UserVe)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "unlink %v of %v from %v failed: %v, %v", identity.UserName, identity.UserVe,
+			authInfo.UserID, status, err)
+	}
+
+	return
+}
+
+// mergeUsers moves the identities and credentials of fromUserID to userID and deletes fromUserID, whose
+// sessions are revoked
+func (c *Controller) mergeUsers(ctx context.Context, userID, fromUserID, adminUserID int64) (
+	status userpb.UserStatus, err error) {
+	if fromUserID == userID || fromUserID == adminUserID {
+		c.logger.Errorf(ctx, "try to merge %v into %v by %v", fromUserID, userID, adminUserID)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	_, err = c.m.GetUserInfo(fromUserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "search user by id %v failed: %v", fromUserID, err)
+
+		status = userpb.UserStatus_USER_STATUS_USER_NOT_EXISTS
+
+		return
+	}
+
+	err = c.m.MergeUsers(userID, fromUserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "merge %v into %v failed: %v", fromUserID, userID, err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	_, err = c.removeAllSessions(ctx, fromUserID, "")
+	if err != nil {
+		c.logger.Warnf(ctx, "remove sessions of merged user %v failed: %v", fromUserID, err)
+
+		err = nil
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
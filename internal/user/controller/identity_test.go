@@ -0,0 +1,413 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sbasestarter/user/internal/user/controller/plugins"
+	"github.com/sbasestarter/user/internal/user/controller/totp"
+)
+
+const (
+	testOpenID  = "o1"
+	testUnionID = "u1"
+)
+
+var testWxMinAUser = &userpb.UserId{UserVe: userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_WX_MINA.String()}
+
+// enableTestWxMinA signs mini program users in by a stub of WeChat, every js_code but "bad" is of testUnionID
+func enableTestWxMinA(t *testing.T, c *Controller) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("js_code") == "bad" {
+			_, _ = w.Write([]byte(`{"errcode":40029,"errmsg":"invalid code"}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"openid":"` + testOpenID + `","unionid":"` + testUnionID + `","session_key":"tiihtNczf5v6AKRyjwEUhQ=="}`))
+	}))
+	t.Cleanup(server.Close)
+
+	c.cfg.WxMinA = config.WxMinAConfig{
+		Enable:        true,
+		AppID:         "app",
+		AppSecret:     "secret",
+		BaseURL:       server.URL,
+		IdentityField: config.WxMinAIdentityUnionID,
+	}
+	c.authPlugins = plugins.NewPlugins(c.cfg, c.cliFactory, nil)
+}
+
+// enableTestGA sets a 2fa key for userID, the codes of it are made by the returned func
+func enableTestGA(t *testing.T, c *Controller, userID int64) func() string {
+	t.Helper()
+
+	c.cfg.GoogleAuthenticator.Enable = true
+
+	key, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealedKey, err := c.secrets.Seal(key, gaSecretContext(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.m.SetUser2FaKey(userID, sealedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func() string {
+		code, err := c.totp.GenerateAt(key, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return code
+	}
+}
+
+func TestLogin_LinkedIdentity(t *testing.T) {
+	c, _, _ := newTestController(t)
+	enableTestWxMinA(t, c)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+
+	status, _ := c.m.LinkUserSource(userID, testUnionID, testWxMinAUser.UserVe)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("link failed: %v", status)
+	}
+
+	status, tokens, _, _, err := c.Login(ctx, testWxMinAUser, "", "code", "", "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login failed: %v, %v", status, err)
+	}
+
+	authInfo, err := c.verifyToken(ctx, tokens.AccessToken)
+	if err != nil || authInfo.UserID != userID || authInfo.UserSourceIDFlag {
+		t.Fatalf("login as %+v, %v", authInfo, err)
+	}
+
+	gaCode := enableTestGA(t, c, userID)
+
+	status, tokens, _, _, _ = c.Login(ctx, testWxMinAUser, "", "code", "", "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_NEED_2FA_AUTH || tokens != nil {
+		t.Fatalf("login without 2fa code: %v", status)
+	}
+
+	status, tokens, _, _, _ = c.Login(ctx, testWxMinAUser, "", "code", "000000", "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE || tokens != nil {
+		t.Fatalf("login with wrong 2fa code: %v", status)
+	}
+
+	c.cfg.LoginLockout = config.LoginLockoutConfig{
+		Enable:          true,
+		UserMaxFailures: 1,
+		IPMaxFailures:   10,
+		FailureWindow:   time.Minute,
+		BaseLock:        time.Minute,
+		MaxLock:         time.Hour,
+	}
+
+	status, _ = c.verifyPasswordWithLockout(ctx, userID, "wrong")
+	if status != userpb.UserStatus_USER_STATUS_LOCKED {
+		t.Fatalf("user not locked: %v", status)
+	}
+
+	status, tokens, _, _, _ = c.Login(ctx, testWxMinAUser, "", "code", gaCode(), "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_LOCKED || tokens != nil {
+		t.Fatalf("login of locked user: %v", status)
+	}
+
+	if err = c.unlockUser(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the refused login above didn't use the code up
+	status, _, _, _, err = c.Login(ctx, testWxMinAUser, "", "code", gaCode(), "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login with 2fa code failed: %v, %v", status, err)
+	}
+}
+
+// newTestCsrfToken returns a csrf token for one call on the session of token
+func newTestCsrfToken(t *testing.T, c *Controller, token string) string {
+	t.Helper()
+
+	status, csrfToken, err := c.GetCsrfToken(context.Background(), token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("get csrf token failed: %v, %v", status, err)
+	}
+
+	return csrfToken
+}
+
+// identitiesOf lists the identities of the user of token as "ve:name"
+func identitiesOf(t *testing.T, c *Controller, token string) []string {
+	t.Helper()
+
+	status, identities, err := c.ListIdentities(context.Background(), token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("list identities failed: %v, %v", status, err)
+	}
+
+	names := make([]string, 0, len(identities))
+	for _, identity := range identities {
+		names = append(names, identity.UserVe+":"+identity.UserName)
+	}
+
+	return names
+}
+
+func TestLinkIdentity(t *testing.T) {
+	c, _, postClient := newTestController(t)
+	enableTestWxMinA(t, c)
+	ctx := context.Background()
+
+	// the first login leaves a source of no user, which is taken over by the link
+	status, orphanTokens, _, _, err := c.Login(ctx, testWxMinAUser, "", "code", "", "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login of no user failed: %v, %v", status, err)
+	}
+
+	status, _, _ = c.ListIdentities(ctx, orphanTokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("identities of no user: %v", status)
+	}
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	_, tokens := newTestSession(t, c, userID, "")
+
+	for _, password := range []string{"", "password2"} {
+		status, _ = c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+			testWxMinAUser, "code", password, "")
+		if status != userpb.UserStatus_USER_STATUS_WRONG_PASSWORD {
+			t.Fatalf("link with password %q: %v", password, status)
+		}
+	}
+
+	status, _ = c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		testWxMinAUser, "bad", "password1", "")
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("link with bad code: %v", status)
+	}
+
+	status, err = c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		testWxMinAUser, "code", "password1", "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("link failed: %v, %v", status, err)
+	}
+
+	phoneUser := &userpb.UserId{
+		UserName: "13800000000",
+		UserVe:   userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_PHONE.String(),
+	}
+
+	status, err = c.TriggerAuth(ctx, phoneUser, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LINK)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("trigger auth failed: %v, %v", status, err)
+	}
+
+	status, _ = c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		phoneUser, "000000", "password1", "")
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("link with wrong code: %v", status)
+	}
+
+	status, err = c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		phoneUser, postClient.code(phoneUser.UserName), "password1", "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("link by code failed: %v, %v", status, err)
+	}
+
+	// the source taken over is the one of the first login, which was there before the user
+	want := []string{
+		"VERIFICATION_EQUIPMENT_WX_MINA:" + testUnionID,
+		"VERIFICATION_EQUIPMENT_MAIL:abc@web.com",
+		"VERIFICATION_EQUIPMENT_PHONE:+8613800000000",
+	}
+	if got := identitiesOf(t, c, tokens.AccessToken); !reflect.DeepEqual(got, want) {
+		t.Fatalf("identities %v, want %v", got, want)
+	}
+
+	status, tokens, _, _, err = c.Login(ctx, testWxMinAUser, "", "code", "", "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login by linked identity failed: %v, %v", status, err)
+	}
+
+	authInfo, err := c.verifyToken(ctx, tokens.AccessToken)
+	if err != nil || authInfo.UserID != userID {
+		t.Fatalf("login by linked identity as %+v, %v", authInfo, err)
+	}
+
+	// the identity of a user isn't taken by another one
+	otherUserID := newTestUser(t, c, "other@web.com", "password1")
+	_, otherTokens := newTestSession(t, c, otherUserID, "")
+
+	status, _ = c.LinkIdentity(ctx, otherTokens.AccessToken, newTestCsrfToken(t, c, otherTokens.AccessToken),
+		testWxMinAUser, "code", "password1", "")
+	if status != userpb.UserStatus_USER_STATUS_USER_ALREADY_EXISTS {
+		t.Fatalf("link identity of another user: %v", status)
+	}
+}
+
+func TestLinkIdentity_GA(t *testing.T) {
+	c, _, _ := newTestController(t)
+	enableTestWxMinA(t, c)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	_, tokens := newTestSession(t, c, userID, "")
+
+	gaCode := enableTestGA(t, c, userID)
+
+	status, _ := c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		testWxMinAUser, "code", "", "")
+	if status != userpb.UserStatus_USER_STATUS_NEED_2FA_AUTH {
+		t.Fatalf("link without 2fa code: %v", status)
+	}
+
+	status, _ = c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		testWxMinAUser, "code", "", "000000")
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("link with wrong 2fa code: %v", status)
+	}
+
+	status, err := c.LinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		testWxMinAUser, "code", "", gaCode())
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("link with 2fa code failed: %v, %v", status, err)
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	_, tokens := newTestSession(t, c, userID, "")
+
+	status, _ := c.m.LinkUserSource(userID, testUnionID, testWxMinAUser.UserVe)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("link failed: %v", status)
+	}
+
+	wxUser := &userpb.UserId{UserName: testUnionID, UserVe: testWxMinAUser.UserVe}
+
+	status, _ = c.UnlinkIdentity(ctx, tokens.AccessToken, "", wxUser)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("unlink without csrf token: %v", status)
+	}
+
+	status, _ = c.UnlinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		&userpb.UserId{UserName: "o2", UserVe: wxUser.UserVe})
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("unlink identity of no one: %v", status)
+	}
+
+	// an identity of another user can't be unlinked either
+	otherUserID := newTestUser(t, c, "other@web.com", "password1")
+
+	status, _ = c.UnlinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		&userpb.UserId{UserName: "other@web.com", UserVe: userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String()})
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("unlink identity of user %v: %v", otherUserID, status)
+	}
+
+	status, err := c.UnlinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken), wxUser)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("unlink failed: %v, %v", status, err)
+	}
+
+	mailUser := &userpb.UserId{
+		UserName: "abc@web.com",
+		UserVe:   userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String(),
+	}
+
+	status, _ = c.UnlinkIdentity(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken), mailUser)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("unlink last identity: %v", status)
+	}
+
+	want := []string{"VERIFICATION_EQUIPMENT_MAIL:abc@web.com"}
+	if got := identitiesOf(t, c, tokens.AccessToken); !reflect.DeepEqual(got, want) {
+		t.Fatalf("identities %v, want %v", got, want)
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	adminUserID := newTestUser(t, c, "admin@web.com", "password1")
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	fromUserID := newTestUser(t, c, "from@web.com", "password2")
+
+	status, _ := c.m.LinkUserSource(fromUserID, testUnionID, testWxMinAUser.UserVe)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("link failed: %v", status)
+	}
+
+	fromSessionID, fromTokens := newTestSession(t, c, fromUserID, "")
+
+	cases := []struct {
+		name       string
+		fromUserID int64
+		status     userpb.UserStatus
+	}{
+		{"self", userID, userpb.UserStatus_USER_STATUS_BAD_INPUT},
+		{"admin", adminUserID, userpb.UserStatus_USER_STATUS_BAD_INPUT},
+		{"no user", fromUserID + 100, userpb.UserStatus_USER_STATUS_USER_NOT_EXISTS},
+	}
+
+	for _, tc := range cases {
+		if status, _ = c.mergeUsers(ctx, userID, tc.fromUserID, adminUserID); status != tc.status {
+			t.Fatalf("merge %v: %v, want %v", tc.name, status, tc.status)
+		}
+	}
+
+	status, err := c.mergeUsers(ctx, userID, fromUserID, adminUserID)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("merge failed: %v, %v", status, err)
+	}
+
+	_, tokens := newTestSession(t, c, userID, "")
+
+	want := []string{
+		"VERIFICATION_EQUIPMENT_MAIL:abc@web.com",
+		"VERIFICATION_EQUIPMENT_MAIL:from@web.com",
+		"VERIFICATION_EQUIPMENT_WX_MINA:" + testUnionID,
+	}
+	if got := identitiesOf(t, c, tokens.AccessToken); !reflect.DeepEqual(got, want) {
+		t.Fatalf("identities %v, want %v", got, want)
+	}
+
+	if _, err = c.m.GetUserInfo(fromUserID); err == nil {
+		t.Fatal("merged user kept")
+	}
+
+	if _, err = c.verifyToken(ctx, fromTokens.AccessToken); err == nil || !c.sessionRevoked(ctx, fromSessionID) {
+		t.Fatalf("session of merged user kept: %v", err)
+	}
+
+	// the merged identities sign in with the password of the user merged into
+	uid, err := c.m.GetUserIDBySource("from@web.com", userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String())
+	if err != nil || uid != userID {
+		t.Fatalf("merged identity of %v, %v", uid, err)
+	}
+
+	if status, _ = c.verifyPassword(ctx, uid, "password1"); status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("password of merged identity: %v", status)
+	}
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"context"
+	"strings"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+const maxIntrospectTokens = 100
+
+// IntrospectTokens tells the state of access tokens as RFC 7662, results are in the order of tokens.
+// Only service accounts with ScopeTokenIntrospect can call it
+func (c *Controller) IntrospectTokens(ctx context.Context, callerToken string, tokens []string) (
+	status userpb.UserStatus, results []*userpb.TokenIntrospection, err error) {
+	_, err = c.verifyServiceCaller(ctx, callerToken, ScopeTokenIntrospect)
+	if err != nil {
+		c.logger.Warnf(ctx, "introspect by %v: %v", c.utils.GetPeerIP(ctx), err)
+
+		status = userpb.UserStatus_USER_STATUS_UNAUTHENTICATED
+
+		return
+	}
+
+	if len(tokens) == 0 || len(tokens) > maxIntrospectTokens {
+		c.logger.Errorf(ctx, "invalid token count: %v", len(tokens))
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	results = make([]*userpb.TokenIntrospection, 0, len(tokens))
+
+	for _, token := range tokens {
+		results = append(results, c.introspectToken(ctx, token))
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// introspectToken doesn't touch the session and doesn't check the ip, the caller isn't the client of
+// the session. An inactive token tells nothing else
+func (c *Controller) introspectToken(ctx context.Context, token string) *userpb.TokenIntrospection {
+	inactive := &userpb.TokenIntrospection{
+		Active: false,
+	}
+
+	if isPersonalAccessToken(token) {
+		return c.introspectPersonalAccessToken(ctx, token, inactive)
+	}
+
+	if isServiceAccountKey(token) {
+		return c.introspectServiceAccountKey(ctx, token, inactive)
+	}
+
+	tc, err := c.parseToken(token)
+	if err != nil || kindOf(tc) != tokenKindAccess {
+		return inactive
+	}
+
+	var authInfo *AuthInfo
+
+	if c.cfg.Token.Stateless && tc.Session != nil {
+		if c.checkStatelessSession(ctx, tc) != nil {
+			return inactive
+		}
+
+		authInfo = tc.Session
+	} else {
+		authInfo, err = c.loadSession(ctx, tc.UserID, tc.SessionID, redisKeyForSession(tc.UserID, tc.SessionID))
+		if err != nil || checkClient(tc, authInfo) != nil {
+			return inactive
+		}
+
+		if authInfo.ParentSessionID != "" {
+			_, err = c.loadSession(ctx, authInfo.UserID, authInfo.ParentSessionID,
+				redisKeyForSession(authInfo.UserID, authInfo.ParentSessionID))
+			if err != nil {
+				return inactive
+			}
+		}
+	}
+
+	result := &userpb.TokenIntrospection{
+		Active:           true,
+		TokenType:        tokenKindAccess,
+		UserId:           authInfo.UserID,
+		SessionId:        authInfo.SessionID,
+		ParentSessionId:  authInfo.ParentSessionID,
+		ClientIp:         authInfo.ClientIP,
+		Exp:              tc.ExpiresAt,
+		Iat:              tc.IssuedAt,
+		Nbf:              tc.NotBefore,
+		Iss:              tc.Issuer,
+		Aud:              tc.Audience,
+		Jti:              tc.ID,
+		SessionExpiresAt: authInfo.ExpiresAt,
+		Scope:            strings.Join(authInfo.Scopes, " "),
+		ServiceAccountId: authInfo.ServiceAccountID,
+		ClientId:         authInfo.OIDCClientID,
+	}
+
+	// sessions of auto login have the id of user source, no user info
+	if !authInfo.UserSourceIDFlag {
+		userInfo, err := c.m.GetUserInfo(authInfo.UserID)
+		if err != nil {
+			c.logger.Errorf(ctx, "get user info %v failed: %v", authInfo.UserID, err)
+
+			return inactive
+		}
+
+		result.Privileges = int64(userInfo.Privileges)
+	}
+
+	return result
+}
+
+// introspectPersonalAccessToken doesn't record the use, the caller isn't the client of the token
+func (c *Controller) introspectPersonalAccessToken(ctx context.Context, token string,
+	inactive *userpb.TokenIntrospection) *userpb.TokenIntrospection {
+	pat, userInfo, err := c.lookupPersonalAccessToken(ctx, token)
+	if err != nil {
+		return inactive
+	}
+
+	return &userpb.TokenIntrospection{
+		Active:     true,
+		TokenType:  tokenTypePersonalAccess,
+		UserId:     pat.UserId,
+		Exp:        pat.ExpireAt.Unix(),
+		Iat:        pat.CreateAt.Unix(),
+		Iss:        c.cfg.Token.Issuer,
+		Scope:      pat.Scopes,
+		Privileges: int64(userInfo.Privileges),
+	}
+}
+
+// introspectServiceAccountKey doesn't record the use either, a service account has no user
+func (c *Controller) introspectServiceAccountKey(ctx context.Context, token string,
+	inactive *userpb.TokenIntrospection) *userpb.TokenIntrospection {
+	account, err := c.lookupServiceAccount(ctx, token)
+	if err != nil {
+		return inactive
+	}
+
+	result := &userpb.TokenIntrospection{
+		Active:           true,
+		TokenType:        tokenTypeServiceAccount,
+		Iat:              account.CreateAt.Unix(),
+		Iss:              c.cfg.Token.Issuer,
+		Scope:            account.Scopes,
+		ServiceAccountId: account.Id,
+	}
+
+	if account.ExpireAt != nil {
+		result.Exp = account.ExpireAt.Unix()
+	}
+
+	return result
+}
@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/model"
+)
+
+func newTestServiceAccount(t *testing.T, c *Controller, name string, ownerUserID int64, scopes ...string) string {
+	t.Helper()
+
+	apiKey, keyID, keyHash, err := newAPIKey(apiKeyKindServiceAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.m.AddServiceAccount(&model.ServiceAccount{
+		Name:        name,
+		OwnerUserId: ownerUserID,
+		Scopes:      strings.Join(scopes, " "),
+		KeyId:       keyID,
+		KeyHash:     keyHash,
+		CreateAt:    time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return apiKey
+}
+
+func TestIntrospectTokens_Caller(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	_, tokens := newTestSession(t, c, userID, "")
+
+	cases := []struct {
+		name   string
+		caller string
+		status userpb.UserStatus
+	}{
+		{"no caller", "", userpb.UserStatus_USER_STATUS_UNAUTHENTICATED},
+		{"access token", tokens.AccessToken, userpb.UserStatus_USER_STATUS_UNAUTHENTICATED},
+		{"no scope", newTestServiceAccount(t, c, "no-scope", userID), userpb.UserStatus_USER_STATUS_UNAUTHENTICATED},
+		{"other scope", newTestServiceAccount(t, c, "other-scope", userID, "other"),
+			userpb.UserStatus_USER_STATUS_UNAUTHENTICATED},
+		{"introspect scope", newTestServiceAccount(t, c, "introspect", userID, ScopeTokenIntrospect),
+			userpb.UserStatus_USER_STATUS_SUCCESS},
+	}
+
+	for _, tc := range cases {
+		status, results, _ := c.IntrospectTokens(ctx, tc.caller, []string{tokens.AccessToken})
+		if status != tc.status {
+			t.Fatalf("%v: status %v, want %v", tc.name, status, tc.status)
+		}
+
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS && len(results) > 0 {
+			t.Fatalf("%v: results returned to refused caller", tc.name)
+		}
+	}
+}
+
+func TestIntrospectTokens_Batch(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	caller := newTestServiceAccount(t, c, "introspect", userID, ScopeTokenIntrospect)
+
+	status, _, _ := c.IntrospectTokens(ctx, caller, nil)
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("no tokens: %v", status)
+	}
+
+	tooMany := make([]string, maxIntrospectTokens+1)
+
+	status, _, _ = c.IntrospectTokens(ctx, caller, tooMany)
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("%v tokens: %v", len(tooMany), status)
+	}
+
+	sessionID1, tokens1 := newTestSession(t, c, userID, "")
+	sessionID2, tokens2 := newTestSession(t, c, userID, "")
+
+	status, results, err := c.IntrospectTokens(ctx, caller, []string{tokens2.AccessToken, "bad", tokens1.AccessToken})
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(results) != 3 {
+		t.Fatalf("introspect failed: %v, %v, %v", status, len(results), err)
+	}
+
+	if !results[0].Active || results[0].SessionId != sessionID2 || results[0].UserId != userID ||
+		results[0].TokenType != tokenKindAccess {
+		t.Fatalf("unexpected first result: %+v", results[0])
+	}
+
+	if results[1].Active {
+		t.Fatalf("bad token active: %+v", results[1])
+	}
+
+	if !results[2].Active || results[2].SessionId != sessionID1 {
+		t.Fatalf("unexpected last result: %+v", results[2])
+	}
+
+	full := make([]string, maxIntrospectTokens)
+	for i := range full {
+		full[i] = tokens1.AccessToken
+	}
+
+	status, results, _ = c.IntrospectTokens(ctx, caller, full)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(results) != maxIntrospectTokens {
+		t.Fatalf("%v tokens: %v, %v", len(full), status, len(results))
+	}
+}
+
+func TestIntrospectTokens_Inactive(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	caller := newTestServiceAccount(t, c, "introspect", userID, ScopeTokenIntrospect)
+
+	sessionID, tokens := newTestSession(t, c, userID, "")
+
+	expired := c.newTokenClaims(userID, sessionID, tokenKindAccess, -time.Minute)
+
+	expiredToken, err := c.signToken(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stateless := range []bool{false, true} {
+		c.cfg.Token.Stateless = stateless
+
+		killedSessionID, killedTokens := newTestSession(t, c, userID, "")
+		c.removeSession(ctx, userID, killedSessionID)
+
+		inactive := []string{tokens.RefreshToken, expiredToken, killedTokens.AccessToken}
+
+		status, results, err := c.IntrospectTokens(ctx, caller, inactive)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(results) != len(inactive) {
+			t.Fatalf("stateless %v: introspect failed: %v, %v", stateless, status, err)
+		}
+
+		for i, result := range results {
+			if result.Active || result.UserId != 0 || result.SessionId != "" {
+				t.Fatalf("stateless %v: token %v active: %+v", stateless, i, result)
+			}
+		}
+	}
+}
+
+func TestIntrospectTokens_Client(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	caller := newTestServiceAccount(t, c, "introspect", userID, ScopeTokenIntrospect)
+	serviceKey := newTestServiceAccount(t, c, "service", userID, "a", "b")
+
+	oidcTokens := newTestOIDCSession(t, c, userID, "app")
+	_, sessionTokens := newTestSession(t, c, userID, "")
+
+	status, results, err := c.IntrospectTokens(ctx, caller,
+		[]string{oidcTokens.AccessToken, sessionTokens.AccessToken, serviceKey, serviceKey + "x"})
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(results) != 4 {
+		t.Fatalf("introspect failed: %v, %v", status, err)
+	}
+
+	if !results[0].Active || results[0].ClientId != "app" || results[0].Scope != "openid" ||
+		results[0].UserId != userID {
+		t.Fatalf("unexpected result of oidc token: %+v", results[0])
+	}
+
+	if !results[1].Active || results[1].ClientId != "" || results[1].Scope != "" {
+		t.Fatalf("unexpected result of session token: %+v", results[1])
+	}
+
+	keyID, _, _ := parseAPIKey(apiKeyKindServiceAccount, serviceKey)
+
+	account, err := c.m.GetServiceAccountByKeyID(keyID)
+	if err != nil || account == nil {
+		t.Fatalf("get service account failed: %v", err)
+	}
+
+	if !results[2].Active || results[2].TokenType != tokenTypeServiceAccount ||
+		results[2].ServiceAccountId != account.Id || results[2].Scope != "a b" || results[2].UserId != 0 {
+		t.Fatalf("unexpected result of service account key: %+v", results[2])
+	}
+
+	if results[3].Active {
+		t.Fatalf("bad service account key active: %+v", results[3])
+	}
+}
@@ -0,0 +1,96 @@
+package ipbind
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type asnRange struct {
+	start net.IP
+	end   net.IP
+	asn   uint32
+}
+
+// ASNTable maps ip ranges to AS numbers
+type ASNTable struct {
+	ranges []asnRange
+}
+
+// LoadASNTable reads a tsv which has "range start, range end, AS number" as the first columns of each line,
+// like ip2asn-combined.tsv of iptoasn.com. Ranges of AS 0 are not routed and skipped
+func LoadASNTable(fileName string) (*ASNTable, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	t := &ASNTable{}
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("bad line %v in %v", lineNo, fileName)
+		}
+
+		start := net.ParseIP(fields[0]).To16()
+		end := net.ParseIP(fields[1]).To16()
+
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if start == nil || end == nil || err != nil || bytes.Compare(start, end) > 0 {
+			return nil, fmt.Errorf("bad line %v in %v", lineNo, fileName)
+		}
+
+		if asn == 0 {
+			continue
+		}
+
+		t.ranges = append(t.ranges, asnRange{start: start, end: end, asn: uint32(asn)})
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(t.ranges, func(i, j int) bool {
+		return bytes.Compare(t.ranges[i].start, t.ranges[j].start) < 0
+	})
+
+	return t, nil
+}
+
+// Lookup returns the AS number of ip, ok is false if ip is in no range
+func (t *ASNTable) Lookup(ip net.IP) (asn uint32, ok bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return
+	}
+
+	// the last range which starts at or before ip
+	idx := sort.Search(len(t.ranges), func(i int) bool {
+		return bytes.Compare(t.ranges[i].start, ip) > 0
+	}) - 1
+
+	if idx < 0 || bytes.Compare(ip, t.ranges[idx].end) > 0 {
+		return
+	}
+
+	return t.ranges[idx].asn, true
+}
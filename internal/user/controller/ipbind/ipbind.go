@@ -0,0 +1,132 @@
+package ipbind
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+// Binder decides whether a session signed in from an ip can be used from another one
+type Binder struct {
+	defaultRule config.IPBindingRule
+	clientRules map[string]config.IPBindingRule
+	ipv4Mask    net.IPMask
+	ipv6Mask    net.IPMask
+	asn         *ASNTable
+}
+
+func New(cfg *config.IPBindingConfig) (*Binder, error) {
+	b := &Binder{
+		defaultRule: config.IPBindingRule{
+			Mode:      cfg.Mode,
+			AuditOnly: cfg.AuditOnly,
+		},
+		clientRules: make(map[string]config.IPBindingRule),
+		ipv4Mask:    net.CIDRMask(cfg.IPv4Prefix, 8*net.IPv4len),
+		ipv6Mask:    net.CIDRMask(cfg.IPv6Prefix, 8*net.IPv6len),
+	}
+
+	if b.ipv4Mask == nil || b.ipv6Mask == nil {
+		return nil, fmt.Errorf("invalid prefix: %v, %v", cfg.IPv4Prefix, cfg.IPv6Prefix)
+	}
+
+	needASN := b.defaultRule.Mode == config.IPBindingASN
+
+	err := checkMode(b.defaultRule.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	for clientType, rule := range cfg.Clients {
+		if rule.Mode == "" {
+			rule.Mode = b.defaultRule.Mode
+		}
+
+		err = checkMode(rule.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("client %v: %w", clientType, err)
+		}
+
+		needASN = needASN || rule.Mode == config.IPBindingASN
+		b.clientRules[clientType] = rule
+	}
+
+	if needASN {
+		if cfg.ASNDatabaseFile == "" {
+			return nil, fmt.Errorf("%v mode needs an asn database", config.IPBindingASN)
+		}
+
+		b.asn, err = LoadASNTable(cfg.ASNDatabaseFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func checkMode(mode string) error {
+	switch mode {
+	case config.IPBindingOff, config.IPBindingSubnet, config.IPBindingASN, config.IPBindingStrict:
+		return nil
+	default:
+		return fmt.Errorf("unknown ip binding mode %q", mode)
+	}
+}
+
+// Rule returns the rule of clientType, or the default one
+func (b *Binder) Rule(clientType string) config.IPBindingRule {
+	if rule, ok := b.clientRules[clientType]; ok {
+		return rule
+	}
+
+	return b.defaultRule
+}
+
+// Match tells if ip satisfies the rule of clientType for a session bound to boundIP. Sessions without
+// an ip only match requests without one
+func (b *Binder) Match(clientType, boundIP, ip string) (rule config.IPBindingRule, ok bool) {
+	rule = b.Rule(clientType)
+
+	if rule.Mode == config.IPBindingOff {
+		return rule, true
+	}
+
+	netIP1 := net.ParseIP(boundIP)
+	netIP2 := net.ParseIP(ip)
+
+	if netIP1 == nil || netIP2 == nil {
+		return rule, netIP1 == nil && netIP2 == nil
+	}
+
+	if netIP1.Equal(netIP2) {
+		return rule, true
+	}
+
+	switch rule.Mode {
+	case config.IPBindingSubnet:
+		ok = b.sameSubnet(netIP1, netIP2)
+	case config.IPBindingASN:
+		ok = b.sameSubnet(netIP1, netIP2) || b.sameASN(netIP1, netIP2)
+	}
+
+	return
+}
+
+func (b *Binder) sameSubnet(ip1, ip2 net.IP) bool {
+	v4IP1, v4IP2 := ip1.To4(), ip2.To4()
+
+	if v4IP1 != nil || v4IP2 != nil {
+		return v4IP1 != nil && v4IP2 != nil && v4IP1.Mask(b.ipv4Mask).Equal(v4IP2.Mask(b.ipv4Mask))
+	}
+
+	return ip1.Mask(b.ipv6Mask).Equal(ip2.Mask(b.ipv6Mask))
+}
+
+func (b *Binder) sameASN(ip1, ip2 net.IP) bool {
+	asn1, ok1 := b.asn.Lookup(ip1)
+	asn2, ok2 := b.asn.Lookup(ip2)
+
+	return ok1 && ok2 && asn1 == asn2
+}
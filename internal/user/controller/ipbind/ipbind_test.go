@@ -0,0 +1,139 @@
+package ipbind
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const testASNData = `# range_start	range_end	AS_number	country_code	AS_description
+1.0.0.0	1.0.0.255	13335	US	CLOUDFLARENET
+10.0.0.0	10.0.255.255	64500	CN	MOBILE
+10.1.0.0	10.1.255.255	64500	CN	MOBILE
+10.2.0.0	10.2.255.255	0	None	Not routed
+2001:db8::	2001:db8:ffff:ffff:ffff:ffff:ffff:ffff	64501	CN	MOBILE6
+`
+
+func writeASNData(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ipbind")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	fileName := filepath.Join(dir, "asn.tsv")
+
+	err = ioutil.WriteFile(fileName, []byte(testASNData), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName
+}
+
+func TestASNTable(t *testing.T) {
+	table, err := LoadASNTable(writeASNData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip  string
+		asn uint32
+		ok  bool
+	}{
+		{"1.0.0.1", 13335, true},
+		{"1.0.1.1", 0, false},
+		{"10.0.3.4", 64500, true},
+		{"10.1.255.255", 64500, true},
+		{"10.2.0.1", 0, false},
+		{"0.0.0.1", 0, false},
+		{"2001:db8:1::1", 64501, true},
+		{"2001:db9::1", 0, false},
+	}
+
+	for _, c := range cases {
+		asn, ok := table.Lookup(net.ParseIP(c.ip))
+		if asn != c.asn || ok != c.ok {
+			t.Errorf("%v: expect %v %v, got %v %v", c.ip, c.asn, c.ok, asn, ok)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	b, err := New(&config.IPBindingConfig{
+		Mode: config.IPBindingStrict,
+		Clients: map[string]config.IPBindingRule{
+			"web":     {Mode: config.IPBindingSubnet},
+			"ios":     {Mode: config.IPBindingASN},
+			"tv":      {Mode: config.IPBindingOff},
+			"android": {AuditOnly: true},
+		},
+		IPv4Prefix:      24,
+		IPv6Prefix:      64,
+		ASNDatabaseFile: writeASNData(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		clientType string
+		ip1, ip2   string
+		ok         bool
+	}{
+		{"", "1.0.0.1", "1.0.0.1", true},
+		{"", "1.0.0.1", "1.0.0.2", false},
+		{"", "", "", true},
+		{"", "", "1.0.0.1", false},
+		{"unknown", "1.0.0.1", "1.0.0.2", false},
+		{"web", "1.0.0.1", "1.0.0.200", true},
+		{"web", "1.0.0.1", "1.0.1.1", false},
+		{"web", "2001:db8:1:2::1", "2001:db8:1:2::ff", true},
+		{"web", "2001:db8:1:2::1", "2001:db8:1:3::1", false},
+		{"web", "1.0.0.1", "::ffff:1.0.0.9", true},
+		{"web", "1.0.0.1", "2001:db8::1", false},
+		{"ios", "10.0.0.1", "10.1.2.3", true},
+		{"ios", "10.0.0.1", "1.0.0.1", false},
+		{"ios", "10.2.0.1", "10.2.1.1", false},
+		{"ios", "10.2.0.1", "10.2.0.9", true},
+		{"ios", "2001:db8:1::1", "2001:db8:2::1", true},
+		{"tv", "1.0.0.1", "10.0.0.1", true},
+		{"android", "1.0.0.1", "1.0.0.2", false},
+	}
+
+	for _, c := range cases {
+		_, ok := b.Match(c.clientType, c.ip1, c.ip2)
+		if ok != c.ok {
+			t.Errorf("%v %v %v: expect %v", c.clientType, c.ip1, c.ip2, c.ok)
+		}
+	}
+
+	rule := b.Rule("android")
+	if rule.Mode != config.IPBindingStrict || !rule.AuditOnly {
+		t.Fatalf("unexpected rule %+v", rule)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(&config.IPBindingConfig{Mode: "loose", IPv4Prefix: 24, IPv6Prefix: 64}); err == nil {
+		t.Fatal("unknown mode accepted")
+	}
+
+	_, err := New(&config.IPBindingConfig{
+		Mode:       config.IPBindingStrict,
+		Clients:    map[string]config.IPBindingRule{"ios": {Mode: config.IPBindingASN}},
+		IPv4Prefix: 24,
+		IPv6Prefix: 64,
+	})
+	if err == nil {
+		t.Fatal("asn mode accepted without database")
+	}
+}
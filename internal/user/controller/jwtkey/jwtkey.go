@@ -0,0 +1,214 @@
+package jwtkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown key id")
+	ErrAlgMismatch  = errors.New("algorithm doesn't match the key")
+	ErrNoSigningKey = errors.New("no signing key")
+)
+
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// Set signs tokens with its signing key and verifies them with the key named by their kid header.
+// Tokens without kid are the HS256 ones signed by the shared secret
+type Set struct {
+	signing *key
+	keys    map[string]*key
+	secret  []byte
+}
+
+// New loads keys, signingKeyID selects the signing one. HS256 with secret signs if signingKeyID is empty
+func New(secret, signingKeyID string, keys []config.TokenSigningKeyConfig) (*Set, error) {
+	s := &Set{
+		keys:   make(map[string]*key),
+		secret: []byte(secret),
+	}
+
+	for idx := range keys {
+		k, err := loadKey(&keys[idx])
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := s.keys[k.id]; ok {
+			return nil, fmt.Errorf("duplicated key id %v", k.id)
+		}
+
+		s.keys[k.id] = k
+	}
+
+	if signingKeyID != "" {
+		k, ok := s.keys[signingKeyID]
+		if !ok {
+			return nil, fmt.Errorf("%w: signing %v", ErrUnknownKey, signingKeyID)
+		}
+
+		if k.private == nil {
+			return nil, fmt.Errorf("signing key %v has no private key", signingKeyID)
+		}
+
+		s.signing = k
+	}
+
+	return s, nil
+}
+
+func loadKey(cfg *config.TokenSigningKeyConfig) (*key, error) {
+	if cfg.KeyID == "" {
+		return nil, errors.New("empty key id")
+	}
+
+	data, err := ioutil.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read key %v: %w", cfg.KeyID, err)
+	}
+
+	k, err := parseKey(cfg.KeyID, cfg.Algorithm, data)
+	if err != nil {
+		return nil, fmt.Errorf("parse key %v: %w", cfg.KeyID, err)
+	}
+
+	return k, nil
+}
+
+func parseKey(id, alg string, data []byte) (*key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem block")
+	}
+
+	k := &key{
+		id: id,
+	}
+
+	var err error
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		k.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		k.private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		k.private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		k.private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported pem type %v", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the pointer forms are what jwt-go methods and x509 return
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		k.public = &private.PublicKey
+	case *ecdsa.PrivateKey:
+		k.public = &private.PublicKey
+	case ed25519.PrivateKey:
+		k.public = private.Public()
+	case nil:
+	default:
+		return nil, fmt.Errorf("unsupported private key %T", private)
+	}
+
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		if alg == AlgRS256 {
+			k.method = jwt.SigningMethodRS256
+		}
+	case *ecdsa.PublicKey:
+		if alg == AlgES256 && public.Curve == elliptic.P256() {
+			k.method = jwt.SigningMethodES256
+		}
+	case ed25519.PublicKey:
+		if alg == AlgEdDSA {
+			k.method = SigningMethodEdDSA
+		}
+	}
+
+	if k.method == nil {
+		return nil, fmt.Errorf("%w: %v, %T", ErrAlgMismatch, alg, k.public)
+	}
+
+	return k, nil
+}
+
+// Sign signs claims with the signing key and names it in the kid header
+func (s *Set) Sign(claims jwt.Claims) (string, error) {
+	if s.signing == nil {
+		if len(s.secret) == 0 {
+			return "", ErrNoSigningKey
+		}
+
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+
+	token := jwt.NewWithClaims(s.signing.method, claims)
+	token.Header["kid"] = s.signing.id
+
+	return token.SignedString(s.signing.private)
+}
+
+// Keyfunc is the jwt.Keyfunc of jwt.Parse, the algorithm of a token must be the one of its key
+func (s *Set) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if token.Method != jwt.SigningMethodHS256 || len(s.secret) == 0 {
+			return nil, ErrUnknownKey
+		}
+
+		return s.secret, nil
+	}
+
+	k, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownKey, kid)
+	}
+
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("%w: %v, %v", ErrAlgMismatch, token.Method.Alg(), kid)
+	}
+
+	return k.public, nil
+}
+
+// Asymmetric tells if tokens are signed by a key which can be published
+func (s *Set) Asymmetric() bool {
+	return s.signing != nil
+}
+
+// Alg is the algorithm tokens are signed by
+func (s *Set) Alg() string {
+	if s.signing == nil {
+		return jwt.SigningMethodHS256.Alg()
+	}
+
+	return s.signing.method.Alg()
+}
@@ -0,0 +1,303 @@
+package jwtkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+type testKeys struct {
+	dir  string
+	keys []config.TokenSigningKeyConfig
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	dir, err := ioutil.TempDir("", "jwtkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	return &testKeys{dir: dir}
+}
+
+func (tk *testKeys) add(t *testing.T, id, alg, pemType string, der []byte) {
+	fileName := filepath.Join(tk.dir, id+".pem")
+
+	err := ioutil.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk.keys = append(tk.keys, config.TokenSigningKeyConfig{KeyID: id, Algorithm: alg, KeyFile: fileName})
+}
+
+func (tk *testKeys) addPKCS8(t *testing.T, id, alg string, privateKey interface{}) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk.add(t, id, alg, "PRIVATE KEY", der)
+}
+
+func (tk *testKeys) addPublic(t *testing.T, id, alg string, publicKey interface{}) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk.add(t, id, alg, "PUBLIC KEY", der)
+}
+
+func verify(s *Set, token string) (*jwt.StandardClaims, error) {
+	var claims jwt.StandardClaims
+
+	_, err := jwt.ParseWithClaims(token, &claims, s.Keyfunc)
+
+	return &claims, err
+}
+
+func TestSignAndVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk := newTestKeys(t)
+	tk.add(t, "rsa", AlgRS256, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	tk.addPKCS8(t, "ec", AlgES256, ecKey)
+	tk.addPKCS8(t, "ed", AlgEdDSA, edKey)
+
+	for _, kid := range []string{"rsa", "ec", "ed"} {
+		s, err := New("", kid, tk.keys)
+		if err != nil {
+			t.Fatalf("%v: %v", kid, err)
+		}
+
+		token, err := s.Sign(&jwt.StandardClaims{Subject: kid})
+		if err != nil {
+			t.Fatalf("%v: %v", kid, err)
+		}
+
+		parsed, _ := jwt.Parse(token, s.Keyfunc)
+		if parsed == nil || parsed.Header["kid"] != kid {
+			t.Fatalf("%v: no kid header", kid)
+		}
+
+		if parsed.Header["alg"] != s.Alg() {
+			t.Fatalf("%v: alg %v, expect %v", kid, parsed.Header["alg"], s.Alg())
+		}
+
+		claims, err := verify(s, token)
+		if err != nil || claims.Subject != kid {
+			t.Fatalf("%v: verify failed: %v", kid, err)
+		}
+
+		// the other keys still verify it, as after a rotation
+		other, _ := New("", "ec", tk.keys)
+
+		if _, err = verify(other, token); err != nil {
+			t.Fatalf("%v: verify by other signing key failed: %v", kid, err)
+		}
+	}
+}
+
+func TestSecretTokens(t *testing.T) {
+	s, err := New("secret", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.Sign(&jwt.StandardClaims{Subject: "hs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = verify(s, token); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Asymmetric() || s.Alg() != jwt.SigningMethodHS256.Alg() {
+		t.Fatalf("unexpected alg %v", s.Alg())
+	}
+
+	if _, err = verify(&Set{keys: map[string]*key{}}, token); err == nil {
+		t.Fatal("secret token verified without secret")
+	}
+
+	if _, err = New("", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = (&Set{}).Sign(&jwt.StandardClaims{}); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestRejectForgedTokens(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk := newTestKeys(t)
+	tk.addPKCS8(t, "rsa", AlgRS256, rsaKey)
+
+	s, err := New("secret", "rsa", tk.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HS256 keyed by the published public key, with the kid of the RSA key
+	publicDer, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{Subject: "forged"})
+	forged.Header["kid"] = "rsa"
+
+	token, _ := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}))
+	if _, err = verify(s, token); err == nil {
+		t.Fatal("algorithm confusion accepted")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.StandardClaims{Subject: "unknown"})
+	unknown.Header["kid"] = "nope"
+
+	token, _ = unknown.SignedString(rsaKey)
+	if _, err = verify(s, token); err == nil {
+		t.Fatal("unknown kid accepted")
+	}
+
+	// kid-less tokens may only be HS256
+	noKid, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.StandardClaims{}).SignedString(rsaKey)
+	if _, err = verify(s, noKid); err == nil {
+		t.Fatal("RS256 token without kid accepted")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	tk := newTestKeys(t)
+	tk.addPKCS8(t, "ec", AlgRS256, ecKey)
+
+	if _, err := New("", "", tk.keys); !errors.Is(err, ErrAlgMismatch) {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	tk = newTestKeys(t)
+	tk.addPKCS8(t, "p384", AlgES256, p384Key)
+
+	if _, err := New("", "", tk.keys); !errors.Is(err, ErrAlgMismatch) {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	tk = newTestKeys(t)
+	tk.addPublic(t, "ec", AlgES256, &ecKey.PublicKey)
+
+	if _, err := New("", "missing", tk.keys); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	if _, err := New("", "ec", tk.keys); err == nil {
+		t.Fatal("public key used to sign")
+	}
+
+	if _, err := New("", "", append(tk.keys, tk.keys[0])); err == nil {
+		t.Fatal("duplicated key id accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tk := newTestKeys(t)
+	tk.addPKCS8(t, "c-ed", AlgEdDSA, edKey)
+	tk.addPKCS8(t, "b-ec", AlgES256, ecKey)
+	tk.addPublic(t, "a-rsa", AlgRS256, &rsaKey.PublicKey)
+
+	s, err := New("secret", "b-ec", tk.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := s.JWKS()
+	if len(jwks.Keys) != 3 || jwks.Keys[0].Kid != "a-rsa" || jwks.Keys[1].Kid != "b-ec" || jwks.Keys[2].Kid != "c-ed" {
+		t.Fatalf("unexpected keys %+v", jwks.Keys)
+	}
+
+	decode := func(s string) []byte {
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	rsaJWK := jwks.Keys[0]
+	if rsaJWK.Kty != "RSA" || rsaJWK.Alg != AlgRS256 || new(big.Int).SetBytes(decode(rsaJWK.N)).Cmp(rsaKey.N) != 0 ||
+		new(big.Int).SetBytes(decode(rsaJWK.E)).Int64() != int64(rsaKey.E) {
+		t.Fatalf("bad rsa jwk %+v", rsaJWK)
+	}
+
+	ecJWK := jwks.Keys[1]
+	if ecJWK.Kty != "EC" || ecJWK.Crv != "P-256" || len(decode(ecJWK.X)) != 32 || len(decode(ecJWK.Y)) != 32 {
+		t.Fatalf("bad ec jwk %+v", ecJWK)
+	}
+
+	// a downstream service verifies with the published key only
+	token, _ := s.Sign(&jwt.StandardClaims{Subject: "offline"})
+
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(decode(ecJWK.X)),
+		Y:     new(big.Int).SetBytes(decode(ecJWK.Y)),
+	}
+
+	_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edJWK := jwks.Keys[2]
+	if edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || string(decode(edJWK.X)) != string(edPublic) {
+		t.Fatalf("bad ed25519 jwk %+v", edJWK)
+	}
+
+	doc, err := s.JWKSJSON()
+	if err != nil || doc == "" {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,264 @@
+package keyring
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+// sealed values look like enc:v1:<key id>:<wrapped data key>:<ciphertext>, a data key is generated for each
+// value and wrapped by the master key, both parts are AES-256-GCM with the nonce prepended
+const (
+	sealedPrefix = "enc:v1:"
+	keySize      = 32
+)
+
+var (
+	ErrNoActiveKey = errors.New("no active key")
+	ErrUnknownKey  = errors.New("unknown key id")
+	ErrBadSealed   = errors.New("bad sealed value")
+)
+
+var sealedEncoding = base64.RawURLEncoding
+
+type Keyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// New loads master keys from cfg.Keys and cfg.KeyFile, the file has a "<key id> <base64 key>" on each line
+func New(cfg *config.SecretEncryptionConfig) (*Keyring, error) {
+	k := &Keyring{
+		activeID: cfg.ActiveKeyID,
+		keys:     make(map[string][]byte),
+	}
+
+	for id, key := range cfg.Keys {
+		err := k.addKey(id, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.KeyFile != "" {
+		err := k.loadKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if k.activeID != "" {
+		if _, ok := k.keys[k.activeID]; !ok {
+			return nil, fmt.Errorf("%w: active %v", ErrUnknownKey, k.activeID)
+		}
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) addKey(id, encodedKey string) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return fmt.Errorf("decode key %v: %w", id, err)
+	}
+
+	if len(key) != keySize {
+		return fmt.Errorf("key %v should be %v bytes", id, keySize)
+	}
+
+	k.keys[id] = key
+
+	return nil
+}
+
+func (k *Keyring) loadKeyFile(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("bad line in key file %v", fileName)
+		}
+
+		err = k.addKey(fields[0], fields[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Enabled tells if new values are sealed, otherwise they are stored as is
+func (k *Keyring) Enabled() bool {
+	return k.activeID != ""
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// KeyID returns the id of master key which sealed value
+func KeyID(value string) (string, bool) {
+	if !IsSealed(value) {
+		return "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedPrefix), ":", 2)
+
+	return parts[0], len(parts) == 2
+}
+
+// NeedReseal tells if value is not sealed by the active key
+func (k *Keyring) NeedReseal(value string) bool {
+	if value == "" || !k.Enabled() {
+		return false
+	}
+
+	id, _ := KeyID(value)
+
+	return id != k.activeID
+}
+
+func gcmSeal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func gcmOpen(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrBadSealed
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// Seal encrypts plaintext with the active key, context is bound to the value so it can't be moved to
+// another owner. plaintext is returned as is if no active key
+func (k *Keyring) Seal(plaintext, context string) (string, error) {
+	if !k.Enabled() || plaintext == "" {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, keySize)
+
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := gcmSeal(k.keys[k.activeID], dataKey, []byte(k.activeID+":"+context))
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := gcmSeal(dataKey, []byte(plaintext), []byte(context))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + k.activeID + ":" + sealedEncoding.EncodeToString(wrappedKey) + ":" +
+		sealedEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts value sealed for context, values which are not sealed are returned as is
+func (k *Keyring) Open(value, context string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", ErrBadSealed
+	}
+
+	masterKey, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %v", ErrUnknownKey, parts[0])
+	}
+
+	wrappedKey, err := sealedEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrBadSealed
+	}
+
+	ciphertext, err := sealedEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrBadSealed
+	}
+
+	dataKey, err := gcmOpen(masterKey, wrappedKey, []byte(parts[0]+":"+context))
+	if err != nil {
+		return "", ErrBadSealed
+	}
+
+	plaintext, err := gcmOpen(dataKey, ciphertext, []byte(context))
+	if err != nil {
+		return "", ErrBadSealed
+	}
+
+	return string(plaintext), nil
+}
+
+// Reseal opens value and seals it again with the active key
+func (k *Keyring) Reseal(value, context string) (string, error) {
+	if !k.Enabled() {
+		return "", ErrNoActiveKey
+	}
+
+	plaintext, err := k.Open(value, context)
+	if err != nil {
+		return "", err
+	}
+
+	return k.Seal(plaintext, context)
+}
@@ -0,0 +1,83 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), keySize)))
+}
+
+// nolint
+func TestKeyring_Rotation(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+
+	err := os.WriteFile(keyFile, []byte("# rotated keys\nk2 "+testKey('b')+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldRing, err := New(&config.SecretEncryptionConfig{
+		ActiveKeyID: "k1",
+		Keys:        map[string]string{"k1": testKey('a')},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := oldRing.Seal("JBSWY3DPEHPK3PXP", "ga_key_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := KeyID(sealed); id != "k1" || strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("unexpected sealed value %v", sealed)
+	}
+
+	if _, err = oldRing.Open(sealed, "ga_key_2"); !errors.Is(err, ErrBadSealed) {
+		t.Fatalf("Open() with other context: %v", err)
+	}
+
+	newRing, err := New(&config.SecretEncryptionConfig{
+		ActiveKeyID: "k2",
+		Keys:        map[string]string{"k1": testKey('a')},
+		KeyFile:     keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !newRing.NeedReseal(sealed) || !newRing.NeedReseal("JBSWY3DPEHPK3PXP") {
+		t.Fatal("NeedReseal() should be true for old key and plaintext")
+	}
+
+	resealed, err := newRing.Reseal(sealed, "ga_key_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newRing.NeedReseal(resealed) {
+		t.Fatal("NeedReseal() should be false for active key")
+	}
+
+	plaintext, err := newRing.Open(resealed, "ga_key_1")
+	if err != nil || plaintext != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Open() = %v, %v", plaintext, err)
+	}
+
+	if _, err = oldRing.Open(resealed, "ga_key_1"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open() without key: %v", err)
+	}
+
+	plaintext, err = newRing.Open("JBSWY3DPEHPK3PXP", "ga_key_1")
+	if err != nil || plaintext != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Open() of legacy plaintext = %v, %v", plaintext, err)
+	}
+}
@@ -16,6 +16,10 @@ func redisKeyForVeAuth(userName, category string) string {
 	return strings.Join([]string{category, userName}, "_")
 }
 
+func redisKeyForVeAuthCode(user *userpb.UserId, purpose userpb.TriggerAuthPurpose) string {
+	return redisKeyForVeAuth(redisUsername(user)+"_"+purpose.String(), keyCatAuthCode)
+}
+
 func redisUsername(user *userpb.UserId) string {
 	return fmt.Sprintf("%v_%v", user.UserName, user.UserVe)
 }
@@ -36,6 +40,50 @@ func redisKeyForCsrfToken(tokenSessionID string) string {
 	return fmt.Sprintf("csrf_%s", tokenSessionID)
 }
 
+func redisKeyForSAMLSession(userID int64, sessionID string) string {
+	return fmt.Sprintf("saml_session_%v_%v", userID, sessionID)
+}
+
 func redisKeyForSessionIDParent(parentSessionID string) string {
 	return fmt.Sprintf("children:session_id:%v", parentSessionID)
 }
+
+func redisKeyForLoginFailures(target string) string {
+	return fmt.Sprintf("login_fail_%v", target)
+}
+
+func redisKeyForLoginLock(target string) string {
+	return fmt.Sprintf("login_lock_%v", target)
+}
+
+func redisKeyForLoginLockLevel(target string) string {
+	return fmt.Sprintf("login_lock_level_%v", target)
+}
+
+func redisKeyForWebAuthnRegistration(userID int64) string {
+	return fmt.Sprintf("webauthn_reg_%v", userID)
+}
+
+func redisKeyForWebAuthnLogin(challenge string) string {
+	return fmt.Sprintf("webauthn_login_%v", challenge)
+}
+
+func redisKeyForUserSessions(userID int64) string {
+	return fmt.Sprintf("user_sessions_%v", userID)
+}
+
+func redisKeyForUserSessionsLastUsed(userID int64) string {
+	return fmt.Sprintf("user_sessions_used_%v", userID)
+}
+
+func redisKeyForRefreshToken(userID int64, sessionID string) string {
+	return fmt.Sprintf("refresh_token_%v_%v", userID, sessionID)
+}
+
+func redisKeyForRevokedSessions() string {
+	return "revoked_sessions"
+}
+
+func redisKeyForOIDCCode(code string) string {
+	return fmt.Sprintf("oidc_code_%v", code)
+}
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/utils"
+)
+
+type lockedError struct {
+	remain time.Duration
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("retry_after=%d", int64((e.remain+time.Second-1)/time.Second))
+}
+
+func lockoutUserTarget(userID int64) string {
+	return fmt.Sprintf("user_%v", userID)
+}
+
+func lockoutIPTarget(ip string) string {
+	return fmt.Sprintf("ip_%v", ip)
+}
+
+func lockoutTargets(userID int64, ip string) []string {
+	targets := []string{lockoutUserTarget(userID)}
+
+	if ip != "" {
+		targets = append(targets, lockoutIPTarget(ip))
+	}
+
+	return targets
+}
+
+// verifyPasswordWithLockout wraps verifyPassword with failure counting per user and per client ip
+func (c *Controller) verifyPasswordWithLockout(ctx context.Context, userID int64, password string) (userpb.UserStatus, error) {
+	if !c.cfg.LoginLockout.Enable {
+		return c.verifyPassword(ctx, userID, password)
+	}
+
+	ip := c.utils.GetPeerIP(ctx)
+
+	remain := c.loginLockRemain(ctx, lockoutTargets(userID, ip))
+	if remain > 0 {
+		c.logger.Warnf(ctx, "user %v from %v locked, remain %v", userID, ip, remain)
+
+		return userpb.UserStatus_USER_STATUS_LOCKED, &lockedError{remain: remain}
+	}
+
+	status, err := c.verifyPassword(ctx, userID, password)
+	if status == userpb.UserStatus_USER_STATUS_WRONG_PASSWORD {
+		remain = c.onLoginFailed(ctx, lockoutUserTarget(userID), c.cfg.LoginLockout.UserMaxFailures)
+
+		if ip != "" {
+			if ipRemain := c.onLoginFailed(ctx, lockoutIPTarget(ip), c.cfg.LoginLockout.IPMaxFailures); ipRemain > remain {
+				remain = ipRemain
+			}
+		}
+
+		if remain > 0 {
+			return userpb.UserStatus_USER_STATUS_LOCKED, &lockedError{remain: remain}
+		}
+
+		return status, err
+	}
+
+	if status == userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.clearLoginFailures(ctx, lockoutUserTarget(userID))
+	}
+
+	return status, err
+}
+
+func (c *Controller) loginLockRemain(ctx context.Context, targets []string) (remain time.Duration) {
+	for _, target := range targets {
+		var ttl time.Duration
+
+		var err error
+
+		utils.DefRedisTimeoutOp(func(ctx context.Context) {
+			ttl, err = c.redis.TTL(ctx, redisKeyForLoginLock(target)).Result()
+		})
+
+		if err != nil {
+			c.logger.Errorf(ctx, "get lock ttl of %v failed: %v", target, err)
+
+			continue
+		}
+
+		if ttl > remain {
+			remain = ttl
+		}
+	}
+
+	return
+}
+
+// onLoginFailed counts a failure of target, and locks it if maxFailures reached. The lock time
+// doubles on each lock until the level key expires
+func (c *Controller) onLoginFailed(ctx context.Context, target string, maxFailures int64) (lockTime time.Duration) {
+	var failures *redis.IntCmd
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			failures = pipe.Incr(ctx, redisKeyForLoginFailures(target))
+			pipe.Expire(ctx, redisKeyForLoginFailures(target), c.cfg.LoginLockout.FailureWindow)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "count login failure of %v failed: %v", target, err)
+
+		return
+	}
+
+	if failures.Val() < maxFailures {
+		return
+	}
+
+	var level *redis.IntCmd
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			level = pipe.Incr(ctx, redisKeyForLoginLockLevel(target))
+			pipe.Expire(ctx, redisKeyForLoginLockLevel(target),
+				c.cfg.LoginLockout.MaxLock+c.cfg.LoginLockout.FailureWindow)
+			pipe.Del(ctx, redisKeyForLoginFailures(target))
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "raise lock level of %v failed: %v", target, err)
+
+		return
+	}
+
+	lockTime = c.cfg.LoginLockout.BaseLock
+
+	for i := int64(1); i < level.Val() && lockTime < c.cfg.LoginLockout.MaxLock; i++ {
+		lockTime *= 2
+	}
+
+	if lockTime > c.cfg.LoginLockout.MaxLock {
+		lockTime = c.cfg.LoginLockout.MaxLock
+	}
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		err = c.redis.Set(ctx, redisKeyForLoginLock(target), time.Now().String(), lockTime).Err()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "lock %v failed: %v", target, err)
+
+		return 0
+	}
+
+	c.logger.Warnf(ctx, "%v locked for %v", target, lockTime)
+
+	return
+}
+
+func (c *Controller) clearLoginFailures(_ context.Context, target string) {
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		c.redis.Del(ctx, redisKeyForLoginFailures(target), redisKeyForLoginLockLevel(target))
+	})
+}
+
+func (c *Controller) unlockUser(ctx context.Context, userID int64) error {
+	target := lockoutUserTarget(userID)
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		err = c.redis.Del(ctx, redisKeyForLoginLock(target), redisKeyForLoginFailures(target),
+			redisKeyForLoginLockLevel(target)).Err()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "unlock user %v failed: %v", userID, err)
+	}
+
+	return err
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+func enableTestLockout(c *Controller, userMaxFailures, ipMaxFailures int64) {
+	c.cfg.LoginLockout = config.LoginLockoutConfig{
+		Enable:          true,
+		UserMaxFailures: userMaxFailures,
+		IPMaxFailures:   ipMaxFailures,
+		FailureWindow:   10 * time.Minute,
+		BaseLock:        time.Minute,
+		MaxLock:         3 * time.Minute,
+	}
+}
+
+// failTestLogin signs userID in with a wrong password n times, the status of the last one is returned
+func failTestLogin(t *testing.T, c *Controller, userID int64, n int) (status userpb.UserStatus, err error) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		status, err = c.verifyPasswordWithLockout(context.Background(), userID, "wrong")
+	}
+
+	return
+}
+
+func lockedRemain(t *testing.T, err error) time.Duration {
+	t.Helper()
+
+	var lockedErr *lockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("not locked: %v", err)
+	}
+
+	return lockedErr.remain
+}
+
+func TestLockout_User(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	enableTestLockout(c, 3, 100)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	target := lockoutUserTarget(userID)
+
+	status, _ := failTestLogin(t, c, userID, 2)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_PASSWORD {
+		t.Fatalf("wrong password: %v", status)
+	}
+
+	if failures, _ := mr.Get(redisKeyForLoginFailures(target)); failures != "2" {
+		t.Fatalf("failures %q, want 2", failures)
+	}
+
+	// a success forgets the failures before it
+	status, _ = c.verifyPasswordWithLockout(ctx, userID, "password1")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || mr.Exists(redisKeyForLoginFailures(target)) {
+		t.Fatalf("login failed: %v", status)
+	}
+
+	status, _ = failTestLogin(t, c, userID, 2)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_PASSWORD {
+		t.Fatalf("wrong password after success: %v", status)
+	}
+
+	// the lock time doubles on each lock up to MaxLock
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		status, err := failTestLogin(t, c, userID, 1)
+		if status != userpb.UserStatus_USER_STATUS_LOCKED || lockedRemain(t, err) != want {
+			t.Fatalf("locked %v, %v, want %v", status, err, want)
+		}
+
+		status, err = c.verifyPasswordWithLockout(ctx, userID, "password1")
+		if status != userpb.UserStatus_USER_STATUS_LOCKED || lockedRemain(t, err) != want {
+			t.Fatalf("login of locked user: %v, %v", status, err)
+		}
+
+		mr.FastForward(want)
+
+		status, _ = failTestLogin(t, c, userID, 2)
+		if status != userpb.UserStatus_USER_STATUS_WRONG_PASSWORD {
+			t.Fatalf("wrong password after lock: %v", status)
+		}
+	}
+
+	status, err := c.verifyPasswordWithLockout(ctx, userID, "password1")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login after lock failed: %v, %v", status, err)
+	}
+
+	// the success dropped the lock level too
+	status, err = failTestLogin(t, c, userID, 3)
+	if status != userpb.UserStatus_USER_STATUS_LOCKED || lockedRemain(t, err) != time.Minute {
+		t.Fatalf("locked %v, %v after success", status, err)
+	}
+
+	if err.Error() != "retry_after=60" {
+		t.Fatalf("locked error %q", err)
+	}
+
+	// the ip is trusted, so the password is all the login asks for
+	if err = c.m.UserTrustInc(userID, testClientIP, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _, _, _, _ = c.Login(ctx, &userpb.UserId{
+		UserName: "abc@web.com",
+		UserVe:   userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String(),
+	}, "password1", "", "", "", false, "")
+	if status != userpb.UserStatus_USER_STATUS_LOCKED {
+		t.Fatalf("login of locked user: %v", status)
+	}
+}
+
+func TestLockout_Unlock(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	enableTestLockout(c, 3, 100)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	target := lockoutUserTarget(userID)
+
+	failTestLogin(t, c, userID, 3)
+	mr.FastForward(time.Minute)
+
+	status, _ := failTestLogin(t, c, userID, 4)
+	if status != userpb.UserStatus_USER_STATUS_LOCKED {
+		t.Fatalf("user not locked: %v", status)
+	}
+
+	if err := c.unlockUser(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{redisKeyForLoginLock(target), redisKeyForLoginFailures(target),
+		redisKeyForLoginLockLevel(target)} {
+		if mr.Exists(key) {
+			t.Fatalf("%v kept", key)
+		}
+	}
+
+	// the lock level starts over
+	status, err := failTestLogin(t, c, userID, 3)
+	if status != userpb.UserStatus_USER_STATUS_LOCKED || lockedRemain(t, err) != time.Minute {
+		t.Fatalf("locked %v, %v after unlock", status, err)
+	}
+
+	if err = c.unlockUser(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = c.verifyPasswordWithLockout(ctx, userID, "password1")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login after unlock failed: %v, %v", status, err)
+	}
+}
+
+func TestLockout_IP(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	enableTestLockout(c, 100, 3)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	otherUserID := newTestUser(t, c, "other@web.com", "password1")
+
+	status, _ := failTestLogin(t, c, userID, 2)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_PASSWORD {
+		t.Fatalf("wrong password: %v", status)
+	}
+
+	// the failures of all users from the ip are counted
+	status, err := failTestLogin(t, c, otherUserID, 1)
+	if status != userpb.UserStatus_USER_STATUS_LOCKED || lockedRemain(t, err) != time.Minute {
+		t.Fatalf("ip not locked: %v, %v", status, err)
+	}
+
+	if !mr.Exists(redisKeyForLoginLock(lockoutIPTarget(testClientIP))) ||
+		mr.Exists(redisKeyForLoginLock(lockoutUserTarget(otherUserID))) {
+		t.Fatal("user locked instead of ip")
+	}
+
+	status, _ = c.verifyPasswordWithLockout(ctx, userID, "password1")
+	if status != userpb.UserStatus_USER_STATUS_LOCKED {
+		t.Fatalf("login from locked ip: %v", status)
+	}
+
+	c.utils.(*testUtils).ip = "10.0.0.2"
+
+	status, err = c.verifyPasswordWithLockout(ctx, userID, "password1")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login from other ip failed: %v, %v", status, err)
+	}
+
+	c.utils.(*testUtils).ip = ""
+
+	// unlocking the user leaves the ip locked
+	if err = c.unlockUser(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ = c.verifyPasswordWithLockout(ctx, userID, "password1")
+	if status != userpb.UserStatus_USER_STATUS_LOCKED {
+		t.Fatalf("login from locked ip after unlock: %v", status)
+	}
+
+	mr.FastForward(time.Minute)
+
+	status, err = c.verifyPasswordWithLockout(ctx, userID, "password1")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("login after ip lock failed: %v, %v", status, err)
+	}
+}
@@ -0,0 +1,374 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/controller/oidc"
+	"github.com/sbasestarter/user/internal/utils"
+)
+
+const oidcCodeBytes = 32
+
+// oidcCode is what an authorization code stands for, SessionID is the session of the user agent
+type oidcCode struct {
+	ClientID      string
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	CodeChallenge string
+	UserID        int64
+	SessionID     string
+	AuthTime      int64
+}
+
+func (c *Controller) OIDCDiscovery() *oidc.Discovery {
+	return oidc.NewDiscovery(c.cfg.OIDC.Issuer, c.tokenKeys.Alg())
+}
+
+func (c *Controller) OIDCJWKS() (string, error) {
+	return c.tokenKeys.JWKSJSON()
+}
+
+// OIDCAuthorize handles an authorization request of the user agent signed in by token, and returns where
+// the user agent goes next. The request is refused without redirection by an oidc.Error if the client or
+// redirect uri is unknown
+func (c *Controller) OIDCAuthorize(ctx context.Context, token string, params url.Values) (redirectURL string, err error) {
+	client, ok := c.oidcClients.Get(params.Get("client_id"))
+	if !ok {
+		err = oidc.NewError(oidc.ErrInvalidClient, "unknown client")
+
+		return
+	}
+
+	redirectURI := params.Get("redirect_uri")
+	if !client.RedirectURIAllowed(redirectURI) {
+		err = oidc.NewError(oidc.ErrInvalidRequest, "unregistered redirect_uri")
+
+		return
+	}
+
+	state := params.Get("state")
+
+	redirectError := func(code, description string) (string, error) {
+		return oidc.RedirectURL(redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {state},
+		}), nil
+	}
+
+	if params.Get("response_type") != oidc.ResponseTypeCode {
+		return redirectError(oidc.ErrUnsupportedResponseType, "only code is supported")
+	}
+
+	scopes := oidc.ParseScope(params.Get("scope"))
+	if !oidc.HasScope(scopes, oidc.ScopeOpenID) {
+		return redirectError(oidc.ErrInvalidScope, "openid is required")
+	}
+
+	if !oidc.ValidCodeChallenge(params.Get("code_challenge"), params.Get("code_challenge_method")) {
+		return redirectError(oidc.ErrInvalidRequest, "S256 code challenge is required")
+	}
+
+	prompts := strings.Fields(params.Get("prompt"))
+
+	var authInfo *AuthInfo
+
+	if token != "" && !oidc.HasScope(prompts, oidc.PromptLogin) {
+		authInfo, err = c.verifyToken(ctx, token)
+		if err != nil {
+			c.logger.Warnf(ctx, "verify token of authorization failed: %v", err)
+
+			authInfo = nil
+			err = nil
+		}
+	}
+
+	if authInfo == nil || !authInfo.IsSession() {
+		if oidc.HasScope(prompts, oidc.PromptNone) {
+			return redirectError(oidc.ErrLoginRequired, "")
+		}
+
+		return c.oidcLoginURL(params), nil
+	}
+
+	// users of auto login have the id of user source, which isn't a subject
+	if authInfo.UserSourceIDFlag {
+		return redirectError(oidc.ErrAccessDenied, "user is not registered")
+	}
+
+	code, err := c.newOIDCCode(ctx, &oidcCode{
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		Nonce:         params.Get("nonce"),
+		CodeChallenge: params.Get("code_challenge"),
+		UserID:        authInfo.UserID,
+		SessionID:     authInfo.SessionID,
+		AuthTime:      authInfo.AuthTime,
+	})
+	if err != nil {
+		return redirectError(oidc.ErrServerError, "")
+	}
+
+	redirectURL = oidc.RedirectURL(redirectURI, url.Values{
+		"code":  {code},
+		"state": {state},
+	})
+
+	return
+}
+
+// oidcLoginURL sends the user agent to sign in, and back to the authorization request without prompt
+func (c *Controller) oidcLoginURL(params url.Values) string {
+	returnParams := url.Values{}
+
+	for key, values := range params {
+		if key != "prompt" {
+			returnParams[key] = values
+		}
+	}
+
+	return oidc.RedirectURL(c.cfg.OIDC.LoginURL, url.Values{
+		"return_to": {c.cfg.OIDC.Issuer + oidc.PathAuthorize + "?" + returnParams.Encode()},
+	})
+}
+
+func (c *Controller) newOIDCCode(ctx context.Context, oc *oidcCode) (code string, err error) {
+	code, err = randomHex(oidcCodeBytes)
+	if err != nil {
+		c.logger.Errorf(ctx, "random code failed: %v", err)
+
+		return
+	}
+
+	data, err := json.Marshal(oc)
+	if err != nil {
+		c.logger.Errorf(ctx, "marshal code failed: %v", err)
+
+		return
+	}
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		err = c.redis.Set(ctx, redisKeyForOIDCCode(code), string(data), c.cfg.OIDC.CodeExpire).Err()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "set code failed: %v", err)
+	}
+
+	return
+}
+
+// takeOIDCCode returns the code and removes it, a code can be used only once
+func (c *Controller) takeOIDCCode(ctx context.Context, code string) (oc *oidcCode, err error) {
+	var get *redis.StringCmd
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			get = pipe.Get(ctx, redisKeyForOIDCCode(code))
+			pipe.Del(ctx, redisKeyForOIDCCode(code))
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return
+	}
+
+	oc = &oidcCode{}
+
+	err = json.Unmarshal([]byte(get.Val()), oc)
+
+	return
+}
+
+// OIDCToken handles a token request of the client authenticated by clientID and clientSecret
+func (c *Controller) OIDCToken(ctx context.Context, clientID, clientSecret string, params url.Values) (
+	resp *oidc.TokenResponse, err error) {
+	client, ok := c.oidcClients.Get(clientID)
+	if !ok || !client.Authenticate(clientSecret) {
+		c.logger.Warnf(ctx, "authenticate client %q failed", clientID)
+
+		err = oidc.NewError(oidc.ErrInvalidClient, "")
+
+		return
+	}
+
+	switch params.Get("grant_type") {
+	case oidc.GrantTypeAuthorizationCode:
+		return c.oidcExchangeCode(ctx, clientID, params)
+	case oidc.GrantTypeRefreshToken:
+		return c.oidcRefreshToken(ctx, clientID, params)
+	default:
+		err = oidc.NewError(oidc.ErrUnsupportedGrantType, "")
+
+		return
+	}
+}
+
+func (c *Controller) oidcExchangeCode(ctx context.Context, clientID string, params url.Values) (
+	resp *oidc.TokenResponse, err error) {
+	invalidGrant := oidc.NewError(oidc.ErrInvalidGrant, "")
+
+	oc, err := c.takeOIDCCode(ctx, params.Get("code"))
+	if err != nil {
+		c.logger.Warnf(ctx, "take code of %v failed: %v", clientID, err)
+
+		return nil, invalidGrant
+	}
+
+	if oc.ClientID != clientID || oc.RedirectURI != params.Get("redirect_uri") ||
+		!oidc.VerifyCodeVerifier(oc.CodeChallenge, params.Get("code_verifier")) {
+		c.logger.Warnf(ctx, "code of %v is not for the request of %v", oc.ClientID, clientID)
+
+		return nil, invalidGrant
+	}
+
+	// the session which authorized the code may have signed out
+	_, err = c.loadSession(ctx, oc.UserID, oc.SessionID, redisKeyForSession(oc.UserID, oc.SessionID))
+	if err != nil {
+		return nil, invalidGrant
+	}
+
+	userInfo, err := c.m.GetUserInfo(oc.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get user info %v failed: %v", oc.UserID, err)
+
+		return nil, invalidGrant
+	}
+
+	// the session of the client is a child of the one of user agent, it ends once the user signs out
+	authInfo := c.dbUser2AuthInfo(userInfo)
+	authInfo.ParentSessionID = oc.SessionID
+	authInfo.AuthTime = oc.AuthTime
+	authInfo.OIDCClientID = clientID
+	authInfo.Scopes = oc.Scopes
+
+	_, tokens, err := c.generateToken(ctx, authInfo)
+	if err != nil {
+		c.logger.Errorf(ctx, "generate token failed: %v", err)
+
+		return nil, oidc.NewError(oidc.ErrServerError, "")
+	}
+
+	idToken, err := c.signIDToken(clientID, authInfo, oc, tokens.AccessToken)
+	if err != nil {
+		c.logger.Errorf(ctx, "sign id token failed: %v", err)
+
+		return nil, oidc.NewError(oidc.ErrServerError, "")
+	}
+
+	resp = c.oidcTokenResponse(tokens, oc.Scopes)
+	resp.IDToken = idToken
+
+	return
+}
+
+func (c *Controller) oidcRefreshToken(ctx context.Context, clientID string, params url.Values) (
+	resp *oidc.TokenResponse, err error) {
+	invalidGrant := oidc.NewError(oidc.ErrInvalidGrant, "")
+
+	refreshToken := params.Get("refresh_token")
+
+	// a client can refresh only its own sessions
+	status, tokens, authInfo, err := c.refreshToken(ctx, refreshToken, clientID)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Warnf(ctx, "refresh token of %v failed: %v, %v", clientID, status, err)
+
+		return nil, invalidGrant
+	}
+
+	return c.oidcTokenResponse(tokens, authInfo.Scopes), nil
+}
+
+// oidcTokenResponse gives the refresh token only to the clients which asked for offline access
+func (c *Controller) oidcTokenResponse(tokens *SignedTokens, scopes []string) *oidc.TokenResponse {
+	resp := &oidc.TokenResponse{
+		AccessToken: tokens.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   tokens.AccessExpiresAt - time.Now().Unix(),
+		Scope:       strings.Join(scopes, " "),
+	}
+
+	if oidc.HasScope(scopes, oidc.ScopeOfflineAccess) {
+		resp.RefreshToken = tokens.RefreshToken
+	}
+
+	return resp
+}
+
+func (c *Controller) signIDToken(clientID string, authInfo *AuthInfo, oc *oidcCode, accessToken string) (
+	string, error) {
+	atHash, err := oidc.AccessTokenHash(c.tokenKeys.Alg(), accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := &oidc.IDTokenClaims{
+		Issuer:    c.cfg.OIDC.Issuer,
+		Subject:   strconv.FormatInt(authInfo.UserID, 10),
+		Audience:  clientID,
+		ExpiresAt: now.Add(c.cfg.OIDC.IDTokenExpire).Unix(),
+		IssuedAt:  now.Unix(),
+		AuthTime:  oc.AuthTime,
+		Nonce:     oc.Nonce,
+		AtHash:    atHash,
+	}
+
+	if oidc.HasScope(oc.Scopes, oidc.ScopeProfile) {
+		claims.Name = authInfo.NickName
+		claims.Picture = authInfo.Avatar
+	}
+
+	return c.tokenKeys.Sign(claims)
+}
+
+// OIDCUserInfo returns the claims of the user of accessToken by the scopes the client was granted
+func (c *Controller) OIDCUserInfo(ctx context.Context, accessToken string) (claims map[string]interface{},
+	err error) {
+	authInfo, err := c.verifyToken(ctx, accessToken)
+	if err != nil || authInfo.OIDCClientID == "" || authInfo.UserSourceIDFlag {
+		return nil, oidc.NewError(oidc.ErrInvalidToken, "")
+	}
+
+	claims = map[string]interface{}{
+		"sub": strconv.FormatInt(authInfo.UserID, 10),
+	}
+
+	if oidc.HasScope(authInfo.Scopes, oidc.ScopeProfile) {
+		claims["name"] = authInfo.NickName
+		claims["picture"] = authInfo.Avatar
+	}
+
+	if !oidc.HasScope(authInfo.Scopes, oidc.ScopeEmail) && !oidc.HasScope(authInfo.Scopes, oidc.ScopePhone) {
+		return
+	}
+
+	userDetail, _, err := c.m.GetUserDetailInfo(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get user detail info failed: %v", err)
+
+		return nil, oidc.NewError(oidc.ErrServerError, "")
+	}
+
+	if oidc.HasScope(authInfo.Scopes, oidc.ScopeEmail) && userDetail.UserExt.Email != "" {
+		claims["email"] = userDetail.UserExt.Email
+	}
+
+	if oidc.HasScope(authInfo.Scopes, oidc.ScopePhone) && userDetail.UserExt.Phone != "" {
+		claims["phone_number"] = userDetail.UserExt.Phone
+	}
+
+	return
+}
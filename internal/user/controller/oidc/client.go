@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+// Client is a registered relying party
+type Client struct {
+	ID           string
+	secret       string
+	redirectURIs map[string]bool
+}
+
+// Public tells if the client can't keep a secret, like a spa or a native app
+func (c *Client) Public() bool {
+	return c.secret == ""
+}
+
+// Authenticate checks the secret of a confidential client, a public client must have none
+func (c *Client) Authenticate(secret string) bool {
+	if c.Public() {
+		return secret == ""
+	}
+
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(c.secret)) == 1
+}
+
+// RedirectURIAllowed compares redirectURI with the registered ones exactly
+func (c *Client) RedirectURIAllowed(redirectURI string) bool {
+	return c.redirectURIs[redirectURI]
+}
+
+type Clients struct {
+	clients map[string]*Client
+}
+
+func NewClients(cfgs []config.OIDCClientConfig) (*Clients, error) {
+	clients := &Clients{
+		clients: make(map[string]*Client),
+	}
+
+	for _, cfg := range cfgs {
+		if cfg.ClientID == "" {
+			return nil, errors.New("client without id")
+		}
+
+		if _, ok := clients.clients[cfg.ClientID]; ok {
+			return nil, fmt.Errorf("duplicated client %v", cfg.ClientID)
+		}
+
+		if len(cfg.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("client %v has no redirect uri", cfg.ClientID)
+		}
+
+		client := &Client{
+			ID:           cfg.ClientID,
+			secret:       cfg.ClientSecret,
+			redirectURIs: make(map[string]bool),
+		}
+
+		for _, redirectURI := range cfg.RedirectURIs {
+			u, err := url.Parse(redirectURI)
+			if err != nil || !u.IsAbs() || u.Fragment != "" {
+				return nil, fmt.Errorf("client %v: invalid redirect uri %q", cfg.ClientID, redirectURI)
+			}
+
+			client.redirectURIs[redirectURI] = true
+		}
+
+		clients.clients[cfg.ClientID] = client
+	}
+
+	return clients, nil
+}
+
+func (cs *Clients) Get(clientID string) (*Client, bool) {
+	client, ok := cs.clients[clientID]
+
+	return client, ok
+}
@@ -0,0 +1,44 @@
+package oidc
+
+// Discovery is the provider metadata of OpenID Connect Discovery 1.0
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// NewDiscovery describes the provider at issuer, which signs id tokens by alg
+func NewDiscovery(issuer, alg string) *Discovery {
+	return &Discovery{
+		Issuer:                 issuer,
+		AuthorizationEndpoint:  issuer + PathAuthorize,
+		TokenEndpoint:          issuer + PathToken,
+		UserInfoEndpoint:       issuer + PathUserInfo,
+		JWKSURI:                issuer + PathJWKS,
+		ScopesSupported:        []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopePhone, ScopeOfflineAccess},
+		ResponseTypesSupported: []string{ResponseTypeCode},
+		ResponseModesSupported: []string{"query"},
+		GrantTypesSupported:    []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		SubjectTypesSupported:  []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{
+			alg,
+		},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "name", "picture", "email",
+			"phone_number",
+		},
+	}
+}
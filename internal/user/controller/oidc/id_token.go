@@ -0,0 +1,40 @@
+package oidc
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrIDTokenExpired = errors.New("id token expired")
+
+// IDTokenClaims are the claims of an id token, Name and Picture are set for the profile scope
+type IDTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	AuthTime  int64  `json:"auth_time,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	AtHash    string `json:"at_hash,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Picture   string `json:"picture,omitempty"`
+}
+
+func (c *IDTokenClaims) Valid() error {
+	if time.Now().Unix() > c.ExpiresAt {
+		return ErrIDTokenExpired
+	}
+
+	return nil
+}
+
+// TokenResponse is the successful response of the token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
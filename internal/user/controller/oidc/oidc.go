@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+)
+
+const (
+	PathDiscovery = "/.well-known/openid-configuration"
+	PathAuthorize = "/oauth2/authorize"
+	PathToken     = "/oauth2/token"
+	PathUserInfo  = "/oauth2/userinfo"
+	PathJWKS      = "/oauth2/jwks"
+)
+
+const (
+	ScopeOpenID        = "openid"
+	ScopeProfile       = "profile"
+	ScopeEmail         = "email"
+	ScopePhone         = "phone"
+	ScopeOfflineAccess = "offline_access"
+
+	ResponseTypeCode = "code"
+
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+
+	CodeChallengeMethodS256 = "S256"
+
+	PromptNone  = "none"
+	PromptLogin = "login"
+)
+
+// error codes of RFC 6749 and OpenID Connect Core
+const (
+	ErrInvalidRequest          = "invalid_request"
+	ErrInvalidClient           = "invalid_client"
+	ErrInvalidGrant            = "invalid_grant"
+	ErrInvalidScope            = "invalid_scope"
+	ErrInvalidToken            = "invalid_token"
+	ErrUnauthorizedClient      = "unauthorized_client"
+	ErrUnsupportedGrantType    = "unsupported_grant_type"
+	ErrUnsupportedResponseType = "unsupported_response_type"
+	ErrAccessDenied            = "access_denied"
+	ErrLoginRequired           = "login_required"
+	ErrServerError             = "server_error"
+)
+
+// Error is an error which is told to the client as is
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func NewError(code, description string) *Error {
+	return &Error{
+		Code:        code,
+		Description: description,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+
+	return e.Code + ": " + e.Description
+}
+
+// ParseScope splits a scope parameter, duplicates are removed
+func ParseScope(scope string) []string {
+	var scopes []string
+
+	seen := make(map[string]bool)
+
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidCodeChallenge checks a PKCE code challenge, only S256 is supported
+func ValidCodeChallenge(challenge, method string) bool {
+	if method != CodeChallengeMethodS256 || len(challenge) != base64.RawURLEncoding.EncodedLen(sha256.Size) {
+		return false
+	}
+
+	_, err := base64.RawURLEncoding.DecodeString(challenge)
+
+	return err == nil
+}
+
+// VerifyCodeVerifier tells if verifier is the one of an S256 challenge
+func VerifyCodeVerifier(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	for _, r := range verifier {
+		if !isUnreserved(r) {
+			return false
+		}
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+func isUnreserved(r rune) bool {
+	return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') ||
+		r == '-' || r == '.' || r == '_' || r == '~'
+}
+
+// AccessTokenHash is the at_hash of an id token signed by alg
+func AccessTokenHash(alg, accessToken string) (string, error) {
+	var h hash.Hash
+
+	switch alg {
+	case "RS256", "ES256", "HS256":
+		h = sha256.New()
+	case "EdDSA":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("no hash of alg %v", alg)
+	}
+
+	_, _ = h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// RedirectURL adds params to the query of redirectURI, which is already checked
+func RedirectURL(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := u.Query()
+
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
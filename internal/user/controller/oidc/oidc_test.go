@@ -0,0 +1,153 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+func TestClients(t *testing.T) {
+	clients, err := NewClients([]config.OIDCClientConfig{
+		{ClientID: "web", ClientSecret: "s3cret", RedirectURIs: []string{"https://a.com/cb"}},
+		{ClientID: "spa", RedirectURIs: []string{"https://b.com/cb?x=1", "http://127.0.0.1:8080/cb"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web, ok := clients.Get("web")
+	if !ok || web.Public() || !web.Authenticate("s3cret") || web.Authenticate("") || web.Authenticate("s3cre") {
+		t.Fatal("unexpected confidential client")
+	}
+
+	spa, ok := clients.Get("spa")
+	if !ok || !spa.Public() || !spa.Authenticate("") || spa.Authenticate("x") {
+		t.Fatal("unexpected public client")
+	}
+
+	for uri, allowed := range map[string]bool{
+		"https://b.com/cb?x=1":     true,
+		"http://127.0.0.1:8080/cb": true,
+		"https://b.com/cb":         false,
+		"https://b.com/cb?x=1&y=2": false,
+		"https://b.com/cb?x=1#a":   false,
+		"https://B.com/cb?x=1":     false,
+		"https://a.com/cb":         false,
+	} {
+		if spa.RedirectURIAllowed(uri) != allowed {
+			t.Errorf("%v: expect %v", uri, allowed)
+		}
+	}
+
+	if _, ok = clients.Get("other"); ok {
+		t.Fatal("unknown client found")
+	}
+}
+
+func TestClientsErrors(t *testing.T) {
+	for _, cfgs := range [][]config.OIDCClientConfig{
+		{{RedirectURIs: []string{"https://a.com/cb"}}},
+		{{ClientID: "a"}},
+		{{ClientID: "a", RedirectURIs: []string{"/cb"}}},
+		{{ClientID: "a", RedirectURIs: []string{"https://a.com/cb#x"}}},
+		{
+			{ClientID: "a", RedirectURIs: []string{"https://a.com/cb"}},
+			{ClientID: "a", RedirectURIs: []string{"https://a.com/cb"}},
+		},
+	} {
+		if _, err := NewClients(cfgs); err == nil {
+			t.Errorf("%+v accepted", cfgs)
+		}
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// the example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !ValidCodeChallenge(challenge, CodeChallengeMethodS256) {
+		t.Fatal("valid challenge refused")
+	}
+
+	if ValidCodeChallenge(challenge, "plain") || ValidCodeChallenge(challenge[1:], CodeChallengeMethodS256) ||
+		ValidCodeChallenge(strings.Replace(challenge, "-", "+", 1), CodeChallengeMethodS256) {
+		t.Fatal("invalid challenge accepted")
+	}
+
+	if !VerifyCodeVerifier(challenge, verifier) {
+		t.Fatal("verifier refused")
+	}
+
+	if VerifyCodeVerifier(challenge, verifier+"a") || VerifyCodeVerifier(challenge, "short") {
+		t.Fatal("wrong verifier accepted")
+	}
+
+	long := strings.Repeat("a", 129)
+	sum := sha256.Sum256([]byte(long))
+
+	if VerifyCodeVerifier(base64.RawURLEncoding.EncodeToString(sum[:]), long) {
+		t.Fatal("too long verifier accepted")
+	}
+
+	bad := strings.Repeat("a", 42) + "+"
+	sum = sha256.Sum256([]byte(bad))
+
+	if VerifyCodeVerifier(base64.RawURLEncoding.EncodeToString(sum[:]), bad) {
+		t.Fatal("reserved char accepted")
+	}
+}
+
+func TestAccessTokenHash(t *testing.T) {
+	// the example of OpenID Connect Core appendix A.3
+	atHash, err := AccessTokenHash("RS256", "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y")
+	if err != nil || atHash != "77QmUPtjPfzWtF2AnpK9RQ" {
+		t.Fatalf("unexpected at_hash %v, %v", atHash, err)
+	}
+
+	atHash, err = AccessTokenHash("EdDSA", "token")
+	if err != nil || len(atHash) != base64.RawURLEncoding.EncodedLen(32) {
+		t.Fatalf("unexpected at_hash %v, %v", atHash, err)
+	}
+
+	if _, err = AccessTokenHash("none", "token"); err == nil {
+		t.Fatal("hash of none")
+	}
+}
+
+func TestScope(t *testing.T) {
+	scopes := ParseScope(" openid  profile openid\temail ")
+	if strings.Join(scopes, " ") != "openid profile email" {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+
+	if !HasScope(scopes, ScopeEmail) || HasScope(scopes, ScopePhone) {
+		t.Fatal("unexpected HasScope")
+	}
+}
+
+func TestRedirectURL(t *testing.T) {
+	s := RedirectURL("https://a.com/cb?x=1", url.Values{"code": {"c"}, "state": {""}})
+
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Host != "a.com" || u.Path != "/cb" || u.Query().Get("x") != "1" || u.Query().Get("code") != "c" ||
+		strings.Contains(s, "state") {
+		t.Fatalf("unexpected url %v", s)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	d := NewDiscovery("https://id.a.com", "ES256")
+	if d.TokenEndpoint != "https://id.a.com/oauth2/token" || d.JWKSURI != "https://id.a.com/oauth2/jwks" ||
+		d.IDTokenSigningAlgValuesSupported[0] != "ES256" {
+		t.Fatalf("unexpected discovery %+v", d)
+	}
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+func newTestOIDCSession(t *testing.T, c *Controller, userID int64, clientID string) *SignedTokens {
+	t.Helper()
+
+	_, tokens, err := c.generateToken(context.Background(), &AuthInfo{
+		UserID:       userID,
+		OIDCClientID: clientID,
+		Scopes:       []string{"openid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokens
+}
+
+func TestOIDCSession_NotFirstParty(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	tokens := newTestOIDCSession(t, c, userID, "app")
+
+	status, _, _ := c.fixAndVerifySessionToken(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("oidc token as session: %v", status)
+	}
+
+	status, csrfToken, _ := c.GetCsrfToken(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT || csrfToken != "" {
+		t.Fatalf("csrf token for oidc token: %v", status)
+	}
+
+	status, _, ssoToken, _, _ := c.Profile(ctx, tokens.AccessToken, true, "https://a.example.com/")
+	if status == userpb.UserStatus_USER_STATUS_SUCCESS || ssoToken != "" {
+		t.Fatalf("sso token for oidc token: %v", status)
+	}
+
+	status, _, _ = c.GetDetailInfo(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("detail info for oidc token: %v", status)
+	}
+
+	status, _, _ = c.ListSessions(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("sessions for oidc token: %v", status)
+	}
+
+	status, _, _ = c.GoogleAuthGetRecoveryCodesCount(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("recovery codes count for oidc token: %v", status)
+	}
+
+	status, _, _ = c.WebAuthnListCredentials(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("webauthn credentials for oidc token: %v", status)
+	}
+
+	claims, err := c.OIDCUserInfo(ctx, tokens.AccessToken)
+	if err != nil || claims["sub"] == nil {
+		t.Fatalf("oidc user info failed: %v, %v", claims, err)
+	}
+
+	_, sessionTokens := newTestSession(t, c, userID, "")
+
+	if _, err = c.OIDCUserInfo(ctx, sessionTokens.AccessToken); err == nil {
+		t.Fatal("oidc user info for session token")
+	}
+}
+
+func TestOIDCSession_RefreshToken(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	tokens := newTestOIDCSession(t, c, userID, "app")
+
+	status, _, _ := c.RefreshToken(ctx, tokens.RefreshToken)
+	if status != userpb.UserStatus_USER_STATUS_UNAUTHENTICATED {
+		t.Fatalf("oidc refresh token refreshed as session: %v", status)
+	}
+
+	status, _, _, _ = c.refreshToken(ctx, tokens.RefreshToken, "other")
+	if status != userpb.UserStatus_USER_STATUS_UNAUTHENTICATED {
+		t.Fatalf("refresh token refreshed by other client: %v", status)
+	}
+
+	// the refused tries didn't use the token up
+	status, newTokens, authInfo, err := c.refreshToken(ctx, tokens.RefreshToken, "app")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo.OIDCClientID != "app" {
+		t.Fatalf("refresh by client failed: %v, %v", status, err)
+	}
+
+	token, refreshToken := c.httpToken.(*testHTTPToken).cookies()
+	if token == newTokens.AccessToken || refreshToken == newTokens.RefreshToken {
+		t.Fatal("tokens of oidc client set in cookies")
+	}
+
+	_, sessionTokens := newTestSession(t, c, userID, "")
+
+	status, _, _, _ = c.refreshToken(ctx, sessionTokens.RefreshToken, "app")
+	if status != userpb.UserStatus_USER_STATUS_UNAUTHENTICATED {
+		t.Fatalf("session refreshed by client: %v", status)
+	}
+}
+
+func TestOIDCSession_Audience(t *testing.T) {
+	for _, stateless := range []bool{false, true} {
+		c, _, _ := newTestController(t)
+		c.cfg.Token.Stateless = stateless
+		c.cfg.Token.Audiences = []string{"first"}
+		ctx := context.Background()
+
+		tokens := newTestOIDCSession(t, c, 1, "app")
+
+		for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+			tc, err := c.parseToken(token)
+			if err != nil || tc.ClientID != "app" || !reflect.DeepEqual(tc.Audience, Audience{"app"}) {
+				t.Fatalf("stateless %v: unexpected token of client: %+v, %v", stateless, tc, err)
+			}
+		}
+
+		_, sessionTokens := newTestSession(t, c, 1, "")
+
+		tc, err := c.parseToken(sessionTokens.AccessToken)
+		if err != nil || tc.ClientID != "" || !reflect.DeepEqual(tc.Audience, Audience{"first"}) {
+			t.Fatalf("stateless %v: unexpected token of session: %+v, %v", stateless, tc, err)
+		}
+
+		// a token of the client can't pass as a first party one, nor can it be moved to another client
+		tc, _ = c.parseToken(tokens.AccessToken)
+		tc.ClientID = ""
+
+		forged, err := c.signToken(tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.verifyToken(ctx, forged); !errors.Is(err, errTokenAudience) {
+			t.Fatalf("stateless %v: token without client: %v", stateless, err)
+		}
+
+		tc.ClientID = "other"
+		tc.Audience = Audience{"other"}
+
+		forged, err = c.signToken(tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.verifyToken(ctx, forged); !errors.Is(err, errTokenClient) {
+			t.Fatalf("stateless %v: token moved to another client: %v", stateless, err)
+		}
+	}
+}
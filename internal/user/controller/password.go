@@ -2,6 +2,8 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
 )
@@ -20,14 +22,143 @@ func (c *Controller) verifyPassword(ctx context.Context, userID int64, password
 		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
 	}
 
-	encryptedPassword, err := c.passEncrypt(password)
+	ok, needRehash, err := c.passVerify(userAuth.Password, password)
 	if err != nil {
+		c.logger.Errorf(ctx, "user %v verify password failed: %v", userID, err)
+
 		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
 	}
 
-	if userAuth.Password != encryptedPassword {
+	if !ok {
 		return userpb.UserStatus_USER_STATUS_WRONG_PASSWORD, nil
 	}
 
+	if needRehash {
+		c.rehashPassword(ctx, userID, password)
+	}
+
+	return userpb.UserStatus_USER_STATUS_SUCCESS, nil
+}
+
+// verifyReauth checks the user again before a credential of it is changed, by codeForGa if it's given, or
+// by the current password
+func (c *Controller) verifyReauth(ctx context.Context, userID int64, password, codeForGa string) (
+	userpb.UserStatus, error) {
+	if codeForGa != "" {
+		status := c.gaVerify(ctx, userID, codeForGa)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			return status, errors.New("ga verify failed")
+		}
+
+		return status, nil
+	}
+
+	if password == "" {
+		if c.gaEnabled(ctx, userID) {
+			return userpb.UserStatus_USER_STATUS_NEED_2FA_AUTH, nil
+		}
+
+		return userpb.UserStatus_USER_STATUS_WRONG_PASSWORD, errors.New("no password")
+	}
+
+	return c.verifyPasswordWithLockout(ctx, userID, password)
+}
+
+// rehashPassword upgrades a verified password to the configured hash, failures only delay the upgrade
+func (c *Controller) rehashPassword(ctx context.Context, userID int64, password string) {
+	encryptedPassword, err := c.passEncrypt(password)
+	if err != nil {
+		c.logger.Errorf(ctx, "user %v rehash password failed: %v", userID, err)
+
+		return
+	}
+
+	err = c.m.UpdateUserPassword(userID, encryptedPassword)
+	if err != nil {
+		c.logger.Errorf(ctx, "user %v update rehashed password failed: %v", userID, err)
+
+		return
+	}
+
+	c.logger.Infof(ctx, "user %v password hash upgraded", userID)
+}
+
+// checkPasswordPolicy checks newPassword against the configured policy, the profile of userID (if any)
+// and userInfos must not be contained in it. Violation reasons are joined in the error message.
+func (c *Controller) checkPasswordPolicy(ctx context.Context, userID int64, newPassword string,
+	userInfos ...string) (userpb.UserStatus, error) {
+	if userID > 0 {
+		userDetail, userSource, err := c.m.GetUserDetailInfo(userID)
+		if err != nil {
+			c.logger.Errorf(ctx, "get user detail info failed: %v", err)
+
+			return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+		}
+
+		userInfos = append(userInfos, userDetail.UserInfo.NickName, userDetail.UserExt.Email, userDetail.UserExt.Phone)
+
+		if userSource != nil {
+			userInfos = append(userInfos, userSource.UserName)
+		}
+	}
+
+	err := c.pwdPolicy.Check(newPassword, userInfos...)
+	if err != nil {
+		c.logger.Warnf(ctx, "user %v password policy check failed: %v", userID, err)
+
+		return userpb.UserStatus_USER_STATUS_WEAK_PASSWORD, err
+	}
+
 	return userpb.UserStatus_USER_STATUS_SUCCESS, nil
 }
+
+// checkPasswordReuse rejects newPassword if it is the current password or one of the recent ones
+func (c *Controller) checkPasswordReuse(ctx context.Context, userID int64, newPassword string) (userpb.UserStatus, error) {
+	if c.cfg.PasswordHistory.Count <= 0 {
+		return userpb.UserStatus_USER_STATUS_SUCCESS, nil
+	}
+
+	userAuth, err := c.m.GetUserAuthentication(userID)
+	if err != nil {
+		c.logger.Errorf(ctx, "user %v get auth info failed: %v", userID, err)
+
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	var since time.Time
+
+	if c.cfg.PasswordHistory.Retention > 0 {
+		since = time.Now().Add(-c.cfg.PasswordHistory.Retention)
+	}
+
+	hashes, err := c.m.GetUserPasswordHistory(userID, c.cfg.PasswordHistory.Count, since)
+	if err != nil {
+		c.logger.Errorf(ctx, "user %v get password history failed: %v", userID, err)
+
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	if userAuth != nil && userAuth.Password != "" {
+		hashes = append(hashes, userAuth.Password)
+	}
+
+	for _, hashed := range hashes {
+		ok, _, errV := c.passVerify(hashed, newPassword)
+		if errV != nil {
+			c.logger.Warnf(ctx, "user %v verify history password failed: %v", userID, errV)
+
+			continue
+		}
+
+		if ok {
+			return userpb.UserStatus_USER_STATUS_PASSWORD_REUSED, errors.New("password used recently")
+		}
+	}
+
+	return userpb.UserStatus_USER_STATUS_SUCCESS, nil
+}
+
+func (c *Controller) updateUserPassword(userID int64, encryptedPassword string) error {
+	return c.m.UpdateUserPasswordWithHistory(userID, encryptedPassword, c.cfg.PasswordHistory.Count,
+		c.cfg.PasswordHistory.Retention)
+}
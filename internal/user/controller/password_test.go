@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+func TestChangePassword_History(t *testing.T) {
+	c, _, _ := newTestController(t)
+	c.cfg.PasswordHistory.Count = 2
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	current := "password1"
+
+	changePassword := func(newPassword string) userpb.UserStatus {
+		_, tokens := newTestSession(t, c, userID, "")
+
+		status, _, _, err := c.ChangePassword(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+			current, newPassword)
+		if status == userpb.UserStatus_USER_STATUS_SUCCESS {
+			current = newPassword
+		} else if status != userpb.UserStatus_USER_STATUS_PASSWORD_REUSED {
+			t.Fatalf("change password to %v failed: %v, %v", newPassword, status, err)
+		}
+
+		return status
+	}
+
+	steps := []struct {
+		newPassword string
+		status      userpb.UserStatus
+	}{
+		{"password1", userpb.UserStatus_USER_STATUS_PASSWORD_REUSED},
+		{"password2", userpb.UserStatus_USER_STATUS_SUCCESS},
+		// the password the user registered with is in the history since it was replaced
+		{"password1", userpb.UserStatus_USER_STATUS_PASSWORD_REUSED},
+		{"password3", userpb.UserStatus_USER_STATUS_SUCCESS},
+		{"password1", userpb.UserStatus_USER_STATUS_PASSWORD_REUSED},
+		{"password2", userpb.UserStatus_USER_STATUS_PASSWORD_REUSED},
+		{"password4", userpb.UserStatus_USER_STATUS_SUCCESS},
+		// only the last 2 replaced ones are kept
+		{"password1", userpb.UserStatus_USER_STATUS_SUCCESS},
+	}
+
+	for i, step := range steps {
+		if status := changePassword(step.newPassword); status != step.status {
+			t.Fatalf("step %v: change password to %v: %v, want %v", i, step.newPassword, status, step.status)
+		}
+	}
+
+	hashes, err := c.m.GetUserPasswordHistory(userID, 10, time.Time{})
+	if err != nil || len(hashes) != 2 {
+		t.Fatalf("history of %v passwords: %v", len(hashes), err)
+	}
+}
@@ -0,0 +1,269 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sbasestarter/db-orm/go/user"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/model"
+)
+
+const (
+	apiKeyKindPersonalAccessToken = "pat"
+
+	// tokenTypePersonalAccess is the token type of personal access tokens in introspection
+	tokenTypePersonalAccess = "personal_access_token"
+)
+
+var (
+	errPersonalAccessTokenRevoked = errors.New("personal access token revoked")
+	errPersonalAccessTokenExpired = errors.New("personal access token expired")
+	errNotSession                 = errors.New("not a session token")
+)
+
+func isPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, apiKeyKindPersonalAccessToken+"_")
+}
+
+// lookupPersonalAccessToken finds the active token of key and its user
+func (c *Controller) lookupPersonalAccessToken(ctx context.Context, key string) (pat *model.PersonalAccessToken,
+	userInfo *user.UserInfo, err error) {
+	keyID, secret, ok := parseAPIKey(apiKeyKindPersonalAccessToken, key)
+	if !ok {
+		err = errBadAPIKey
+
+		return
+	}
+
+	pat, err = c.m.GetPersonalAccessTokenByKeyID(keyID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get personal access token of key %v failed: %v", keyID, err)
+
+		return
+	}
+
+	if pat == nil || !apiKeySecretMatch(secret, pat.KeyHash) {
+		err = errBadAPIKey
+
+		return
+	}
+
+	if pat.RevokeAt != nil {
+		err = errPersonalAccessTokenRevoked
+
+		return
+	}
+
+	if time.Now().After(pat.ExpireAt) {
+		err = errPersonalAccessTokenExpired
+
+		return
+	}
+
+	userInfo, err = c.m.GetUserInfo(pat.UserId)
+
+	return
+}
+
+// verifyPersonalAccessToken authenticates as the owner of the token, without a session
+func (c *Controller) verifyPersonalAccessToken(ctx context.Context, key string) (authInfo *AuthInfo, err error) {
+	pat, userInfo, err := c.lookupPersonalAccessToken(ctx, key)
+	if err != nil {
+		return
+	}
+
+	ip := c.utils.GetPeerIP(ctx)
+
+	if !apiKeyUsedRecently(pat.LastUsedAt, pat.LastUsedIp, ip) {
+		if err = c.m.UpdatePersonalAccessTokenUsed(pat.Id, ip); err != nil {
+			c.logger.Warnf(ctx, "update personal access token %v used failed: %v", pat.Id, err)
+
+			err = nil
+		}
+	}
+
+	authInfo = c.dbUser2AuthInfo(userInfo)
+	authInfo.ExpiresAt = pat.ExpireAt.Unix()
+	authInfo.ClientIP = ip
+	authInfo.PersonalAccessTokenID = pat.Id
+	authInfo.Scopes = strings.Fields(pat.Scopes)
+
+	return
+}
+
+// checkSessionToken refuses api keys and the tokens of oidc clients for the calls which manage the account
+// or its credentials
+func (c *Controller) checkSessionToken(ctx context.Context, authInfo *AuthInfo) (userpb.UserStatus, error) {
+	if !authInfo.IsSession() {
+		c.logger.Warnf(ctx, "token of user %v, service account %v, oidc client %q refused", authInfo.UserID,
+			authInfo.ServiceAccountID, authInfo.OIDCClientID)
+
+		return userpb.UserStatus_USER_STATUS_DONT_SUPPORT, errNotSession
+	}
+
+	return userpb.UserStatus_USER_STATUS_SUCCESS, nil
+}
+
+// fixAndVerifySessionToken is fixAndVerifyToken for the calls which refuse api keys
+func (c *Controller) fixAndVerifySessionToken(ctx context.Context, token string) (
+	status userpb.UserStatus, authInfo *AuthInfo, err error) {
+	status, _, authInfo, err = c.fixAndVerifyToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || authInfo == nil {
+		c.logger.Errorf(ctx, "verify token failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	status, err = c.checkSessionToken(ctx, authInfo)
+
+	return
+}
+
+func (c *Controller) ListPersonalAccessTokens(ctx context.Context, token string) (status userpb.UserStatus,
+	tokens []*userpb.PersonalAccessToken, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	pats, err := c.m.GetPersonalAccessTokens(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get personal access tokens of %v failed: %v", authInfo.UserID, err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	for _, pat := range pats {
+		tokens = append(tokens, personalAccessToken2Pb(pat))
+	}
+
+	return
+}
+
+// CreatePersonalAccessToken returns the key of the new token, it can't be got again. The token lives
+// for the max lifetime if expireAt is 0
+func (c *Controller) CreatePersonalAccessToken(ctx context.Context, token, csrfToken, name string, scopes []string,
+	expireAt int64) (status userpb.UserStatus, pat *userpb.PersonalAccessToken, key string, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	now := time.Now()
+	maxExpireAt := now.Add(c.cfg.PersonalAccessToken.MaxLifetime)
+
+	if expireAt == 0 {
+		expireAt = maxExpireAt.Unix()
+	}
+
+	scopes, ok := fixScopes(scopes)
+	if name == "" || len(name) > 64 || !ok || expireAt <= now.Unix() || expireAt > maxExpireAt.Unix() {
+		c.logger.Errorf(ctx, "invalid input: %v, %v, %v", name, scopes, expireAt)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	pats, err := c.m.GetPersonalAccessTokens(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get personal access tokens of %v failed: %v", authInfo.UserID, err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if len(pats) >= c.cfg.PersonalAccessToken.MaxCount {
+		c.logger.Warnf(ctx, "user %v has too many personal access tokens", authInfo.UserID)
+
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+
+		return
+	}
+
+	for _, p := range pats {
+		if p.Name == name {
+			status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+			return
+		}
+	}
+
+	key, keyID, keyHash, err := newAPIKey(apiKeyKindPersonalAccessToken)
+	if err != nil {
+		c.logger.Errorf(ctx, "new api key failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	dbPat := &model.PersonalAccessToken{
+		UserId:   authInfo.UserID,
+		Name:     name,
+		Scopes:   strings.Join(scopes, " "),
+		KeyId:    keyID,
+		KeyHash:  keyHash,
+		ExpireAt: time.Unix(expireAt, 0),
+		CreateAt: now,
+	}
+
+	err = c.m.AddPersonalAccessToken(dbPat)
+	if err != nil {
+		c.logger.Errorf(ctx, "add personal access token of %v failed: %v", authInfo.UserID, err)
+
+		key = ""
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	pat = personalAccessToken2Pb(dbPat)
+
+	return
+}
+
+func (c *Controller) RevokePersonalAccessToken(ctx context.Context, token, csrfToken string, id int64) (
+	status userpb.UserStatus, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	ok, err := c.m.RevokePersonalAccessToken(authInfo.UserID, id)
+	if err != nil {
+		c.logger.Errorf(ctx, "revoke personal access token %v failed: %v", id, err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if !ok {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+	}
+
+	return
+}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/model"
+)
+
+func newTestPersonalAccessToken(t *testing.T, c *Controller, token, name string, scopes ...string) (
+	*userpb.PersonalAccessToken, string) {
+	t.Helper()
+
+	status, pat, key, err := c.CreatePersonalAccessToken(context.Background(), token,
+		newTestCsrfToken(t, c, token), name, scopes, 0)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("create personal access token %v failed: %v, %v", name, status, err)
+	}
+
+	return pat, key
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	c, _, _ := newTestController(t)
+	c.cfg.PersonalAccessToken.MaxCount = 2
+	c.cfg.PersonalAccessToken.MaxLifetime = 24 * time.Hour
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	_, tokens := newTestSession(t, c, userID, "")
+
+	pat, key := newTestPersonalAccessToken(t, c, tokens.AccessToken, "ci", "read", "write", "read")
+	if !isPersonalAccessToken(key) || !reflect.DeepEqual(pat.Scopes, []string{"read", "write"}) ||
+		pat.ExpireAt > time.Now().Add(24*time.Hour).Unix() {
+		t.Fatalf("unexpected personal access token %+v, %v", pat, key)
+	}
+
+	authInfo, err := c.verifyToken(ctx, key)
+	if err != nil || authInfo.UserID != userID || authInfo.PersonalAccessTokenID != pat.Id ||
+		!reflect.DeepEqual(authInfo.Scopes, pat.Scopes) {
+		t.Fatalf("verify personal access token: %+v, %v", authInfo, err)
+	}
+
+	status, tokensList, err := c.ListPersonalAccessTokens(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(tokensList) != 1 || tokensList[0].Id != pat.Id ||
+		tokensList[0].LastUsedAt == 0 {
+		t.Fatalf("list personal access tokens: %v, %+v, %v", status, tokensList, err)
+	}
+
+	cases := []struct {
+		name     string
+		scopes   []string
+		expireAt int64
+	}{
+		{"", nil, 0},
+		{"ci", nil, 0},
+		{"bad scope", []string{"a b"}, 0},
+		{"expired", nil, time.Now().Add(-time.Minute).Unix()},
+		{"too long", nil, time.Now().Add(25 * time.Hour).Unix()},
+	}
+
+	for _, tc := range cases {
+		status, _, key, _ := c.CreatePersonalAccessToken(ctx, tokens.AccessToken,
+			newTestCsrfToken(t, c, tokens.AccessToken), tc.name, tc.scopes, tc.expireAt)
+		if status != userpb.UserStatus_USER_STATUS_BAD_INPUT || key != "" {
+			t.Fatalf("create %q: %v", tc.name, status)
+		}
+	}
+
+	status, _, _, _ = c.CreatePersonalAccessToken(ctx, tokens.AccessToken, "", "deploy", nil, 0)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("create without csrf token: %v", status)
+	}
+
+	newTestPersonalAccessToken(t, c, tokens.AccessToken, "deploy")
+
+	status, _, _, _ = c.CreatePersonalAccessToken(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		"more", nil, 0)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("create over max count: %v", status)
+	}
+
+	// the tokens of other users can't be revoked
+	otherUserID := newTestUser(t, c, "other@web.com", "password1")
+	_, otherTokens := newTestSession(t, c, otherUserID, "")
+
+	status, _ = c.RevokePersonalAccessToken(ctx, otherTokens.AccessToken,
+		newTestCsrfToken(t, c, otherTokens.AccessToken), pat.Id)
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("revoke token of other user: %v", status)
+	}
+
+	status, err = c.RevokePersonalAccessToken(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken), pat.Id)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("revoke failed: %v, %v", status, err)
+	}
+
+	if _, err = c.verifyToken(ctx, key); err == nil {
+		t.Fatal("revoked personal access token verified")
+	}
+
+	// the revoked token isn't counted any more
+	newTestPersonalAccessToken(t, c, tokens.AccessToken, "ci")
+}
+
+func TestPersonalAccessToken_Expired(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+
+	key, keyID, keyHash, err := newAPIKey(apiKeyKindPersonalAccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.m.AddPersonalAccessToken(&model.PersonalAccessToken{
+		UserId:   userID,
+		Name:     "ci",
+		KeyId:    keyID,
+		KeyHash:  keyHash,
+		ExpireAt: time.Now().Add(-time.Second),
+		CreateAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.verifyToken(ctx, key); err == nil {
+		t.Fatal("expired personal access token verified")
+	}
+
+	_, tokens := newTestSession(t, c, userID, "")
+
+	status, tokensList, _ := c.ListPersonalAccessTokens(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(tokensList) != 0 {
+		t.Fatalf("expired token listed: %v, %+v", status, tokensList)
+	}
+}
+
+// TestPersonalAccessToken_NotSession the key of a token doesn't manage the account it acts for
+func TestPersonalAccessToken_NotSession(t *testing.T) {
+	c, _, _ := newTestController(t)
+	c.cfg.PersonalAccessToken.MaxCount = 2
+	c.cfg.PersonalAccessToken.MaxLifetime = 24 * time.Hour
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	_, tokens := newTestSession(t, c, userID, "")
+	_, key := newTestPersonalAccessToken(t, c, tokens.AccessToken, "ci")
+
+	status, csrfToken, _ := c.GetCsrfToken(ctx, key)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT || csrfToken != "" {
+		t.Fatalf("csrf token for personal access token: %v", status)
+	}
+
+	status, _, _, _ = c.ChangePassword(ctx, key, newTestCsrfToken(t, c, tokens.AccessToken), "password1", "password2")
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("change password by personal access token: %v", status)
+	}
+
+	status, _, _, _ = c.CreatePersonalAccessToken(ctx, key, newTestCsrfToken(t, c, tokens.AccessToken), "more", nil, 0)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("create personal access token by personal access token: %v", status)
+	}
+
+	status, _, _ = c.ListSessions(ctx, key)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("sessions for personal access token: %v", status)
+	}
+
+	status, _ = c.Logout(ctx, key)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("logout by personal access token: %v", status)
+	}
+
+	status, _, ssoToken, _, _ := c.Profile(ctx, key, true, "https://a.example.com/")
+	if status == userpb.UserStatus_USER_STATUS_SUCCESS || ssoToken != "" {
+		t.Fatalf("sso token for personal access token: %v", status)
+	}
+
+	// the token still reads the profile, and the password wasn't changed
+	status, userInfo, _, _, err := c.Profile(ctx, key, false, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || userInfo == nil {
+		t.Fatalf("profile by personal access token failed: %v, %v", status, err)
+	}
+
+	status, _, _, _ = c.ChangePassword(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		"password1", "password2")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("change password by session failed: %v", status)
+	}
+}
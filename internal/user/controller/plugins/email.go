@@ -104,3 +104,7 @@ func (ea *emailAuthentication) GetSendLockTimeDuration() time.Duration {
 func (ea *emailAuthentication) GetValidDelayDuration() time.Duration {
 	return ea.cfg.ValidDelayDuration
 }
+
+func (ea *emailAuthentication) GetMaxAttempts() int {
+	return ea.cfg.MaxAttempts
+}
@@ -0,0 +1,108 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sbasestarter/user/internal/user/controller/plugins/ldapauth"
+	"github.com/sgostarter/i/l"
+	"github.com/sgostarter/libeasygo/cuserror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ldapAuthentication signs users in with the password of an LDAP or Active Directory server, the user ve
+// of them is the name of the directory
+type ldapAuthentication struct {
+	name      string
+	directory *ldapauth.Directory
+	logger    l.WrapperWithContext
+}
+
+func NewLDAPAuthentication(cfg *config.LDAPConfig, logger l.Wrapper) (Plugin, error) {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	directory, err := ldapauth.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ldapAuthentication{
+		name:      cfg.Name,
+		directory: directory,
+		logger:    logger.WithFields(l.StringField(l.ClsKey, "ldapAuthentication")).GetWrapperWithContext(),
+	}, nil
+}
+
+func (la *ldapAuthentication) FixUserID(ctx context.Context, user *userpb.UserId) (*userpb.UserId, bool, error) {
+	if user.UserVe != la.name {
+		return nil, false, nil
+	}
+
+	// directories match user names case-insensitively, keep one local user for all the spellings
+	userName := strings.ToLower(strings.TrimSpace(user.UserName))
+	if userName == "" {
+		return nil, true, cuserror.NewWithErrorMsg("empty user name")
+	}
+
+	return &userpb.UserId{
+		UserName: userName,
+		UserVe:   user.UserVe,
+	}, true, nil
+}
+
+func (la *ldapAuthentication) TriggerAuthentication(ctx context.Context, userName, code string,
+	purpose userpb.TriggerAuthPurpose) (err error) {
+	return cuserror.NewWithErrorMsg("no code to send")
+}
+
+func (la *ldapAuthentication) GetNickName(ctx context.Context, userName string) string {
+	return ""
+}
+
+func (la *ldapAuthentication) TryAutoLogin(ctx context.Context, user *userpb.UserId, token string) (
+	userFixed *userpb.UserId, nickName, avatar string, err error) {
+	err = status.Error(codes.Unimplemented, "sign in with the password")
+
+	return
+}
+
+func (la *ldapAuthentication) VerifyPassword(ctx context.Context, userName, password string) (*DirectoryUser, error) {
+	entry, err := la.directory.Authenticate(ctx, userName, password)
+	if err != nil {
+		if errors.Is(err, ldapauth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		la.logger.Errorf(ctx, "authenticate %v failed: %v", userName, err)
+
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	admin, syncAdmin := la.directory.IsAdmin(entry)
+
+	return &DirectoryUser{
+		NickName:  entry.NickName,
+		Email:     entry.Email,
+		Admin:     admin,
+		SyncAdmin: syncAdmin,
+	}, nil
+}
+
+func (la *ldapAuthentication) GetSendLockTimeDuration() time.Duration {
+	return 0
+}
+
+func (la *ldapAuthentication) GetValidDelayDuration() time.Duration {
+	return 0
+}
+
+func (la *ldapAuthentication) GetMaxAttempts() int {
+	return 1
+}
@@ -0,0 +1,280 @@
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+var (
+	// ErrInvalidCredentials the user doesn't exist, or the password is wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrAmbiguousUser more than one entry match the user name
+	ErrAmbiguousUser = errors.New("ambiguous user")
+)
+
+// Entry is the directory entry of a user
+type Entry struct {
+	DN       string
+	NickName string
+	Email    string
+	Groups   []string
+}
+
+type Directory struct {
+	cfg         *config.LDAPConfig
+	adminGroups map[string]bool
+	tlsConfig   *tls.Config
+}
+
+func New(cfg *config.LDAPConfig) (*Directory, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("ldap needs url")
+	}
+
+	if cfg.BindDNTemplate == "" && (cfg.UserFilter == "" || cfg.BaseDN == "") {
+		return nil, errors.New("ldap needs bind dn template, or user filter and base dn")
+	}
+
+	if cfg.BindDNTemplate != "" && strings.Count(cfg.BindDNTemplate, "%s") != 1 {
+		return nil, fmt.Errorf("bind dn template %q needs exactly one %%s", cfg.BindDNTemplate)
+	}
+
+	if cfg.UserFilter != "" && strings.Count(cfg.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("user filter %q needs exactly one %%s", cfg.UserFilter)
+	}
+
+	d := &Directory{
+		cfg:         cfg,
+		adminGroups: make(map[string]bool),
+	}
+
+	for _, group := range cfg.AdminGroups {
+		d.adminGroups[normalizeDN(group)] = true
+	}
+
+	return d, nil
+}
+
+// SetTLSConfig replaces the tls config of ldaps and StartTLS
+func (d *Directory) SetTLSConfig(tlsConfig *tls.Config) {
+	d.tlsConfig = tlsConfig
+}
+
+// IsAdmin tells if entry is a member of one of the admin groups, ok is false if there're none
+func (d *Directory) IsAdmin(entry *Entry) (admin, ok bool) {
+	if len(d.adminGroups) == 0 {
+		return false, false
+	}
+
+	for _, group := range entry.Groups {
+		if d.adminGroups[normalizeDN(group)] {
+			return true, true
+		}
+	}
+
+	return false, true
+}
+
+// Authenticate binds as userName with password, and reads the entry of it
+func (d *Directory) Authenticate(ctx context.Context, userName, password string) (*Entry, error) {
+	// a simple bind without password is an unauthenticated one, which always succeeds, RFC 4513 section 5.1.2
+	if userName == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	var entry *ldap.Entry
+
+	var bindDN string
+
+	if d.cfg.BindDNTemplate == "" {
+		if d.cfg.BindDN != "" {
+			err = conn.Bind(d.cfg.BindDN, d.cfg.BindPassword)
+			if err != nil {
+				return nil, fmt.Errorf("bind service account: %w", err)
+			}
+		}
+
+		entry, err = d.searchUser(conn, userName)
+		if err != nil {
+			return nil, err
+		}
+
+		bindDN = entry.DN
+	} else {
+		bindDN = fmt.Sprintf(d.cfg.BindDNTemplate, escapeDN(userName))
+	}
+
+	err = conn.Bind(bindDN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, fmt.Errorf("bind user: %w", err)
+	}
+
+	if entry == nil {
+		if d.cfg.UserFilter != "" {
+			entry, err = d.searchUser(conn, userName)
+		} else {
+			entry, err = d.readEntry(conn, bindDN)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Entry{
+		DN:       entry.DN,
+		NickName: entry.GetEqualFoldAttributeValue(d.cfg.NickNameAttribute),
+		Email:    entry.GetEqualFoldAttributeValue(d.cfg.EmailAttribute),
+		Groups:   entry.GetEqualFoldAttributeValues(d.cfg.GroupAttribute),
+	}, nil
+}
+
+func (d *Directory) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: d.cfg.Timeout,
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	opts := []ldap.DialOpt{ldap.DialWithDialer(dialer)}
+	if d.tlsConfig != nil {
+		opts = append(opts, ldap.DialWithTLSConfig(d.tlsConfig))
+	}
+
+	conn, err := ldap.DialURL(d.cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(d.cfg.Timeout)
+
+	if d.cfg.StartTLS {
+		tlsConfig := d.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+
+		if tlsConfig.ServerName == "" {
+			if u, errP := url.Parse(d.cfg.URL); errP == nil {
+				tlsConfig = tlsConfig.Clone()
+				tlsConfig.ServerName = u.Hostname()
+			}
+		}
+
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (d *Directory) attributes() []string {
+	return []string{d.cfg.NickNameAttribute, d.cfg.EmailAttribute, d.cfg.GroupAttribute}
+}
+
+func (d *Directory) searchUser(conn *ldap.Conn, userName string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.cfg.Timeout.Seconds()), false, fmt.Sprintf(d.cfg.UserFilter, ldap.EscapeFilter(userName)),
+		d.attributes(), nil))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrAmbiguousUser
+		}
+
+		return nil, fmt.Errorf("search user: %w", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrInvalidCredentials
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, ErrAmbiguousUser
+	}
+}
+
+func (d *Directory) readEntry(conn *ldap.Conn, dn string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, int(d.cfg.Timeout.Seconds()), false, "(objectClass=*)", d.attributes(), nil))
+	if err != nil {
+		return nil, fmt.Errorf("read entry: %w", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return nil, fmt.Errorf("read entry: %v entries of %v", len(result.Entries), dn)
+	}
+
+	return result.Entries[0], nil
+}
+
+// escapeDN escapes s as an attribute value of a dn, RFC 4514 section 2.4
+func escapeDN(s string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c == '"' || c == '+' || c == ',' || c == ';' || c == '<' || c == '>' || c == '\\' || c == '=':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(s)-1:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&sb, "\\%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
+
+// normalizeDN makes dns of different letter cases and spaces comparable, which is good enough for groups
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+
+	rdns := make([]string, 0, len(parsed.RDNs))
+
+	for _, rdn := range parsed.RDNs {
+		attributes := make([]string, 0, len(rdn.Attributes))
+
+		for _, attribute := range rdn.Attributes {
+			attributes = append(attributes, strings.ToLower(attribute.Type)+"="+strings.ToLower(attribute.Value))
+		}
+
+		rdns = append(rdns, strings.Join(attributes, "+"))
+	}
+
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,419 @@
+package ldapauth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchResultItem = 4
+	opSearchResultDone = 5
+
+	resultSuccess                 = 0
+	resultSizeLimitExceeded       = 4
+	resultInvalidCredentials      = 49
+	resultInsufficientAccessRight = 50
+	resultUnwillingToPerform      = 53
+)
+
+type testEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testServer is an in-process directory which knows simple binds and searches by and, or, equality and
+// present filters
+type testServer struct {
+	listener net.Listener
+	entries  []*testEntry
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{
+		listener: listener,
+		entries: []*testEntry{
+			{dn: "cn=user-service,ou=services,dc=example,dc=com", password: "service-secret"},
+			{
+				dn:       "uid=alice,ou=people,dc=example,dc=com",
+				password: "alice-secret",
+				attributes: map[string][]string{
+					"objectClass": {"person"},
+					"uid":         {"alice"},
+					"displayName": {"Alice Liddell"},
+					"mail":        {"alice@example.com"},
+					"memberOf":    {"CN=Admins, OU=Groups,DC=example,DC=com", "cn=staff,ou=groups,dc=example,dc=com"},
+				},
+			},
+			{
+				dn:       "uid=bob,ou=people,dc=example,dc=com",
+				password: "bob-secret",
+				attributes: map[string][]string{
+					"objectClass": {"person"},
+					"uid":         {"bob"},
+					"displayName": {"Bob"},
+					"mail":        {"shared@example.com"},
+					"memberOf":    {"cn=staff,ou=groups,dc=example,dc=com"},
+				},
+			},
+			{
+				dn:       "uid=carol,ou=people,dc=example,dc=com",
+				password: "carol-secret",
+				attributes: map[string][]string{
+					"objectClass": {"person"},
+					"uid":         {"carol"},
+					"mail":        {"shared@example.com"},
+				},
+			},
+		},
+	}
+
+	go s.serve()
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return s
+}
+
+func (s *testServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *testServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	var boundDN string
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		messageID := packet.Children[0].Value
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case opBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := string(op.Children[2].Data.Bytes())
+
+			code := resultInvalidCredentials
+
+			switch {
+			case dn == "" && password == "":
+				boundDN, code = "", resultSuccess
+			case password == "":
+				code = resultUnwillingToPerform
+			default:
+				for _, entry := range s.entries {
+					if strings.EqualFold(entry.dn, dn) && entry.password == password {
+						boundDN, code = entry.dn, resultSuccess
+					}
+				}
+			}
+
+			s.write(conn, messageID, result(opBindResponse, code))
+		case opUnbindRequest:
+			return
+		case opSearchRequest:
+			if boundDN == "" {
+				s.write(conn, messageID, result(opSearchResultDone, resultInsufficientAccessRight))
+
+				continue
+			}
+
+			s.search(conn, messageID, op)
+		default:
+			return
+		}
+	}
+}
+
+func (s *testServer) search(conn net.Conn, messageID interface{}, op *ber.Packet) {
+	baseDN := strings.ToLower(op.Children[0].Value.(string))
+	scope := op.Children[1].Value.(int64)
+	sizeLimit := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+
+	found := 0
+
+	for _, entry := range s.entries {
+		dn := strings.ToLower(entry.dn)
+		if (scope == 0 && dn != baseDN) || (scope != 0 && !strings.HasSuffix(dn, ","+baseDN)) {
+			continue
+		}
+
+		if !match(entry, filter) {
+			continue
+		}
+
+		if sizeLimit > 0 && int64(found) >= sizeLimit {
+			s.write(conn, messageID, result(opSearchResultDone, resultSizeLimitExceeded))
+
+			return
+		}
+
+		found++
+
+		item := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchResultItem, nil, "")
+		item.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+
+		attributes := ber.NewSequence("")
+
+		for name, values := range entry.attributes {
+			attribute := ber.NewSequence("")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+
+		item.AppendChild(attributes)
+		s.write(conn, messageID, item)
+	}
+
+	s.write(conn, messageID, result(opSearchResultDone, resultSuccess))
+}
+
+func (s *testServer) write(conn net.Conn, messageID interface{}, op *ber.Packet) {
+	packet := ber.NewSequence("")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	packet.AppendChild(op)
+
+	_, _ = conn.Write(packet.Bytes())
+}
+
+func result(op ber.Tag, code int) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+
+	return packet
+}
+
+func match(entry *testEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case 0: // and
+		for _, child := range filter.Children {
+			if !match(entry, child) {
+				return false
+			}
+		}
+
+		return true
+	case 1: // or
+		for _, child := range filter.Children {
+			if match(entry, child) {
+				return true
+			}
+		}
+
+		return false
+	case 3: // equality
+		return hasValue(entry, filter.Children[0].Value.(string), filter.Children[1].Value.(string))
+	case 7: // present
+		name := string(filter.Data.Bytes())
+
+		return strings.EqualFold(name, "objectClass") || hasValue(entry, name, "")
+	}
+
+	return false
+}
+
+func hasValue(entry *testEntry, name, value string) bool {
+	for attribute, values := range entry.attributes {
+		if !strings.EqualFold(attribute, name) {
+			continue
+		}
+
+		for _, v := range values {
+			if value == "" || strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func testConfig(url string) *config.LDAPConfig {
+	return &config.LDAPConfig{
+		URL:               url,
+		BindDN:            "cn=user-service,ou=services,dc=example,dc=com",
+		BindPassword:      "service-secret",
+		BaseDN:            "ou=people,dc=example,dc=com",
+		UserFilter:        "(&(objectClass=person)(uid=%s))",
+		NickNameAttribute: "displayName",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		AdminGroups:       []string{"cn=admins,ou=groups,dc=example,dc=com"},
+	}
+}
+
+func TestAuthenticateBySearch(t *testing.T) {
+	server := newTestServer(t)
+
+	d, err := New(testConfig(server.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := d.Authenticate(context.Background(), "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.DN != "uid=alice,ou=people,dc=example,dc=com" || entry.NickName != "Alice Liddell" ||
+		entry.Email != "alice@example.com" || len(entry.Groups) != 2 {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	if admin, ok := d.IsAdmin(entry); !admin || !ok {
+		t.Fatal("alice isn't admin")
+	}
+
+	entry, err = d.Authenticate(context.Background(), "bob", "bob-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if admin, ok := d.IsAdmin(entry); admin || !ok {
+		t.Fatal("bob is admin")
+	}
+
+	for _, c := range [][2]string{
+		{"alice", "bob-secret"},
+		{"alice", ""},
+		{"nobody", "alice-secret"},
+		{"*", "alice-secret"},
+		{"", ""},
+	} {
+		if _, err = d.Authenticate(context.Background(), c[0], c[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%v: unexpected error %v", c, err)
+		}
+	}
+}
+
+func TestAuthenticateByTemplate(t *testing.T) {
+	server := newTestServer(t)
+
+	cfg := testConfig(server.URL())
+	cfg.BindDN = ""
+	cfg.UserFilter = ""
+	cfg.BindDNTemplate = "uid=%s,ou=people,dc=example,dc=com"
+
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := d.Authenticate(context.Background(), "bob", "bob-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.NickName != "Bob" || entry.Email != "shared@example.com" {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	for _, c := range [][2]string{
+		{"bob", "alice-secret"},
+		{"bob,ou=people", "bob-secret"},
+	} {
+		if _, err = d.Authenticate(context.Background(), c[0], c[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%v: unexpected error %v", c, err)
+		}
+	}
+}
+
+func TestAuthenticateErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	cfg := testConfig(server.URL())
+	cfg.UserFilter = "(mail=%s)"
+
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = d.Authenticate(context.Background(), "shared@example.com", "bob-secret"); !errors.Is(err, ErrAmbiguousUser) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cfg = testConfig(server.URL())
+	cfg.BindPassword = "wrong"
+
+	d, _ = New(cfg)
+
+	if _, err = d.Authenticate(context.Background(), "alice", "alice-secret"); err == nil ||
+		errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, cfg := range []*config.LDAPConfig{
+		{BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)"},
+		{URL: server.URL(), UserFilter: "(uid=%s)"},
+		{URL: server.URL(), BaseDN: "dc=example,dc=com", UserFilter: "(uid=alice)"},
+		{URL: server.URL(), BindDNTemplate: "uid=%s,cn=%s"},
+	} {
+		if _, err = New(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	for s, escaped := range map[string]string{
+		"alice":     "alice",
+		"a,b=c+d":   `a\,b\=c\+d`,
+		" #a ":      `\ #a\ `,
+		"#a":        `\#a`,
+		"a\x00b":    `a\00b`,
+		`x"<;>\`:    `x\"\<\;\>\\`,
+		"张三":        "张三",
+		"a#b c":     "a#b c",
+		"trailing ": `trailing\ `,
+	} {
+		if got := escapeDN(s); got != escaped {
+			t.Errorf("escapeDN(%q) = %q, expect %q", s, got, escaped)
+		}
+	}
+}
@@ -90,6 +90,10 @@ func (pa *phoneAuthentication) GetValidDelayDuration() time.Duration {
 	return pa.cfg.ValidDelayDuration
 }
 
+func (pa *phoneAuthentication) GetMaxAttempts() int {
+	return pa.cfg.MaxAttempts
+}
+
 func (pa *phoneAuthentication) fixPhone(ctx context.Context, phone string) (string, error) {
 	if !strings.HasPrefix(phone, "+") {
 		phone = "+86" + phone
@@ -15,4 +15,20 @@ type Plugin interface {
 		userFixed *userpb.UserId, nickName, avatar string, err error)
 	GetSendLockTimeDuration() time.Duration
 	GetValidDelayDuration() time.Duration
+	GetMaxAttempts() int
+}
+
+// DirectoryUser is what an external directory tells about a user who signed in
+type DirectoryUser struct {
+	NickName string
+	Email    string
+	// Admin is meaningful only if SyncAdmin is true
+	Admin     bool
+	SyncAdmin bool
+}
+
+// PasswordPlugin is a Plugin whose users sign in with the password of an external directory instead of the
+// local one. VerifyPassword fails with codes.Unauthenticated if the password is wrong
+type PasswordPlugin interface {
+	VerifyPassword(ctx context.Context, userName, password string) (*DirectoryUser, error)
 }
@@ -2,8 +2,9 @@ package plugins
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"strconv"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 	"github.com/sbasestarter/user/internal/user/controller/factory"
 	"github.com/sgostarter/i/l"
 	"github.com/sgostarter/libeasygo/cuserror"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 type Plugins struct {
@@ -36,6 +39,49 @@ func NewPlugins(cfg *config.Config, cliFactory factory.GRPCClientFactory, logger
 	plugins.authentications[userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_PHONE.String()] =
 		NewPhoneAuthentication(&cfg.PhoneConfig, cliFactory, logger)
 
+	if cfg.WxMinA.Enable {
+		plugin, err := NewWxMinAAuthentication(&cfg.WxMinA, nil, logger)
+		if err != nil {
+			plugins.logger.Fatalf(context.Background(), "load mini program failed: %v", err)
+		}
+
+		plugins.authentications[userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_WX_MINA.String()] = plugin
+	}
+
+	for _, socialCfg := range cfg.SocialLogins {
+		if _, ok := plugins.authentications[socialCfg.Name]; ok {
+			plugins.logger.Fatalf(context.Background(), "duplicated user ve %v", socialCfg.Name)
+		}
+
+		if _, ok := userpb.VerificationEquipment_value[socialCfg.Name]; ok {
+			plugins.logger.Fatalf(context.Background(), "social login %v takes a builtin user ve", socialCfg.Name)
+		}
+
+		plugin, err := NewSocialAuthentication(socialCfg, logger)
+		if err != nil {
+			plugins.logger.Fatalf(context.Background(), "load social login failed: %v", err)
+		}
+
+		plugins.authentications[socialCfg.Name] = plugin
+	}
+
+	if cfg.LDAP.Enable {
+		if _, ok := plugins.authentications[cfg.LDAP.Name]; ok {
+			plugins.logger.Fatalf(context.Background(), "duplicated user ve %v", cfg.LDAP.Name)
+		}
+
+		if _, ok := userpb.VerificationEquipment_value[cfg.LDAP.Name]; ok {
+			plugins.logger.Fatalf(context.Background(), "ldap takes a builtin user ve %v", cfg.LDAP.Name)
+		}
+
+		plugin, err := NewLDAPAuthentication(&cfg.LDAP, logger)
+		if err != nil {
+			plugins.logger.Fatalf(context.Background(), "load ldap failed: %v", err)
+		}
+
+		plugins.authentications[cfg.LDAP.Name] = plugin
+	}
+
 	return plugins
 }
 
@@ -106,7 +152,14 @@ func (ps *Plugins) TriggerAuthentication(ctx context.Context, user *userpb.UserI
 	status userpb.UserStatus, code string, err error) {
 	status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
 
-	newCode := ps.newVerifyCode()
+	newCode, err := ps.newVerifyCode()
+	if err != nil {
+		ps.logger.Errorf(ctx, "new verify code failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
 
 	ps.pluginDo(user, func(plugin Plugin) {
 		err = plugin.TriggerAuthentication(ctx, user.UserName, newCode, purpose)
@@ -140,16 +193,69 @@ func (ps *Plugins) GetNickName(ctx context.Context, user *userpb.UserId) (status
 
 func (ps *Plugins) TryAutoLogin(ctx context.Context, user *userpb.UserId, token string) (
 	status userpb.UserStatus, userFixed *userpb.UserId, nickName, avatar string) {
-	if user.UserVe == userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_WX_MINA.String() {
-		ps.logger.Warn(ctx, "WxMinA not implement")
+	status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
 
-		status = userpb.UserStatus_USER_STATUS_NOT_IMPLEMENT
+	ps.pluginDo(user, func(plugin Plugin) {
+		var err error
 
-		return
-	}
+		userFixed, nickName, avatar, err = plugin.TryAutoLogin(ctx, user, token)
+		if err == nil {
+			status = userpb.UserStatus_USER_STATUS_SUCCESS
 
+			return
+		}
+
+		switch grpcstatus.Code(err) {
+		case codes.Unimplemented:
+		case codes.InvalidArgument:
+			status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+		default:
+			ps.logger.Errorf(ctx, "auto login of %v failed: %v", user.UserVe, err)
+
+			status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+		}
+	})
+
+	return
+}
+
+// IsPasswordVe tells if the users of the ve of user sign in with the password of an external directory
+func (ps *Plugins) IsPasswordVe(user *userpb.UserId) (ok bool) {
+	ps.pluginDo(user, func(plugin Plugin) {
+		_, ok = plugin.(PasswordPlugin)
+	})
+
+	return
+}
+
+func (ps *Plugins) VerifyPassword(ctx context.Context, user *userpb.UserId, password string) (
+	status userpb.UserStatus, directoryUser *DirectoryUser) {
 	status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
 
+	ps.pluginDo(user, func(plugin Plugin) {
+		passwordPlugin, ok := plugin.(PasswordPlugin)
+		if !ok {
+			return
+		}
+
+		var err error
+
+		directoryUser, err = passwordPlugin.VerifyPassword(ctx, user.UserName, password)
+		if err == nil {
+			status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+			return
+		}
+
+		if grpcstatus.Code(err) == codes.Unauthenticated {
+			status = userpb.UserStatus_USER_STATUS_WRONG_PASSWORD
+		} else {
+			ps.logger.Errorf(ctx, "verify password of %v failed: %v", user.UserVe, err)
+
+			status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+		}
+	})
+
 	return
 }
 
@@ -169,11 +275,25 @@ func (ps *Plugins) ValidDelayDuration(ctx context.Context, user *userpb.UserId)
 	return
 }
 
-func (ps *Plugins) newVerifyCode() string {
+func (ps *Plugins) MaxAttempts(_ context.Context, user *userpb.UserId) (attempts int) {
+	attempts = 1
+
+	ps.pluginDo(user, func(plugin Plugin) {
+		attempts = plugin.GetMaxAttempts()
+	})
+
+	return
+}
+
+func (ps *Plugins) newVerifyCode() (string, error) {
 	if ps.cfg.DummyVerifyCode != "" {
-		return ps.cfg.DummyVerifyCode
+		return ps.cfg.DummyVerifyCode, nil
 	}
 
-	// nolint: gosec
-	return fmt.Sprintf("%v", rand.Intn(900000)+100000)
+	n, err := rand.Int(rand.Reader, big.NewInt(900000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%v", n.Int64()+100000), nil
 }
@@ -31,6 +31,9 @@ func GRPCPostCode(ctx context.Context, purpose userpb.TriggerAuthPurpose, userNa
 		purposeType = postsbspb.PostPurposeType_POST_PURPOSE_TYPE_LOGIN
 	case userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_RESET_PASSWORD:
 		purposeType = postsbspb.PostPurposeType_POST_PURPOSE_TYPE_RESET_PASSWORD
+	case userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LINK:
+		// a linked identity is a new one of the user, it's verified as on registering
+		purposeType = postsbspb.PostPurposeType_POST_PURPOSE_TYPE_REGISTER
 	default:
 		err := cuserror.NewWithErrorMsg(fmt.Sprintf("unknown purpose %v", purposeType))
 		logger.Error(ctx, err)
@@ -0,0 +1,94 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sbasestarter/user/internal/user/controller/plugins/social"
+	"github.com/sgostarter/i/l"
+	"github.com/sgostarter/libeasygo/cuserror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// socialAuthentication signs users in with the authorization code of an OAuth2 or OpenID Connect provider,
+// the user ve of them is the name of the provider
+type socialAuthentication struct {
+	provider *social.Provider
+	logger   l.WrapperWithContext
+}
+
+func NewSocialAuthentication(cfg config.SocialLoginConfig, logger l.Wrapper) (Plugin, error) {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	provider, err := social.NewProvider(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &socialAuthentication{
+		provider: provider,
+		logger:   logger.WithFields(l.StringField(l.ClsKey, "socialAuthentication")).GetWrapperWithContext(),
+	}, nil
+}
+
+func (sa *socialAuthentication) FixUserID(ctx context.Context, user *userpb.UserId) (*userpb.UserId, bool, error) {
+	if user.UserVe != sa.provider.Name() {
+		return nil, false, nil
+	}
+
+	sa.logger.Errorf(ctx, "user of %v signs in without code", user.UserVe)
+
+	return nil, true, cuserror.NewWithErrorMsg("sign in with the authorization code")
+}
+
+func (sa *socialAuthentication) TriggerAuthentication(ctx context.Context, userName, code string,
+	purpose userpb.TriggerAuthPurpose) (err error) {
+	return cuserror.NewWithErrorMsg("no code to send")
+}
+
+func (sa *socialAuthentication) GetNickName(ctx context.Context, userName string) string {
+	return ""
+}
+
+func (sa *socialAuthentication) TryAutoLogin(ctx context.Context, user *userpb.UserId, token string) (
+	userFixed *userpb.UserId, nickName, avatar string, err error) {
+	identity, err := sa.provider.Exchange(ctx, token)
+	if err != nil {
+		sa.logger.Errorf(ctx, "exchange code of %v failed: %v", sa.provider.Name(), err)
+
+		if errors.Is(err, social.ErrBadCode) {
+			err = status.Error(codes.InvalidArgument, err.Error())
+		} else {
+			err = status.Error(codes.Unavailable, err.Error())
+		}
+
+		return
+	}
+
+	userFixed = &userpb.UserId{
+		UserName: identity.Subject,
+		UserVe:   sa.provider.Name(),
+	}
+	nickName = identity.NickName
+	avatar = identity.Avatar
+
+	return
+}
+
+func (sa *socialAuthentication) GetSendLockTimeDuration() time.Duration {
+	return 0
+}
+
+func (sa *socialAuthentication) GetValidDelayDuration() time.Duration {
+	return 0
+}
+
+func (sa *socialAuthentication) GetMaxAttempts() int {
+	return 1
+}
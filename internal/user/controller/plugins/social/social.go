@@ -0,0 +1,368 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const (
+	maxResponseSize = 1 << 20
+	idTokenLeeway   = time.Minute
+)
+
+// ErrBadCode the provider refuses the authorization code
+var ErrBadCode = errors.New("bad authorization code")
+
+// Identity is the user of a provider, Subject is unique and stable in the provider
+type Identity struct {
+	Subject  string
+	NickName string
+	Avatar   string
+}
+
+// Provider is an OAuth2 or OpenID Connect provider which signs users in by the authorization code flow
+type Provider struct {
+	cfg        config.SocialLoginConfig
+	httpClient *http.Client
+
+	lock        sync.Mutex
+	tokenURL    string
+	userInfoURL string
+}
+
+func NewProvider(cfg config.SocialLoginConfig, httpClient *http.Client) (*Provider, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("provider without name")
+	}
+
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("provider %v needs client id and redirect url", cfg.Name)
+	}
+
+	switch cfg.Type {
+	case config.SocialLoginOIDC:
+		if cfg.Issuer == "" {
+			return nil, fmt.Errorf("oidc provider %v needs issuer", cfg.Name)
+		}
+	case config.SocialLoginOAuth2:
+		if cfg.TokenURL == "" || cfg.UserInfoURL == "" {
+			return nil, fmt.Errorf("oauth2 provider %v needs token url and user info url", cfg.Name)
+		}
+	default:
+		return nil, fmt.Errorf("provider %v: unknown type %q", cfg.Name, cfg.Type)
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout: 10 * time.Second,
+		}
+	}
+
+	return &Provider{
+		cfg:         cfg,
+		httpClient:  httpClient,
+		tokenURL:    cfg.TokenURL,
+		userInfoURL: cfg.UserInfoURL,
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// Exchange redeems code for the tokens of the user, and looks up who the user is
+func (p *Provider) Exchange(ctx context.Context, code string) (*Identity, error) {
+	if code == "" {
+		return nil, ErrBadCode
+	}
+
+	tokenURL, userInfoURL, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := p.redeemCode(ctx, tokenURL, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var idTokenClaims map[string]interface{}
+
+	if p.cfg.Type == config.SocialLoginOIDC {
+		idTokenClaims, err = p.verifyIDToken(tokens.IDToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var userInfo map[string]interface{}
+
+	if userInfoURL != "" {
+		userInfo, err = p.getUserInfo(ctx, userInfoURL, tokens.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p.identity(idTokenClaims, userInfo)
+}
+
+func (p *Provider) identity(idTokenClaims, userInfo map[string]interface{}) (*Identity, error) {
+	identity := &Identity{}
+
+	if idTokenClaims != nil {
+		identity.Subject = claimString(idTokenClaims, "sub")
+
+		// the user info must be of the same user, OpenID Connect Core section 5.3.2
+		if userInfo != nil && claimString(userInfo, "sub") != identity.Subject {
+			return nil, errors.New("subject of user info mismatch")
+		}
+
+		identity.NickName = claimString(idTokenClaims, p.cfg.NickNameField)
+		identity.Avatar = claimString(idTokenClaims, p.cfg.AvatarField)
+	} else {
+		identity.Subject = claimString(userInfo, p.cfg.SubjectField)
+	}
+
+	if identity.Subject == "" {
+		return nil, errors.New("no subject")
+	}
+
+	if v := claimString(userInfo, p.cfg.NickNameField); v != "" {
+		identity.NickName = v
+	}
+
+	if v := claimString(userInfo, p.cfg.AvatarField); v != "" {
+		identity.Avatar = v
+	}
+
+	return identity, nil
+}
+
+func (p *Provider) endpoints(ctx context.Context) (tokenURL, userInfoURL string, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.tokenURL != "" {
+		return p.tokenURL, p.userInfoURL, nil
+	}
+
+	var discovery struct {
+		Issuer           string `json:"issuer"`
+		TokenEndpoint    string `json:"token_endpoint"`
+		UserinfoEndpoint string `json:"userinfo_endpoint"`
+	}
+
+	err = p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", "", &discovery)
+	if err != nil {
+		return
+	}
+
+	if discovery.Issuer != p.cfg.Issuer {
+		err = fmt.Errorf("issuer of discovery mismatch: %v", discovery.Issuer)
+
+		return
+	}
+
+	if discovery.TokenEndpoint == "" {
+		err = errors.New("no token endpoint in discovery")
+
+		return
+	}
+
+	p.tokenURL = discovery.TokenEndpoint
+
+	if p.userInfoURL == "" {
+		p.userInfoURL = discovery.UserinfoEndpoint
+	}
+
+	return p.tokenURL, p.userInfoURL, nil
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (p *Provider) redeemCode(ctx context.Context, tokenURL, code string) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.cfg.RedirectURL},
+		"client_id":    {p.cfg.ClientID},
+	}
+
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	tokens := &tokenResponse{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(tokens)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+
+	// github tells errors with 200
+	if tokens.Error == "invalid_grant" || tokens.Error == "bad_verification_code" {
+		return nil, fmt.Errorf("%w: %v", ErrBadCode, tokens.ErrorDescription)
+	}
+
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %v %v %v", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.AccessToken == "" {
+		return nil, errors.New("no access token")
+	}
+
+	if tokens.TokenType != "" && !strings.EqualFold(tokens.TokenType, "bearer") {
+		return nil, fmt.Errorf("unknown token type %v", tokens.TokenType)
+	}
+
+	return tokens, nil
+}
+
+// verifyIDToken checks the claims of idToken. Its signature isn't checked, since it comes from the token
+// endpoint directly over tls, OpenID Connect Core section 3.1.3.7
+func (p *Provider) verifyIDToken(idToken string) (map[string]interface{}, error) {
+	if idToken == "" {
+		return nil, errors.New("no id token")
+	}
+
+	claims := jwt.MapClaims{}
+
+	_, _, err := new(jwt.Parser).ParseUnverified(idToken, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claimString(claims, "iss") != p.cfg.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+
+	var audiences []string
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, v := range aud {
+			if s, ok := v.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	audOk := false
+
+	for _, aud := range audiences {
+		if aud == p.cfg.ClientID {
+			audOk = true
+
+			break
+		}
+	}
+
+	if !audOk {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+
+	if azp := claimString(claims, "azp"); azp != "" && azp != p.cfg.ClientID {
+		return nil, fmt.Errorf("unexpected authorized party %v", azp)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Unix(int64(exp), 0).Add(idTokenLeeway).Before(time.Now()) {
+		return nil, errors.New("id token expired")
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) getUserInfo(ctx context.Context, userInfoURL, accessToken string) (map[string]interface{}, error) {
+	userInfo := make(map[string]interface{})
+
+	err := p.getJSON(ctx, userInfoURL, accessToken, &userInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return userInfo, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+		return fmt.Errorf("get %v: %v", u, resp.Status)
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// claimString tells the claim of key as a string, numeric ids like the ones of github included
+func claimString(claims map[string]interface{}, key string) string {
+	switch v := claims[key].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return ""
+}
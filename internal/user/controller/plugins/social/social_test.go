@@ -0,0 +1,212 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+type mockIssuer struct {
+	*httptest.Server
+	code string
+	aud  interface{}
+	sub  string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	m := &mockIssuer{
+		code: "good-code",
+		aud:  "rp",
+		sub:  "10001",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":            m.URL,
+			"token_endpoint":    m.URL + "/token",
+			"userinfo_endpoint": m.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "authorization_code" ||
+			r.PostFormValue("client_id") != "rp" || r.PostFormValue("client_secret") != "rp-secret" ||
+			r.PostFormValue("redirect_uri") != "https://rp.com/cb" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+
+			return
+		}
+
+		if r.PostFormValue("code") != m.code {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+
+			return
+		}
+
+		idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss":  m.URL,
+			"aud":  m.aud,
+			"sub":  "10001",
+			"exp":  time.Now().Add(time.Minute).Unix(),
+			"name": "Alice",
+		}).SignedString([]byte("whatever"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"sub":"` + m.sub + `","id":10001,"login":"alice","picture":"https://a.com/a.png"}`))
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func (m *mockIssuer) config(typ string) config.SocialLoginConfig {
+	return config.SocialLoginConfig{
+		Name:          "mock",
+		Type:          typ,
+		Issuer:        m.URL,
+		ClientID:      "rp",
+		ClientSecret:  "rp-secret",
+		RedirectURL:   "https://rp.com/cb",
+		SubjectField:  "sub",
+		NickNameField: "name",
+		AvatarField:   "picture",
+	}
+}
+
+func TestOIDCProvider(t *testing.T) {
+	m := newMockIssuer(t)
+
+	p, err := NewProvider(m.config(config.SocialLoginOIDC), m.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := p.Exchange(context.Background(), "good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *identity != (Identity{Subject: "10001", NickName: "Alice", Avatar: "https://a.com/a.png"}) {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	if _, err = p.Exchange(context.Background(), "bad-code"); !errors.Is(err, ErrBadCode) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err = p.Exchange(context.Background(), ""); !errors.Is(err, ErrBadCode) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	m.aud = []string{"other", "rp"}
+
+	if _, err = p.Exchange(context.Background(), "good-code"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.aud = "other"
+
+	if _, err = p.Exchange(context.Background(), "good-code"); err == nil {
+		t.Fatal("id token of other audience accepted")
+	}
+
+	m.aud = "rp"
+	m.sub = "10002"
+
+	if _, err = p.Exchange(context.Background(), "good-code"); err == nil {
+		t.Fatal("user info of other subject accepted")
+	}
+}
+
+func TestOIDCProviderIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+
+	cfg := m.config(config.SocialLoginOIDC)
+	cfg.Issuer += "/"
+
+	p, err := NewProvider(cfg, m.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.Exchange(context.Background(), "good-code"); err == nil || errors.Is(err, ErrBadCode) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestOAuth2Provider(t *testing.T) {
+	m := newMockIssuer(t)
+
+	cfg := m.config(config.SocialLoginOAuth2)
+	cfg.TokenURL = m.URL + "/token"
+	cfg.UserInfoURL = m.URL + "/userinfo"
+	cfg.SubjectField = "id"
+	cfg.NickNameField = "login"
+
+	p, err := NewProvider(cfg, m.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := p.Exchange(context.Background(), "good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *identity != (Identity{Subject: "10001", NickName: "alice", Avatar: "https://a.com/a.png"}) {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	cfg.ClientSecret = "wrong"
+
+	p, err = NewProvider(cfg, m.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.Exchange(context.Background(), "good-code"); err == nil || errors.Is(err, ErrBadCode) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestNewProviderErrors(t *testing.T) {
+	for _, cfg := range []config.SocialLoginConfig{
+		{Type: config.SocialLoginOIDC, Issuer: "https://a.com", ClientID: "a", RedirectURL: "https://b.com/cb"},
+		{Name: "a", Type: config.SocialLoginOIDC, ClientID: "a", RedirectURL: "https://b.com/cb"},
+		{Name: "a", Type: config.SocialLoginOIDC, Issuer: "https://a.com", RedirectURL: "https://b.com/cb"},
+		{Name: "a", Type: config.SocialLoginOAuth2, TokenURL: "https://a.com/t", ClientID: "a", RedirectURL: "https://b.com/cb"},
+		{Name: "a", Type: "saml", ClientID: "a", RedirectURL: "https://b.com/cb"},
+	} {
+		if _, err := NewProvider(cfg, nil); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
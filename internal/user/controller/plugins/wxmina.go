@@ -0,0 +1,172 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sbasestarter/user/internal/user/controller/plugins/wxmina"
+	"github.com/sgostarter/i/l"
+	"github.com/sgostarter/libeasygo/cuserror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// wxMinAAuthentication signs users of the WeChat mini program in with the js_code of wx.login. The user
+// name of them is the field of identityField only, falling back to the other one would sign a user in as two
+type wxMinAAuthentication struct {
+	client        *wxmina.Client
+	identityField string
+	logger        l.WrapperWithContext
+}
+
+func NewWxMinAAuthentication(cfg *config.WxMinAConfig, httpClient wxmina.HTTPClient, logger l.Wrapper) (Plugin, error) {
+	if logger == nil {
+		logger = l.NewNopLoggerWrapper()
+	}
+
+	if cfg.IdentityField != config.WxMinAIdentityUnionID && cfg.IdentityField != config.WxMinAIdentityOpenID {
+		return nil, fmt.Errorf("unsupported identity field of mini program: %q", cfg.IdentityField)
+	}
+
+	client, err := wxmina.NewClient(cfg, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wxMinAAuthentication{
+		client:        client,
+		identityField: cfg.IdentityField,
+		logger:        logger.WithFields(l.StringField(l.ClsKey, "wxMinAAuthentication")).GetWrapperWithContext(),
+	}, nil
+}
+
+func (wa *wxMinAAuthentication) FixUserID(ctx context.Context, user *userpb.UserId) (*userpb.UserId, bool, error) {
+	if user.UserVe != userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_WX_MINA.String() {
+		return nil, false, nil
+	}
+
+	wa.logger.Error(ctx, "mini program user signs in without code")
+
+	return nil, true, cuserror.NewWithErrorMsg("sign in with the js code")
+}
+
+func (wa *wxMinAAuthentication) TriggerAuthentication(ctx context.Context, userName, code string,
+	purpose userpb.TriggerAuthPurpose) (err error) {
+	return cuserror.NewWithErrorMsg("no code to send")
+}
+
+func (wa *wxMinAAuthentication) GetNickName(ctx context.Context, userName string) string {
+	return ""
+}
+
+// TryAutoLogin token is the js_code, or the json of wxmina.LoginData with the encrypted user info and
+// phone number. The user info tells the unionid which jscode2session may omit
+func (wa *wxMinAAuthentication) TryAutoLogin(ctx context.Context, user *userpb.UserId, token string) (
+	userFixed *userpb.UserId, nickName, avatar string, err error) {
+	loginData, err := wxmina.ParseLoginData(token)
+	if err != nil {
+		wa.logger.Errorf(ctx, "parse login data failed: %v", err)
+
+		err = status.Error(codes.InvalidArgument, err.Error())
+
+		return
+	}
+
+	session, err := wa.client.Code2Session(ctx, loginData.Code)
+	if err != nil {
+		wa.logger.Errorf(ctx, "code to session failed: %v", err)
+
+		if errors.Is(err, wxmina.ErrBadCode) {
+			err = status.Error(codes.InvalidArgument, err.Error())
+		} else {
+			err = status.Error(codes.Unavailable, err.Error())
+		}
+
+		return
+	}
+
+	unionID := session.UnionID
+
+	if loginData.EncryptedData != "" {
+		var userInfo *wxmina.UserInfo
+
+		userInfo, err = wa.client.DecryptUserInfo(session.SessionKey, loginData.EncryptedData, loginData.IV)
+		if err != nil {
+			wa.logger.Errorf(ctx, "decrypt user info failed: %v", err)
+
+			err = status.Error(codes.InvalidArgument, err.Error())
+
+			return
+		}
+
+		if userInfo.OpenID != "" && userInfo.OpenID != session.OpenID {
+			wa.logger.Errorf(ctx, "user info of %v, not %v", userInfo.OpenID, session.OpenID)
+
+			err = status.Error(codes.InvalidArgument, "user info of other user")
+
+			return
+		}
+
+		if unionID == "" {
+			unionID = userInfo.UnionID
+		}
+
+		nickName = userInfo.NickName
+		avatar = userInfo.AvatarURL
+	}
+
+	if loginData.PhoneEncryptedData != "" {
+		var phoneNumber *wxmina.PhoneNumber
+
+		phoneNumber, err = wa.client.DecryptPhoneNumber(session.SessionKey, loginData.PhoneEncryptedData, loginData.PhoneIV)
+		if err != nil {
+			wa.logger.Errorf(ctx, "decrypt phone number failed: %v", err)
+
+			err = status.Error(codes.InvalidArgument, err.Error())
+
+			return
+		}
+
+		if nickName == "" && len(phoneNumber.PurePhoneNumber) > 7 {
+			nickName = phoneNumber.PurePhoneNumber[:3] + "****" + phoneNumber.PurePhoneNumber[len(phoneNumber.PurePhoneNumber)-4:]
+		}
+	}
+
+	userName := session.OpenID
+
+	if wa.identityField == config.WxMinAIdentityUnionID {
+		if unionID == "" {
+			wa.logger.Errorf(ctx, "no unionid of %v, the mini program isn't bound to an open platform account",
+				session.OpenID)
+
+			err = status.Error(codes.FailedPrecondition, "no unionid")
+
+			return
+		}
+
+		userName = unionID
+	}
+
+	userFixed = &userpb.UserId{
+		UserName: userName,
+		UserVe:   user.UserVe,
+	}
+
+	return
+}
+
+func (wa *wxMinAAuthentication) GetSendLockTimeDuration() time.Duration {
+	return 0
+}
+
+func (wa *wxMinAAuthentication) GetValidDelayDuration() time.Duration {
+	return 0
+}
+
+func (wa *wxMinAAuthentication) GetMaxAttempts() int {
+	return 1
+}
@@ -0,0 +1,239 @@
+package wxmina
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const maxResponseSize = 1 << 20
+
+const (
+	errCodeInvalidCode = 40029
+	errCodeCodeUsed    = 40163
+)
+
+var (
+	// ErrBadCode WeChat refuses the js_code
+	ErrBadCode = errors.New("bad js code")
+	// ErrBadData the encrypted data can't be decrypted by the session key, or isn't of the mini program
+	ErrBadData = errors.New("bad encrypted data")
+)
+
+// HTTPClient sends the requests to the WeChat api, *http.Client is one
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Session is what WeChat tells about the user of a js_code, UnionID is empty unless the mini program is
+// bound to an open platform account
+type Session struct {
+	OpenID     string `json:"openid"`
+	UnionID    string `json:"unionid"`
+	SessionKey string `json:"session_key"`
+}
+
+type Watermark struct {
+	AppID     string `json:"appid"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// UserInfo is the encrypted data of wx.getUserInfo
+type UserInfo struct {
+	OpenID    string    `json:"openId"`
+	UnionID   string    `json:"unionId"`
+	NickName  string    `json:"nickName"`
+	AvatarURL string    `json:"avatarUrl"`
+	Watermark Watermark `json:"watermark"`
+}
+
+// PhoneNumber is the encrypted data of the getPhoneNumber button
+type PhoneNumber struct {
+	PhoneNumber     string    `json:"phoneNumber"`
+	PurePhoneNumber string    `json:"purePhoneNumber"`
+	CountryCode     string    `json:"countryCode"`
+	Watermark       Watermark `json:"watermark"`
+}
+
+// LoginData is what the mini program signs in with. It's sent as codeForVe, either the js_code alone or
+// the json of LoginData
+type LoginData struct {
+	Code               string `json:"code"`
+	EncryptedData      string `json:"encrypted_data"`
+	IV                 string `json:"iv"`
+	PhoneEncryptedData string `json:"phone_encrypted_data"`
+	PhoneIV            string `json:"phone_iv"`
+}
+
+func ParseLoginData(s string) (*LoginData, error) {
+	if !strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return &LoginData{Code: s}, nil
+	}
+
+	data := &LoginData{}
+
+	err := json.Unmarshal([]byte(s), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+type Client struct {
+	appID      string
+	appSecret  string
+	baseURL    string
+	httpClient HTTPClient
+}
+
+func NewClient(cfg *config.WxMinAConfig, httpClient HTTPClient) (*Client, error) {
+	if cfg.AppID == "" || cfg.AppSecret == "" || cfg.BaseURL == "" {
+		return nil, errors.New("mini program needs app id, app secret and base url")
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout: 10 * time.Second,
+		}
+	}
+
+	return &Client{
+		appID:      cfg.AppID,
+		appSecret:  cfg.AppSecret,
+		baseURL:    cfg.BaseURL,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Code2Session exchanges jsCode of wx.login for the session of the user
+func (c *Client) Code2Session(ctx context.Context, jsCode string) (*Session, error) {
+	if jsCode == "" {
+		return nil, ErrBadCode
+	}
+
+	query := url.Values{
+		"appid":      {c.appID},
+		"secret":     {c.appSecret},
+		"js_code":    {jsCode},
+		"grant_type": {"authorization_code"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/sns/jscode2session?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jscode2session: %v", resp.Status)
+	}
+
+	var result struct {
+		Session
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch result.ErrCode {
+	case 0:
+	case errCodeInvalidCode, errCodeCodeUsed:
+		return nil, fmt.Errorf("%w: %v %v", ErrBadCode, result.ErrCode, result.ErrMsg)
+	default:
+		return nil, fmt.Errorf("jscode2session: %v %v", result.ErrCode, result.ErrMsg)
+	}
+
+	if result.OpenID == "" || result.SessionKey == "" {
+		return nil, errors.New("jscode2session: no openid or session key")
+	}
+
+	return &result.Session, nil
+}
+
+func (c *Client) DecryptUserInfo(sessionKey, encryptedData, iv string) (*UserInfo, error) {
+	userInfo := &UserInfo{}
+
+	err := c.decrypt(sessionKey, encryptedData, iv, userInfo, &userInfo.Watermark)
+	if err != nil {
+		return nil, err
+	}
+
+	return userInfo, nil
+}
+
+func (c *Client) DecryptPhoneNumber(sessionKey, encryptedData, iv string) (*PhoneNumber, error) {
+	phoneNumber := &PhoneNumber{}
+
+	err := c.decrypt(sessionKey, encryptedData, iv, phoneNumber, &phoneNumber.Watermark)
+	if err != nil {
+		return nil, err
+	}
+
+	return phoneNumber, nil
+}
+
+// decrypt opens the AES-128-CBC encrypted data by the session key, and makes sure that it's of the app
+func (c *Client) decrypt(sessionKey, encryptedData, iv string, v interface{}, watermark *Watermark) error {
+	key, err := base64.StdEncoding.DecodeString(sessionKey)
+	if err != nil || len(key) != 16 {
+		return fmt.Errorf("%w: invalid session key", ErrBadData)
+	}
+
+	ivBytes, err := base64.StdEncoding.DecodeString(iv)
+	if err != nil || len(ivBytes) != aes.BlockSize {
+		return fmt.Errorf("%w: invalid iv", ErrBadData)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return fmt.Errorf("%w: invalid data", ErrBadData)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, ivBytes).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return fmt.Errorf("%w: invalid padding", ErrBadData)
+	}
+
+	err = json.Unmarshal(plain[:len(plain)-padding], v)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadData, err)
+	}
+
+	if watermark.AppID != c.appID {
+		return fmt.Errorf("%w: data of app %v", ErrBadData, watermark.AppID)
+	}
+
+	return nil
+}
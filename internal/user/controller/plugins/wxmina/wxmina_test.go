@@ -0,0 +1,174 @@
+package wxmina
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const (
+	testAppID      = "wx4f4bc4dec97d474b"
+	testSessionKey = "tiihtNczf5v6AKRyjwEUhQ=="
+	testIV         = "r7BXXKkLb8qrSNn05n0qiA=="
+)
+
+func newStub(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/sns/jscode2session" || query.Get("appid") != testAppID || query.Get("secret") != "s3cret" ||
+			query.Get("grant_type") != "authorization_code" {
+			_, _ = w.Write([]byte(`{"errcode":40013,"errmsg":"invalid appid"}`))
+
+			return
+		}
+
+		switch query.Get("js_code") {
+		case "good-code":
+			_, _ = w.Write([]byte(`{"openid":"o1","unionid":"u1","session_key":"` + testSessionKey + `"}`))
+		case "busy-code":
+			_, _ = w.Write([]byte(`{"errcode":-1,"errmsg":"system error"}`))
+		default:
+			_, _ = w.Write([]byte(`{"errcode":40029,"errmsg":"invalid code"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func encrypt(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	plain, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := base64.StdEncoding.DecodeString(testSessionKey)
+	iv, _ := base64.StdEncoding.DecodeString(testIV)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	plain = append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(plain, plain)
+
+	return base64.StdEncoding.EncodeToString(plain)
+}
+
+func TestCode2Session(t *testing.T) {
+	server := newStub(t)
+
+	client, err := NewClient(&config.WxMinAConfig{AppID: testAppID, AppSecret: "s3cret", BaseURL: server.URL},
+		server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := client.Code2Session(context.Background(), "good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *session != (Session{OpenID: "o1", UnionID: "u1", SessionKey: testSessionKey}) {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	for _, code := range []string{"bad-code", ""} {
+		if _, err = client.Code2Session(context.Background(), code); !errors.Is(err, ErrBadCode) {
+			t.Fatalf("%v: unexpected error %v", code, err)
+		}
+	}
+
+	if _, err = client.Code2Session(context.Background(), "busy-code"); err == nil || errors.Is(err, ErrBadCode) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	client, _ = NewClient(&config.WxMinAConfig{AppID: testAppID, AppSecret: "wrong", BaseURL: server.URL},
+		server.Client())
+
+	if _, err = client.Code2Session(context.Background(), "good-code"); err == nil || errors.Is(err, ErrBadCode) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	client, err := NewClient(&config.WxMinAConfig{AppID: testAppID, AppSecret: "s3cret", BaseURL: "http://127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userInfo, err := client.DecryptUserInfo(testSessionKey, encrypt(t, &UserInfo{
+		OpenID:    "o1",
+		UnionID:   "u1",
+		NickName:  "Band",
+		AvatarURL: "https://a.com/a.png",
+		Watermark: Watermark{AppID: testAppID, Timestamp: 1477314187},
+	}), testIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userInfo.UnionID != "u1" || userInfo.NickName != "Band" || userInfo.AvatarURL != "https://a.com/a.png" {
+		t.Fatalf("unexpected user info %+v", userInfo)
+	}
+
+	phoneNumber, err := client.DecryptPhoneNumber(testSessionKey, encrypt(t, &PhoneNumber{
+		PhoneNumber:     "+86 13800138000",
+		PurePhoneNumber: "13800138000",
+		CountryCode:     "86",
+		Watermark:       Watermark{AppID: testAppID},
+	}), testIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if phoneNumber.PurePhoneNumber != "13800138000" || phoneNumber.CountryCode != "86" {
+		t.Fatalf("unexpected phone number %+v", phoneNumber)
+	}
+
+	otherApp := encrypt(t, &PhoneNumber{PurePhoneNumber: "1", Watermark: Watermark{AppID: "wx_other"}})
+
+	for _, args := range [][3]string{
+		{testSessionKey, otherApp, testIV},
+		{"AAAAAAAAAAAAAAAAAAAAAA==", encrypt(t, &PhoneNumber{Watermark: Watermark{AppID: testAppID}}), testIV},
+		{testSessionKey, otherApp[:len(otherApp)-4], testIV},
+		{testSessionKey, otherApp, "AAAA"},
+		{"short", otherApp, testIV},
+	} {
+		if _, err = client.DecryptPhoneNumber(args[0], args[1], args[2]); !errors.Is(err, ErrBadData) {
+			t.Errorf("%v: unexpected error %v", args, err)
+		}
+	}
+}
+
+func TestParseLoginData(t *testing.T) {
+	data, err := ParseLoginData("081abc")
+	if err != nil || *data != (LoginData{Code: "081abc"}) {
+		t.Fatalf("unexpected login data %+v, %v", data, err)
+	}
+
+	data, err = ParseLoginData(`{"code":"081abc","encrypted_data":"d","iv":"i"}`)
+	if err != nil || *data != (LoginData{Code: "081abc", EncryptedData: "d", IV: "i"}) {
+		t.Fatalf("unexpected login data %+v, %v", data, err)
+	}
+
+	if _, err = ParseLoginData(`{"code":`); err == nil {
+		t.Fatal("broken json accepted")
+	}
+}
@@ -0,0 +1,135 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/config"
+	"github.com/sbasestarter/user/internal/user/controller/plugins/wxmina"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testWxMinAAppID      = "wx4f4bc4dec97d474b"
+	testWxMinASessionKey = "tiihtNczf5v6AKRyjwEUhQ=="
+	testWxMinAIV         = "r7BXXKkLb8qrSNn05n0qiA=="
+)
+
+// newTestWxMinA is the plugin on a stub of WeChat, the js_code "union" tells the unionid and the others don't
+func newTestWxMinA(t *testing.T, identityField string) Plugin {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("js_code") == "union" {
+			_, _ = w.Write([]byte(`{"openid":"o1","unionid":"u1","session_key":"` + testWxMinASessionKey + `"}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"openid":"o1","session_key":"` + testWxMinASessionKey + `"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	plugin, err := NewWxMinAAuthentication(&config.WxMinAConfig{
+		Enable:        true,
+		AppID:         testWxMinAAppID,
+		AppSecret:     "s3cret",
+		BaseURL:       server.URL,
+		IdentityField: identityField,
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return plugin
+}
+
+func encryptTestUserInfo(t *testing.T, userInfo *wxmina.UserInfo) string {
+	t.Helper()
+
+	plain, err := json.Marshal(userInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := base64.StdEncoding.DecodeString(testWxMinASessionKey)
+	iv, _ := base64.StdEncoding.DecodeString(testWxMinAIV)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	plain = append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(plain, plain)
+
+	return base64.StdEncoding.EncodeToString(plain)
+}
+
+func TestWxMinAAuthentication_TryAutoLogin(t *testing.T) {
+	loginData, err := json.Marshal(&wxmina.LoginData{
+		Code: "no-union",
+		EncryptedData: encryptTestUserInfo(t, &wxmina.UserInfo{
+			OpenID:    "o1",
+			UnionID:   "u1",
+			NickName:  "Band",
+			Watermark: wxmina.Watermark{AppID: testWxMinAAppID},
+		}),
+		IV: testWxMinAIV,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &userpb.UserId{UserVe: userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_WX_MINA.String()}
+
+	tests := []struct {
+		name          string
+		identityField string
+		token         string
+		userName      string
+		code          codes.Code
+	}{
+		{"unionid of session", config.WxMinAIdentityUnionID, "union", "u1", codes.OK},
+		{"unionid of user info", config.WxMinAIdentityUnionID, string(loginData), "u1", codes.OK},
+		{"no unionid", config.WxMinAIdentityUnionID, "no-union", "", codes.FailedPrecondition},
+		{"openid", config.WxMinAIdentityOpenID, "union", "o1", codes.OK},
+		{"openid without unionid", config.WxMinAIdentityOpenID, "no-union", "o1", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userFixed, _, _, err := newTestWxMinA(t, tt.identityField).TryAutoLogin(context.Background(), user, tt.token)
+			if status.Code(err) != tt.code {
+				t.Fatalf("TryAutoLogin() error = %v, want %v", err, tt.code)
+			}
+
+			if tt.code == codes.OK && (userFixed.UserName != tt.userName || userFixed.UserVe != user.UserVe) {
+				t.Fatalf("TryAutoLogin() = %+v, want %v", userFixed, tt.userName)
+			}
+		})
+	}
+}
+
+func TestNewWxMinAAuthentication_IdentityField(t *testing.T) {
+	_, err := NewWxMinAAuthentication(&config.WxMinAConfig{
+		Enable:        true,
+		AppID:         testWxMinAAppID,
+		AppSecret:     "s3cret",
+		BaseURL:       "http://127.0.0.1",
+		IdentityField: "nickname",
+	}, nil, nil)
+	if err == nil {
+		t.Fatal("unsupported identity field accepted")
+	}
+}
@@ -0,0 +1,244 @@
+package pwdpolicy
+
+import (
+	"bufio"
+	"crypto/sha1" // nolint: gosec
+	"encoding/hex"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+// reasons are machine-readable, the frontend maps them to messages
+const (
+	ReasonTooShort         = "too_short"
+	ReasonTooLong          = "too_long"
+	ReasonMissingLower     = "missing_lower"
+	ReasonMissingUpper     = "missing_upper"
+	ReasonMissingDigit     = "missing_digit"
+	ReasonMissingSymbol    = "missing_symbol"
+	ReasonTooFewCharClass  = "too_few_char_classes"
+	ReasonTooManyRepeats   = "too_many_repeats"
+	ReasonContainsUserInfo = "contains_user_info"
+	ReasonBreached         = "breached"
+)
+
+type Error struct {
+	Reasons []string
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.Reasons, ",")
+}
+
+type Policy struct {
+	cfg      *config.PasswordPolicyConfig
+	breached map[string]interface{}
+}
+
+func NewPolicy(cfg *config.PasswordPolicyConfig) (*Policy, error) {
+	p := &Policy{
+		cfg:      cfg,
+		breached: make(map[string]interface{}),
+	}
+
+	if cfg.BreachedListFile != "" {
+		err := p.loadBreachedList(cfg.BreachedListFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// loadBreachedList reads one entry per line, either the plain password or its hex SHA1
+func (p *Policy) loadBreachedList(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// HIBP style lines: HASH:COUNT
+		if idx := strings.Index(line, ":"); idx == sha1.Size*2 {
+			line = line[:idx]
+		}
+
+		if isSHA1Hex(line) {
+			line = strings.ToUpper(line)
+		}
+
+		p.breached[line] = true
+	}
+
+	return scanner.Err()
+}
+
+// Check returns nil if password satisfies the policy, userInfos are the user name, nickname, email...
+func (p *Policy) Check(password string, userInfos ...string) error {
+	var reasons []string
+
+	length := utf8.RuneCountInString(password)
+
+	if length < p.cfg.MinLength {
+		reasons = append(reasons, ReasonTooShort)
+	}
+
+	if p.cfg.MaxLength > 0 && length > p.cfg.MaxLength {
+		reasons = append(reasons, ReasonTooLong)
+	}
+
+	reasons = append(reasons, p.checkCharClasses(password)...)
+
+	if p.cfg.MaxRepeat > 0 && maxRepeat(password) > p.cfg.MaxRepeat {
+		reasons = append(reasons, ReasonTooManyRepeats)
+	}
+
+	if p.cfg.RejectUserInfo && p.containsUserInfo(password, userInfos) {
+		reasons = append(reasons, ReasonContainsUserInfo)
+	}
+
+	if p.isBreached(password) {
+		reasons = append(reasons, ReasonBreached)
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	return &Error{Reasons: reasons}
+}
+
+func (p *Policy) checkCharClasses(password string) (reasons []string) {
+	var lower, upper, digit, symbol bool
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if p.cfg.RequireLower && !lower {
+		reasons = append(reasons, ReasonMissingLower)
+	}
+
+	if p.cfg.RequireUpper && !upper {
+		reasons = append(reasons, ReasonMissingUpper)
+	}
+
+	if p.cfg.RequireDigit && !digit {
+		reasons = append(reasons, ReasonMissingDigit)
+	}
+
+	if p.cfg.RequireSymbol && !symbol {
+		reasons = append(reasons, ReasonMissingSymbol)
+	}
+
+	classes := 0
+
+	for _, b := range []bool{lower, upper, digit, symbol} {
+		if b {
+			classes++
+		}
+	}
+
+	if classes < p.cfg.MinCharClasses {
+		reasons = append(reasons, ReasonTooFewCharClass)
+	}
+
+	return
+}
+
+func (p *Policy) containsUserInfo(password string, userInfos []string) bool {
+	password = strings.ToLower(password)
+
+	for _, info := range userInfos {
+		candidates := []string{info}
+
+		if idx := strings.Index(info, "@"); idx > 0 {
+			candidates = append(candidates, info[:idx])
+		}
+
+		for _, candidate := range candidates {
+			candidate = strings.ToLower(strings.TrimSpace(candidate))
+			if utf8.RuneCountInString(candidate) < p.cfg.UserInfoMinLength {
+				continue
+			}
+
+			if strings.Contains(password, candidate) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *Policy) isBreached(password string) bool {
+	if len(p.breached) == 0 {
+		return false
+	}
+
+	if _, ok := p.breached[password]; ok {
+		return true
+	}
+
+	// nolint: gosec
+	sum := sha1.Sum([]byte(password))
+
+	_, ok := p.breached[strings.ToUpper(hex.EncodeToString(sum[:]))]
+
+	return ok
+}
+
+func maxRepeat(s string) int {
+	maxCnt := 0
+	cnt := 0
+
+	var last rune
+
+	for idx, r := range s {
+		if idx > 0 && r == last {
+			cnt++
+		} else {
+			cnt = 1
+		}
+
+		if cnt > maxCnt {
+			maxCnt = cnt
+		}
+
+		last = r
+	}
+
+	return maxCnt
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+
+	return err == nil
+}
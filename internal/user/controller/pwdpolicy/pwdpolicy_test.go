@@ -0,0 +1,73 @@
+package pwdpolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+// nolint
+func TestPolicy_Check(t *testing.T) {
+	dir := t.TempDir()
+	breachedFile := filepath.Join(dir, "breached.txt")
+
+	// sha1("Password1!")
+	err := os.WriteFile(breachedFile, []byte("# comment\nQwerty123!\n"+
+		"32CA9FC1A0F5B6330E3F4C8C1BBECDE9BEDB9573:12\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPolicy(&config.PasswordPolicyConfig{
+		MinLength:         8,
+		MaxLength:         64,
+		RequireLower:      true,
+		RequireDigit:      true,
+		MinCharClasses:    3,
+		MaxRepeat:         2,
+		RejectUserInfo:    true,
+		BreachedListFile:  breachedFile,
+		UserInfoMinLength: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		password  string
+		userInfos []string
+		want      []string
+	}{
+		{"ok", "aB3$efgh", nil, nil},
+		{"short", "aB3$", nil, []string{ReasonTooShort}},
+		{"classes", "abcdefgh", nil, []string{ReasonMissingDigit, ReasonTooFewCharClass}},
+		{"repeat", "aB3$efffg", nil, []string{ReasonTooManyRepeats}},
+		{"user name", "xJohnDoe1!", []string{"johndoe"}, []string{ReasonContainsUserInfo}},
+		{"email local part", "Zmike2024!", []string{"mike2024@a.com"}, []string{ReasonContainsUserInfo}},
+		{"short user info ignored", "ab1$Efgh", []string{"ab"}, nil},
+		{"breached plain", "Qwerty123!", nil, []string{ReasonBreached}},
+		{"breached sha1", "Password1!", nil, []string{ReasonBreached}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.password, tt.userInfos...)
+
+			var got []string
+
+			var pErr *Error
+			if errors.As(err, &pErr) {
+				got = pErr.Reasons
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
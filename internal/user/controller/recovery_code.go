@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+const (
+	recoveryCodeBytes = 10
+	recoveryCodeLen   = recoveryCodeBytes * 8 / 5
+	recoveryCodeGroup = 4
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeBytes)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+
+	groups := make([]string, 0, recoveryCodeLen/recoveryCodeGroup)
+	for idx := 0; idx < len(code); idx += recoveryCodeGroup {
+		groups = append(groups, code[idx:idx+recoveryCodeGroup])
+	}
+
+	return strings.Join(groups, "-"), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+
+	return code
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes makes the recovery codes shown to the user and the hashes of them to store
+func (c *Controller) newRecoveryCodes(ctx context.Context) (codes, hashes []string, err error) {
+	hashes = make([]string, 0, c.cfg.GoogleAuthenticator.RecoveryCodeCount)
+
+	for i := 0; i < c.cfg.GoogleAuthenticator.RecoveryCodeCount; i++ {
+		var code string
+
+		code, err = newRecoveryCode()
+		if err != nil {
+			c.logger.Errorf(ctx, "new recovery code failed: %v", err)
+
+			return nil, nil, err
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return
+}
+
+// genRecoveryCodes replaces all recovery codes of user, only the hashes are stored
+func (c *Controller) genRecoveryCodes(ctx context.Context, userID int64) (codes []string, err error) {
+	codes, hashes, err := c.newRecoveryCodes(ctx)
+	if err != nil {
+		return
+	}
+
+	err = c.m.ReplaceUserRecoveryCodes(userID, hashes)
+	if err != nil {
+		c.logger.Errorf(ctx, "replace recovery codes of %v failed: %v", userID, err)
+
+		return nil, err
+	}
+
+	return
+}
+
+// useRecoveryCode consumes code if it is an unused recovery code of user
+func (c *Controller) useRecoveryCode(ctx context.Context, userID int64, code string) bool {
+	if len(normalizeRecoveryCode(code)) != recoveryCodeLen {
+		return false
+	}
+
+	ok, err := c.m.UseUserRecoveryCode(userID, hashRecoveryCode(code))
+	if err != nil {
+		c.logger.Errorf(ctx, "use recovery code of %v failed: %v", userID, err)
+
+		return false
+	}
+
+	if ok {
+		c.logger.Warnf(ctx, "user %v used a recovery code", userID)
+	}
+
+	return ok
+}
+
+func (c *Controller) GoogleAuthRegenerateRecoveryCodes(ctx context.Context, token, csrfToken, codeForGa string) (
+	status userpb.UserStatus, codes []string, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	if codeForGa == "" {
+		status = userpb.UserStatus_USER_STATUS_NEED_2FA_AUTH
+
+		return
+	}
+
+	status = c.gaVerify(ctx, authInfo.UserID, codeForGa)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "ga verify failed: %v", status)
+
+		return
+	}
+
+	codes, err = c.genRecoveryCodes(ctx, authInfo.UserID)
+	if err != nil {
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+func (c *Controller) GoogleAuthGetRecoveryCodesCount(ctx context.Context, token string) (status userpb.UserStatus,
+	remain int64, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	remain, err = c.m.CountUserUnusedRecoveryCodes(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "count recovery codes failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbasestarter/db-orm/go/user"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+// newTestPendingGAKey starts a 2fa setup of userID and returns a code of the pending key
+func newTestPendingGAKey(t *testing.T, c *Controller, userID int64) string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	if _, err := c.gaNewSecretQRCode(ctx, userID, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	sealedKey, err := c.redis.Get(ctx, gaSecretKeyRedisKey(userID)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := c.secrets.Open(sealedKey, gaSecretContext(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := c.totp.GenerateAt(key, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func recoveryCodesCount(t *testing.T, c *Controller, userID int64) int64 {
+	t.Helper()
+
+	cnt, err := c.m.CountUserUnusedRecoveryCodes(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cnt
+}
+
+func TestGASetup_RecoveryCodes(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+
+	status, codes, err := c.gaSetupWithCode(ctx, userID, newTestPendingGAKey(t, c, userID))
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(codes) != c.cfg.GoogleAuthenticator.RecoveryCodeCount {
+		t.Fatalf("setup failed: %v, %v, %v", status, len(codes), err)
+	}
+
+	if !c.gaEnabled(ctx, userID) || mr.Exists(gaSecretKeyRedisKey(userID)) {
+		t.Fatal("key not set")
+	}
+
+	for _, code := range codes {
+		if len(code) != recoveryCodeLen+recoveryCodeLen/recoveryCodeGroup-1 {
+			t.Fatalf("unexpected recovery code %v", code)
+		}
+	}
+
+	if cnt := recoveryCodesCount(t, c, userID); cnt != int64(len(codes)) {
+		t.Fatalf("%v recovery codes stored", cnt)
+	}
+}
+
+func TestGASetup_KeepOldOnFailure(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	db := newTestDB(t)
+	c.m = newTestModel(t, db, c.utils.(*testUtils))
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	enableTestGA(t, c, userID)
+
+	oldKey, err := c.m.GetUser2FaKey(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldCodes, err := c.genRecoveryCodes(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new key can't be set, after the old codes are replaced in the transaction
+	_, err = db.Exec("CREATE TRIGGER no_2fa_key BEFORE UPDATE ON " + user.OUserAuthentication.TableName() +
+		" BEGIN SELECT RAISE(FAIL, 'no 2fa key'); END")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, codes, _ := c.gaSetupWithCode(ctx, userID, newTestPendingGAKey(t, c, userID))
+	if status != userpb.UserStatus_USER_STATUS_INTERNAL_ERROR || codes != nil {
+		t.Fatalf("setup with failed codes: %v, %v", status, codes)
+	}
+
+	key, err := c.m.GetUser2FaKey(userID)
+	if err != nil || key != oldKey {
+		t.Fatalf("key changed: %v", err)
+	}
+
+	if cnt := recoveryCodesCount(t, c, userID); cnt != int64(len(oldCodes)) {
+		t.Fatalf("%v recovery codes left, want %v", cnt, len(oldCodes))
+	}
+
+	if status = c.gaVerify(ctx, userID, oldCodes[0]); status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("old recovery code refused: %v", status)
+	}
+}
+
+func TestRecoveryCodes_SingleUse(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	gaCode := enableTestGA(t, c, userID)
+
+	codes, err := c.genRecoveryCodes(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := c.gaVerify(ctx, userID, codes[0]); status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("recovery code refused: %v", status)
+	}
+
+	if status := c.gaVerify(ctx, userID, codes[0]); status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("recovery code used twice: %v", status)
+	}
+
+	// the code is typed without the dashes and in upper case
+	code := strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))
+	if status := c.gaVerify(ctx, userID, code); status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("normalized recovery code refused: %v", status)
+	}
+
+	for _, code := range []string{"000000", strings.Repeat("a", recoveryCodeLen)} {
+		if status := c.gaVerify(ctx, userID, code); status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+			t.Fatalf("code %v: %v", code, status)
+		}
+	}
+
+	_, tokens := newTestSession(t, c, userID, "")
+
+	status, remain, err := c.GoogleAuthGetRecoveryCodesCount(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || remain != int64(len(codes)-2) {
+		t.Fatalf("%v recovery codes left: %v, %v", remain, status, err)
+	}
+
+	status, newCodes, err := c.GoogleAuthRegenerateRecoveryCodes(ctx, tokens.AccessToken,
+		newTestCsrfToken(t, c, tokens.AccessToken), gaCode())
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(newCodes) != len(codes) {
+		t.Fatalf("regenerate failed: %v, %v", status, err)
+	}
+
+	if status = c.gaVerify(ctx, userID, codes[2]); status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("replaced recovery code: %v", status)
+	}
+
+	if cnt := recoveryCodesCount(t, c, userID); cnt != int64(len(newCodes)) {
+		t.Fatalf("%v recovery codes after regenerate", cnt)
+	}
+}
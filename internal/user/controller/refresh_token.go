@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/utils"
+	"github.com/sbasestarter/user/pkg/user"
+	"github.com/sgostarter/libservicetoolset/grpce"
+)
+
+const (
+	refreshTokenReused   = -1
+	refreshTokenNoFamily = -2
+)
+
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// rotateRefreshTokenScript moves the generation of a session forward if ARGV[1] is the current one
+var rotateRefreshTokenScript = redis.NewScript(`
+local generation = redis.call("GET", KEYS[1])
+if not generation then
+	return -2
+end
+if generation ~= ARGV[1] then
+	return -1
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return redis.call("INCR", KEYS[1])
+`)
+
+// newRefreshToken starts the refresh token family of the new session u
+func (c *Controller) newRefreshToken(ctx context.Context, u *AuthInfo) (token string, err error) {
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		err = c.redis.Set(ctx, redisKeyForRefreshToken(u.UserID, u.SessionID), 1,
+			c.sessionTTL(u, c.cfg.Token.IdleTimeout)).Err()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "set refresh token generation failed: %v", err)
+
+		return
+	}
+
+	return c.signRefreshToken(u, 1)
+}
+
+// signRefreshToken signs a refresh token of session u, it expires with the idle timeout of the session
+func (c *Controller) signRefreshToken(u *AuthInfo, generation int64) (string, error) {
+	tc := c.newTokenClaims(u.UserID, u.SessionID, tokenKindRefresh, c.sessionTTL(u, c.cfg.Token.IdleTimeout))
+	tc.Generation = generation
+	bindClient(tc, u)
+
+	return c.signToken(tc)
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token, and extends
+// the session. A refresh token can be used only once, using it again kills the session and its children.
+// The sessions of oidc clients are refreshed only by the token endpoint, which authenticates the client
+func (c *Controller) RefreshToken(ctx context.Context, refreshToken string) (status userpb.UserStatus,
+	tokens *SignedTokens, err error) {
+	if refreshToken == "" {
+		refreshToken = grpce.GetStringFromContext(ctx, user.RefreshCookieName)
+	}
+
+	status, tokens, _, err = c.refreshToken(ctx, refreshToken, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	err = c.httpToken.SetUserTokenCookie(ctx, tokens.AccessToken, tokens.RefreshToken)
+	if err != nil {
+		c.logger.Errorf(ctx, "setUserTokenCookie failed: %v", err)
+
+		err = nil
+	}
+
+	return
+}
+
+// refreshToken rotates refreshToken of a session issued to oidcClientID, which is empty for the sessions
+// the user signed in
+func (c *Controller) refreshToken(ctx context.Context, refreshToken, oidcClientID string) (
+	status userpb.UserStatus, tokens *SignedTokens, authInfo *AuthInfo, err error) {
+	if refreshToken == "" {
+		status = userpb.UserStatus_USER_STATUS_UNAUTHENTICATED
+
+		return
+	}
+
+	tc, err := c.parseToken(refreshToken)
+	if err != nil || tc.Kind != tokenKindRefresh {
+		c.logger.Errorf(ctx, "parse refresh token failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_UNAUTHENTICATED
+
+		return
+	}
+
+	redisKey := redisKeyForSession(tc.UserID, tc.SessionID)
+
+	// checked before the rotation, a token refused here is still good for its own client
+	authInfo, err = c.loadSession(ctx, tc.UserID, tc.SessionID, redisKey)
+	if err == nil {
+		err = checkClient(tc, authInfo)
+	}
+
+	if err != nil || authInfo.OIDCClientID != oidcClientID {
+		c.logger.Errorf(ctx, "refresh token of session [%v-%v] refused: %v", tc.UserID, tc.SessionID, err)
+
+		status = userpb.UserStatus_USER_STATUS_UNAUTHENTICATED
+
+		return
+	}
+
+	var generation int64
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		generation, err = rotateRefreshTokenScript.Run(ctx, c.redis,
+			[]string{redisKeyForRefreshToken(tc.UserID, tc.SessionID)}, tc.Generation,
+			c.cfg.Token.IdleTimeout.Milliseconds()).Int64()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "rotate refresh token failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if generation == refreshTokenReused {
+		c.logger.Warnf(ctx, "refresh token %v of session %v reused, kill the session", tc.Generation, tc.SessionID)
+
+		c.removeSession(ctx, tc.UserID, tc.SessionID)
+
+		status = userpb.UserStatus_USER_STATUS_UNAUTHENTICATED
+		err = errRefreshTokenReused
+
+		return
+	}
+
+	if generation == refreshTokenNoFamily {
+		status = userpb.UserStatus_USER_STATUS_UNAUTHENTICATED
+
+		return
+	}
+
+	authInfo, err = c.verifySessionID(ctx, tc.UserID, tc.SessionID, redisKey)
+	if err != nil {
+		c.logger.Errorf(ctx, "verify session [%v-%v] failed: %v", tc.UserID, tc.SessionID, err)
+
+		status = userpb.UserStatus_USER_STATUS_UNAUTHENTICATED
+
+		return
+	}
+
+	accessToken, err := c.generateTokenEx(ctx, tc.SessionID, redisKey, tokenKindAccess, c.cfg.Token.IdleTimeout,
+		c.cfg.Token.AccessExpire, authInfo)
+	if err != nil {
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	newRefreshToken, err := c.signRefreshToken(authInfo, generation)
+	if err != nil {
+		c.logger.Errorf(ctx, "sign refresh token failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	c.touchSession(ctx, authInfo)
+
+	tokens = &SignedTokens{
+		AccessToken:     accessToken,
+		RefreshToken:    newRefreshToken,
+		AccessExpiresAt: time.Now().Add(c.sessionTTL(authInfo, c.cfg.Token.AccessExpire)).Unix(),
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/pkg/user"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRefreshToken_Rotation(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	sessionID, tokens := newTestSession(t, c, 1, "")
+
+	for generation := int64(2); generation <= 3; generation++ {
+		status, newTokens, err := c.RefreshToken(ctx, tokens.RefreshToken)
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+			t.Fatalf("refresh %v failed: %v, %v", generation, status, err)
+		}
+
+		tc, err := c.parseToken(newTokens.RefreshToken)
+		if err != nil || tc.Kind != tokenKindRefresh || tc.Generation != generation || tc.SessionID != sessionID {
+			t.Fatalf("unexpected refresh token: %+v, %v", tc, err)
+		}
+
+		if got, _ := mr.Get(redisKeyForRefreshToken(1, sessionID)); got != strconv.FormatInt(generation, 10) {
+			t.Fatalf("generation in redis %v, want %v", got, generation)
+		}
+
+		authInfo, err := c.verifyToken(ctx, newTokens.AccessToken)
+		if err != nil || authInfo.SessionID != sessionID {
+			t.Fatalf("verify new access token failed: %+v, %v", authInfo, err)
+		}
+
+		tokens = newTokens
+	}
+
+	status, _, _ := c.RefreshToken(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_UNAUTHENTICATED {
+		t.Fatalf("access token used as refresh token: %v", status)
+	}
+
+	_, err := c.verifyToken(ctx, tokens.RefreshToken)
+	if !errors.Is(err, errTokenKind) {
+		t.Fatalf("refresh token used as access token: %v", err)
+	}
+}
+
+func TestRefreshToken_ReuseKillsSession(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	sessionID, tokens := newTestSession(t, c, 1, "")
+	childSessionID, childTokens := newTestSession(t, c, 1, sessionID)
+
+	status, newTokens, err := c.RefreshToken(ctx, tokens.RefreshToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("refresh failed: %v, %v", status, err)
+	}
+
+	status, _, err = c.RefreshToken(ctx, tokens.RefreshToken)
+	if status != userpb.UserStatus_USER_STATUS_UNAUTHENTICATED || !errors.Is(err, errRefreshTokenReused) {
+		t.Fatalf("reused refresh token: %v, %v", status, err)
+	}
+
+	for _, id := range []string{sessionID, childSessionID} {
+		if mr.Exists(redisKeyForSession(1, id)) || mr.Exists(redisKeyForRefreshToken(1, id)) {
+			t.Fatalf("session %v kept after reuse", id)
+		}
+
+		if !c.sessionRevoked(ctx, id) {
+			t.Fatalf("session %v not revoked", id)
+		}
+	}
+
+	for _, token := range []string{newTokens.AccessToken, childTokens.AccessToken} {
+		if _, err = c.verifyToken(ctx, token); err == nil {
+			t.Fatal("access token of killed session accepted")
+		}
+	}
+
+	for _, token := range []string{newTokens.RefreshToken, childTokens.RefreshToken} {
+		status, _, _ = c.RefreshToken(ctx, token)
+		if status != userpb.UserStatus_USER_STATUS_UNAUTHENTICATED {
+			t.Fatalf("refresh token of killed session accepted: %v", status)
+		}
+	}
+}
+
+func TestRefreshToken_Cookie(t *testing.T) {
+	c, _, _ := newTestController(t)
+
+	_, tokens := newTestSession(t, c, 1, "")
+
+	status, _, _ := c.RefreshToken(context.Background(), "")
+	if status != userpb.UserStatus_USER_STATUS_UNAUTHENTICATED {
+		t.Fatalf("refresh without token: %v", status)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(user.RefreshCookieName, tokens.RefreshToken))
+
+	status, newTokens, err := c.RefreshToken(ctx, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("refresh by cookie failed: %v, %v", status, err)
+	}
+
+	token, refreshToken := c.httpToken.(*testHTTPToken).cookies()
+	if token != newTokens.AccessToken || refreshToken != newTokens.RefreshToken {
+		t.Fatal("new tokens not set in cookies")
+	}
+
+	// the token in the request wins over the cookie, which is the rotated one now
+	status, _, err = c.RefreshToken(ctx, newTokens.RefreshToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("refresh by request failed: %v, %v", status, err)
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"github.com/sbasestarter/user/internal/user/controller/keyring"
+	"github.com/sbasestarter/user/internal/user/model"
+	"github.com/sgostarter/i/l"
+)
+
+const resealBatchSize = 100
+
+// ResealUser2FaKeys seals all stored 2fa secrets which are in plaintext or sealed by an old key
+// with the active key, so the old key can be removed after it
+func ResealUser2FaKeys(m *model.Model, secrets *keyring.Keyring, logger l.Wrapper, dryRun bool) (resealed int, err error) {
+	if !secrets.Enabled() {
+		err = keyring.ErrNoActiveKey
+
+		return
+	}
+
+	var lastUserID int64
+
+	for {
+		userAuthentications, e := m.GetUser2FaKeysAfter(lastUserID, resealBatchSize)
+		if e != nil {
+			err = e
+
+			return
+		}
+
+		if len(userAuthentications) == 0 {
+			return
+		}
+
+		for _, userAuthentication := range userAuthentications {
+			lastUserID = userAuthentication.UserId
+
+			if !secrets.NeedReseal(userAuthentication.Token2fa) {
+				continue
+			}
+
+			key, e := secrets.Reseal(userAuthentication.Token2fa, gaSecretContext(userAuthentication.UserId))
+			if e != nil {
+				logger.Errorf("reseal 2fa key of %v failed: %v", userAuthentication.UserId, e)
+
+				continue
+			}
+
+			if dryRun {
+				resealed++
+
+				continue
+			}
+
+			ok, e := m.ReplaceUser2FaKey(userAuthentication.UserId, userAuthentication.Token2fa, key)
+			if e != nil {
+				err = e
+
+				return
+			}
+
+			if !ok {
+				logger.Warnf("2fa key of %v changed while resealing, skipped", userAuthentication.UserId)
+
+				continue
+			}
+
+			resealed++
+		}
+	}
+}
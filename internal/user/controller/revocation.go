@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/utils"
+)
+
+// revocationList caches the sessions killed before their access tokens expire. Stateless verification
+// checks it instead of the sessions in redis, so a revocation takes effect within Token.RevocationRefresh
+// on other instances
+type revocationList struct {
+	sync.RWMutex
+
+	sessions map[string]int64
+	loadAt   time.Time
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{
+		sessions: make(map[string]int64),
+	}
+}
+
+// revokeAccessTokens adds sessions into the revocation list until the last access tokens of them expire
+func (c *Controller) revokeAccessTokens(ctx context.Context, sessionIDs ...string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(c.cfg.Token.AccessExpire).Unix()
+
+	members := make([]*redis.Z, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		members = append(members, &redis.Z{Score: float64(expiresAt), Member: sessionID})
+	}
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, redisKeyForRevokedSessions(), members...)
+			pipe.ZRemRangeByScore(ctx, redisKeyForRevokedSessions(), "-inf", strconv.FormatInt(now.Unix(), 10))
+			pipe.Expire(ctx, redisKeyForRevokedSessions(), c.cfg.Token.AccessExpire)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "add revoked sessions %v failed: %v", sessionIDs, err)
+	}
+
+	c.revoked.Lock()
+	for _, sessionID := range sessionIDs {
+		c.revoked.sessions[sessionID] = expiresAt
+	}
+	c.revoked.Unlock()
+}
+
+func (c *Controller) loadRevokedSessions(ctx context.Context) (sessions map[string]int64, err error) {
+	var members []redis.Z
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		members, err = c.redis.ZRangeByScoreWithScores(ctx, redisKeyForRevokedSessions(), &redis.ZRangeBy{
+			Min: strconv.FormatInt(time.Now().Unix(), 10),
+			Max: "+inf",
+		}).Result()
+	})
+
+	if err != nil {
+		return
+	}
+
+	sessions = make(map[string]int64, len(members))
+
+	for _, member := range members {
+		sessionID, ok := member.Member.(string)
+		if ok {
+			sessions[sessionID] = int64(member.Score)
+		}
+	}
+
+	return
+}
+
+// sessionRevoked reloads the list once it's older than Token.RevocationRefresh. The cached list is kept
+// if redis fails, so stateless verification goes on without redis
+func (c *Controller) sessionRevoked(ctx context.Context, sessionID string) bool {
+	c.revoked.RLock()
+	stale := time.Since(c.revoked.loadAt) > c.cfg.Token.RevocationRefresh
+	c.revoked.RUnlock()
+
+	if stale {
+		sessions, err := c.loadRevokedSessions(ctx)
+
+		c.revoked.Lock()
+		c.revoked.loadAt = time.Now()
+
+		if err != nil {
+			c.logger.Warnf(ctx, "load revoked sessions failed: %v", err)
+		} else {
+			c.revoked.sessions = sessions
+		}
+		c.revoked.Unlock()
+	}
+
+	c.revoked.RLock()
+	expiresAt, ok := c.revoked.sessions[sessionID]
+	c.revoked.RUnlock()
+
+	return ok && time.Now().Unix() <= expiresAt
+}
+
+func (c *Controller) GetJWKS(ctx context.Context) (status userpb.UserStatus, jwks string, err error) {
+	jwks, err = c.tokenKeys.JWKSJSON()
+	if err != nil {
+		c.logger.Errorf(ctx, "marshal jwks failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// GetRevokedSessions publishes the revocation list for the services which verify access tokens offline
+func (c *Controller) GetRevokedSessions(ctx context.Context) (status userpb.UserStatus,
+	sessions []*userpb.RevokedSession, err error) {
+	revoked, err := c.loadRevokedSessions(ctx)
+	if err != nil {
+		c.logger.Errorf(ctx, "load revoked sessions failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	for sessionID, expiresAt := range revoked {
+		sessions = append(sessions, &userpb.RevokedSession{
+			SessionId: sessionID,
+			ExpiresAt: expiresAt,
+		})
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sbasestarter/user/internal/user/controller/saml"
+)
+
+func (c *Controller) SAMLMetadata() []byte {
+	return c.samlIdP.Metadata()
+}
+
+// SAMLSSO handles an authn request of the user agent signed in by token, and returns either the page which
+// posts the response to the service provider, or where the user agent goes to sign in. The request is
+// refused without a response if the service provider or the acs url is unknown
+func (c *Controller) SAMLSSO(ctx context.Context, token string, req *saml.AuthnRequest, relayState string) (
+	form []byte, redirectURL string, err error) {
+	sp, acsURL, err := c.samlIdP.CheckRequest(req)
+	if err != nil {
+		c.logger.Warnf(ctx, "check authn request failed: %v", err)
+
+		return
+	}
+
+	var samlResponse string
+
+	defer func() {
+		if samlResponse != "" {
+			form, err = saml.PostForm(acsURL, samlResponse, relayState)
+		}
+	}()
+
+	var authInfo *AuthInfo
+
+	if token != "" && !req.ForceAuthn {
+		authInfo, err = c.verifyToken(ctx, token)
+		if err != nil {
+			c.logger.Warnf(ctx, "verify token of authn request failed: %v", err)
+
+			authInfo = nil
+			err = nil
+		}
+	}
+
+	if authInfo == nil || !authInfo.IsSession() {
+		if req.IsPassive {
+			samlResponse, err = c.samlIdP.ErrorResponse(req, acsURL, saml.StatusNoPassive)
+
+			return
+		}
+
+		redirectURL = c.samlLoginURL(req, relayState)
+
+		return
+	}
+
+	// users of auto login have the id of user source, which isn't a user id
+	if authInfo.UserSourceIDFlag {
+		samlResponse, err = c.samlIdP.ErrorResponse(req, acsURL, saml.StatusRequestDenied)
+
+		return
+	}
+
+	userDetail, _, err := c.m.GetUserDetailInfo(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get user detail info failed: %v", err)
+
+		samlResponse, err = c.samlIdP.ErrorResponse(req, acsURL, saml.StatusResponder)
+
+		return
+	}
+
+	spAuthInfo, err := c.newSAMLSession(ctx, authInfo)
+	if err != nil {
+		c.logger.Errorf(ctx, "new saml session failed: %v", err)
+
+		samlResponse, err = c.samlIdP.ErrorResponse(req, acsURL, saml.StatusResponder)
+
+		return
+	}
+
+	authnInstant := authInfo.AuthTime
+	if authnInstant == 0 {
+		authnInstant = authInfo.CreateAt
+	}
+
+	samlResponse, err = c.samlIdP.Response(req, sp, acsURL, &saml.User{
+		ID:                  authInfo.UserID,
+		Email:               userDetail.UserExt.Email,
+		NickName:            userDetail.UserInfo.NickName,
+		AuthnInstant:        time.Unix(authnInstant, 0),
+		SessionIndex:        spAuthInfo.SessionID,
+		SessionNotOnOrAfter: time.Unix(spAuthInfo.ExpiresAt, 0),
+	})
+	if err != nil {
+		c.logger.Errorf(ctx, "sign saml response failed: %v", err)
+	}
+
+	return
+}
+
+// samlLoginURL sends the user agent to sign in, and back to the authn request by the redirect binding
+func (c *Controller) samlLoginURL(req *saml.AuthnRequest, relayState string) string {
+	params := url.Values{
+		"SAMLRequest": {req.EncodeRedirect()},
+	}
+
+	if relayState != "" {
+		params.Set("RelayState", relayState)
+	}
+
+	u, err := url.Parse(c.cfg.SAML.LoginURL)
+	if err != nil {
+		return c.cfg.SAML.LoginURL
+	}
+
+	query := u.Query()
+	query.Set("return_to", c.samlIdP.SSOURL()+"?"+params.Encode())
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// newSAMLSession signs the service provider on as newSSOToken does, the id of the session is the session
+// index of the assertion. It never outlives the session of the user agent
+func (c *Controller) newSAMLSession(ctx context.Context, u *AuthInfo) (*AuthInfo, error) {
+	sessionID := uuid.NewV4().String()
+
+	// u is the parent session, which must not be changed
+	samlAuthInfo := *u
+	samlAuthInfo.ParentSessionID = u.SessionID
+	samlAuthInfo.ExpiresAt = time.Now().Add(c.cfg.SAML.SessionExpire).Unix()
+
+	if samlAuthInfo.ExpiresAt > u.ExpiresAt {
+		samlAuthInfo.ExpiresAt = u.ExpiresAt
+	}
+
+	_, err := c.generateTokenEx(ctx, sessionID, redisKeyForSAMLSession(u.UserID, sessionID), tokenKindSAML,
+		c.cfg.SAML.SessionExpire, c.cfg.SAML.SessionExpire, &samlAuthInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &samlAuthInfo, nil
+}
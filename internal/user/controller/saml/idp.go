@@ -0,0 +1,392 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const (
+	algExcC14N     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	clockSkew      = time.Minute
+	xmlDeclaration = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+)
+
+// ServiceProvider is a registered service provider
+type ServiceProvider struct {
+	EntityID string
+	NameID   string
+	acsURLs  []string
+}
+
+// ACSURL is acsURL if it's registered, or the default one if acsURL is empty
+func (sp *ServiceProvider) ACSURL(acsURL string) (string, bool) {
+	if acsURL == "" {
+		return sp.acsURLs[0], true
+	}
+
+	for _, u := range sp.acsURLs {
+		if u == acsURL {
+			return u, true
+		}
+	}
+
+	return "", false
+}
+
+// User is whom an assertion is about
+type User struct {
+	ID                  int64
+	Email               string
+	NickName            string
+	AuthnInstant        time.Time
+	SessionIndex        string
+	SessionNotOnOrAfter time.Time
+}
+
+type IdentityProvider struct {
+	entityID        string
+	ssoURL          string
+	assertionExpire time.Duration
+	key             *rsa.PrivateKey
+	cert            *x509.Certificate
+	sps             map[string]*ServiceProvider
+}
+
+func New(cfg *config.SAMLConfig) (*IdentityProvider, error) {
+	if cfg.BaseURL == "" || cfg.EntityID == "" {
+		return nil, errors.New("saml needs base url and entity id")
+	}
+
+	key, cert, err := loadKeyPair(cfg.KeyFile, cfg.CertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	idp := &IdentityProvider{
+		entityID:        cfg.EntityID,
+		ssoURL:          cfg.BaseURL + PathSSO,
+		assertionExpire: cfg.AssertionExpire,
+		key:             key,
+		cert:            cert,
+		sps:             make(map[string]*ServiceProvider),
+	}
+
+	for _, spCfg := range cfg.ServiceProviders {
+		if spCfg.EntityID == "" {
+			return nil, errors.New("service provider without entity id")
+		}
+
+		if _, ok := idp.sps[spCfg.EntityID]; ok {
+			return nil, fmt.Errorf("duplicated service provider %v", spCfg.EntityID)
+		}
+
+		if len(spCfg.ACSURLs) == 0 {
+			return nil, fmt.Errorf("service provider %v has no acs url", spCfg.EntityID)
+		}
+
+		for _, acsURL := range spCfg.ACSURLs {
+			u, err := url.Parse(acsURL)
+			if err != nil || !u.IsAbs() {
+				return nil, fmt.Errorf("service provider %v: invalid acs url %q", spCfg.EntityID, acsURL)
+			}
+		}
+
+		if spCfg.NameID != config.SAMLNameIDUserID && spCfg.NameID != config.SAMLNameIDEmail {
+			return nil, fmt.Errorf("service provider %v: unknown name id %q", spCfg.EntityID, spCfg.NameID)
+		}
+
+		idp.sps[spCfg.EntityID] = &ServiceProvider{
+			EntityID: spCfg.EntityID,
+			NameID:   spCfg.NameID,
+			acsURLs:  spCfg.ACSURLs,
+		}
+	}
+
+	return idp, nil
+}
+
+func loadKeyPair(keyFile, certFile string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no pem key in %v", keyFile)
+	}
+
+	var key *rsa.PrivateKey
+
+	if parsed, errP := x509.ParsePKCS8PrivateKey(block.Bytes); errP == nil {
+		var ok bool
+
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, nil, fmt.Errorf("%v isn't a rsa key", keyFile)
+		}
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, nil, fmt.Errorf("parse key %v: %w", keyFile, err)
+	}
+
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ = pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no pem certificate in %v", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse certificate %v: %w", certFile, err)
+	}
+
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); !ok || pub.N.Cmp(key.N) != 0 || pub.E != key.E {
+		return nil, nil, fmt.Errorf("certificate %v isn't of key %v", certFile, keyFile)
+	}
+
+	return key, cert, nil
+}
+
+// SSOURL is the url of the single sign on service, for both bindings
+func (idp *IdentityProvider) SSOURL() string {
+	return idp.ssoURL
+}
+
+// CheckRequest finds the service provider of req and the acs url to respond to. The user agent can't be
+// sent back to an unchecked request
+func (idp *IdentityProvider) CheckRequest(req *AuthnRequest) (sp *ServiceProvider, acsURL string, err error) {
+	sp, ok := idp.sps[req.Issuer]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnknownServiceProvider, req.Issuer)
+	}
+
+	acsURL, ok = sp.ACSURL(req.AssertionConsumerServiceURL)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnregisteredACSURL, req.AssertionConsumerServiceURL)
+	}
+
+	if req.ProtocolBinding != "" && req.ProtocolBinding != BindingHTTPPost {
+		return nil, "", fmt.Errorf("%w: unsupported protocol binding %v", ErrBadRequest, req.ProtocolBinding)
+	}
+
+	if req.Destination != "" && req.Destination != idp.ssoURL {
+		return nil, "", fmt.Errorf("%w: destination %v", ErrBadRequest, req.Destination)
+	}
+
+	return sp, acsURL, nil
+}
+
+// Metadata is the metadata document of the identity provider
+func (idp *IdentityProvider) Metadata() []byte {
+	descriptor := newElement("md:IDPSSODescriptor").
+		attr("WantAuthnRequestsSigned", "false").
+		attr("protocolSupportEnumeration", nsProtocol).
+		add(newElement("md:KeyDescriptor").attr("use", "signing").add(idp.keyInfo()))
+
+	for _, format := range []string{NameIDFormatPersistent, NameIDFormatEmail, NameIDFormatUnspecified} {
+		descriptor.add(newElement("md:NameIDFormat").setText(format))
+	}
+
+	for _, binding := range []string{BindingHTTPRedirect, BindingHTTPPost} {
+		descriptor.add(newElement("md:SingleSignOnService").attr("Binding", binding).attr("Location", idp.ssoURL))
+	}
+
+	entity := newElement("md:EntityDescriptor").ns("md", nsMetadata).attr("entityID", idp.entityID).
+		add(descriptor)
+
+	return []byte(xmlDeclaration + entity.String())
+}
+
+func (idp *IdentityProvider) keyInfo() *element {
+	return newElement("ds:KeyInfo").ns("ds", nsDSig).add(
+		newElement("ds:X509Data").add(
+			newElement("ds:X509Certificate").setText(base64.StdEncoding.EncodeToString(idp.cert.Raw))))
+}
+
+// Response is the base64 encoded response to req with the signed assertion about user
+func (idp *IdentityProvider) Response(req *AuthnRequest, sp *ServiceProvider, acsURL string, user *User) (
+	string, error) {
+	nameID, nameIDFormat := strconv.FormatInt(user.ID, 10), NameIDFormatPersistent
+	if sp.NameID == config.SAMLNameIDEmail {
+		nameID, nameIDFormat = user.Email, NameIDFormatEmail
+	}
+
+	if nameID == "" {
+		return idp.ErrorResponse(req, acsURL, StatusInvalidNameIDPolicy)
+	}
+
+	if format := req.NameIDFormat(); format != NameIDFormatUnspecified && format != nameIDFormat {
+		return idp.ErrorResponse(req, acsURL, StatusInvalidNameIDPolicy)
+	}
+
+	assertionID, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	notOnOrAfter := formatTime(now.Add(idp.assertionExpire))
+
+	attributes := newElement("saml:AttributeStatement").
+		add(attribute(AttributeUserID, strconv.FormatInt(user.ID, 10)))
+
+	if user.Email != "" {
+		attributes.add(attribute(AttributeEmail, user.Email))
+	}
+
+	if user.NickName != "" {
+		attributes.add(attribute(AttributeNickName, user.NickName))
+	}
+
+	issuer := newElement("saml:Issuer").setText(idp.entityID)
+
+	assertion := newElement("saml:Assertion").ns("saml", nsAssertion).
+		attr("ID", assertionID).attr("IssueInstant", formatTime(now)).attr("Version", "2.0").
+		add(issuer,
+			newElement("saml:Subject").add(
+				newElement("saml:NameID").attr("Format", nameIDFormat).attr("SPNameQualifier", sp.EntityID).
+					setText(nameID),
+				newElement("saml:SubjectConfirmation").attr("Method", confirmationBearer).add(
+					newElement("saml:SubjectConfirmationData").attr("InResponseTo", req.ID).
+						attr("NotOnOrAfter", notOnOrAfter).attr("Recipient", acsURL))),
+			newElement("saml:Conditions").attr("NotBefore", formatTime(now.Add(-clockSkew))).
+				attr("NotOnOrAfter", notOnOrAfter).add(
+				newElement("saml:AudienceRestriction").add(newElement("saml:Audience").setText(sp.EntityID))),
+			newElement("saml:AuthnStatement").attr("AuthnInstant", formatTime(user.AuthnInstant)).
+				attr("SessionIndex", user.SessionIndex).
+				attr("SessionNotOnOrAfter", formatTime(user.SessionNotOnOrAfter)).add(
+				newElement("saml:AuthnContext").add(
+					newElement("saml:AuthnContextClassRef").setText(AuthnContextUnspecified))),
+			attributes)
+
+	signature, err := idp.sign(assertion, assertionID)
+	if err != nil {
+		return "", err
+	}
+
+	// the signature of an assertion follows the issuer of it, SAML core section 5.3
+	assertion.children = append([]*element{issuer, signature}, assertion.children[1:]...)
+
+	return idp.response(req, acsURL, StatusSuccess, assertion)
+}
+
+// ErrorResponse is the base64 encoded response to req which tells statusCode, the top-level status code is
+// the one of the requester or the responder
+func (idp *IdentityProvider) ErrorResponse(req *AuthnRequest, acsURL, statusCode string) (string, error) {
+	return idp.response(req, acsURL, statusCode, nil)
+}
+
+func (idp *IdentityProvider) response(req *AuthnRequest, acsURL, statusCode string, assertion *element) (
+	string, error) {
+	responseID, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	status := newElement("samlp:StatusCode")
+
+	switch statusCode {
+	case StatusSuccess, StatusRequester, StatusResponder:
+		status.attr("Value", statusCode)
+	case StatusRequestDenied:
+		status.attr("Value", StatusResponder).add(newElement("samlp:StatusCode").attr("Value", statusCode))
+	default:
+		status.attr("Value", StatusRequester).add(newElement("samlp:StatusCode").attr("Value", statusCode))
+	}
+
+	response := newElement("samlp:Response").ns("saml", nsAssertion).ns("samlp", nsProtocol).
+		attr("Destination", acsURL).attr("ID", responseID).attr("InResponseTo", req.ID).
+		attr("IssueInstant", formatTime(time.Now())).attr("Version", "2.0").
+		add(newElement("saml:Issuer").setText(idp.entityID), newElement("samlp:Status").add(status))
+
+	if assertion != nil {
+		response.add(assertion)
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(xmlDeclaration + response.String())), nil
+}
+
+func attribute(name, value string) *element {
+	return newElement("saml:Attribute").attr("Name", name).attr("NameFormat", attrNameFormatBasic).
+		add(newElement("saml:AttributeValue").setText(value))
+}
+
+// sign makes the enveloped signature of e, which has the id and no signature yet. e is the apex of its
+// canonical form, so the digest is of the bytes of it
+func (idp *IdentityProvider) sign(e *element, id string) (*element, error) {
+	digest := sha256.Sum256([]byte(e.String()))
+
+	signedInfo := newElement("ds:SignedInfo").ns("ds", nsDSig).add(
+		newElement("ds:CanonicalizationMethod").attr("Algorithm", algExcC14N),
+		newElement("ds:SignatureMethod").attr("Algorithm", algRSASHA256),
+		newElement("ds:Reference").attr("URI", "#"+id).add(
+			newElement("ds:Transforms").add(
+				newElement("ds:Transform").attr("Algorithm", algEnveloped),
+				newElement("ds:Transform").attr("Algorithm", algExcC14N)),
+			newElement("ds:DigestMethod").attr("Algorithm", algSHA256),
+			newElement("ds:DigestValue").setText(base64.StdEncoding.EncodeToString(digest[:]))))
+
+	hashed := sha256.Sum256([]byte(signedInfo.String()))
+
+	signatureValue, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return newElement("ds:Signature").ns("ds", nsDSig).add(
+		signedInfo,
+		newElement("ds:SignatureValue").setText(base64.StdEncoding.EncodeToString(signatureValue)),
+		idp.keyInfo()), nil
+}
+
+var postFormTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Signing in</title></head>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.URL}}">
+<input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+{{- if .RelayState}}
+<input type="hidden" name="RelayState" value="{{.RelayState}}">
+{{- end}}
+<noscript><input type="submit" value="Continue"></noscript>
+</form>
+</body>
+</html>
+`))
+
+// PostForm is the page which posts samlResponse to acsURL by the HTTP-POST binding
+func PostForm(acsURL, samlResponse, relayState string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := postFormTemplate.Execute(&buf, map[string]string{
+		"URL":          acsURL,
+		"SAMLResponse": samlResponse,
+		"RelayState":   relayState,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,165 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	PathMetadata = "/saml/metadata"
+	PathSSO      = "/saml/sso"
+)
+
+const (
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	NameIDFormatPersistent  = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	NameIDFormatEmail       = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+
+	AuthnContextUnspecified = "urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified"
+
+	AttributeUserID   = "uid"
+	AttributeEmail    = "email"
+	AttributeNickName = "nickname"
+)
+
+// status codes of SAML core section 3.2.2.2
+const (
+	StatusSuccess             = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	StatusRequester           = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+	StatusResponder           = "urn:oasis:names:tc:SAML:2.0:status:Responder"
+	StatusRequestDenied       = "urn:oasis:names:tc:SAML:2.0:status:RequestDenied"
+	StatusNoPassive           = "urn:oasis:names:tc:SAML:2.0:status:NoPassive"
+	StatusInvalidNameIDPolicy = "urn:oasis:names:tc:SAML:2.0:status:InvalidNameIDPolicy"
+)
+
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsDSig      = "http://www.w3.org/2000/09/xmldsig#"
+
+	attrNameFormatBasic = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+	confirmationBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	maxRequestSize = 1 << 16
+	timeFormat     = "2006-01-02T15:04:05Z"
+)
+
+var (
+	ErrBadRequest             = errors.New("bad authn request")
+	ErrUnknownServiceProvider = errors.New("unknown service provider")
+	ErrUnregisteredACSURL     = errors.New("unregistered assertion consumer service url")
+)
+
+// AuthnRequest is what a service provider asks for, the raw xml of it is kept to send the user agent
+// back to it after signing in
+type AuthnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	ForceAuthn                  bool     `xml:"ForceAuthn,attr"`
+	IsPassive                   bool     `xml:"IsPassive,attr"`
+	Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                *struct {
+		Format string `xml:"Format,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+
+	raw []byte
+}
+
+// DecodeRedirectRequest decodes the SAMLRequest parameter of the HTTP-Redirect binding, which is deflated
+func DecodeRedirectRequest(samlRequest string) (*AuthnRequest, error) {
+	data, err := base64.StdEncoding.DecodeString(samlRequest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), maxRequestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	return parseRequest(raw)
+}
+
+// DecodePostRequest decodes the SAMLRequest parameter of the HTTP-POST binding
+func DecodePostRequest(samlRequest string) (*AuthnRequest, error) {
+	raw, err := base64.StdEncoding.DecodeString(samlRequest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	return parseRequest(raw)
+}
+
+func parseRequest(raw []byte) (*AuthnRequest, error) {
+	if len(raw) > maxRequestSize {
+		return nil, fmt.Errorf("%w: too large", ErrBadRequest)
+	}
+
+	req := &AuthnRequest{}
+
+	err := xml.Unmarshal(raw, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	if req.ID == "" || req.Version != "2.0" || req.Issuer == "" {
+		return nil, fmt.Errorf("%w: no id, version 2.0 or issuer", ErrBadRequest)
+	}
+
+	req.raw = raw
+
+	return req, nil
+}
+
+// EncodeRedirect encodes req as the SAMLRequest parameter of the HTTP-Redirect binding
+func (req *AuthnRequest) EncodeRedirect() string {
+	var buf bytes.Buffer
+
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	_, _ = w.Write(req.raw)
+	_ = w.Close()
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// NameIDFormat is the format the service provider asks for, or the unspecified one
+func (req *AuthnRequest) NameIDFormat() string {
+	if req.NameIDPolicy == nil || req.NameIDPolicy.Format == "" {
+		return NameIDFormatUnspecified
+	}
+
+	return req.NameIDPolicy.Format
+}
+
+func newID() (string, error) {
+	b := make([]byte, 20)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	// an id is an xml NCName, which can't start with a digit
+	return "_" + hex.EncodeToString(b), nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
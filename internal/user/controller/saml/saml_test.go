@@ -0,0 +1,413 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sbasestarter/user/internal/config"
+)
+
+const (
+	testSP   = "https://sp.example.com/metadata"
+	testACS  = "https://sp.example.com/acs"
+	testACS2 = "https://sp.example.com/acs2"
+)
+
+func newTestIdP(t *testing.T, nameID string) *IdentityProvider {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "saml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.SAMLConfig{
+		BaseURL:         "https://idp.example.com",
+		EntityID:        "https://idp.example.com/saml/metadata",
+		KeyFile:         filepath.Join(dir, "key.pem"),
+		CertFile:        filepath.Join(dir, "cert.pem"),
+		AssertionExpire: 5 * time.Minute,
+		ServiceProviders: []config.SAMLServiceProviderConfig{
+			{EntityID: testSP, ACSURLs: []string{testACS, testACS2}, NameID: nameID},
+		},
+	}
+
+	for file, block := range map[string]*pem.Block{
+		cfg.KeyFile:  {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		cfg.CertFile: {Type: "CERTIFICATE", Bytes: certDER},
+	} {
+		if err = ioutil.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idp, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return idp
+}
+
+func authnRequest(attrs string) string {
+	return `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ` +
+		`xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-1" Version="2.0" ` + attrs + `>` +
+		`<saml:Issuer>` + testSP + `</saml:Issuer></samlp:AuthnRequest>`
+}
+
+func deflate(s string) string {
+	var buf bytes.Buffer
+
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	_, _ = w.Write([]byte(s))
+	_ = w.Close()
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecodeRequest(t *testing.T) {
+	raw := authnRequest(`AssertionConsumerServiceURL="` + testACS + `" IsPassive="true"`)
+
+	req, err := DecodeRedirectRequest(deflate(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.ID != "id-1" || req.Issuer != testSP || req.AssertionConsumerServiceURL != testACS || !req.IsPassive ||
+		req.NameIDFormat() != NameIDFormatUnspecified {
+		t.Fatalf("unexpected request %+v", req)
+	}
+
+	again, err := DecodeRedirectRequest(req.EncodeRedirect())
+	if err != nil || again.ID != req.ID {
+		t.Fatalf("unexpected request %+v, %v", again, err)
+	}
+
+	req, err = DecodePostRequest(base64.StdEncoding.EncodeToString([]byte(authnRequest(""))))
+	if err != nil || req.Issuer != testSP {
+		t.Fatalf("unexpected request %+v, %v", req, err)
+	}
+
+	for _, samlRequest := range []string{
+		"!",
+		base64.StdEncoding.EncodeToString([]byte(raw)),
+		deflate(`<AuthnRequest ID="x" Version="2.0"/>`),
+		deflate(strings.Replace(raw, `Version="2.0"`, `Version="1.1"`, 1)),
+		deflate(strings.Replace(raw, "<saml:Issuer>"+testSP+"</saml:Issuer>", "", 1)),
+		deflate(strings.Replace(raw, "</samlp:AuthnRequest>", strings.Repeat(" ", maxRequestSize), 1)),
+	} {
+		if _, err = DecodeRedirectRequest(samlRequest); !errors.Is(err, ErrBadRequest) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestCheckRequest(t *testing.T) {
+	idp := newTestIdP(t, config.SAMLNameIDUserID)
+
+	for _, c := range [][2]string{
+		{``, testACS},
+		{`AssertionConsumerServiceURL="` + testACS2 + `"`, testACS2},
+		{`Destination="https://idp.example.com/saml/sso" ProtocolBinding="` + BindingHTTPPost + `"`, testACS},
+	} {
+		req, _ := DecodePostRequest(base64.StdEncoding.EncodeToString([]byte(authnRequest(c[0]))))
+
+		sp, got, err := idp.CheckRequest(req)
+		if err != nil || sp.EntityID != testSP || got != c[1] {
+			t.Errorf("%v: unexpected %v, %v", c[0], got, err)
+		}
+	}
+
+	for attrs, expected := range map[string]error{
+		`AssertionConsumerServiceURL="https://evil.example.com/acs"`: ErrUnregisteredACSURL,
+		`Destination="https://other.example.com/saml/sso"`:           ErrBadRequest,
+		`ProtocolBinding="` + BindingHTTPRedirect + `"`:              ErrBadRequest,
+	} {
+		req, _ := DecodePostRequest(base64.StdEncoding.EncodeToString([]byte(authnRequest(attrs))))
+
+		if _, _, err := idp.CheckRequest(req); !errors.Is(err, expected) {
+			t.Errorf("%v: unexpected error %v", attrs, err)
+		}
+	}
+
+	req, _ := DecodePostRequest(base64.StdEncoding.EncodeToString([]byte(
+		strings.Replace(authnRequest(""), testSP, "https://other.example.com", 1))))
+
+	if _, _, err := idp.CheckRequest(req); !errors.Is(err, ErrUnknownServiceProvider) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+type testResponse struct {
+	InResponseTo string `xml:"InResponseTo,attr"`
+	Destination  string `xml:"Destination,attr"`
+	Status       struct {
+		StatusCode struct {
+			Value      string `xml:"Value,attr"`
+			StatusCode struct {
+				Value string `xml:"Value,attr"`
+			}
+		}
+	}
+	Assertion *struct {
+		Subject struct {
+			NameID struct {
+				Format string `xml:"Format,attr"`
+				Value  string `xml:",chardata"`
+			}
+			SubjectConfirmation struct {
+				SubjectConfirmationData struct {
+					InResponseTo string `xml:"InResponseTo,attr"`
+					Recipient    string `xml:"Recipient,attr"`
+				}
+			}
+		}
+		Conditions struct {
+			AudienceRestriction struct {
+				Audience string
+			}
+		}
+		AuthnStatement struct {
+			SessionIndex string `xml:"SessionIndex,attr"`
+		}
+		AttributeStatement struct {
+			Attribute []struct {
+				Name           string `xml:"Name,attr"`
+				AttributeValue string
+			}
+		}
+	}
+}
+
+func decodeResponse(t *testing.T, samlResponse string) (string, *testResponse) {
+	t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &testResponse{}
+
+	if err = xml.Unmarshal(data, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data), resp
+}
+
+func newValidationContext(idp *IdentityProvider) *dsig.ValidationContext {
+	return dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{idp.cert},
+	})
+}
+
+// verifyAssertion returns the assertion of the response data as signed by idp
+func verifyAssertion(t *testing.T, idp *IdentityProvider, data string) *etree.Element {
+	t.Helper()
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(data); err != nil {
+		t.Fatal(err)
+	}
+
+	assertion := doc.FindElement("//Assertion")
+	if assertion == nil {
+		t.Fatalf("no assertion in %v", data)
+	}
+
+	validated, err := newValidationContext(idp).Validate(assertion)
+	if err != nil {
+		t.Fatalf("verify signature failed: %v", err)
+	}
+
+	return validated
+}
+
+func TestResponse(t *testing.T) {
+	idp := newTestIdP(t, config.SAMLNameIDUserID)
+
+	req, _ := DecodePostRequest(base64.StdEncoding.EncodeToString([]byte(authnRequest(""))))
+	sp, acsURL, _ := idp.CheckRequest(req)
+
+	samlResponse, err := idp.Response(req, sp, acsURL, &User{
+		ID:                  42,
+		Email:               "alice@example.com",
+		NickName:            `Alice & "Bob" <x>`,
+		AuthnInstant:        time.Now(),
+		SessionIndex:        "session-1",
+		SessionNotOnOrAfter: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, resp := decodeResponse(t, samlResponse)
+
+	if resp.InResponseTo != "id-1" || resp.Destination != testACS || resp.Status.StatusCode.Value != StatusSuccess ||
+		resp.Assertion == nil {
+		t.Fatalf("unexpected response %v", data)
+	}
+
+	assertion := resp.Assertion
+	if assertion.Subject.NameID.Value != "42" || assertion.Subject.NameID.Format != NameIDFormatPersistent ||
+		assertion.Subject.SubjectConfirmation.SubjectConfirmationData.InResponseTo != "id-1" ||
+		assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient != testACS ||
+		assertion.Conditions.AudienceRestriction.Audience != testSP ||
+		assertion.AuthnStatement.SessionIndex != "session-1" {
+		t.Fatalf("unexpected assertion %v", data)
+	}
+
+	attributes := make(map[string]string)
+	for _, attribute := range assertion.AttributeStatement.Attribute {
+		attributes[attribute.Name] = attribute.AttributeValue
+	}
+
+	if attributes[AttributeUserID] != "42" || attributes[AttributeEmail] != "alice@example.com" ||
+		attributes[AttributeNickName] != `Alice & "Bob" <x>` {
+		t.Fatalf("unexpected attributes %v", attributes)
+	}
+
+	// the signature is checked by another implementation of xml-dsig, which canonicalizes by itself
+	validated := verifyAssertion(t, idp, data)
+	if nameID := validated.FindElement(".//NameID"); nameID == nil || nameID.Text() != "42" {
+		t.Fatalf("unexpected signed assertion %v", data)
+	}
+
+	tampered := strings.Replace(data, "alice@example.com", "mallory@example.com", 1)
+
+	doc := etree.NewDocument()
+	if err = doc.ReadFromString(tampered); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = newValidationContext(idp).Validate(doc.FindElement("//Assertion")); err == nil {
+		t.Fatal("tampered assertion verified")
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	idp := newTestIdP(t, config.SAMLNameIDEmail)
+
+	req, _ := DecodePostRequest(base64.StdEncoding.EncodeToString([]byte(authnRequest(""))))
+	sp, acsURL, _ := idp.CheckRequest(req)
+
+	samlResponse, err := idp.Response(req, sp, acsURL, &User{ID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, resp := decodeResponse(t, samlResponse)
+	if resp.Status.StatusCode.Value != StatusRequester ||
+		resp.Status.StatusCode.StatusCode.Value != StatusInvalidNameIDPolicy || resp.Assertion != nil {
+		t.Fatalf("unexpected response %v", data)
+	}
+
+	samlResponse, err = idp.Response(req, sp, acsURL, &User{ID: 42, Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, resp = decodeResponse(t, samlResponse)
+	if resp.Assertion == nil || resp.Assertion.Subject.NameID.Value != "alice@example.com" ||
+		resp.Assertion.Subject.NameID.Format != NameIDFormatEmail {
+		t.Fatalf("unexpected response %v", data)
+	}
+
+	samlResponse, _ = idp.ErrorResponse(req, acsURL, StatusRequestDenied)
+
+	data, resp = decodeResponse(t, samlResponse)
+	if resp.Status.StatusCode.Value != StatusResponder || resp.Status.StatusCode.StatusCode.Value != StatusRequestDenied {
+		t.Fatalf("unexpected response %v", data)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	idp := newTestIdP(t, config.SAMLNameIDUserID)
+
+	var metadata struct {
+		EntityID         string `xml:"entityID,attr"`
+		IDPSSODescriptor struct {
+			KeyDescriptor struct {
+				Certificate string `xml:"KeyInfo>X509Data>X509Certificate"`
+			}
+			SingleSignOnService []struct {
+				Binding  string `xml:"Binding,attr"`
+				Location string `xml:"Location,attr"`
+			}
+		}
+	}
+
+	if err := xml.Unmarshal(idp.Metadata(), &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.EntityID != "https://idp.example.com/saml/metadata" ||
+		metadata.IDPSSODescriptor.KeyDescriptor.Certificate != base64.StdEncoding.EncodeToString(idp.cert.Raw) ||
+		len(metadata.IDPSSODescriptor.SingleSignOnService) != 2 ||
+		metadata.IDPSSODescriptor.SingleSignOnService[0].Location != "https://idp.example.com/saml/sso" {
+		t.Fatalf("unexpected metadata %+v", metadata)
+	}
+}
+
+func TestPostForm(t *testing.T) {
+	form, err := PostForm(testACS, "PHJlc3BvbnNlLz4=", `a"b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{`action="https://sp.example.com/acs"`, `value="PHJlc3BvbnNlLz4="`, `value="a&#34;b"`} {
+		if !strings.Contains(string(form), s) {
+			t.Errorf("no %v in %s", s, form)
+		}
+	}
+}
+
+func TestElement(t *testing.T) {
+	e := newElement("a:e").attr("z", "1").ns("b", "urn:b").attr("b", "x\"\n<&").ns("a", "urn:a").attr("empty", "").
+		add(newElement("a:c").setText("1 < 2 & 3 > 2\r\x01"), newElement("a:d"))
+
+	expected := `<a:e xmlns:a="urn:a" xmlns:b="urn:b" b="x&quot;&#xA;&lt;&amp;" z="1">` +
+		`<a:c>1 &lt; 2 &amp; 3 &gt; 2&#xD;</a:c><a:d></a:d></a:e>`
+	if e.String() != expected {
+		t.Fatalf("unexpected %v", e)
+	}
+}
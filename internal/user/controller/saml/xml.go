@@ -0,0 +1,115 @@
+package saml
+
+import (
+	"sort"
+	"strings"
+)
+
+// element is an xml element which is written in the exclusive canonical form, so the bytes which are
+// signed are the bytes which are sent. It has either text or children
+type element struct {
+	name     string
+	nss      []attr
+	attrs    []attr
+	text     string
+	children []*element
+}
+
+type attr struct {
+	name  string
+	value string
+}
+
+func newElement(name string) *element {
+	return &element{
+		name: name,
+	}
+}
+
+// ns declares prefix on the element, which must use it
+func (e *element) ns(prefix, uri string) *element {
+	e.nss = append(e.nss, attr{name: "xmlns:" + prefix, value: uri})
+
+	return e
+}
+
+// attr sets an unqualified attribute, empty values are left out
+func (e *element) attr(name, value string) *element {
+	if value != "" {
+		e.attrs = append(e.attrs, attr{name: name, value: value})
+	}
+
+	return e
+}
+
+func (e *element) setText(text string) *element {
+	e.text = text
+
+	return e
+}
+
+func (e *element) add(children ...*element) *element {
+	e.children = append(e.children, children...)
+
+	return e
+}
+
+func (e *element) String() string {
+	var sb strings.Builder
+
+	e.write(&sb)
+
+	return sb.String()
+}
+
+// write follows Exclusive XML Canonicalization 1.0: no self-closing tags, namespace declarations first and
+// sorted by prefix, then attributes sorted by name
+func (e *element) write(sb *strings.Builder) {
+	nss := append([]attr(nil), e.nss...)
+	sort.Slice(nss, func(i, j int) bool {
+		return nss[i].name < nss[j].name
+	})
+
+	attrs := append([]attr(nil), e.attrs...)
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].name < attrs[j].name
+	})
+
+	sb.WriteString("<" + e.name)
+
+	for _, a := range append(nss, attrs...) {
+		sb.WriteString(" " + a.name + `="` + escapeAttr(a.value) + `"`)
+	}
+
+	sb.WriteString(">")
+	sb.WriteString(escapeText(e.text))
+
+	for _, child := range e.children {
+		child.write(sb)
+	}
+
+	sb.WriteString("</" + e.name + ">")
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;",
+		"\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(strings.Map(xmlChar, s))
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(strings.Map(xmlChar, s))
+}
+
+// xmlChar drops the characters xml 1.0 can't carry, even escaped
+func xmlChar(r rune) rune {
+	if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xfffe || r == 0xffff {
+		return -1
+	}
+
+	return r
+}
@@ -0,0 +1,358 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/model"
+)
+
+const (
+	apiKeyKindServiceAccount = "sa"
+
+	// tokenTypeServiceAccount is the token type of the keys of service accounts in introspection
+	tokenTypeServiceAccount = "service_account"
+
+	// ScopeTokenIntrospect allows a service account to introspect tokens
+	ScopeTokenIntrospect = "token:introspect"
+
+	maxScopeLen = 64
+)
+
+var (
+	errBadAPIKey             = errors.New("bad api key")
+	errServiceAccountRevoked = errors.New("service account revoked")
+	errServiceAccountExpired = errors.New("service account expired")
+	errNoScope               = errors.New("no scope")
+)
+
+func isServiceAccountKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyKindServiceAccount+"_")
+}
+
+// lookupServiceAccount finds the active service account of key
+func (c *Controller) lookupServiceAccount(ctx context.Context, key string) (account *model.ServiceAccount, err error) {
+	keyID, secret, ok := parseAPIKey(apiKeyKindServiceAccount, key)
+	if !ok {
+		err = errBadAPIKey
+
+		return
+	}
+
+	account, err = c.m.GetServiceAccountByKeyID(keyID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get service account of key %v failed: %v", keyID, err)
+
+		return
+	}
+
+	if account == nil || !apiKeySecretMatch(secret, account.KeyHash) {
+		err = errBadAPIKey
+
+		return
+	}
+
+	if account.RevokeAt != nil {
+		err = errServiceAccountRevoked
+
+		return
+	}
+
+	if account.ExpireAt != nil && time.Now().After(*account.ExpireAt) {
+		err = errServiceAccountExpired
+
+		return
+	}
+
+	return
+}
+
+// verifyServiceAccountKey resolves an api key to its service account, which has no user
+func (c *Controller) verifyServiceAccountKey(ctx context.Context, key string) (authInfo *AuthInfo, err error) {
+	account, err := c.lookupServiceAccount(ctx, key)
+	if err != nil {
+		return
+	}
+
+	c.markServiceAccountUsed(ctx, account)
+
+	authInfo = &AuthInfo{
+		NickName:         account.Name,
+		ClientIP:         c.utils.GetPeerIP(ctx),
+		CreateAt:         account.CreateAt.Unix(),
+		ServiceAccountID: account.Id,
+		Scopes:           strings.Fields(account.Scopes),
+	}
+
+	if account.ExpireAt != nil {
+		authInfo.ExpiresAt = account.ExpireAt.Unix()
+	}
+
+	return
+}
+
+func (c *Controller) markServiceAccountUsed(ctx context.Context, account *model.ServiceAccount) {
+	ip := c.utils.GetPeerIP(ctx)
+
+	if apiKeyUsedRecently(account.LastUsedAt, account.LastUsedIp, ip) {
+		return
+	}
+
+	err := c.m.UpdateServiceAccountUsed(account.Id, ip)
+	if err != nil {
+		c.logger.Warnf(ctx, "update service account %v used failed: %v", account.Id, err)
+	}
+}
+
+// verifyServiceCaller accepts only the keys of service accounts which have scope
+func (c *Controller) verifyServiceCaller(ctx context.Context, token, scope string) (*AuthInfo, error) {
+	if !isServiceAccountKey(token) {
+		return nil, errBadAPIKey
+	}
+
+	authInfo, err := c.verifyServiceAccountKey(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range authInfo.Scopes {
+		if s == scope {
+			return authInfo, nil
+		}
+	}
+
+	return nil, errNoScope
+}
+
+// verifyAdmin checks token and csrfToken belong to a user with privileges
+func (c *Controller) verifyAdmin(ctx context.Context, token, csrfToken string) (status userpb.UserStatus,
+	authInfo *AuthInfo, err error) {
+	status, authInfo, err = c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	adminUserInfo, err := c.m.GetUserInfo(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "admin user by id %v failed: %v", authInfo.UserID, err)
+
+		status = userpb.UserStatus_USER_STATUS_USER_NOT_EXISTS
+
+		return
+	}
+
+	if adminUserInfo.Privileges == 0 {
+		c.logger.Warnf(ctx, "user %v no permission", authInfo.UserID)
+
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+func fixScopes(scopes []string) (fixed []string, ok bool) {
+	seen := make(map[string]bool)
+
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || len(scope) > maxScopeLen || strings.ContainsAny(scope, " \t\r\n") {
+			return nil, false
+		}
+
+		if !seen[scope] {
+			seen[scope] = true
+
+			fixed = append(fixed, scope)
+		}
+	}
+
+	return fixed, true
+}
+
+func (c *Controller) ListServiceAccounts(ctx context.Context, token, csrfToken string) (status userpb.UserStatus,
+	accounts []*userpb.ServiceAccount, err error) {
+	status, _, err = c.verifyAdmin(ctx, token, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	dbAccounts, err := c.m.GetServiceAccounts()
+	if err != nil {
+		c.logger.Errorf(ctx, "get service accounts failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	for _, account := range dbAccounts {
+		accounts = append(accounts, serviceAccount2Pb(account))
+	}
+
+	return
+}
+
+// CreateServiceAccount returns the api key of the new account, it can't be got again
+func (c *Controller) CreateServiceAccount(ctx context.Context, token, csrfToken, name string, ownerUserID int64,
+	scopes []string, expireAt int64) (status userpb.UserStatus, account *userpb.ServiceAccount, apiKey string, err error) {
+	status, authInfo, err := c.verifyAdmin(ctx, token, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	scopes, ok := fixScopes(scopes)
+	if name == "" || len(name) > 64 || !ok || (expireAt > 0 && expireAt <= time.Now().Unix()) {
+		c.logger.Errorf(ctx, "invalid input: %v, %v, %v", name, scopes, expireAt)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	if ownerUserID == 0 {
+		ownerUserID = authInfo.UserID
+	}
+
+	_, err = c.m.GetUserInfo(ownerUserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "owner %v of service account failed: %v", ownerUserID, err)
+
+		status = userpb.UserStatus_USER_STATUS_USER_NOT_EXISTS
+
+		return
+	}
+
+	exists, err := c.m.ServiceAccountNameExists(name)
+	if err != nil {
+		c.logger.Errorf(ctx, "check service account %v failed: %v", name, err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if exists {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	apiKey, keyID, keyHash, err := newAPIKey(apiKeyKindServiceAccount)
+	if err != nil {
+		c.logger.Errorf(ctx, "new api key failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	dbAccount := &model.ServiceAccount{
+		Name:        name,
+		OwnerUserId: ownerUserID,
+		Scopes:      strings.Join(scopes, " "),
+		KeyId:       keyID,
+		KeyHash:     keyHash,
+		CreateAt:    time.Now(),
+	}
+
+	if expireAt > 0 {
+		t := time.Unix(expireAt, 0)
+		dbAccount.ExpireAt = &t
+	}
+
+	err = c.m.AddServiceAccount(dbAccount)
+	if err != nil {
+		c.logger.Errorf(ctx, "add service account %v failed: %v", name, err)
+
+		apiKey = ""
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	c.logger.Infof(ctx, "service account %v(%v) created by %v", name, dbAccount.Id, authInfo.UserID)
+
+	account = serviceAccount2Pb(dbAccount)
+
+	return
+}
+
+// RotateServiceAccountKey replaces the api key of an account, the old one stops working at once
+func (c *Controller) RotateServiceAccountKey(ctx context.Context, token, csrfToken string, id int64) (
+	status userpb.UserStatus, apiKey string, err error) {
+	status, authInfo, err := c.verifyAdmin(ctx, token, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	apiKey, keyID, keyHash, err := newAPIKey(apiKeyKindServiceAccount)
+	if err != nil {
+		c.logger.Errorf(ctx, "new api key failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	ok, err := c.m.RotateServiceAccountKey(id, keyID, keyHash)
+	if err != nil {
+		c.logger.Errorf(ctx, "rotate service account %v failed: %v", id, err)
+
+		apiKey = ""
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if !ok {
+		apiKey = ""
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	c.logger.Infof(ctx, "key of service account %v rotated by %v", id, authInfo.UserID)
+
+	return
+}
+
+func (c *Controller) RevokeServiceAccount(ctx context.Context, token, csrfToken string, id int64) (
+	status userpb.UserStatus, err error) {
+	status, authInfo, err := c.verifyAdmin(ctx, token, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	ok, err := c.m.RevokeServiceAccount(id)
+	if err != nil {
+		c.logger.Errorf(ctx, "revoke service account %v failed: %v", id, err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if !ok {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	c.logger.Infof(ctx, "service account %v revoked by %v", id, authInfo.UserID)
+
+	return
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/model"
+)
+
+// newTestAdmin is a user with privileges and a session of it
+func newTestAdmin(t *testing.T, c *Controller) (int64, *SignedTokens) {
+	t.Helper()
+
+	userID := newTestUser(t, c, "admin@web.com", "password1")
+
+	err := c.m.SetUserPrivileges(userID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, tokens := newTestSession(t, c, userID, "")
+
+	return userID, tokens
+}
+
+func TestServiceAccount(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	adminUserID, tokens := newTestAdmin(t, c)
+	csrfToken := func() string {
+		return newTestCsrfToken(t, c, tokens.AccessToken)
+	}
+
+	status, account, apiKey, err := c.CreateServiceAccount(ctx, tokens.AccessToken, csrfToken(), "ci", 0,
+		[]string{ScopeTokenIntrospect, ScopeTokenIntrospect}, 0)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || !isServiceAccountKey(apiKey) ||
+		account.OwnerUserId != adminUserID || !reflect.DeepEqual(account.Scopes, []string{ScopeTokenIntrospect}) {
+		t.Fatalf("create service account: %v, %+v, %v", status, account, err)
+	}
+
+	authInfo, err := c.verifyToken(ctx, apiKey)
+	if err != nil || authInfo.ServiceAccountID != account.Id || authInfo.UserID != 0 || authInfo.ExpiresAt != 0 {
+		t.Fatalf("verify service account key: %+v, %v", authInfo, err)
+	}
+
+	cases := []struct {
+		name        string
+		ownerUserID int64
+		scopes      []string
+		expireAt    int64
+		status      userpb.UserStatus
+	}{
+		{"", 0, nil, 0, userpb.UserStatus_USER_STATUS_BAD_INPUT},
+		{"ci", 0, nil, 0, userpb.UserStatus_USER_STATUS_BAD_INPUT},
+		{"bad scope", 0, []string{""}, 0, userpb.UserStatus_USER_STATUS_BAD_INPUT},
+		{"expired", 0, nil, time.Now().Add(-time.Minute).Unix(), userpb.UserStatus_USER_STATUS_BAD_INPUT},
+		{"no owner", adminUserID + 100, nil, 0, userpb.UserStatus_USER_STATUS_USER_NOT_EXISTS},
+	}
+
+	for _, tc := range cases {
+		status, _, key, _ := c.CreateServiceAccount(ctx, tokens.AccessToken, csrfToken(), tc.name, tc.ownerUserID,
+			tc.scopes, tc.expireAt)
+		if status != tc.status || key != "" {
+			t.Fatalf("create %q: %v, want %v", tc.name, status, tc.status)
+		}
+	}
+
+	status, rotatedKey, err := c.RotateServiceAccountKey(ctx, tokens.AccessToken, csrfToken(), account.Id)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || rotatedKey == apiKey {
+		t.Fatalf("rotate failed: %v, %v", status, err)
+	}
+
+	if _, err = c.verifyToken(ctx, apiKey); err == nil {
+		t.Fatal("key rotated out verified")
+	}
+
+	if authInfo, err = c.verifyToken(ctx, rotatedKey); err != nil || authInfo.ServiceAccountID != account.Id {
+		t.Fatalf("verify rotated key: %+v, %v", authInfo, err)
+	}
+
+	status, _, _ = c.RotateServiceAccountKey(ctx, tokens.AccessToken, csrfToken(), account.Id+100)
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("rotate no account: %v", status)
+	}
+
+	status, err = c.RevokeServiceAccount(ctx, tokens.AccessToken, csrfToken(), account.Id)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("revoke failed: %v, %v", status, err)
+	}
+
+	if _, err = c.verifyServiceAccountKey(ctx, rotatedKey); err != errServiceAccountRevoked {
+		t.Fatalf("revoked service account verified: %v", err)
+	}
+
+	status, _, _ = c.RotateServiceAccountKey(ctx, tokens.AccessToken, csrfToken(), account.Id)
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("rotate revoked account: %v", status)
+	}
+
+	status, _ = c.RevokeServiceAccount(ctx, tokens.AccessToken, csrfToken(), account.Id)
+	if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+		t.Fatalf("revoke twice: %v", status)
+	}
+}
+
+func TestServiceAccount_Expired(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	apiKey, keyID, keyHash, err := newAPIKey(apiKeyKindServiceAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expireAt := time.Now().Add(-time.Second)
+
+	err = c.m.AddServiceAccount(&model.ServiceAccount{
+		Name:     "ci",
+		KeyId:    keyID,
+		KeyHash:  keyHash,
+		ExpireAt: &expireAt,
+		CreateAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.verifyServiceAccountKey(ctx, apiKey); err != errServiceAccountExpired {
+		t.Fatalf("expired service account verified: %v", err)
+	}
+
+	for _, badKey := range []string{apiKey + "0", "sa_" + keyID + "_0", "pat" + apiKey[2:]} {
+		if _, err = c.verifyServiceAccountKey(ctx, badKey); err != errBadAPIKey {
+			t.Fatalf("bad key %v verified: %v", badKey, err)
+		}
+	}
+}
+
+func TestServiceAccount_NotAdmin(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	_, tokens := newTestSession(t, c, userID, "")
+
+	status, _, apiKey, _ := c.CreateServiceAccount(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		"ci", 0, nil, 0)
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT || apiKey != "" {
+		t.Fatalf("create by user without privileges: %v", status)
+	}
+
+	status, _, _ = c.ListServiceAccounts(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken))
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("list by user without privileges: %v", status)
+	}
+
+	_, adminTokens := newTestAdmin(t, c)
+
+	status, _, _, _ = c.CreateServiceAccount(ctx, adminTokens.AccessToken, "", "ci", 0, nil, 0)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("create without csrf token: %v", status)
+	}
+
+	// the key of a service account isn't an admin either
+	adminKey := newTestServiceAccount(t, c, "admin", userID, ScopeTokenIntrospect)
+
+	status, _, _ = c.verifyAdmin(ctx, adminKey, newTestCsrfToken(t, c, adminTokens.AccessToken))
+	if status != userpb.UserStatus_USER_STATUS_DONT_SUPPORT {
+		t.Fatalf("service account key as admin: %v", status)
+	}
+
+	status, accounts, err := c.ListServiceAccounts(ctx, adminTokens.AccessToken,
+		newTestCsrfToken(t, c, adminTokens.AccessToken))
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(accounts) != 1 || accounts[0].Name != "admin" {
+		t.Fatalf("list service accounts: %v, %+v, %v", status, accounts, err)
+	}
+}
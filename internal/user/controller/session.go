@@ -0,0 +1,386 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/utils"
+)
+
+// SessionMeta is the entry of a session in the per-user session index
+type SessionMeta struct {
+	SessionID       string
+	ParentSessionID string
+	ClientIP        string
+	UserAgent       string
+	CreateAt        int64
+}
+
+// indexSession adds the session which generateToken just created into the index of its user
+func (c *Controller) indexSession(ctx context.Context, u *AuthInfo) {
+	meta := &SessionMeta{
+		SessionID:       u.SessionID,
+		ParentSessionID: u.ParentSessionID,
+		ClientIP:        u.ClientIP,
+		UserAgent:       c.utils.GetUserAgent(ctx),
+		CreateAt:        time.Now().Unix(),
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		c.logger.Errorf(ctx, "marshal session meta failed: %v", err)
+
+		return
+	}
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisKeyForUserSessions(u.UserID), meta.SessionID, string(data))
+			pipe.Expire(ctx, redisKeyForUserSessions(u.UserID), c.cfg.Token.MaxLifetime)
+			pipe.HSet(ctx, redisKeyForUserSessionsLastUsed(u.UserID), meta.SessionID, meta.CreateAt)
+			pipe.Expire(ctx, redisKeyForUserSessionsLastUsed(u.UserID), c.cfg.Token.MaxLifetime)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "index session %v of %v failed: %v", meta.SessionID, u.UserID, err)
+	}
+}
+
+// touchSession records the use of session u and slides its idle timeout, never over its absolute end
+func (c *Controller) touchSession(ctx context.Context, u *AuthInfo) {
+	idleTimeout := c.sessionTTL(u, c.cfg.Token.IdleTimeout)
+	if idleTimeout <= 0 {
+		return
+	}
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Expire(ctx, redisKeyForSession(u.UserID, u.SessionID), idleTimeout)
+			pipe.Expire(ctx, redisKeyForRefreshToken(u.UserID, u.SessionID), idleTimeout)
+			pipe.HSet(ctx, redisKeyForUserSessionsLastUsed(u.UserID), u.SessionID, time.Now().Unix())
+			pipe.Expire(ctx, redisKeyForUserSessionsLastUsed(u.UserID), c.cfg.Token.MaxLifetime)
+			pipe.Expire(ctx, redisKeyForUserSessions(u.UserID), c.cfg.Token.MaxLifetime)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		c.logger.Warnf(ctx, "touch session %v of %v failed: %v", u.SessionID, u.UserID, err)
+	}
+}
+
+// sessionExpiry tells when session u ends if it's not used anymore, and when it ends anyway
+func (c *Controller) sessionExpiry(ctx context.Context, u *AuthInfo) *userpb.SessionExpiry {
+	expiry := &userpb.SessionExpiry{
+		ExpiresAt: u.ExpiresAt,
+	}
+
+	if !u.IsSession() {
+		return expiry
+	}
+
+	var ttl time.Duration
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		ttl, err = c.redis.PTTL(ctx, redisKeyForSession(u.UserID, u.SessionID)).Result()
+	})
+
+	if err != nil {
+		c.logger.Warnf(ctx, "get ttl of session %v failed: %v", u.SessionID, err)
+	} else if ttl > 0 {
+		expiry.IdleExpiresAt = time.Now().Add(ttl).Unix()
+	}
+
+	return expiry
+}
+
+func (c *Controller) unindexSessions(ctx context.Context, userID int64, sessionIDs ...string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, redisKeyForUserSessions(userID), sessionIDs...)
+			pipe.HDel(ctx, redisKeyForUserSessionsLastUsed(userID), sessionIDs...)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "unindex sessions of %v failed: %v", userID, err)
+	}
+}
+
+// listSessions returns the alive sessions of user, newest first. Expired ones are dropped from the index
+func (c *Controller) listSessions(ctx context.Context, userID int64, currentSessionID string) (
+	sessions []*userpb.SessionInfo, err error) {
+	var metas, lastUsed map[string]string
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		metas, err = c.redis.HGetAll(ctx, redisKeyForUserSessions(userID)).Result()
+		if err != nil {
+			return
+		}
+
+		lastUsed, err = c.redis.HGetAll(ctx, redisKeyForUserSessionsLastUsed(userID)).Result()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "get sessions of %v failed: %v", userID, err)
+
+		return
+	}
+
+	var staleSessionIDs []string
+
+	for sessionID, data := range metas {
+		var exists int64
+
+		utils.DefRedisTimeoutOp(func(ctx context.Context) {
+			exists, err = c.redis.Exists(ctx, redisKeyForSession(userID, sessionID)).Result()
+		})
+
+		if err != nil {
+			c.logger.Errorf(ctx, "check session %v failed: %v", sessionID, err)
+
+			return
+		}
+
+		var meta SessionMeta
+
+		if exists == 0 || json.Unmarshal([]byte(data), &meta) != nil {
+			staleSessionIDs = append(staleSessionIDs, sessionID)
+
+			continue
+		}
+
+		lastUsedAt, _ := strconv.ParseInt(lastUsed[sessionID], 10, 64)
+
+		sessions = append(sessions, &userpb.SessionInfo{
+			SessionId:       meta.SessionID,
+			ParentSessionId: meta.ParentSessionID,
+			ClientIp:        meta.ClientIP,
+			UserAgent:       meta.UserAgent,
+			CreateAt:        meta.CreateAt,
+			LastUsedAt:      lastUsedAt,
+			Current:         meta.SessionID == currentSessionID,
+		})
+	}
+
+	c.unindexSessions(ctx, userID, staleSessionIDs...)
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreateAt > sessions[j].CreateAt
+	})
+
+	return
+}
+
+// removeSession kills session and the sessions signed on by it
+func (c *Controller) removeSession(ctx context.Context, userID int64, sessionID string) {
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		c.redis.Del(ctx, redisKeyForSession(userID, sessionID), redisKeyForRefreshToken(userID, sessionID))
+	})
+
+	var children []string
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		children, err = c.redis.SMembers(ctx, redisKeyForSessionIDParent(sessionID)).Result()
+	})
+
+	if err != nil && !errors.Is(err, redis.Nil) {
+		c.logger.Errorf(ctx, "redis smembers %v failed: %v", sessionID, err)
+	}
+
+	for _, childSessionID := range children {
+		utils.DefRedisTimeoutOp(func(ctx context.Context) {
+			err = c.redis.Del(ctx, redisKeyForSession(userID, childSessionID),
+				redisKeyForRefreshToken(userID, childSessionID)).Err()
+		})
+
+		if err != nil {
+			c.logger.Errorf(ctx, "redis del %v failed: %v", redisKeyForSession(userID, childSessionID), err)
+		}
+	}
+
+	if len(children) > 0 {
+		utils.DefRedisTimeoutOp(func(ctx context.Context) {
+			c.redis.Del(ctx, redisKeyForSessionIDParent(sessionID))
+		})
+	}
+
+	c.unindexSessions(ctx, userID, append(children, sessionID)...)
+	c.revokeAccessTokens(ctx, append(children, sessionID)...)
+}
+
+// removeAllSessions kills all indexed sessions of user except exceptSessionID and its children. Sessions
+// created before the index existed are covered once they were used
+func (c *Controller) removeAllSessions(ctx context.Context, userID int64, exceptSessionID string) (removed int, err error) {
+	var metas, lastUsed map[string]string
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		metas, err = c.redis.HGetAll(ctx, redisKeyForUserSessions(userID)).Result()
+		if err != nil {
+			return
+		}
+
+		lastUsed, err = c.redis.HGetAll(ctx, redisKeyForUserSessionsLastUsed(userID)).Result()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "get sessions of %v failed: %v", userID, err)
+
+		return
+	}
+
+	for sessionID := range lastUsed {
+		if _, ok := metas[sessionID]; !ok {
+			metas[sessionID] = ""
+		}
+	}
+
+	for sessionID, data := range metas {
+		if sessionID == exceptSessionID {
+			continue
+		}
+
+		var meta SessionMeta
+
+		if data != "" && json.Unmarshal([]byte(data), &meta) == nil && meta.ParentSessionID != "" &&
+			meta.ParentSessionID == exceptSessionID {
+			continue
+		}
+
+		c.removeSession(ctx, userID, sessionID)
+
+		removed++
+	}
+
+	c.logger.Infof(ctx, "%v sessions of %v removed", removed, userID)
+
+	return
+}
+
+// revokeSessionsOnCredentialChange kills the sessions of user after its password was changed or reset,
+// or its 2fa was disabled
+func (c *Controller) revokeSessionsOnCredentialChange(ctx context.Context, userID int64, currentSessionID string) {
+	if !c.cfg.SessionRevocation.Enable {
+		return
+	}
+
+	if !c.cfg.SessionRevocation.KeepCurrentSession {
+		currentSessionID = ""
+	}
+
+	_, _ = c.removeAllSessions(ctx, userID, currentSessionID)
+}
+
+func (c *Controller) ListSessions(ctx context.Context, token string) (status userpb.UserStatus,
+	sessions []*userpb.SessionInfo, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	sessions, err = c.listSessions(ctx, authInfo.UserID, authInfo.SessionID)
+	if err != nil {
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+func (c *Controller) RevokeSession(ctx context.Context, token, csrfToken, sessionID string) (
+	status userpb.UserStatus, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	status = c.revokeUserSession(ctx, authInfo.UserID, sessionID)
+
+	return
+}
+
+func (c *Controller) RevokeOtherSessions(ctx context.Context, token, csrfToken string) (
+	status userpb.UserStatus, revoked int32, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	removed, err := c.removeAllSessions(ctx, authInfo.UserID, authInfo.SessionID)
+	if err != nil {
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	revoked = int32(removed)
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// revokeUserSession removes sessionID only if it's in the index of userID
+func (c *Controller) revokeUserSession(ctx context.Context, userID int64, sessionID string) userpb.UserStatus {
+	var exists bool
+
+	var err error
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		exists, err = c.redis.HExists(ctx, redisKeyForUserSessions(userID), sessionID).Result()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "check session %v of %v failed: %v", sessionID, userID, err)
+
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+	}
+
+	if !exists {
+		return userpb.UserStatus_USER_STATUS_BAD_INPUT
+	}
+
+	c.removeSession(ctx, userID, sessionID)
+
+	return userpb.UserStatus_USER_STATUS_SUCCESS
+}
@@ -0,0 +1,333 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+// indexedSessions returns the sessions in both indexes of userID, which must be the same
+func indexedSessions(t *testing.T, mr *miniredis.Miniredis, userID int64) []string {
+	t.Helper()
+
+	sessionIDs, _ := mr.HKeys(redisKeyForUserSessions(userID))
+	lastUsedSessionIDs, _ := mr.HKeys(redisKeyForUserSessionsLastUsed(userID))
+
+	sort.Strings(sessionIDs)
+	sort.Strings(lastUsedSessionIDs)
+
+	if !reflect.DeepEqual(sessionIDs, lastUsedSessionIDs) {
+		t.Fatalf("sessions %v, last used of %v", sessionIDs, lastUsedSessionIDs)
+	}
+
+	return sessionIDs
+}
+
+func sortedStrings(ss ...string) []string {
+	sort.Strings(ss)
+
+	return ss
+}
+
+func TestListSessions(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	sessionID, tokens := newTestSession(t, c, userID, "")
+	otherSessionID, _ := newTestSession(t, c, userID, "")
+	childSessionID, _ := newTestSession(t, c, userID, sessionID)
+	staleSessionID, _ := newTestSession(t, c, userID, "")
+
+	otherUserID := newTestUser(t, c, "other@web.com", "password1")
+	newTestSession(t, c, otherUserID, "")
+
+	status, sessions, err := c.ListSessions(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(sessions) != 4 {
+		t.Fatalf("list sessions: %v, %+v, %v", status, sessions, err)
+	}
+
+	for _, session := range sessions {
+		if session.Current != (session.SessionId == sessionID) || session.ClientIp != testClientIP ||
+			session.CreateAt == 0 || session.LastUsedAt == 0 {
+			t.Fatalf("unexpected session %+v", session)
+		}
+
+		if (session.ParentSessionId == sessionID) != (session.SessionId == childSessionID) {
+			t.Fatalf("parent of session %+v", session)
+		}
+	}
+
+	// an expired session is dropped from the indexes
+	mr.Del(redisKeyForSession(userID, staleSessionID))
+
+	status, sessions, _ = c.ListSessions(ctx, tokens.AccessToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || len(sessions) != 3 {
+		t.Fatalf("list sessions with expired one: %v, %+v", status, sessions)
+	}
+
+	want := sortedStrings(sessionID, otherSessionID, childSessionID)
+	if got := indexedSessions(t, mr, userID); !reflect.DeepEqual(got, want) {
+		t.Fatalf("indexed sessions %v, want %v", got, want)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	sessionID, tokens := newTestSession(t, c, userID, "")
+	otherSessionID, otherTokens := newTestSession(t, c, userID, "")
+	childSessionID, childTokens := newTestSession(t, c, userID, otherSessionID)
+
+	otherUserID := newTestUser(t, c, "other@web.com", "password1")
+	otherUserSessionID, _ := newTestSession(t, c, otherUserID, "")
+
+	status, _ := c.RevokeSession(ctx, tokens.AccessToken, "", otherSessionID)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("revoke without csrf token: %v", status)
+	}
+
+	for _, badSessionID := range []string{otherUserSessionID, "no-session"} {
+		status, _ = c.RevokeSession(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken), badSessionID)
+		if status != userpb.UserStatus_USER_STATUS_BAD_INPUT {
+			t.Fatalf("revoke session %v: %v", badSessionID, status)
+		}
+	}
+
+	if got := indexedSessions(t, mr, otherUserID); !reflect.DeepEqual(got, []string{otherUserSessionID}) {
+		t.Fatalf("sessions of other user %v", got)
+	}
+
+	// the sessions signed on by the revoked one go with it
+	status, err := c.RevokeSession(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken), otherSessionID)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("revoke failed: %v, %v", status, err)
+	}
+
+	for _, revokedTokens := range []*SignedTokens{otherTokens, childTokens} {
+		if _, err = c.verifyToken(ctx, revokedTokens.AccessToken); err == nil {
+			t.Fatal("revoked session verified")
+		}
+	}
+
+	for _, key := range []string{redisKeyForSession(userID, otherSessionID), redisKeyForRefreshToken(userID, otherSessionID),
+		redisKeyForSession(userID, childSessionID), redisKeyForSessionIDParent(otherSessionID)} {
+		if mr.Exists(key) {
+			t.Fatalf("%v kept", key)
+		}
+	}
+
+	if got := indexedSessions(t, mr, userID); !reflect.DeepEqual(got, []string{sessionID}) {
+		t.Fatalf("indexed sessions %v, want %v", got, sessionID)
+	}
+
+	if _, err = c.verifyToken(ctx, tokens.AccessToken); err != nil {
+		t.Fatalf("current session revoked: %v", err)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	sessionID, tokens := newTestSession(t, c, userID, "")
+	childSessionID, childTokens := newTestSession(t, c, userID, sessionID)
+	_, otherTokens := newTestSession(t, c, userID, "")
+	unindexedSessionID, unindexedTokens := newTestSession(t, c, userID, "")
+
+	// a session used but missing in the index of metas is revoked too
+	mr.HDel(redisKeyForUserSessions(userID), unindexedSessionID)
+
+	status, revoked, err := c.RevokeOtherSessions(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken))
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS || revoked != 2 {
+		t.Fatalf("revoke other sessions: %v, %v, %v", status, revoked, err)
+	}
+
+	for _, revokedTokens := range []*SignedTokens{otherTokens, unindexedTokens} {
+		if _, err = c.verifyToken(ctx, revokedTokens.AccessToken); err == nil {
+			t.Fatal("other session verified")
+		}
+	}
+
+	for _, keptTokens := range []*SignedTokens{tokens, childTokens} {
+		if _, err = c.verifyToken(ctx, keptTokens.AccessToken); err != nil {
+			t.Fatalf("current session revoked: %v", err)
+		}
+	}
+
+	want := sortedStrings(sessionID, childSessionID)
+	if got := indexedSessions(t, mr, userID); !reflect.DeepEqual(got, want) {
+		t.Fatalf("indexed sessions %v, want %v", got, want)
+	}
+
+	removed, err := c.removeAllSessions(ctx, userID, "")
+	if err != nil || removed != 2 {
+		t.Fatalf("remove all sessions: %v, %v", removed, err)
+	}
+
+	if _, err = c.verifyToken(ctx, tokens.AccessToken); err == nil {
+		t.Fatal("session kept by remove all")
+	}
+
+	if got := indexedSessions(t, mr, userID); len(got) != 0 {
+		t.Fatalf("indexed sessions %v kept", got)
+	}
+}
+
+// newTestCredentialSessions signs userID in as the caller, a session signed on by it and another session
+func newTestCredentialSessions(t *testing.T, c *Controller, userID int64) (tokens, childTokens,
+	otherTokens *SignedTokens) {
+	t.Helper()
+
+	c.cfg.SessionRevocation.Enable = true
+	c.cfg.SessionRevocation.KeepCurrentSession = true
+
+	sessionID, tokens := newTestSession(t, c, userID, "")
+	_, childTokens = newTestSession(t, c, userID, sessionID)
+	_, otherTokens = newTestSession(t, c, userID, "")
+
+	return
+}
+
+// checkTestSessions fails unless the sessions of kept verify and the ones of revoked don't
+func checkTestSessions(t *testing.T, c *Controller, kept, revoked []*SignedTokens) {
+	t.Helper()
+
+	for _, tokens := range kept {
+		if _, err := c.verifyToken(context.Background(), tokens.AccessToken); err != nil {
+			t.Fatalf("session revoked: %v", err)
+		}
+	}
+
+	for _, tokens := range revoked {
+		if _, err := c.verifyToken(context.Background(), tokens.AccessToken); err == nil {
+			t.Fatal("session kept")
+		}
+	}
+}
+
+func TestRevokeSessionsOnCredentialChange_ChangePassword(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	tokens, childTokens, otherTokens := newTestCredentialSessions(t, c, userID)
+
+	status, newTokens, _, err := c.ChangePassword(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		"password1", "password2")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("change password failed: %v, %v", status, err)
+	}
+
+	checkTestSessions(t, c, []*SignedTokens{tokens, childTokens, newTokens}, []*SignedTokens{otherTokens})
+
+	// the caller goes too unless it's kept
+	c.cfg.SessionRevocation.KeepCurrentSession = false
+
+	status, _, _, err = c.ChangePassword(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		"password2", "password3")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("change password failed: %v, %v", status, err)
+	}
+
+	checkTestSessions(t, c, nil, []*SignedTokens{tokens, childTokens, newTokens})
+
+	// and none goes if it's disabled
+	c.cfg.SessionRevocation.Enable = false
+	_, tokens = newTestSession(t, c, userID, "")
+	_, otherTokens = newTestSession(t, c, userID, "")
+
+	status, _, _, err = c.ChangePassword(ctx, tokens.AccessToken, newTestCsrfToken(t, c, tokens.AccessToken),
+		"password3", "password4")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("change password failed: %v, %v", status, err)
+	}
+
+	checkTestSessions(t, c, []*SignedTokens{tokens, otherTokens}, nil)
+}
+
+func TestRevokeSessionsOnCredentialChange_ResetPassword(t *testing.T) {
+	c, _, postClient := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	tokens, childTokens, otherTokens := newTestCredentialSessions(t, c, userID)
+
+	mailUser := &userpb.UserId{
+		UserName: "abc@web.com",
+		UserVe:   userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String(),
+	}
+
+	status, err := c.TriggerAuth(ctx, mailUser, userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_RESET_PASSWORD)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("trigger auth failed: %v, %v", status, err)
+	}
+
+	status, newTokens, _, err := c.ResetPassword(ctx, mailUser, "password2", postClient.code(mailUser.UserName), "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("reset password failed: %v, %v", status, err)
+	}
+
+	// the caller isn't signed in, so no session is kept
+	checkTestSessions(t, c, []*SignedTokens{newTokens}, []*SignedTokens{tokens, childTokens, otherTokens})
+}
+
+func TestRevokeSessionsOnCredentialChange_AdminResetPassword(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	adminUserID := newTestUser(t, c, "admin@web.com", "password1")
+	adminTokens, adminChildTokens, adminOtherTokens := newTestCredentialSessions(t, c, adminUserID)
+
+	adminAuthInfo, err := c.verifyToken(ctx, adminTokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	tokens, childTokens, otherTokens := newTestCredentialSessions(t, c, userID)
+
+	status, err := c.adminResetPassword(ctx, userID, "password2", adminAuthInfo.SessionID)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("admin reset password failed: %v, %v", status, err)
+	}
+
+	checkTestSessions(t, c, []*SignedTokens{adminTokens, adminChildTokens, adminOtherTokens},
+		[]*SignedTokens{tokens, childTokens, otherTokens})
+
+	// an admin resetting its own password is the caller
+	status, err = c.adminResetPassword(ctx, adminUserID, "password2", adminAuthInfo.SessionID)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("admin reset own password failed: %v, %v", status, err)
+	}
+
+	checkTestSessions(t, c, []*SignedTokens{adminTokens, adminChildTokens}, []*SignedTokens{adminOtherTokens})
+}
+
+func TestRevokeSessionsOnCredentialChange_Disable2FA(t *testing.T) {
+	c, _, _ := newTestController(t)
+	ctx := context.Background()
+
+	userID := newTestUser(t, c, "abc@web.com", "password1")
+	gaCode := enableTestGA(t, c, userID)
+	tokens, childTokens, otherTokens := newTestCredentialSessions(t, c, userID)
+
+	status, gaToken, err := c.GoogleAuthVerify(ctx, tokens.AccessToken, gaCode())
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("2fa verify failed: %v, %v", status, err)
+	}
+
+	status, _, err = c.GoogleAuthSet(ctx, tokens.AccessToken, "", gaToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("disable 2fa failed: %v, %v", status, err)
+	}
+
+	checkTestSessions(t, c, []*SignedTokens{tokens, childTokens}, []*SignedTokens{otherTokens})
+}
@@ -8,7 +8,7 @@ import (
 )
 
 func (c *Controller) signResponseInfoAfterCheckPass(ctx context.Context, userID int64, userInfo *user.UserInfo,
-	incTrustNum int) (status userpb.UserStatus, token string, info *userpb.UserInfo,
+	incTrustNum int) (status userpb.UserStatus, token *SignedTokens, info *userpb.UserInfo,
 	err error) {
 	status, _, token, info, err = c.signResponseInfoAfterCheckPassEx(ctx, userID, userInfo, incTrustNum,
 		false, "")
@@ -17,8 +17,8 @@ func (c *Controller) signResponseInfoAfterCheckPass(ctx context.Context, userID
 }
 
 func (c *Controller) signResponseInfoAfterCheckPassEx(ctx context.Context, userID int64, userInfo *user.UserInfo,
-	incTrustNum int, attachSsoToken bool, ssoJumpURL string) (status userpb.UserStatus, ssoToken, token string,
-	info *userpb.UserInfo, err error) {
+	incTrustNum int, attachSsoToken bool, ssoJumpURL string) (status userpb.UserStatus, ssoToken string,
+	token *SignedTokens, info *userpb.UserInfo, err error) {
 	if userInfo == nil {
 		userInfo, err = c.m.GetUserInfo(userID)
 		if err != nil {
@@ -54,7 +54,7 @@ func (c *Controller) signResponseInfoAfterCheckPassEx(ctx context.Context, userI
 }
 
 func (c *Controller) signResponseInfoOnAuthInfo(ctx context.Context, auth *AuthInfo,
-	attachSsoToken bool, ssoJumpURL string) (ssoToken, token string, info *userpb.UserInfo, err error) {
+	attachSsoToken bool, ssoJumpURL string) (ssoToken string, token *SignedTokens, info *userpb.UserInfo, err error) {
 	var sessionID string
 
 	sessionID, token, err = c.generateToken(ctx, auth)
@@ -73,7 +73,7 @@ func (c *Controller) signResponseInfoOnAuthInfo(ctx context.Context, auth *AuthI
 		}
 	}
 
-	err = c.httpToken.SetUserTokenCookie(ctx, token)
+	err = c.httpToken.SetUserTokenCookie(ctx, token.AccessToken, token.RefreshToken)
 	if err != nil {
 		c.logger.Errorf(ctx, "setUserTokenCookie failed: %v", err)
 
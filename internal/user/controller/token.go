@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
 	"net/url"
 	"strings"
 	"time"
@@ -21,31 +20,53 @@ import (
 
 // AuthInfo class on token
 type AuthInfo struct {
-	UserSourceIDFlag bool
-	UserID           int64
-	NickName         string
-	Avatar           string
-	ExpiresAt        int64
-	ClientIP         string
-	CreateAt         int64
-	ParentSessionID  string
-	SessionID        string
+	UserSourceIDFlag      bool
+	UserID                int64
+	NickName              string
+	Avatar                string
+	ExpiresAt             int64
+	ClientIP              string
+	ClientType            string
+	CreateAt              int64
+	ParentSessionID       string
+	SessionID             string
+	ServiceAccountID      int64    `json:",omitempty"`
+	PersonalAccessTokenID int64    `json:",omitempty"`
+	Scopes                []string `json:",omitempty"`
+	OIDCClientID          string   `json:",omitempty"`
+	AuthTime              int64    `json:",omitempty"`
 
 	ExpiresAtString string
 	CreateAtString  string
 }
 
+// Valid tells if the session is not expired
 func (ai *AuthInfo) Valid() error {
+	if time.Now().Unix() > ai.ExpiresAt {
+		return fmt.Errorf("%w: %v, now is %v", errSessionExpired, time.Unix(ai.ExpiresAt, 0), time.Now())
+	}
+
 	return nil
 }
 
-type TokenClaims struct {
-	UserID    int64
-	SessionID string
+// IsSession tells if the token is one of a session the user signed in, but not an api key or a token
+// issued to an oidc client
+func (ai *AuthInfo) IsSession() bool {
+	return ai.ServiceAccountID == 0 && ai.PersonalAccessTokenID == 0 && ai.OIDCClientID == ""
 }
 
-func (tc *TokenClaims) Valid() error {
-	return nil
+var (
+	errTokenKind      = errors.New("unexpected token kind")
+	errSessionRevoked = errors.New("session revoked")
+	errSessionExpired = errors.New("session expired")
+)
+
+// SignedTokens is the result of a sign in, AccessToken authenticates the other calls and RefreshToken
+// gets a new pair by RefreshToken
+type SignedTokens struct {
+	AccessToken     string
+	RefreshToken    string
+	AccessExpiresAt int64
 }
 
 func (c *Controller) checkSSOJumpURL(ctx context.Context, ssoJumpURL string) (valid bool, err error) {
@@ -96,13 +117,18 @@ func (c *Controller) newSSOToken(ctx context.Context, parentSessionID string, u
 	}
 
 	sessionID := uuid.NewV4().String()
-	u.ParentSessionID = parentSessionID
 
-	return c.generateTokenEx(ctx, sessionID, redisKeyForSSOToken(u.UserID, sessionID), c.cfg.Token.Expire, u)
+	// u is the parent session, which must not be changed
+	ssoAuthInfo := *u
+	ssoAuthInfo.ParentSessionID = parentSessionID
+	ssoAuthInfo.ExpiresAt = time.Now().Add(c.cfg.Token.SSOExpire).Unix()
+
+	return c.generateTokenEx(ctx, sessionID, redisKeyForSSOToken(u.UserID, sessionID), tokenKindSSO,
+		c.cfg.Token.SSOExpire, c.cfg.Token.SSOExpire, &ssoAuthInfo)
 }
 
 func (c *Controller) verifySSOToken(ctx context.Context, tokenString string) (authInfo *AuthInfo, err error) {
-	redisKey, authInfo, err := c.verifyTokenEx(ctx, redisKeyForSSOToken, tokenString)
+	redisKey, authInfo, err := c.verifyTokenEx(ctx, redisKeyForSSOToken, tokenKindSSO, tokenString)
 	if err != nil {
 		c.logger.Errorf(ctx, "verify token failed: %v", err)
 
@@ -122,10 +148,33 @@ func (c *Controller) verifySSOToken(ctx context.Context, tokenString string) (au
 	return
 }
 
-func (c *Controller) generateToken(ctx context.Context, u *AuthInfo) (sessionID string, token string, err error) {
+func (c *Controller) generateToken(ctx context.Context, u *AuthInfo) (sessionID string, tokens *SignedTokens, err error) {
 	sessionID = uuid.NewV4().String()
+	u.ExpiresAt = time.Now().Add(c.cfg.Token.MaxLifetime).Unix()
 
-	token, err = c.generateTokenEx(ctx, sessionID, redisKeyForSession(u.UserID, sessionID), c.cfg.Token.Expire, u)
+	// sessions signed in by another one keep the time the user authenticated
+	if u.AuthTime == 0 {
+		u.AuthTime = time.Now().Unix()
+	}
+
+	accessToken, err := c.generateTokenEx(ctx, sessionID, redisKeyForSession(u.UserID, sessionID),
+		tokenKindAccess, c.cfg.Token.IdleTimeout, c.cfg.Token.AccessExpire, u)
+	if err != nil {
+		return
+	}
+
+	refreshToken, err := c.newRefreshToken(ctx, u)
+	if err != nil {
+		return
+	}
+
+	tokens = &SignedTokens{
+		AccessToken:     accessToken,
+		RefreshToken:    refreshToken,
+		AccessExpiresAt: time.Now().Add(c.sessionTTL(u, c.cfg.Token.AccessExpire)).Unix(),
+	}
+
+	c.indexSession(ctx, u)
 
 	if u.ParentSessionID != "" {
 		utils.DefRedisTimeoutOp(func(ctx context.Context) {
@@ -136,10 +185,17 @@ func (c *Controller) generateToken(ctx context.Context, u *AuthInfo) (sessionID
 	return
 }
 
-func (c *Controller) generateTokenEx(ctx context.Context, sessionID string, redisKey string, redisExpire time.Duration,
-	u *AuthInfo) (string, error) {
+// generateTokenEx stores session u until it's idle for idleTimeout or u.ExpiresAt, the absolute end of it,
+// and returns a token of kind of it which expires after tokenExpire
+func (c *Controller) generateTokenEx(ctx context.Context, sessionID, redisKey, kind string, idleTimeout,
+	tokenExpire time.Duration, u *AuthInfo) (string, error) {
+	redisExpire := c.sessionTTL(u, idleTimeout)
+	if redisExpire <= 0 {
+		return "", errSessionExpired
+	}
+
 	u.ClientIP = c.utils.GetPeerIP(ctx)
-	u.ExpiresAt = time.Now().Add(c.cfg.Token.Expire).Unix()
+	u.ClientType = c.utils.GetClientType(ctx)
 	u.SessionID = sessionID
 	u.ExpiresAtString = time.Unix(u.ExpiresAt, 0).String()
 	u.CreateAtString = time.Unix(u.CreateAt, 0).String()
@@ -161,53 +217,153 @@ func (c *Controller) generateTokenEx(ctx context.Context, sessionID string, redi
 		return "", err
 	}
 
-	tc := &TokenClaims{
-		UserID:    u.UserID,
+	tc := c.newTokenClaims(u.UserID, sessionID, kind, c.sessionTTL(u, tokenExpire))
+	bindClient(tc, u)
+
+	if c.cfg.Token.Stateless && kind == tokenKindAccess {
+		tc.Session = u
+	}
+
+	return c.signToken(tc)
+}
+
+// sessionTTL is d, or less if session u ends before it
+func (c *Controller) sessionTTL(u *AuthInfo, d time.Duration) time.Duration {
+	if remaining := time.Until(time.Unix(u.ExpiresAt, 0)); remaining < d {
+		return remaining
+	}
+
+	return d
+}
+
+func (c *Controller) newTokenClaims(userID int64, sessionID, kind string, expire time.Duration) *TokenClaims {
+	now := time.Now()
+
+	return &TokenClaims{
+		Issuer:    c.cfg.Token.Issuer,
+		Audience:  c.cfg.Token.Audiences,
+		ExpiresAt: now.Add(expire).Unix(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ID:        uuid.NewV4().String(),
+		UserID:    userID,
 		SessionID: sessionID,
+		Kind:      kind,
+	}
+}
+
+// bindClient makes tc a token of the oidc client of session u, which isn't for the first party audiences
+func bindClient(tc *TokenClaims, u *AuthInfo) {
+	if u.OIDCClientID == "" {
+		return
 	}
 
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, tc).SignedString([]byte(c.cfg.Token.Secret))
+	tc.ClientID = u.OIDCClientID
+	tc.Audience = Audience{u.OIDCClientID}
 }
 
-func (c *Controller) verifyToken(ctx context.Context, tokenString string) (authInfo *AuthInfo, err error) {
-	if ai, ok := c.whiteListTokens[tokenString]; ok {
-		return ai, nil
+// checkClient checks the client of tc is the one of its session
+func checkClient(tc *TokenClaims, u *AuthInfo) error {
+	if tc.ClientID != u.OIDCClientID {
+		return fmt.Errorf("%w: %q of session %v, %q of token", errTokenClient, u.OIDCClientID, u.SessionID,
+			tc.ClientID)
 	}
 
-	redisKey, authInfo, err := c.verifyTokenEx(ctx, redisKeyForSession, tokenString)
+	return nil
+}
+
+func (c *Controller) signToken(tc *TokenClaims) (string, error) {
+	return c.tokenKeys.Sign(tc)
+}
+
+// parseToken verifies the signature and the registered claims, the session is not checked
+func (c *Controller) parseToken(tokenString string) (*TokenClaims, error) {
+	var tc TokenClaims
+
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	_, err := parser.ParseWithClaims(tokenString, &tc, c.tokenKeys.Keyfunc)
 	if err != nil {
-		c.logger.Errorf(ctx, "verify token failed: %v", err)
+		return nil, err
+	}
+
+	audiences := c.cfg.Token.Audiences
+	if tc.ClientID != "" {
+		audiences = []string{tc.ClientID}
+	}
+
+	err = validateClaims(&tc, c.cfg.Token.Issuer, audiences, c.cfg.Token.ClockSkew, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &tc, nil
+}
+
+func (c *Controller) verifyToken(ctx context.Context, tokenString string) (authInfo *AuthInfo, err error) {
+	if isServiceAccountKey(tokenString) {
+		authInfo, err = c.verifyServiceAccountKey(ctx, tokenString)
+		if err != nil {
+			c.logger.Errorf(ctx, "verify service account key failed: %v", err)
+		}
 
 		return
 	}
 
-	utils.DefRedisTimeoutOp(func(ctx context.Context) {
-		_, err = c.redis.Expire(ctx, redisKey, c.cfg.Token.Expire).Result()
-	})
+	if isPersonalAccessToken(tokenString) {
+		authInfo, err = c.verifyPersonalAccessToken(ctx, tokenString)
+		if err != nil {
+			c.logger.Errorf(ctx, "verify personal access token failed: %v", err)
+		}
+
+		return
+	}
+
+	if c.cfg.Token.Stateless {
+		var tc *TokenClaims
+
+		tc, err = c.parseToken(tokenString)
+		if err != nil {
+			c.logger.Errorf(ctx, "verify token failed: %v", err)
+
+			return
+		}
+
+		// tokens issued before stateless mode have no session in them, they are verified by redis
+		if tc.Session != nil {
+			return c.verifyStatelessToken(ctx, tc)
+		}
+	}
 
+	// the idle timeout of the session slides by touchSession, the session is never extended
+	_, authInfo, err = c.verifyTokenEx(ctx, redisKeyForSession, tokenKindAccess, tokenString)
 	if err != nil {
-		c.logger.Errorf(ctx, "set ttl of key %v failed: %v", redisKey, err)
+		c.logger.Errorf(ctx, "verify token failed: %v", err)
 
-		err = nil
+		return
 	}
 
+	c.touchSession(ctx, authInfo)
+
 	return
 }
 
+// verifyTokenEx accepts only tokens of kind
 func (c *Controller) verifyTokenEx(ctx context.Context, fnRedisKey func(userId int64, sessionId string) string,
-	tokenString string) (redisKey string, authInfo *AuthInfo, err error) {
-	var tc TokenClaims
-
-	_, err = jwt.ParseWithClaims(tokenString, &tc, func(token *jwt.Token) (interface{}, error) {
-		return []byte(c.cfg.Token.Secret), nil
-	})
-
+	kind, tokenString string) (redisKey string, authInfo *AuthInfo, err error) {
+	tc, err := c.parseToken(tokenString)
 	if err != nil {
 		c.logger.Errorf(ctx, "verifyTokenEx failed: %v", err)
 
 		return
 	}
 
+	if kindOf(tc) != kind {
+		err = fmt.Errorf("%w: %v token used as %v token", errTokenKind, kindOf(tc), kind)
+
+		return
+	}
+
 	redisKey = fnRedisKey(tc.UserID, tc.SessionID)
 
 	authInfo, err = c.verifySessionID(ctx, tc.UserID, tc.SessionID, redisKey)
@@ -217,6 +373,13 @@ func (c *Controller) verifyTokenEx(ctx context.Context, fnRedisKey func(userId i
 		return
 	}
 
+	err = checkClient(tc, authInfo)
+	if err != nil {
+		c.logger.Errorf(ctx, "verifyTokenEx failed: %v", err)
+
+		return
+	}
+
 	if authInfo.ParentSessionID != "" {
 		_, err = c.verifySessionID(ctx, authInfo.UserID, authInfo.ParentSessionID, redisKeyForSession(authInfo.UserID, authInfo.ParentSessionID))
 
@@ -228,7 +391,71 @@ func (c *Controller) verifyTokenEx(ctx context.Context, fnRedisKey func(userId i
 	return
 }
 
+// verifyStatelessToken trusts the session in the signed token, only the revocation list and ip are checked
+func (c *Controller) verifyStatelessToken(ctx context.Context, tc *TokenClaims) (authInfo *AuthInfo, err error) {
+	err = c.checkStatelessSession(ctx, tc)
+	if err != nil {
+		return
+	}
+
+	err = c.checkClientIP(ctx, tc.Session)
+	if err != nil {
+		return
+	}
+
+	authInfo = tc.Session
+
+	return
+}
+
+// checkStatelessSession accepts only access tokens, the tokens of the other kinds are verified by redis
+func (c *Controller) checkStatelessSession(ctx context.Context, tc *TokenClaims) (err error) {
+	if tc.Kind != tokenKindAccess {
+		err = fmt.Errorf("%w: %v token used as %v token", errTokenKind, tc.Kind, tokenKindAccess)
+
+		return
+	}
+
+	if tc.Session.UserID != tc.UserID || tc.Session.SessionID != tc.SessionID {
+		err = fmt.Errorf("session miss: [%v-%v], [%v-%v]", tc.Session.UserID, tc.Session.SessionID,
+			tc.UserID, tc.SessionID)
+		c.logger.Error(ctx, err)
+
+		return
+	}
+
+	err = checkClient(tc, tc.Session)
+	if err != nil {
+		c.logger.Error(ctx, err)
+
+		return
+	}
+
+	for _, sessionID := range []string{tc.Session.SessionID, tc.Session.ParentSessionID} {
+		if sessionID != "" && c.sessionRevoked(ctx, sessionID) {
+			err = fmt.Errorf("%w: %v", errSessionRevoked, sessionID)
+			c.logger.Warnf(ctx, err.Error())
+
+			return
+		}
+	}
+
+	return
+}
+
 func (c *Controller) verifySessionID(ctx context.Context, userID int64, sessionID, redisKey string) (authInfo *AuthInfo, err error) {
+	authInfo, err = c.loadSession(ctx, userID, sessionID, redisKey)
+	if err != nil {
+		return
+	}
+
+	err = c.checkClientIP(ctx, authInfo)
+
+	return
+}
+
+// loadSession reads a session and checks it belongs to userID and is alive, the ip is not checked
+func (c *Controller) loadSession(ctx context.Context, userID int64, sessionID, redisKey string) (authInfo *AuthInfo, err error) {
 	var data string
 
 	utils.DefRedisTimeoutOp(func(ctx context.Context) {
@@ -264,16 +491,8 @@ func (c *Controller) verifySessionID(ctx context.Context, userID int64, sessionI
 		return
 	}
 
-	if time.Now().Unix() > authInfo.ExpiresAt {
-		err = fmt.Errorf("token timeout: %v, now is %v", time.Unix(authInfo.ExpiresAt, 0), time.Now())
-		c.logger.Warnf(ctx, err.Error())
-
-		return
-	}
-
-	clientIP := c.utils.GetPeerIP(ctx)
-	if !c.compareIP(ctx, authInfo.ClientIP, clientIP) {
-		err = fmt.Errorf("ip miss: %v, now is %v", authInfo.ClientIP, clientIP)
+	err = authInfo.Valid()
+	if err != nil {
 		c.logger.Warnf(ctx, err.Error())
 
 		return
@@ -283,70 +502,39 @@ func (c *Controller) verifySessionID(ctx context.Context, userID int64, sessionI
 }
 
 func (c *Controller) removeToken(ctx context.Context, tokenString string) error {
-	var tc TokenClaims
-
-	_, err := jwt.ParseWithClaims(tokenString, &tc, func(token *jwt.Token) (interface{}, error) {
-		return []byte(c.cfg.Token.Secret), nil
-	})
-
+	tc, err := c.parseToken(tokenString)
 	if err != nil {
 		c.logger.Errorf(ctx, "verifyToken failed: %v", err)
 
 		return err
 	}
 
-	utils.DefRedisTimeoutOp(func(ctx context.Context) {
-		c.redis.Del(ctx, redisKeyForSession(tc.UserID, tc.SessionID))
-	})
-
-	utils.DefRedisTimeoutOp(func(ctx context.Context) {
-		children, err := c.redis.SMembers(ctx, redisKeyForSessionIDParent(tc.SessionID)).Result()
-		if err != nil {
-			if errors.Is(err, redis.Nil) {
-				c.logger.Errorf(ctx, "redis smembers %v failed: %v", tc.SessionID, err)
-			}
-
-			return
-		}
-		if len(children) <= 0 {
-			return
-		}
-		for _, sessionID := range children {
-			utils.DefRedisTimeoutOp(func(ctx context.Context) {
-				err = c.redis.Del(ctx, redisKeyForSession(tc.UserID, sessionID)).Err()
-				if err != nil {
-					c.logger.Errorf(ctx, "redis del %v failed: %v", redisKeyForSession(tc.UserID, tc.SessionID), err)
-				}
-			})
-		}
-
-		utils.DefRedisTimeoutOp(func(ctx context.Context) {
-			c.redis.Del(ctx, redisKeyForSessionIDParent(tc.SessionID))
-		})
-	})
+	c.removeSession(ctx, tc.UserID, tc.SessionID)
 
 	return nil
 }
 
-func (c *Controller) compareIP(ctx context.Context, ip1, ip2 string) bool {
-	netIP1 := net.ParseIP(ip1)
-	netIP2 := net.ParseIP(ip2)
+// checkClientIP applies the ip binding rule of the client type of session u to the peer ip
+func (c *Controller) checkClientIP(ctx context.Context, u *AuthInfo) error {
+	clientIP := c.utils.GetPeerIP(ctx)
 
-	if (netIP1 == nil && netIP2 != nil) || (netIP1 != nil && netIP2 == nil) {
-		return false
+	rule, ok := c.ipBinder.Match(u.ClientType, u.ClientIP, clientIP)
+	if ok {
+		return nil
 	}
 
-	if netIP1 == nil {
-		return true
-	}
+	if rule.AuditOnly {
+		c.logger.Warnf(ctx, "audit: session %v of %v signed in from %v is used from %v, %v binding of client %q",
+			u.SessionID, u.UserID, u.ClientIP, clientIP, rule.Mode, u.ClientType)
 
-	if netIP1.Equal(netIP2) {
-		return true
+		return nil
 	}
 
-	c.logger.Warnf(ctx, "ip miss: %v, %v", netIP1.String(), netIP2.String())
+	err := fmt.Errorf("ip miss: %v, now is %v, %v binding of client %q", u.ClientIP, clientIP, rule.Mode,
+		u.ClientType)
+	c.logger.Warnf(ctx, err.Error())
 
-	return false
+	return err
 }
 
 func (c *Controller) getUserTokenCookie(ctx context.Context) string {
@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+func TestTokenKinds(t *testing.T) {
+	for _, stateless := range []bool{false, true} {
+		c, _, _ := newTestController(t)
+		c.cfg.Token.Stateless = stateless
+		ctx := context.Background()
+
+		sessionID, tokens := newTestSession(t, c, 1, "")
+
+		authInfo, err := c.verifyToken(ctx, tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("stateless %v: verify access token failed: %v", stateless, err)
+		}
+
+		ssoToken, err := c.newSSOToken(ctx, sessionID, authInfo, "https://a.example.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tc, err := c.parseToken(ssoToken)
+		if err != nil || tc.Kind != tokenKindSSO || tc.Session != nil {
+			t.Fatalf("stateless %v: unexpected sso token: %+v, %v", stateless, tc, err)
+		}
+
+		if _, err = c.verifyToken(ctx, ssoToken); !errors.Is(err, errTokenKind) {
+			t.Fatalf("stateless %v: sso token used as access token: %v", stateless, err)
+		}
+
+		if _, err = c.verifySSOToken(ctx, tokens.AccessToken); !errors.Is(err, errTokenKind) {
+			t.Fatalf("stateless %v: access token used as sso token: %v", stateless, err)
+		}
+
+		if _, err = c.verifySSOToken(ctx, ssoToken); err != nil {
+			t.Fatalf("stateless %v: verify sso token failed: %v", stateless, err)
+		}
+
+		if _, err = c.verifySSOToken(ctx, ssoToken); err == nil {
+			t.Fatalf("stateless %v: sso token used twice", stateless)
+		}
+
+		// a token of another kind is refused even if it carries a session
+		tc.Session = authInfo
+
+		forged, err := c.signToken(tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.verifyToken(ctx, forged); !errors.Is(err, errTokenKind) {
+			t.Fatalf("stateless %v: sso token with session used as access token: %v", stateless, err)
+		}
+	}
+}
+
+// aboutNow fails unless at is d from now, within the seconds the test takes
+func aboutNow(t *testing.T, what string, at int64, d time.Duration) {
+	t.Helper()
+
+	if want := time.Now().Add(d).Unix(); at < want-2 || at > want+2 {
+		t.Fatalf("%v at %v, want %v", what, time.Unix(at, 0), time.Unix(want, 0))
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	c, _, _ := newTestController(t)
+
+	u := &AuthInfo{ExpiresAt: time.Now().Add(10 * time.Minute).Unix()}
+
+	if ttl := c.sessionTTL(u, 5*time.Minute); ttl != 5*time.Minute {
+		t.Fatalf("ttl %v within lifetime", ttl)
+	}
+
+	if ttl := c.sessionTTL(u, time.Hour); ttl > 10*time.Minute || ttl < 9*time.Minute {
+		t.Fatalf("ttl %v over lifetime", ttl)
+	}
+
+	u.ExpiresAt = time.Now().Add(-time.Second).Unix()
+
+	if ttl := c.sessionTTL(u, time.Hour); ttl > 0 {
+		t.Fatalf("ttl %v of ended session", ttl)
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	sessionID, tokens := newTestSession(t, c, 1, "")
+	redisKey := redisKeyForSession(1, sessionID)
+
+	if ttl := mr.TTL(redisKey); ttl != c.cfg.Token.IdleTimeout {
+		t.Fatalf("session ttl %v, want %v", ttl, c.cfg.Token.IdleTimeout)
+	}
+
+	// each use slides the idle timeout
+	for i := 0; i < 3; i++ {
+		mr.FastForward(50 * time.Minute)
+
+		if _, err := c.verifyToken(ctx, tokens.AccessToken); err != nil {
+			t.Fatalf("verify used session failed: %v", err)
+		}
+
+		if ttl := mr.TTL(redisKey); ttl != c.cfg.Token.IdleTimeout {
+			t.Fatalf("session ttl %v after use, want %v", ttl, c.cfg.Token.IdleTimeout)
+		}
+
+		if ttl := mr.TTL(redisKeyForRefreshToken(1, sessionID)); ttl != c.cfg.Token.IdleTimeout {
+			t.Fatalf("refresh token ttl %v after use, want %v", ttl, c.cfg.Token.IdleTimeout)
+		}
+	}
+
+	mr.FastForward(c.cfg.Token.IdleTimeout)
+
+	if _, err := c.verifyToken(ctx, tokens.AccessToken); err == nil {
+		t.Fatal("idle session verified")
+	}
+
+	status, _, _ := c.RefreshToken(ctx, tokens.RefreshToken)
+	if status == userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatal("idle session refreshed")
+	}
+}
+
+func TestSessionMaxLifetime(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	c.cfg.Token.MaxLifetime = 30 * time.Minute
+	ctx := context.Background()
+
+	sessionID, tokens := newTestSession(t, c, 1, "")
+	redisKey := redisKeyForSession(1, sessionID)
+
+	authInfo, err := c.verifyToken(ctx, tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aboutNow(t, "session end", authInfo.ExpiresAt, 30*time.Minute)
+
+	// the idle timeout is longer than the rest of the session, so the uses never extend it
+	for i := 0; i < 3; i++ {
+		mr.FastForward(20 * time.Minute)
+
+		if authInfo, err = c.verifyToken(ctx, tokens.AccessToken); err != nil {
+			t.Fatalf("verify used session failed: %v", err)
+		}
+
+		if ttl := mr.TTL(redisKey); ttl > time.Until(time.Unix(authInfo.ExpiresAt+1, 0)) {
+			t.Fatalf("session ttl %v over its end %v", ttl, time.Unix(authInfo.ExpiresAt, 0))
+		}
+
+		aboutNow(t, "session end after use", authInfo.ExpiresAt, 30*time.Minute)
+	}
+
+	// and the session ends at its end however recently it was used
+	data, _ := mr.Get(redisKey)
+
+	stored := &AuthInfo{}
+	if err = json.Unmarshal([]byte(data), stored); err != nil {
+		t.Fatal(err)
+	}
+
+	stored.ExpiresAt = time.Now().Add(-time.Second).Unix()
+
+	ended, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := mr.TTL(redisKey)
+	_ = mr.Set(redisKey, string(ended))
+	mr.SetTTL(redisKey, ttl)
+
+	if _, err = c.verifyToken(ctx, tokens.AccessToken); err == nil {
+		t.Fatal("ended session verified")
+	}
+}
+
+func TestProfile_Expiry(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	_, tokens := newTestSession(t, c, 1, "")
+
+	mr.FastForward(50 * time.Minute)
+
+	status, _, _, expiry, err := c.Profile(ctx, tokens.AccessToken, false, "")
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		t.Fatalf("profile failed: %v, %v", status, err)
+	}
+
+	// the profile is a use of the session, which slid its idle timeout
+	aboutNow(t, "idle end", expiry.IdleExpiresAt, c.cfg.Token.IdleTimeout)
+	aboutNow(t, "absolute end", expiry.ExpiresAt, c.cfg.Token.MaxLifetime)
+}
+
+func TestSSOToken_Expire(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	ctx := context.Background()
+
+	sessionID, tokens := newTestSession(t, c, 1, "")
+
+	newSSOToken := func() (string, *TokenClaims) {
+		status, _, ssoToken, _, err := c.Profile(ctx, tokens.AccessToken, true, "https://a.example.com/")
+		if status != userpb.UserStatus_USER_STATUS_SUCCESS || ssoToken == "" {
+			t.Fatalf("sso token failed: %v, %v", status, err)
+		}
+
+		tc, err := c.parseToken(ssoToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		aboutNow(t, "sso token end", tc.ExpiresAt, c.cfg.Token.SSOExpire)
+
+		// the end of the session is in seconds, so the ttl is a little less
+		if ttl := mr.TTL(redisKeyForSSOToken(1, tc.SessionID)); ttl > c.cfg.Token.SSOExpire ||
+			ttl < c.cfg.Token.SSOExpire-2*time.Second {
+			t.Fatalf("sso session ttl %v, want %v", ttl, c.cfg.Token.SSOExpire)
+		}
+
+		return ssoToken, tc
+	}
+
+	ssoToken, _ := newSSOToken()
+
+	authInfo, err := c.verifySSOToken(ctx, ssoToken)
+	if err != nil || authInfo.ParentSessionID != sessionID {
+		t.Fatalf("verify sso token: %+v, %v", authInfo, err)
+	}
+
+	// the sso session lives SSOExpire, not the lifetime of its parent
+	aboutNow(t, "sso session end", authInfo.ExpiresAt, c.cfg.Token.SSOExpire)
+
+	ssoToken, _ = newSSOToken()
+
+	mr.FastForward(c.cfg.Token.SSOExpire)
+
+	if _, err = c.verifySSOToken(ctx, ssoToken); err == nil {
+		t.Fatal("expired sso token verified")
+	}
+
+	if _, err = c.verifyToken(ctx, tokens.AccessToken); err != nil {
+		t.Fatalf("parent session expired with sso token: %v", err)
+	}
+}
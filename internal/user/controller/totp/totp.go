@@ -0,0 +1,186 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmSHA1   = "SHA1"
+	AlgorithmSHA256 = "SHA256"
+	AlgorithmSHA512 = "SHA512"
+
+	// SecretSize is the recommended 160 bits of RFC 4226
+	SecretSize = 20
+)
+
+var (
+	ErrInvalidSecret = errors.New("invalid totp secret")
+
+	secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// TOTP implements RFC 6238
+type TOTP struct {
+	digits    int
+	period    time.Duration
+	algorithm string
+	skew      int
+	newHash   func() hash.Hash
+}
+
+func New(digits int, period time.Duration, algorithm string, skew int) (*TOTP, error) {
+	t := &TOTP{
+		digits:    digits,
+		period:    period,
+		algorithm: strings.ToUpper(algorithm),
+		skew:      skew,
+	}
+
+	switch t.algorithm {
+	case AlgorithmSHA1:
+		t.newHash = sha1.New
+	case AlgorithmSHA256:
+		t.newHash = sha256.New
+	case AlgorithmSHA512:
+		t.newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported totp algorithm: %v", algorithm)
+	}
+
+	if digits < 6 || digits > 10 {
+		return nil, fmt.Errorf("unsupported totp digits: %v", digits)
+	}
+
+	if period < time.Second {
+		return nil, fmt.Errorf("unsupported totp period: %v", period)
+	}
+
+	if skew < 0 {
+		return nil, fmt.Errorf("unsupported totp skew: %v", skew)
+	}
+
+	return t, nil
+}
+
+// GenerateSecret returns a random base32 secret without padding
+func GenerateSecret() (string, error) {
+	b := make([]byte, SecretSize)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return secretEncoding.EncodeToString(b), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+
+	key, err := secretEncoding.DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+
+	return key, nil
+}
+
+func (t *TOTP) Counter(tm time.Time) int64 {
+	return tm.Unix() / int64(t.period/time.Second)
+}
+
+// GenerateAt returns the code of the step which includes tm
+func (t *TOTP) GenerateAt(secret string, tm time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return t.hotp(key, t.Counter(tm)), nil
+}
+
+// Validate checks code within ±skew steps around tm, counter is the matched step which callers
+// should remember to refuse replays
+func (t *TOTP) Validate(secret, code string, tm time.Time) (ok bool, counter int64, err error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return
+	}
+
+	if len(code) != t.digits {
+		return
+	}
+
+	current := t.Counter(tm)
+
+	for i := -t.skew; i <= t.skew; i++ {
+		expect := t.hotp(key, current+int64(i))
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(code)) == 1 {
+			return true, current + int64(i), nil
+		}
+	}
+
+	return
+}
+
+// URI returns the otpauth uri for qr codes
+func (t *TOTP) URI(secret, accountName, issuer string) string {
+	label := accountName
+	if issuer != "" {
+		label = issuer + ":" + accountName
+	}
+
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("algorithm", t.algorithm)
+	values.Set("digits", strconv.Itoa(t.digits))
+	values.Set("period", strconv.Itoa(int(t.period/time.Second)))
+
+	if issuer != "" {
+		values.Set("issuer", issuer)
+	}
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + label,
+		RawQuery: values.Encode(),
+	}
+
+	return u.String()
+}
+
+func (t *TOTP) hotp(key []byte, counter int64) string {
+	var msg [8]byte
+
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(t.newHash, key)
+	_, _ = mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := int64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+
+	mod := int64(1)
+	for i := 0; i < t.digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", t.digits, value%mod)
+}
@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nolint
+func TestTOTP_RFC6238(t *testing.T) {
+	secrets := map[string]string{
+		AlgorithmSHA1:   strings.Repeat("1234567890", 2),
+		AlgorithmSHA256: strings.Repeat("1234567890", 3) + "12",
+		AlgorithmSHA512: strings.Repeat("1234567890", 6) + "1234",
+	}
+
+	tests := []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, AlgorithmSHA1, "94287082"},
+		{59, AlgorithmSHA256, "46119246"},
+		{59, AlgorithmSHA512, "90693936"},
+		{1111111109, AlgorithmSHA1, "07081804"},
+		{1111111109, AlgorithmSHA256, "68084774"},
+		{1111111109, AlgorithmSHA512, "25091201"},
+		{20000000000, AlgorithmSHA1, "65353130"},
+		{20000000000, AlgorithmSHA256, "77737706"},
+		{20000000000, AlgorithmSHA512, "47863826"},
+	}
+
+	for _, tt := range tests {
+		otp, err := New(8, 30*time.Second, tt.algorithm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret := base32.StdEncoding.EncodeToString([]byte(secrets[tt.algorithm]))
+
+		got, err := otp.GenerateAt(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("GenerateAt(%v, %v) = %v, want %v", tt.unix, tt.algorithm, got, tt.want)
+		}
+	}
+}
+
+func TestTOTP_ValidateSkew(t *testing.T) {
+	otp, err := New(6, 30*time.Second, AlgorithmSHA1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+
+	code, _ := otp.GenerateAt(secret, now.Add(-30*time.Second))
+
+	ok, counter, err := otp.Validate(secret, code, now)
+	if err != nil || !ok || counter != otp.Counter(now)-1 {
+		t.Fatalf("previous step should be accepted: %v, %v, %v", ok, counter, err)
+	}
+
+	code, _ = otp.GenerateAt(secret, now.Add(-60*time.Second))
+
+	ok, _, _ = otp.Validate(secret, code, now)
+	if ok {
+		t.Fatal("code out of skew accepted")
+	}
+}
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sbasestarter/db-orm/go/user"
 	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
@@ -75,3 +77,41 @@ func (c *Controller) userItem2PbUserListItem(item *model.UserItem, gaEnabled boo
 		Privileges:  int64(item.UserInfo.Privileges),
 	}
 }
+
+func timeUnix(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+// serviceAccount2Pb never carries the key, only the key id
+func serviceAccount2Pb(account *model.ServiceAccount) *userpb.ServiceAccount {
+	return &userpb.ServiceAccount{
+		Id:          account.Id,
+		Name:        account.Name,
+		OwnerUserId: account.OwnerUserId,
+		Scopes:      strings.Fields(account.Scopes),
+		KeyId:       account.KeyId,
+		ExpireAt:    timeUnix(account.ExpireAt),
+		RevokeAt:    timeUnix(account.RevokeAt),
+		CreateAt:    account.CreateAt.Unix(),
+		RotateAt:    timeUnix(account.RotateAt),
+		LastUsedAt:  timeUnix(account.LastUsedAt),
+		LastUsedIp:  account.LastUsedIp,
+	}
+}
+
+// personalAccessToken2Pb never carries the key
+func personalAccessToken2Pb(pat *model.PersonalAccessToken) *userpb.PersonalAccessToken {
+	return &userpb.PersonalAccessToken{
+		Id:         pat.Id,
+		Name:       pat.Name,
+		Scopes:     strings.Fields(pat.Scopes),
+		ExpireAt:   pat.ExpireAt.Unix(),
+		CreateAt:   pat.CreateAt.Unix(),
+		LastUsedAt: timeUnix(pat.LastUsedAt),
+		LastUsedIp: pat.LastUsedIp,
+	}
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 
@@ -10,15 +11,46 @@ import (
 	"github.com/sbasestarter/user/internal/utils"
 )
 
-func (c *Controller) checkVe(user *userpb.UserId, code string) (userpb.UserStatus, error) {
-	key := redisKeyForVeAuth(redisUsername(user), keyCatAuthCode)
+const (
+	veFieldCode     = "code"
+	veFieldAttempts = "attempts"
+)
+
+// incrVeAttemptsScript counts a wrong attempt on the code of KEYS[1], a code which is gone is left gone,
+// since HINCRBY would recreate it without a ttl
+var incrVeAttemptsScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+return redis.call("HINCRBY", KEYS[1], ARGV[1], 1)
+`)
+
+func (c *Controller) saveVe(ctx context.Context, user *userpb.UserId, purpose userpb.TriggerAuthPurpose, code string) (err error) {
+	key := redisKeyForVeAuthCode(user, purpose)
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, veFieldCode, code)
+			pipe.Expire(ctx, key, c.authPlugins.ValidDelayDuration(ctx, user))
+
+			return nil
+		})
+	})
+
+	return
+}
+
+// checkVe verifies the code issued for purpose, the code is dropped after too many wrong attempts
+func (c *Controller) checkVe(user *userpb.UserId, code string, purpose userpb.TriggerAuthPurpose) (userpb.UserStatus, error) {
+	key := redisKeyForVeAuthCode(user, purpose)
 
 	var verifyCodeInDB string
 
 	var err error
 
 	utils.DefRedisTimeoutOp(func(ctx context.Context) {
-		verifyCodeInDB, err = c.redis.Get(ctx, key).Result()
+		verifyCodeInDB, err = c.redis.HGet(ctx, key, veFieldCode).Result()
 	})
 
 	if err != nil {
@@ -31,15 +63,35 @@ func (c *Controller) checkVe(user *userpb.UserId, code string) (userpb.UserStatu
 		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
 	}
 
-	if verifyCodeInDB != code {
-		return userpb.UserStatus_USER_STATUS_WRONG_CODE, nil
+	if subtle.ConstantTimeCompare([]byte(verifyCodeInDB), []byte(code)) == 1 {
+		return userpb.UserStatus_USER_STATUS_SUCCESS, nil
+	}
+
+	var attempts int64
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		attempts, err = incrVeAttemptsScript.Run(ctx, c.redis, []string{key}, veFieldAttempts).Int64()
+	})
+
+	if err != nil {
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	if attempts == 0 {
+		return userpb.UserStatus_USER_STATUS_WRONG_CODE, errors.New("verify ve removed")
+	}
+
+	if attempts >= int64(c.authPlugins.MaxAttempts(context.Background(), user)) {
+		c.removeVe(user, purpose)
+
+		return userpb.UserStatus_USER_STATUS_WRONG_CODE, errors.New("too many wrong attempts, code invalidated")
 	}
 
-	return userpb.UserStatus_USER_STATUS_SUCCESS, nil
+	return userpb.UserStatus_USER_STATUS_WRONG_CODE, nil
 }
 
-func (c *Controller) removeVe(user *userpb.UserId) {
+func (c *Controller) removeVe(user *userpb.UserId, purpose userpb.TriggerAuthPurpose) {
 	utils.DefRedisTimeoutOp(func(ctx context.Context) {
-		c.redis.Del(ctx, redisKeyForVeAuth(redisUsername(user), keyCatAuthCode))
+		c.redis.Del(ctx, redisKeyForVeAuthCode(user, purpose))
 	})
 }
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+)
+
+func newTestMailUser() *userpb.UserId {
+	return &userpb.UserId{
+		UserName: "abc@web.com",
+		UserVe:   userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String(),
+	}
+}
+
+func TestCheckVe_MaxAttempts(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	user := newTestMailUser()
+	purpose := userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LOGIN
+
+	if err := c.saveVe(context.Background(), user, purpose, "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < c.cfg.EmailConfig.MaxAttempts; i++ {
+		status, err := c.checkVe(user, "000000", purpose)
+		if status != userpb.UserStatus_USER_STATUS_WRONG_CODE || err != nil {
+			t.Fatalf("attempt %v: %v, %v", i, status, err)
+		}
+	}
+
+	status, err := c.checkVe(user, "000000", purpose)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE || err == nil {
+		t.Fatalf("last attempt: %v, %v", status, err)
+	}
+
+	if mr.Exists(redisKeyForVeAuthCode(user, purpose)) {
+		t.Fatal("code kept after too many wrong attempts")
+	}
+
+	status, _ = c.checkVe(user, "123456", purpose)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE {
+		t.Fatalf("invalidated code accepted: %v", status)
+	}
+}
+
+func TestCheckVe_WrongCodeAfterRemove(t *testing.T) {
+	c, mr, _ := newTestController(t)
+	user := newTestMailUser()
+	purpose := userpb.TriggerAuthPurpose_TRIGGER_AUTH_PURPOSE_LOGIN
+	key := redisKeyForVeAuthCode(user, purpose)
+
+	if err := c.saveVe(context.Background(), user, purpose, "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	if mr.TTL(key) <= 0 {
+		t.Fatalf("code saved without ttl: %v", mr.TTL(key))
+	}
+
+	// the code is removed between the read and the count of a wrong attempt
+	c.removeVe(user, purpose)
+
+	attempts, err := incrVeAttemptsScript.Run(context.Background(), c.redis, []string{key}, veFieldAttempts).Int64()
+	if err != nil || attempts != 0 {
+		t.Fatalf("count on removed code: %v, %v", attempts, err)
+	}
+
+	if mr.Exists(key) {
+		t.Fatal("removed code recreated")
+	}
+
+	status, _ := c.checkVe(user, "000000", purpose)
+	if status != userpb.UserStatus_USER_STATUS_WRONG_CODE || mr.Exists(key) {
+		t.Fatalf("wrong code on removed code: %v, %v", status, mr.Exists(key))
+	}
+}
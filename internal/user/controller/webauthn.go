@@ -0,0 +1,494 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"github.com/sbasestarter/user/internal/user/controller/webauthn"
+	"github.com/sbasestarter/user/internal/user/model"
+	"github.com/sbasestarter/user/internal/utils"
+)
+
+const webAuthnCredentialNameMaxLen = 64
+
+func webAuthnUserHandle(userID int64) []byte {
+	return []byte(strconv.FormatInt(userID, 10))
+}
+
+func webAuthnCredentialIDs(credentials []*model.UserWebAuthnCredential) [][]byte {
+	ids := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		ids = append(ids, credential.CredentialId)
+	}
+
+	return ids
+}
+
+func (c *Controller) webAuthnEnabled(ctx context.Context, userID int64) bool {
+	if c.webAuthn == nil {
+		return false
+	}
+
+	cnt, err := c.m.CountUserWebAuthnCredentials(userID)
+	if err != nil {
+		c.logger.Errorf(ctx, "count webauthn credentials failed: %v", err)
+
+		return false
+	}
+
+	return cnt > 0
+}
+
+func (c *Controller) WebAuthnBeginRegistration(ctx context.Context, token string) (status userpb.UserStatus,
+	options string, err error) {
+	if c.webAuthn == nil {
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+
+		return
+	}
+
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	credentials, err := c.m.GetUserWebAuthnCredentials(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get webauthn credentials failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		c.logger.Errorf(ctx, "new challenge failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		err = c.redis.Set(ctx, redisKeyForWebAuthnRegistration(authInfo.UserID), challenge,
+			c.cfg.WebAuthn.Timeout).Err()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "save registration challenge failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	optionsBytes, err := json.Marshal(c.webAuthn.CreationOptions(challenge, webAuthnUserHandle(authInfo.UserID),
+		authInfo.NickName, authInfo.NickName, webAuthnCredentialIDs(credentials)))
+	if err != nil {
+		c.logger.Errorf(ctx, "marshal creation options failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	options = string(optionsBytes)
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// WebAuthnFinishRegistration adds the credential of response, the user is checked again by password or codeForGa
+func (c *Controller) WebAuthnFinishRegistration(ctx context.Context, token, csrfToken, password, codeForGa, name,
+	response string) (status userpb.UserStatus, credentialID int64, err error) {
+	if c.webAuthn == nil {
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+
+		return
+	}
+
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	status, err = c.verifyReauth(ctx, authInfo.UserID, password, codeForGa)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "reauth failed: %v, %v", status, err)
+
+		return
+	}
+
+	if name == "" || len(name) > webAuthnCredentialNameMaxLen {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	var challenge string
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		challenge, err = c.redis.Get(ctx, redisKeyForWebAuthnRegistration(authInfo.UserID)).Result()
+		if err == nil {
+			c.redis.Del(ctx, redisKeyForWebAuthnRegistration(authInfo.UserID))
+		}
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "get registration challenge failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+
+		return
+	}
+
+	resp, err := webauthn.ParseRegistrationResponse(response)
+	if err != nil {
+		c.logger.Errorf(ctx, "parse registration response failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	credential, err := c.webAuthn.VerifyRegistration(challenge, resp)
+	if err != nil {
+		c.logger.Errorf(ctx, "verify registration failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+
+		return
+	}
+
+	existed, err := c.m.GetWebAuthnCredential(credential.ID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get webauthn credential failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if existed != nil {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	dbCredential := &model.UserWebAuthnCredential{
+		UserId:       authInfo.UserID,
+		Name:         name,
+		CredentialId: credential.ID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.SignCount,
+		Aaguid:       credential.AAGUID,
+		CreateAt:     time.Now(),
+	}
+
+	err = c.m.AddUserWebAuthnCredential(dbCredential)
+	if err != nil {
+		c.logger.Errorf(ctx, "add webauthn credential failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	credentialID = dbCredential.Id
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+func (c *Controller) WebAuthnListCredentials(ctx context.Context, token string) (status userpb.UserStatus,
+	credentials []*userpb.WebAuthnCredential, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	dbCredentials, err := c.m.GetUserWebAuthnCredentials(authInfo.UserID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get webauthn credentials failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	for _, dbCredential := range dbCredentials {
+		credential := &userpb.WebAuthnCredential{
+			Id:       dbCredential.Id,
+			Name:     dbCredential.Name,
+			CreateAt: dbCredential.CreateAt.Unix(),
+		}
+
+		if dbCredential.LastUsedAt != nil {
+			credential.LastUsedAt = dbCredential.LastUsedAt.Unix()
+		}
+
+		credentials = append(credentials, credential)
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// WebAuthnDeleteCredential deletes the credential id, the user is checked again by password or codeForGa
+func (c *Controller) WebAuthnDeleteCredential(ctx context.Context, token, csrfToken, password, codeForGa string,
+	id int64) (status userpb.UserStatus, err error) {
+	status, authInfo, err := c.fixAndVerifySessionToken(ctx, token)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	status, err = c.verifyCsrfToken(ctx, csrfToken)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "check csrf token failed: %v, %v", status, err)
+
+		return
+	}
+
+	status, err = c.verifyReauth(ctx, authInfo.UserID, password, codeForGa)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		c.logger.Errorf(ctx, "reauth failed: %v, %v", status, err)
+
+		return
+	}
+
+	ok, err := c.m.DeleteUserWebAuthnCredential(authInfo.UserID, id)
+	if err != nil {
+		c.logger.Errorf(ctx, "delete webauthn credential failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if !ok {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// WebAuthnBeginLogin returns the request options for the Login assertion of userID
+func (c *Controller) WebAuthnBeginLogin(ctx context.Context, userID *userpb.UserId) (status userpb.UserStatus,
+	options string, err error) {
+	if c.webAuthn == nil {
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+
+		return
+	}
+
+	if userID == nil || userID.UserVe == "" {
+		c.logger.Errorf(ctx, "invalid input: %+v", userID)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	status, userID, err = c.authPlugins.FixUserID(ctx, userID)
+	if status != userpb.UserStatus_USER_STATUS_SUCCESS {
+		return
+	}
+
+	uid, err := c.m.GetUserIDBySource(userID.UserName, userID.UserVe)
+	if err != nil {
+		c.logger.Errorf(ctx, "GetUserIDBySource failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if uid <= 0 {
+		status = userpb.UserStatus_USER_STATUS_USER_NOT_EXISTS
+
+		return
+	}
+
+	credentials, err := c.m.GetUserWebAuthnCredentials(uid)
+	if err != nil {
+		c.logger.Errorf(ctx, "get webauthn credentials failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if len(credentials) == 0 {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		c.logger.Errorf(ctx, "new challenge failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		err = c.redis.Set(ctx, redisKeyForWebAuthnLogin(challenge), uid, c.cfg.WebAuthn.Timeout).Err()
+	})
+
+	if err != nil {
+		c.logger.Errorf(ctx, "save login challenge failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	optionsBytes, err := json.Marshal(c.webAuthn.RequestOptions(challenge, webAuthnCredentialIDs(credentials)))
+	if err != nil {
+		c.logger.Errorf(ctx, "marshal request options failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	options = string(optionsBytes)
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
+
+// webAuthnVerifyLogin checks the assertion against the challenge issued to userID by WebAuthnBeginLogin,
+// userVerified tells if the authenticator verified the user itself, which can replace the password
+func (c *Controller) webAuthnVerifyLogin(ctx context.Context, userID int64, assertion string) (
+	status userpb.UserStatus, userVerified bool, err error) {
+	if c.webAuthn == nil {
+		status = userpb.UserStatus_USER_STATUS_DONT_SUPPORT
+
+		return
+	}
+
+	resp, err := webauthn.ParseAssertionResponse(assertion)
+	if err != nil {
+		c.logger.Errorf(ctx, "parse assertion failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	challenge, err := webauthn.ClientDataChallenge(resp.Response.ClientDataJSON)
+	if err != nil {
+		c.logger.Errorf(ctx, "parse client data failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	var challengeUserID int64
+
+	// the challenge can be used only once
+	utils.DefRedisTimeoutOp(func(ctx context.Context) {
+		challengeUserID, err = c.redis.Get(ctx, redisKeyForWebAuthnLogin(challenge)).Int64()
+		if err == nil {
+			c.redis.Del(ctx, redisKeyForWebAuthnLogin(challenge))
+		}
+	})
+
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.logger.Errorf(ctx, "get login challenge failed: %v", err)
+		}
+
+		status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+
+		return
+	}
+
+	if challengeUserID != userID {
+		c.logger.Errorf(ctx, "challenge of %v used by %v", challengeUserID, userID)
+
+		status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+
+		return
+	}
+
+	credentialID, err := resp.CredentialID()
+	if err != nil {
+		status = userpb.UserStatus_USER_STATUS_BAD_INPUT
+
+		return
+	}
+
+	dbCredential, err := c.m.GetWebAuthnCredential(credentialID)
+	if err != nil {
+		c.logger.Errorf(ctx, "get webauthn credential failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if dbCredential == nil || dbCredential.UserId != userID {
+		c.logger.Errorf(ctx, "unknown credential for %v", userID)
+
+		status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+
+		return
+	}
+
+	signCount, userVerified, err := c.webAuthn.VerifyAssertion(challenge, &webauthn.Credential{
+		ID:        dbCredential.CredentialId,
+		PublicKey: dbCredential.PublicKey,
+		SignCount: dbCredential.SignCount,
+	}, resp)
+	if err != nil {
+		c.logger.Errorf(ctx, "verify assertion of credential %v failed: %v", dbCredential.Id, err)
+
+		status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+
+		return
+	}
+
+	ok, err := c.m.UpdateWebAuthnCredentialUsed(dbCredential.Id, dbCredential.SignCount, signCount)
+	if err != nil {
+		c.logger.Errorf(ctx, "update webauthn credential failed: %v", err)
+
+		status = userpb.UserStatus_USER_STATUS_INTERNAL_ERROR
+
+		return
+	}
+
+	if !ok {
+		c.logger.Errorf(ctx, "credential %v used concurrently", dbCredential.Id)
+
+		status = userpb.UserStatus_USER_STATUS_WRONG_CODE
+
+		return
+	}
+
+	status = userpb.UserStatus_USER_STATUS_SUCCESS
+
+	return
+}
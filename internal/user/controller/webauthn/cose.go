@@ -0,0 +1,164 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// https://www.iana.org/assignments/cose/cose.xhtml
+const (
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+
+	coseLabelKty = 1
+	coseLabelAlg = 3
+	coseLabelCrv = -1
+	coseLabelX   = -2
+	coseLabelY   = -3
+	coseLabelN   = -1
+	coseLabelE   = -2
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported cose key")
+	ErrBadSignature   = errors.New("bad signature")
+)
+
+type coseKey struct {
+	alg int64
+	pub crypto.PublicKey
+}
+
+func coseInt(m map[int64]interface{}, label int64) (int64, bool) {
+	switch v := m[label].(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	}
+
+	return 0, false
+}
+
+func coseBytes(m map[int64]interface{}, label int64) []byte {
+	b, _ := m[label].([]byte)
+
+	return b
+}
+
+func parseCOSEKey(data []byte) (*coseKey, error) {
+	var m map[int64]interface{}
+
+	err := cbor.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	kty, _ := coseInt(m, coseLabelKty)
+	alg, _ := coseInt(m, coseLabelAlg)
+	crv, _ := coseInt(m, coseLabelCrv)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256 && crv == coseCurveP256:
+		x, y := coseBytes(m, coseLabelX), coseBytes(m, coseLabelY)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrUnsupportedKey
+		}
+
+		return &coseKey{alg: alg, pub: pub}, nil
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA && crv == coseCurveEd25519:
+		x := coseBytes(m, coseLabelX)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+
+		return &coseKey{alg: alg, pub: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, e := coseBytes(m, coseLabelN), coseBytes(m, coseLabelE)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+
+		return &coseKey{alg: alg, pub: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: kty %v, alg %v", ErrUnsupportedKey, kty, alg)
+}
+
+func verifySignature(pub crypto.PublicKey, alg int64, data, sig []byte) error {
+	switch alg {
+	case AlgES256:
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedKey
+		}
+
+		var esig struct {
+			R, S *big.Int
+		}
+
+		rest, err := asn1.Unmarshal(sig, &esig)
+		if err != nil || len(rest) != 0 {
+			return ErrBadSignature
+		}
+
+		digest := sha256.Sum256(data)
+		if !ecdsa.Verify(key, digest[:], esig.R, esig.S) {
+			return ErrBadSignature
+		}
+	case AlgEdDSA:
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return ErrUnsupportedKey
+		}
+
+		if !ed25519.Verify(key, data, sig) {
+			return ErrBadSignature
+		}
+	case AlgRS256:
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedKey
+		}
+
+		digest := sha256.Sum256(data)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return ErrBadSignature
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+
+	return nil
+}
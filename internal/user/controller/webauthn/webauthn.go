@@ -0,0 +1,535 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	attestationFormatNone   = "none"
+	attestationFormatPacked = "packed"
+
+	credentialTypePublicKey = "public-key"
+
+	challengeSize = 32
+
+	authDataMinLen = 37
+)
+
+var (
+	ErrBadClientData  = errors.New("bad client data")
+	ErrBadAuthData    = errors.New("bad authenticator data")
+	ErrBadAttestation = errors.New("bad attestation")
+	ErrSignCount      = errors.New("sign count not increased, credential may be cloned")
+)
+
+// Credential is what a user registered, PublicKey is the COSE_Key
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// WebAuthn implements the relying party side of https://www.w3.org/TR/webauthn-2/
+type WebAuthn struct {
+	rpID     string
+	rpName   string
+	origins  map[string]interface{}
+	timeout  time.Duration
+	rpIDHash [32]byte
+}
+
+func New(rpID, rpName string, origins []string, timeout time.Duration) *WebAuthn {
+	w := &WebAuthn{
+		rpID:     rpID,
+		rpName:   rpName,
+		origins:  make(map[string]interface{}),
+		timeout:  timeout,
+		rpIDHash: sha256.Sum256([]byte(rpID)),
+	}
+
+	for _, origin := range origins {
+		w.origins[origin] = true
+	}
+
+	return w
+}
+
+func EncodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func NewChallenge() (string, error) {
+	b := make([]byte, challengeSize)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeBase64URL(b), nil
+}
+
+//
+// options sent to navigator.credentials.create() / get(), binary fields are base64url
+//
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type User struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+func credentialDescriptors(ids [][]byte) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		descriptors = append(descriptors, CredentialDescriptor{
+			Type: credentialTypePublicKey,
+			ID:   EncodeBase64URL(id),
+		})
+	}
+
+	return descriptors
+}
+
+func (w *WebAuthn) CreationOptions(challenge string, userHandle []byte, userName, displayName string,
+	excludeIDs [][]byte) *CreationOptions {
+	return &CreationOptions{
+		Challenge: challenge,
+		RP: RelyingParty{
+			ID:   w.rpID,
+			Name: w.rpName,
+		},
+		User: User{
+			ID:          EncodeBase64URL(userHandle),
+			Name:        userName,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: credentialTypePublicKey, Alg: AlgES256},
+			{Type: credentialTypePublicKey, Alg: AlgEdDSA},
+			{Type: credentialTypePublicKey, Alg: AlgRS256},
+		},
+		Timeout:            w.timeout.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(excludeIDs),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: attestationFormatNone,
+	}
+}
+
+func (w *WebAuthn) RequestOptions(challenge string, allowIDs [][]byte) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          w.timeout.Milliseconds(),
+		RPID:             w.rpID,
+		AllowCredentials: credentialDescriptors(allowIDs),
+		UserVerification: "preferred",
+	}
+}
+
+//
+// responses of navigator.credentials.create() / get(), binary fields are base64url
+//
+
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+func ParseRegistrationResponse(data string) (*RegistrationResponse, error) {
+	var resp RegistrationResponse
+
+	err := json.Unmarshal([]byte(data), &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func ParseAssertionResponse(data string) (*AssertionResponse, error) {
+	var resp AssertionResponse
+
+	err := json.Unmarshal([]byte(data), &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// CredentialID returns the raw id of the asserted credential
+func (resp *AssertionResponse) CredentialID() ([]byte, error) {
+	return DecodeBase64URL(resp.RawID)
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// ClientDataChallenge returns the challenge carried by the client data, used to find the pending ceremony
+func ClientDataChallenge(clientDataJSON string) (string, error) {
+	raw, err := DecodeBase64URL(clientDataJSON)
+	if err != nil {
+		return "", err
+	}
+
+	var cd clientData
+
+	err = json.Unmarshal(raw, &cd)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(cd.Challenge, "="), nil
+}
+
+// verifyClientData returns the hash of client data which is signed by the authenticator
+func (w *WebAuthn) verifyClientData(clientDataJSON, ceremony, challenge string) ([]byte, error) {
+	raw, err := DecodeBase64URL(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var cd clientData
+
+	err = json.Unmarshal(raw, &cd)
+	if err != nil {
+		return nil, err
+	}
+
+	if cd.Type != ceremony {
+		return nil, fmt.Errorf("%w: type %v", ErrBadClientData, cd.Type)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(cd.Challenge, "=")), []byte(challenge)) != 1 {
+		return nil, fmt.Errorf("%w: challenge mismatch", ErrBadClientData)
+	}
+
+	if _, ok := w.origins[cd.Origin]; !ok {
+		return nil, fmt.Errorf("%w: origin %v", ErrBadClientData, cd.Origin)
+	}
+
+	sum := sha256.Sum256(raw)
+
+	return sum[:], nil
+}
+
+type authenticatorData struct {
+	raw                 []byte
+	flags               byte
+	signCount           uint32
+	aaguid              []byte
+	credentialID        []byte
+	credentialPublicKey []byte
+}
+
+func (w *WebAuthn) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authDataMinLen {
+		return nil, ErrBadAuthData
+	}
+
+	if subtle.ConstantTimeCompare(data[:32], w.rpIDHash[:]) != 1 {
+		return nil, fmt.Errorf("%w: rp id hash mismatch", ErrBadAuthData)
+	}
+
+	ad := &authenticatorData{
+		raw:       data,
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user not present", ErrBadAuthData)
+	}
+
+	if ad.flags&flagAttestedCredentialData == 0 {
+		return ad, nil
+	}
+
+	rest := data[authDataMinLen:]
+	if len(rest) < 18 {
+		return nil, ErrBadAuthData
+	}
+
+	ad.aaguid = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if len(rest) < idLen {
+		return nil, ErrBadAuthData
+	}
+
+	ad.credentialID = rest[:idLen]
+
+	var pubKey cbor.RawMessage
+
+	_, err := cbor.UnmarshalFirst(rest[idLen:], &pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadAuthData, err)
+	}
+
+	ad.credentialPublicKey = pubKey
+
+	return ad, nil
+}
+
+type attestationObject struct {
+	Fmt      string                 `cbor:"fmt"`
+	AttStmt  map[string]interface{} `cbor:"attStmt"`
+	AuthData []byte                 `cbor:"authData"`
+}
+
+// VerifyRegistration verifies the response of a create() ceremony for challenge. Attestation
+// statements are checked for signature only, the authenticator model is not trusted
+func (w *WebAuthn) VerifyRegistration(challenge string, resp *RegistrationResponse) (*Credential, error) {
+	if resp.Type != credentialTypePublicKey {
+		return nil, fmt.Errorf("%w: type %v", ErrBadClientData, resp.Type)
+	}
+
+	clientDataHash, err := w.verifyClientData(resp.Response.ClientDataJSON, ceremonyCreate, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := DecodeBase64URL(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	var att attestationObject
+
+	err = cbor.Unmarshal(rawAttestation, &att)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadAttestation, err)
+	}
+
+	ad, err := w.parseAuthenticatorData(att.AuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if ad.credentialID == nil {
+		return nil, fmt.Errorf("%w: no attested credential", ErrBadAuthData)
+	}
+
+	rawID, err := DecodeBase64URL(resp.RawID)
+	if err != nil || !bytes.Equal(rawID, ad.credentialID) {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrBadAttestation)
+	}
+
+	key, err := parseCOSEKey(ad.credentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifyAttestationStatement(&att, key, clientDataHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.credentialPublicKey,
+		SignCount: ad.signCount,
+		AAGUID:    ad.aaguid,
+	}, nil
+}
+
+func verifyAttestationStatement(att *attestationObject, key *coseKey, clientDataHash []byte) error {
+	switch att.Fmt {
+	case attestationFormatNone:
+		if len(att.AttStmt) != 0 {
+			return fmt.Errorf("%w: none with statement", ErrBadAttestation)
+		}
+
+		return nil
+	case attestationFormatPacked:
+		alg, ok := att.AttStmt["alg"].(int64)
+		if !ok {
+			return fmt.Errorf("%w: packed without alg", ErrBadAttestation)
+		}
+
+		sig, ok := att.AttStmt["sig"].([]byte)
+		if !ok {
+			return fmt.Errorf("%w: packed without sig", ErrBadAttestation)
+		}
+
+		signed := make([]byte, 0, len(att.AuthData)+len(clientDataHash))
+		signed = append(signed, att.AuthData...)
+		signed = append(signed, clientDataHash...)
+
+		x5c, _ := att.AttStmt["x5c"].([]interface{})
+		if len(x5c) == 0 {
+			// self attestation
+			if alg != key.alg {
+				return fmt.Errorf("%w: alg mismatch", ErrBadAttestation)
+			}
+
+			return verifySignature(key.pub, alg, signed, sig)
+		}
+
+		der, _ := x5c[0].([]byte)
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBadAttestation, err)
+		}
+
+		return verifySignature(cert.PublicKey, alg, signed, sig)
+	}
+
+	// conveyance "none" is requested, so other formats are accepted without checking the statement
+	return nil
+}
+
+// VerifyAssertion verifies the response of a get() ceremony for challenge against the stored credential
+func (w *WebAuthn) VerifyAssertion(challenge string, credential *Credential, resp *AssertionResponse) (
+	signCount uint32, userVerified bool, err error) {
+	if resp.Type != credentialTypePublicKey {
+		err = fmt.Errorf("%w: type %v", ErrBadClientData, resp.Type)
+
+		return
+	}
+
+	rawID, err := resp.CredentialID()
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(rawID, credential.ID) {
+		err = fmt.Errorf("%w: credential id mismatch", ErrBadClientData)
+
+		return
+	}
+
+	clientDataHash, err := w.verifyClientData(resp.Response.ClientDataJSON, ceremonyGet, challenge)
+	if err != nil {
+		return
+	}
+
+	rawAuthData, err := DecodeBase64URL(resp.Response.AuthenticatorData)
+	if err != nil {
+		return
+	}
+
+	ad, err := w.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return
+	}
+
+	sig, err := DecodeBase64URL(resp.Response.Signature)
+	if err != nil {
+		return
+	}
+
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return
+	}
+
+	signed := make([]byte, 0, len(rawAuthData)+len(clientDataHash))
+	signed = append(signed, rawAuthData...)
+	signed = append(signed, clientDataHash...)
+
+	err = verifySignature(key.pub, key.alg, signed, sig)
+	if err != nil {
+		return
+	}
+
+	if (ad.signCount != 0 || credential.SignCount != 0) && ad.signCount <= credential.SignCount {
+		err = ErrSignCount
+
+		return
+	}
+
+	signCount = ad.signCount
+	userVerified = ad.flags&flagUserVerified != 0
+
+	return
+}
@@ -0,0 +1,227 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is a minimal ES256 authenticator for tests
+type softAuthenticator struct {
+	t         *testing.T
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	return &softAuthenticator{t: t, key: key, id: id}
+}
+
+func padTo32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	b, err := cbor.Marshal(map[int64]interface{}{
+		coseLabelKty: coseKeyTypeEC2,
+		coseLabelAlg: AlgES256,
+		coseLabelCrv: coseCurveP256,
+		coseLabelX:   padTo32(a.key.X.Bytes()),
+		coseLabelY:   padTo32(a.key.Y.Bytes()),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return b
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = append(data, byte(len(a.id)>>8), byte(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey()...)
+	}
+
+	return data
+}
+
+func (a *softAuthenticator) clientData(typ, challenge, origin string) []byte {
+	b, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+
+	return b
+}
+
+func (a *softAuthenticator) sign(authData, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	sig, err := asn1.Marshal(struct {
+		R, S *big.Int
+	}{r, s})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return sig
+}
+
+func (a *softAuthenticator) create(challenge, format string) *RegistrationResponse {
+	clientDataJSON := a.clientData(ceremonyCreate, challenge, testOrigin)
+	authData := a.authData(testRPID, flagUserPresent|flagUserVerified|flagAttestedCredentialData, true)
+
+	attStmt := map[string]interface{}{}
+	if format == attestationFormatPacked {
+		attStmt["alg"] = AlgES256
+		attStmt["sig"] = a.sign(authData, clientDataJSON)
+	}
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      format,
+		"attStmt":  attStmt,
+		"authData": authData,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	resp := &RegistrationResponse{
+		ID:    EncodeBase64URL(a.id),
+		RawID: EncodeBase64URL(a.id),
+		Type:  credentialTypePublicKey,
+	}
+	resp.Response.ClientDataJSON = EncodeBase64URL(clientDataJSON)
+	resp.Response.AttestationObject = EncodeBase64URL(attestation)
+
+	return resp
+}
+
+func (a *softAuthenticator) get(challenge, origin string, flags byte) *AssertionResponse {
+	a.signCount++
+
+	clientDataJSON := a.clientData(ceremonyGet, challenge, origin)
+	authData := a.authData(testRPID, flags, false)
+
+	resp := &AssertionResponse{
+		ID:    EncodeBase64URL(a.id),
+		RawID: EncodeBase64URL(a.id),
+		Type:  credentialTypePublicKey,
+	}
+	resp.Response.ClientDataJSON = EncodeBase64URL(clientDataJSON)
+	resp.Response.AuthenticatorData = EncodeBase64URL(authData)
+	resp.Response.Signature = EncodeBase64URL(a.sign(authData, clientDataJSON))
+
+	return resp
+}
+
+// nolint
+func TestWebAuthn_Ceremonies(t *testing.T) {
+	w := New(testRPID, "Example", []string{testOrigin}, time.Minute)
+
+	for _, format := range []string{attestationFormatNone, attestationFormatPacked} {
+		t.Run(format, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t)
+
+			challenge, err := NewChallenge()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := authenticator.create(challenge, format)
+
+			got, err := ClientDataChallenge(resp.Response.ClientDataJSON)
+			if err != nil || got != challenge {
+				t.Fatalf("ClientDataChallenge() = %v, %v", got, err)
+			}
+
+			other, _ := NewChallenge()
+			if _, err = w.VerifyRegistration(other, resp); !errors.Is(err, ErrBadClientData) {
+				t.Fatalf("VerifyRegistration() with wrong challenge: %v", err)
+			}
+
+			cred, err := w.VerifyRegistration(challenge, resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			challenge, _ = NewChallenge()
+
+			signCount, uv, err := w.VerifyAssertion(challenge, cred,
+				authenticator.get(challenge, testOrigin, flagUserPresent|flagUserVerified))
+			if err != nil || signCount != 1 || !uv {
+				t.Fatalf("VerifyAssertion() = %v, %v, %v", signCount, uv, err)
+			}
+
+			cred.SignCount = signCount
+
+			_, uv, err = w.VerifyAssertion(challenge, cred, authenticator.get(challenge, testOrigin, flagUserPresent))
+			if err != nil || uv {
+				t.Fatalf("VerifyAssertion() without uv = %v, %v", uv, err)
+			}
+
+			_, _, err = w.VerifyAssertion(challenge, cred,
+				authenticator.get(challenge, "https://evil.com", flagUserPresent))
+			if !errors.Is(err, ErrBadClientData) {
+				t.Fatalf("VerifyAssertion() with wrong origin: %v", err)
+			}
+
+			cred.SignCount = 100
+
+			_, _, err = w.VerifyAssertion(challenge, cred, authenticator.get(challenge, testOrigin, flagUserPresent))
+			if !errors.Is(err, ErrSignCount) {
+				t.Fatalf("VerifyAssertion() with cloned authenticator: %v", err)
+			}
+
+			cred.SignCount = 0
+
+			resp2 := authenticator.get(challenge, testOrigin, flagUserPresent)
+			resp2.Response.Signature = EncodeBase64URL(newSoftAuthenticator(t).sign([]byte("x"), []byte("y")))
+
+			_, _, err = w.VerifyAssertion(challenge, cred, resp2)
+			if !errors.Is(err, ErrBadSignature) {
+				t.Fatalf("VerifyAssertion() with bad signature: %v", err)
+			}
+		})
+	}
+}
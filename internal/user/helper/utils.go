@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/sgostarter/libservicetoolset/grpce"
+	"google.golang.org/grpc/metadata"
 )
 
 type UtilsImpl struct{}
@@ -19,3 +20,34 @@ func (u *UtilsImpl) RandomString(n int, allowedChars ...[]rune) string {
 func (u *UtilsImpl) GetPeerIP(ctx context.Context) string {
 	return grpce.GrpcGetRealIP(ctx)
 }
+
+// GetClientType is what the client tells in x-client-type, like web, ios or android. grpc-gateway forwards
+// it from the Grpc-Metadata-X-Client-Type header
+func (u *UtilsImpl) GetClientType(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md.Get("x-client-type"); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// GetUserAgent prefers the agent forwarded by grpc-gateway over the one of grpc client
+func (u *UtilsImpl) GetUserAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	for _, key := range []string{"grpcgateway-user-agent", "user-agent"} {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
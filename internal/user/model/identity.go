@@ -0,0 +1,250 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/sbasestarter/db-orm/go/user"
+	userpb "github.com/sbasestarter/proto-repo/gen/protorepo-user-go"
+	"xorm.io/xorm"
+)
+
+func (m *Model) GetUserSources(userID int64) (userSources []*user.UserSource, err error) {
+	err = m.db.Where(user.OUserSource.EqUserId(), userID).Asc("id").Find(&userSources)
+
+	return
+}
+
+// LinkUserSource lets userID sign in by the source too. A source left by auto login without a user is
+// taken over, one of another user is not. The email or phone of the user is filled by the source if empty
+func (m *Model) LinkUserSource(userID int64, userName, userVe string) (userpb.UserStatus, error) {
+	session := m.db.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	defer func() {
+		_ = session.Rollback()
+	}()
+
+	userSource := &user.UserSource{}
+
+	exists, err := session.Where(user.OUserSource.EqUserName(), userName).And(user.OUserSource.EqUserVe(),
+		userVe).ForUpdate().Get(userSource)
+	if err != nil {
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	// nolint: nestif
+	if exists {
+		if userSource.UserId == userID {
+			return userpb.UserStatus_USER_STATUS_SUCCESS, nil
+		}
+
+		if userSource.UserId > 0 {
+			return userpb.UserStatus_USER_STATUS_USER_ALREADY_EXISTS, errors.New("source of another user")
+		}
+
+		_, err = session.ID(userSource.Id).Cols(user.OUserSource.UserId()).
+			Update(&user.UserSource{UserId: userID})
+		if err != nil {
+			return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+		}
+	} else {
+		_, err = session.Insert(&user.UserSource{
+			UserName: userName,
+			UserVe:   userVe,
+			UserId:   userID,
+		})
+		if err != nil {
+			var me *mysql.MySQLError
+			if errors.As(err, &me) && me.Number == errMySQLDupEntry {
+				return userpb.UserStatus_USER_STATUS_USER_ALREADY_EXISTS, err
+			}
+
+			return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+		}
+	}
+
+	var email, phone string
+
+	if userVe == userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_MAIL.String() {
+		email = userName
+	} else if userVe == userpb.VerificationEquipment_VERIFICATION_EQUIPMENT_PHONE.String() {
+		phone = userName
+	}
+
+	err = fillUserExt(session, userID, email, phone)
+	if err != nil {
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	return userpb.UserStatus_USER_STATUS_SUCCESS, session.Commit()
+}
+
+// UnlinkUserSource removes a source of userID, the last one is kept so the user can still sign in
+func (m *Model) UnlinkUserSource(userID int64, userName, userVe string) (userpb.UserStatus, error) {
+	session := m.db.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	defer func() {
+		_ = session.Rollback()
+	}()
+
+	var userSources []*user.UserSource
+
+	// the rows are locked, so two unlinks at once can't remove both of the last two sources
+	err = session.Where(user.OUserSource.EqUserId(), userID).ForUpdate().Find(&userSources)
+	if err != nil {
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	var sourceID int64
+
+	for _, userSource := range userSources {
+		if userSource.UserName == userName && userSource.UserVe == userVe {
+			sourceID = userSource.Id
+		}
+	}
+
+	if sourceID == 0 {
+		return userpb.UserStatus_USER_STATUS_BAD_INPUT, errors.New("no such source")
+	}
+
+	if len(userSources) <= 1 {
+		return userpb.UserStatus_USER_STATUS_DONT_SUPPORT, errors.New("last source")
+	}
+
+	_, err = session.Delete(&user.UserSource{Id: sourceID})
+	if err != nil {
+		return userpb.UserStatus_USER_STATUS_INTERNAL_ERROR, err
+	}
+
+	return userpb.UserStatus_USER_STATUS_SUCCESS, session.Commit()
+}
+
+// MergeUsers moves the sources, passkeys, personal access tokens and service accounts of fromUserID to
+// toUserID, then deletes fromUserID. The password, 2fa key and recovery codes of fromUserID are dropped
+// nolint: funlen
+func (m *Model) MergeUsers(toUserID, fromUserID int64) error {
+	session := m.db.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = session.Rollback()
+	}()
+
+	_, err = session.Table(user.OUserSource.TableName()).Where(user.OUserSource.EqUserId(), fromUserID).
+		Update(map[string]interface{}{user.OUserSource.UserId(): toUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Table(new(UserWebAuthnCredential)).Where("user_id = ?", fromUserID).
+		Update(map[string]interface{}{"user_id": toUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Table(new(PersonalAccessToken)).Where("user_id = ?", fromUserID).
+		Update(map[string]interface{}{"user_id": toUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Table(new(ServiceAccount)).Where("owner_user_id = ?", fromUserID).
+		Update(map[string]interface{}{"owner_user_id": toUserID})
+	if err != nil {
+		return err
+	}
+
+	fromUserExt := &user.UserExt{}
+
+	_, err = session.Where(user.OUserExt.EqUserId(), fromUserID).Get(fromUserExt)
+	if err != nil {
+		return err
+	}
+
+	err = fillUserExt(session, toUserID, fromUserExt.Email, fromUserExt.Phone)
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&user.UserAuthentication{UserId: fromUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&user.UserExt{UserId: fromUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&user.UserInfo{UserId: fromUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&user.UserTrust{UserId: fromUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&UserPasswordHistory{UserId: fromUserID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&UserRecoveryCode{UserId: fromUserID})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// fillUserExt sets the email and phone of userID which are empty
+func fillUserExt(session *xorm.Session, userID int64, email, phone string) error {
+	if email == "" && phone == "" {
+		return nil
+	}
+
+	userExt := &user.UserExt{}
+
+	exists, err := session.Where(user.OUserExt.EqUserId(), userID).Get(userExt)
+	if err != nil || !exists {
+		return err
+	}
+
+	// empty fields are not updated
+	patch := &user.UserExt{}
+
+	if userExt.Email == "" {
+		patch.Email = email
+	}
+
+	if userExt.Phone == "" {
+		patch.Phone = phone
+	}
+
+	if patch.Email == "" && patch.Phone == "" {
+		return nil
+	}
+
+	_, err = session.Where(user.OUserExt.EqUserId(), userID).Update(patch)
+
+	return err
+}
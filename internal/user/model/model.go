@@ -58,6 +58,31 @@ func (m *Model) GetUser2FaKey(userID int64) (key string, err error) {
 	return
 }
 
+// GetUser2FaKeysAfter returns a batch of users who set 2fa, ordered by user id
+func (m *Model) GetUser2FaKeysAfter(userID int64, limit int) (userAuthentications []*user.UserAuthentication, err error) {
+	err = m.db.Where(user.OUserAuthentication.UserId()+" > ?", userID).
+		And(user.OUserAuthentication.Token2fa() + " != ''").
+		Asc(user.OUserAuthentication.UserId()).Limit(limit).Find(&userAuthentications)
+
+	return
+}
+
+// ReplaceUser2FaKey updates key only if it's not changed since oldKey was read
+func (m *Model) ReplaceUser2FaKey(userID int64, oldKey, key string) (ok bool, err error) {
+	affected, err := m.db.Where(user.OUserAuthentication.EqUserId(), userID).
+		And(user.OUserAuthentication.Token2fa()+" = ?", oldKey).
+		Cols(user.OUserAuthentication.Token2fa()).Update(&user.UserAuthentication{
+		Token2fa: key,
+	})
+	if err != nil {
+		return
+	}
+
+	ok = affected > 0
+
+	return
+}
+
 func (m *Model) GetUserInfo(userID int64) (*user.UserInfo, error) {
 	var userInfo user.UserInfo
 	exists, err := m.db.Where(user.OUserInfo.EqUserId(), userID).Get(&userInfo)
@@ -436,5 +461,20 @@ func (m *Model) DeleteUser(userID int64) error {
 		return err
 	}
 
+	_, err = session.Delete(&UserPasswordHistory{UserId: userID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&UserRecoveryCode{UserId: userID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Delete(&UserWebAuthnCredential{UserId: userID})
+	if err != nil {
+		return err
+	}
+
 	return session.Commit()
 }
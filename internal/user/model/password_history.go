@@ -0,0 +1,114 @@
+package model
+
+import (
+	"time"
+
+	"github.com/sbasestarter/db-orm/go/user"
+	"xorm.io/xorm"
+)
+
+// UserPasswordHistory is a password hash a user had before the current one, new passwords are checked
+// against the recent ones and the current one
+// nolint: revive, stylecheck
+type UserPasswordHistory struct {
+	Id       int64     `xorm:"pk autoincr BIGINT(20)"`
+	UserId   int64     `xorm:"not null index BIGINT(20)"`
+	Password string    `xorm:"not null VARCHAR(255)"`
+	CreateAt time.Time `xorm:"not null DATETIME"`
+}
+
+func (*UserPasswordHistory) TableName() string {
+	return "user_password_history"
+}
+
+// GetUserPasswordHistory returns the newest password hashes of user, at most limit ones set after since
+func (m *Model) GetUserPasswordHistory(userID int64, limit int, since time.Time) ([]string, error) {
+	var histories []*UserPasswordHistory
+
+	err := m.db.Where("user_id = ?", userID).And("create_at >= ?", since).Desc("id").Limit(limit).
+		Find(&histories)
+	if err != nil {
+		return nil, err
+	}
+
+	passwords := make([]string, 0, len(histories))
+	for _, history := range histories {
+		passwords = append(passwords, history.Password)
+	}
+
+	return passwords, nil
+}
+
+// UpdateUserPasswordWithHistory updates the password and records the one it replaces, the current password
+// is not in the history. Only the newest keep records within retention are left
+func (m *Model) UpdateUserPasswordWithHistory(userID int64, newPassword string, keep int, retention time.Duration) error {
+	session := m.db.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = session.Rollback()
+	}()
+
+	userAuth := &user.UserAuthentication{}
+
+	_, err = session.Where(user.OUserAuthentication.EqUserId(), userID).ForUpdate().Get(userAuth)
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Where(user.OUserAuthentication.EqUserId(), userID).Update(&user.UserAuthentication{
+		Password: newPassword,
+	})
+	if err != nil {
+		return err
+	}
+
+	if keep > 0 && userAuth.Password != "" {
+		_, err = session.Insert(&UserPasswordHistory{
+			UserId:   userID,
+			Password: userAuth.Password,
+			CreateAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = m.pruneUserPasswordHistory(session, userID, keep, retention)
+		if err != nil {
+			return err
+		}
+	}
+
+	return session.Commit()
+}
+
+func (m *Model) pruneUserPasswordHistory(session *xorm.Session, userID int64, keep int, retention time.Duration) error {
+	var ids []int64
+
+	err := session.Table(new(UserPasswordHistory)).Where("user_id = ?", userID).Desc("id").
+		Limit(keep).Cols("id").Find(&ids)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err = session.Where("user_id = ?", userID).NotIn("id", ids).Delete(new(UserPasswordHistory))
+	if err != nil {
+		return err
+	}
+
+	if retention > 0 {
+		_, err = session.Where("user_id = ?", userID).And("create_at < ?", time.Now().Add(-retention)).
+			Delete(new(UserPasswordHistory))
+	}
+
+	return err
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"time"
+)
+
+// PersonalAccessToken is a token a user creates for scripts, it authenticates as the user with an api
+// key of KeyId and the hash of the secret. Scopes are space separated
+// nolint: revive, stylecheck
+type PersonalAccessToken struct {
+	Id         int64      `xorm:"pk autoincr BIGINT(20)"`
+	UserId     int64      `xorm:"not null index BIGINT(20)"`
+	Name       string     `xorm:"not null VARCHAR(64)"`
+	Scopes     string     `xorm:"not null VARCHAR(1024)"`
+	KeyId      string     `xorm:"not null unique VARCHAR(32)"`
+	KeyHash    string     `xorm:"not null VARCHAR(64)"`
+	ExpireAt   time.Time  `xorm:"not null DATETIME"`
+	RevokeAt   *time.Time `xorm:"DATETIME"`
+	CreateAt   time.Time  `xorm:"not null DATETIME"`
+	LastUsedAt *time.Time `xorm:"DATETIME"`
+	LastUsedIp string     `xorm:"not null VARCHAR(64)"`
+}
+
+func (*PersonalAccessToken) TableName() string {
+	return "personal_access_token"
+}
+
+func (m *Model) AddPersonalAccessToken(token *PersonalAccessToken) error {
+	_, err := m.db.Insert(token)
+
+	return err
+}
+
+// GetPersonalAccessTokens returns the tokens of user which are neither revoked nor expired
+func (m *Model) GetPersonalAccessTokens(userID int64) (tokens []*PersonalAccessToken, err error) {
+	err = m.db.Where("user_id = ? AND revoke_at IS NULL AND expire_at > ?", userID, time.Now()).
+		Asc("id").Find(&tokens)
+
+	return
+}
+
+func (m *Model) GetPersonalAccessTokenByKeyID(keyID string) (token *PersonalAccessToken, err error) {
+	token = &PersonalAccessToken{}
+
+	exists, err := m.db.Where("key_id = ?", keyID).Get(token)
+	if err != nil {
+		return
+	}
+
+	if !exists {
+		token = nil
+	}
+
+	return
+}
+
+func (m *Model) RevokePersonalAccessToken(userID, id int64) (ok bool, err error) {
+	now := time.Now()
+
+	affected, err := m.db.Where("id = ? AND user_id = ? AND revoke_at IS NULL", id, userID).Cols("revoke_at").
+		Update(&PersonalAccessToken{
+			RevokeAt: &now,
+		})
+	if err != nil {
+		return
+	}
+
+	ok = affected > 0
+
+	return
+}
+
+func (m *Model) UpdatePersonalAccessTokenUsed(id int64, ip string) error {
+	now := time.Now()
+
+	_, err := m.db.Where("id = ?", id).Cols("last_used_at", "last_used_ip").Update(&PersonalAccessToken{
+		LastUsedAt: &now,
+		LastUsedIp: ip,
+	})
+
+	return err
+}
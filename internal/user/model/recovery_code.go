@@ -0,0 +1,124 @@
+package model
+
+import (
+	"time"
+
+	"github.com/sbasestarter/db-orm/go/user"
+	"xorm.io/xorm"
+)
+
+// UserRecoveryCode is the hash of a one-time code which replaces the 2fa code, UsedAt is set once it is used
+// nolint: revive, stylecheck
+type UserRecoveryCode struct {
+	Id       int64      `xorm:"pk autoincr BIGINT(20)"`
+	UserId   int64      `xorm:"not null index BIGINT(20)"`
+	CodeHash string     `xorm:"not null VARCHAR(64)"`
+	CreateAt time.Time  `xorm:"not null DATETIME"`
+	UsedAt   *time.Time `xorm:"DATETIME"`
+}
+
+func (*UserRecoveryCode) TableName() string {
+	return "user_recovery_code"
+}
+
+// ReplaceUserRecoveryCodes drops all codes of user and stores the new hashes
+func (m *Model) ReplaceUserRecoveryCodes(userID int64, codeHashes []string) error {
+	session := m.db.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = session.Rollback()
+	}()
+
+	err = replaceUserRecoveryCodes(session, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// SetUser2FaKeyWithRecoveryCodes sets the 2fa key and replaces the recovery codes at once, the user never
+// has a key without the codes shown with it
+func (m *Model) SetUser2FaKeyWithRecoveryCodes(userID int64, key string, codeHashes []string) error {
+	session := m.db.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = session.Rollback()
+	}()
+
+	_, err = session.Where(user.OUserAuthentication.EqUserId(), userID).
+		Cols(user.OUserAuthentication.Token2fa()).Update(&user.UserAuthentication{
+		Token2fa: key,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = replaceUserRecoveryCodes(session, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+func replaceUserRecoveryCodes(session *xorm.Session, userID int64, codeHashes []string) error {
+	_, err := session.Delete(&UserRecoveryCode{UserId: userID})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	codes := make([]*UserRecoveryCode, 0, len(codeHashes))
+	for _, codeHash := range codeHashes {
+		codes = append(codes, &UserRecoveryCode{
+			UserId:   userID,
+			CodeHash: codeHash,
+			CreateAt: now,
+		})
+	}
+
+	if len(codes) > 0 {
+		_, err = session.Insert(&codes)
+	}
+
+	return err
+}
+
+// UseUserRecoveryCode marks the code used, ok is false if no such unused code
+func (m *Model) UseUserRecoveryCode(userID int64, codeHash string) (ok bool, err error) {
+	now := time.Now()
+
+	affected, err := m.db.Where("user_id = ?", userID).And("code_hash = ?", codeHash).And("used_at IS NULL").
+		Cols("used_at").Update(&UserRecoveryCode{UsedAt: &now})
+	if err != nil {
+		return
+	}
+
+	ok = affected > 0
+
+	return
+}
+
+func (m *Model) CountUserUnusedRecoveryCodes(userID int64) (int64, error) {
+	return m.db.Where("user_id = ?", userID).And("used_at IS NULL").Count(new(UserRecoveryCode))
+}
+
+func (m *Model) DeleteUserRecoveryCodes(userID int64) error {
+	_, err := m.db.Delete(&UserRecoveryCode{UserId: userID})
+
+	return err
+}
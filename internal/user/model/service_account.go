@@ -0,0 +1,119 @@
+package model
+
+import (
+	"time"
+)
+
+// ServiceAccount is a caller of other services, it authenticates with an api key of KeyId and the hash
+// of the secret. Scopes are space separated
+// nolint: revive, stylecheck
+type ServiceAccount struct {
+	Id          int64      `xorm:"pk autoincr BIGINT(20)"`
+	Name        string     `xorm:"not null unique VARCHAR(64)"`
+	OwnerUserId int64      `xorm:"not null index BIGINT(20)"`
+	Scopes      string     `xorm:"not null VARCHAR(1024)"`
+	KeyId       string     `xorm:"not null unique VARCHAR(32)"`
+	KeyHash     string     `xorm:"not null VARCHAR(64)"`
+	ExpireAt    *time.Time `xorm:"DATETIME"`
+	RevokeAt    *time.Time `xorm:"DATETIME"`
+	CreateAt    time.Time  `xorm:"not null DATETIME"`
+	RotateAt    *time.Time `xorm:"DATETIME"`
+	LastUsedAt  *time.Time `xorm:"DATETIME"`
+	LastUsedIp  string     `xorm:"not null VARCHAR(64)"`
+}
+
+func (*ServiceAccount) TableName() string {
+	return "service_account"
+}
+
+func (m *Model) AddServiceAccount(account *ServiceAccount) error {
+	_, err := m.db.Insert(account)
+
+	return err
+}
+
+func (m *Model) GetServiceAccounts() (accounts []*ServiceAccount, err error) {
+	err = m.db.Asc("id").Find(&accounts)
+
+	return
+}
+
+func (m *Model) GetServiceAccount(id int64) (account *ServiceAccount, err error) {
+	account = &ServiceAccount{}
+
+	exists, err := m.db.Where("id = ?", id).Get(account)
+	if err != nil {
+		return
+	}
+
+	if !exists {
+		account = nil
+	}
+
+	return
+}
+
+func (m *Model) ServiceAccountNameExists(name string) (bool, error) {
+	return m.db.Where("name = ?", name).Exist(new(ServiceAccount))
+}
+
+func (m *Model) GetServiceAccountByKeyID(keyID string) (account *ServiceAccount, err error) {
+	account = &ServiceAccount{}
+
+	exists, err := m.db.Where("key_id = ?", keyID).Get(account)
+	if err != nil {
+		return
+	}
+
+	if !exists {
+		account = nil
+	}
+
+	return
+}
+
+// RotateServiceAccountKey replaces the key of an account which is not revoked, the old key stops working
+func (m *Model) RotateServiceAccountKey(id int64, keyID, keyHash string) (ok bool, err error) {
+	now := time.Now()
+
+	affected, err := m.db.Where("id = ?", id).And("revoke_at IS NULL").Cols("key_id", "key_hash", "rotate_at").
+		Update(&ServiceAccount{
+			KeyId:    keyID,
+			KeyHash:  keyHash,
+			RotateAt: &now,
+		})
+	if err != nil {
+		return
+	}
+
+	ok = affected > 0
+
+	return
+}
+
+func (m *Model) RevokeServiceAccount(id int64) (ok bool, err error) {
+	now := time.Now()
+
+	affected, err := m.db.Where("id = ?", id).And("revoke_at IS NULL").Cols("revoke_at").
+		Update(&ServiceAccount{
+			RevokeAt: &now,
+		})
+	if err != nil {
+		return
+	}
+
+	ok = affected > 0
+
+	return
+}
+
+func (m *Model) UpdateServiceAccountUsed(id int64, ip string) error {
+	now := time.Now()
+
+	_, err := m.db.Where("id = ?", id).Cols("last_used_at", "last_used_ip").Update(&ServiceAccount{
+		LastUsedAt: &now,
+		LastUsedIp: ip,
+	})
+
+	return err
+}
@@ -0,0 +1,12 @@
+package model
+
+// SyncTables creates or alters the tables which are not generated by db-orm
+func (m *Model) SyncTables() error {
+	return m.db.Sync2(
+		new(UserPasswordHistory),
+		new(UserRecoveryCode),
+		new(UserWebAuthnCredential),
+		new(ServiceAccount),
+		new(PersonalAccessToken),
+	)
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"time"
+)
+
+// UserWebAuthnCredential is a passkey or security key of a user, SignCount is the last counter it signed
+// nolint: revive, stylecheck
+type UserWebAuthnCredential struct {
+	Id           int64      `xorm:"pk autoincr BIGINT(20)"`
+	UserId       int64      `xorm:"not null index BIGINT(20)"`
+	Name         string     `xorm:"not null VARCHAR(64)"`
+	CredentialId []byte     `xorm:"not null unique VARBINARY(255)"`
+	PublicKey    []byte     `xorm:"not null BLOB"`
+	SignCount    uint32     `xorm:"not null INT(10)"`
+	Aaguid       []byte     `xorm:"VARBINARY(16)"`
+	CreateAt     time.Time  `xorm:"not null DATETIME"`
+	LastUsedAt   *time.Time `xorm:"DATETIME"`
+}
+
+func (*UserWebAuthnCredential) TableName() string {
+	return "user_webauthn_credential"
+}
+
+func (m *Model) AddUserWebAuthnCredential(credential *UserWebAuthnCredential) error {
+	_, err := m.db.Insert(credential)
+
+	return err
+}
+
+func (m *Model) GetUserWebAuthnCredentials(userID int64) (credentials []*UserWebAuthnCredential, err error) {
+	err = m.db.Where("user_id = ?", userID).Asc("id").Find(&credentials)
+
+	return
+}
+
+func (m *Model) CountUserWebAuthnCredentials(userID int64) (int64, error) {
+	return m.db.Where("user_id = ?", userID).Count(new(UserWebAuthnCredential))
+}
+
+func (m *Model) GetWebAuthnCredential(credentialID []byte) (credential *UserWebAuthnCredential, err error) {
+	credential = &UserWebAuthnCredential{}
+
+	exists, err := m.db.Where("credential_id = ?", credentialID).Get(credential)
+	if err != nil {
+		return
+	}
+
+	if !exists {
+		credential = nil
+	}
+
+	return
+}
+
+// UpdateWebAuthnCredentialUsed stores the new sign count, ok is false if signCount is not increased,
+// which means another login with the same assertion raced
+func (m *Model) UpdateWebAuthnCredentialUsed(id int64, oldSignCount, signCount uint32) (ok bool, err error) {
+	now := time.Now()
+
+	affected, err := m.db.Where("id = ?", id).And("sign_count = ?", oldSignCount).
+		Cols("sign_count", "last_used_at").Update(&UserWebAuthnCredential{
+		SignCount:  signCount,
+		LastUsedAt: &now,
+	})
+	if err != nil {
+		return
+	}
+
+	ok = affected > 0
+
+	return
+}
+
+func (m *Model) DeleteUserWebAuthnCredential(userID, id int64) (ok bool, err error) {
+	affected, err := m.db.Delete(&UserWebAuthnCredential{Id: id, UserId: userID})
+	if err != nil {
+		return
+	}
+
+	ok = affected > 0
+
+	return
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sbasestarter/user/internal/user/controller/oidc"
+	"github.com/sbasestarter/user/pkg/user"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// OIDCHandler serves the OpenID Connect provider over http
+func (us *UserServer) OIDCHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(oidc.PathDiscovery, allowCORS(us.oidcDiscovery))
+	mux.HandleFunc(oidc.PathJWKS, allowCORS(us.oidcJWKS))
+	mux.HandleFunc(oidc.PathAuthorize, us.oidcAuthorize)
+	mux.HandleFunc(oidc.PathToken, allowCORS(us.oidcToken))
+	mux.HandleFunc(oidc.PathUserInfo, allowCORS(us.oidcUserInfo))
+
+	return mux
+}
+
+// allowCORS lets browser clients call the endpoints which don't rely on cookies
+func allowCORS(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// oidcContext passes the client of r to the controller as grpc-gateway does
+func oidcContext(r *http.Request) context.Context {
+	md := metadata.Pairs("user-agent", r.UserAgent())
+
+	for _, key := range []string{"X-Forwarded-For", "X-Real-Ip"} {
+		if value := r.Header.Get(key); value != "" {
+			md.Set(key, value)
+		}
+	}
+
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+
+	return ctx
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeOIDCError tells an oidc.Error as is, and hides the other errors
+func writeOIDCError(w http.ResponseWriter, statusCode int, err error) {
+	var oidcErr *oidc.Error
+	if !errors.As(err, &oidcErr) {
+		oidcErr = oidc.NewError(oidc.ErrServerError, "")
+		statusCode = http.StatusInternalServerError
+	}
+
+	writeJSON(w, statusCode, oidcErr)
+}
+
+func (us *UserServer) oidcDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, us.controller.OIDCDiscovery())
+}
+
+func (us *UserServer) oidcJWKS(w http.ResponseWriter, _ *http.Request) {
+	jwks, err := us.controller.OIDCJWKS()
+	if err != nil {
+		writeOIDCError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(jwks))
+}
+
+func (us *UserServer) oidcAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, oidc.ErrInvalidRequest, http.StatusBadRequest)
+
+		return
+	}
+
+	var token string
+	if cookie, err := r.Cookie(user.SignCookieName); err == nil {
+		token = cookie.Value
+	}
+
+	redirectURL, err := us.controller.OIDCAuthorize(oidcContext(r), token, r.Form)
+	if err != nil {
+		// the redirect uri can't be trusted, so the user agent stays here
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+func (us *UserServer) oidcToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOIDCError(w, http.StatusBadRequest, oidc.NewError(oidc.ErrInvalidRequest, ""))
+
+		return
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		// client_secret_basic form encodes the id and the secret, RFC 6749 section 2.3.1
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	resp, err := us.controller.OIDCToken(oidcContext(r), clientID, clientSecret, r.PostForm)
+	if err != nil {
+		statusCode := http.StatusBadRequest
+
+		var oidcErr *oidc.Error
+		if errors.As(err, &oidcErr) && oidcErr.Code == oidc.ErrInvalidClient {
+			statusCode = http.StatusUnauthorized
+
+			if basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+			}
+		}
+
+		writeOIDCError(w, statusCode, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (us *UserServer) oidcUserInfo(w http.ResponseWriter, r *http.Request) {
+	const bearerPrefix = "Bearer "
+
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) <= len(bearerPrefix) || !strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo"`)
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	claims, err := us.controller.OIDCUserInfo(oidcContext(r), authorization[len(bearerPrefix):])
+	if err != nil {
+		var oidcErr *oidc.Error
+		if errors.As(err, &oidcErr) && oidcErr.Code == oidc.ErrInvalidToken {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeOIDCError(w, http.StatusUnauthorized, err)
+
+			return
+		}
+
+		writeOIDCError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, claims)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/sbasestarter/user/internal/user/controller/saml"
+	"github.com/sbasestarter/user/pkg/user"
+)
+
+// SAMLHandler serves the SAML identity provider over http
+func (us *UserServer) SAMLHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(saml.PathMetadata, us.samlMetadata)
+	mux.HandleFunc(saml.PathSSO, us.samlSSO)
+
+	return mux
+}
+
+func (us *UserServer) samlMetadata(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, _ = w.Write(us.controller.SAMLMetadata())
+}
+
+// samlSSO takes the authn request by the HTTP-Redirect binding on GET, and by the HTTP-POST binding on POST
+func (us *UserServer) samlSSO(w http.ResponseWriter, r *http.Request) {
+	var req *saml.AuthnRequest
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		req, err = saml.DecodeRedirectRequest(r.Form.Get("SAMLRequest"))
+	case http.MethodPost:
+		req, err = saml.DecodePostRequest(r.PostForm.Get("SAMLRequest"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var token string
+	if cookie, errC := r.Cookie(user.SignCookieName); errC == nil {
+		token = cookie.Value
+	}
+
+	form, redirectURL, err := us.controller.SAMLSSO(oidcContext(r), token, req, r.Form.Get("RelayState"))
+	if err != nil {
+		// the acs url can't be trusted, so the user agent stays here
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if redirectURL != "" {
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(form)
+}
@@ -57,19 +57,26 @@ func (us *UserServer) makeStatusWithMsg(status userpb.UserStatus, msg string) *u
 	}
 }
 
-func (us *UserServer) makeSignResponseNoSSOToken(status userpb.UserStatus, token string, info *userpb.UserInfo,
+func (us *UserServer) makeSignResponseNoSSOToken(status userpb.UserStatus, token *controller.SignedTokens, info *userpb.UserInfo,
 	err error) *userpb.SignResponse {
 	return us.makeSignResponse(status, token, info, "", err)
 }
 
-func (us *UserServer) makeSignResponse(status userpb.UserStatus, token string, info *userpb.UserInfo, ssoToken string,
-	err error) *userpb.SignResponse {
-	return &userpb.SignResponse{
+func (us *UserServer) makeSignResponse(status userpb.UserStatus, token *controller.SignedTokens, info *userpb.UserInfo,
+	ssoToken string, err error) *userpb.SignResponse {
+	resp := &userpb.SignResponse{
 		Status:   us.makeStatus(status, err),
-		Token:    token,
 		Info:     info,
 		SsoToken: ssoToken,
 	}
+
+	if token != nil {
+		resp.Token = token.AccessToken
+		resp.RefreshToken = token.RefreshToken
+		resp.ExpiresAt = token.AccessExpiresAt
+	}
+
+	return resp
 }
 
 func (us *UserServer) TriggerAuth(ctx context.Context, req *userpb.TriggerAuthRequest) (*userpb.TriggerAuthResponse, error) {
@@ -85,7 +92,13 @@ func (us *UserServer) Register(ctx context.Context, req *userpb.RegisterRequest)
 
 func (us *UserServer) Login(ctx context.Context, req *userpb.LoginRequest) (*userpb.SignResponse, error) {
 	return us.makeSignResponse(us.controller.Login(ctx, req.User, req.Password, req.CodeForVe, req.CodeForGa,
-		req.AttachSsoToken, req.SsoJumpUrl)), nil
+		req.WebauthnAssertion, req.AttachSsoToken, req.SsoJumpUrl)), nil
+}
+
+func (us *UserServer) RefreshToken(ctx context.Context, req *userpb.RefreshTokenRequest) (*userpb.SignResponse, error) {
+	status, token, err := us.controller.RefreshToken(ctx, req.RefreshToken)
+
+	return us.makeSignResponseNoSSOToken(status, token, nil, err), nil
 }
 
 func (us *UserServer) SSOLogin(ctx context.Context, req *userpb.SSOLoginRequest) (*userpb.SignResponse, error) {
@@ -119,18 +132,93 @@ func (us *UserServer) GoogleAuthVerify(ctx context.Context, req *userpb.GoogleAu
 }
 
 func (us *UserServer) GoogleAuthSet(ctx context.Context, req *userpb.GoogleAuthSetRequest) (*userpb.GoogleAuthSetResponse, error) {
+	status, recoveryCodes, err := us.controller.GoogleAuthSet(ctx, req.Token, req.Code, req.TokenGaOld)
+
 	return &userpb.GoogleAuthSetResponse{
-		Status: us.makeStatus(us.controller.GoogleAuthSet(ctx, req.Token, req.Code, req.TokenGaOld)),
+		Status:        us.makeStatus(status, err),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (us *UserServer) GoogleAuthRegenerateRecoveryCodes(ctx context.Context,
+	req *userpb.GoogleAuthRegenerateRecoveryCodesRequest) (*userpb.GoogleAuthRegenerateRecoveryCodesResponse, error) {
+	status, recoveryCodes, err := us.controller.GoogleAuthRegenerateRecoveryCodes(ctx, req.Token, req.CsrfToken, req.CodeForGa)
+
+	return &userpb.GoogleAuthRegenerateRecoveryCodesResponse{
+		Status:        us.makeStatus(status, err),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (us *UserServer) GoogleAuthGetRecoveryCodesCount(ctx context.Context,
+	req *userpb.GoogleAuthGetRecoveryCodesCountRequest) (*userpb.GoogleAuthGetRecoveryCodesCountResponse, error) {
+	status, remain, err := us.controller.GoogleAuthGetRecoveryCodesCount(ctx, req.Token)
+
+	return &userpb.GoogleAuthGetRecoveryCodesCountResponse{
+		Status: us.makeStatus(status, err),
+		Remain: remain,
+	}, nil
+}
+
+func (us *UserServer) WebAuthnBeginRegistration(ctx context.Context,
+	req *userpb.WebAuthnBeginRegistrationRequest) (*userpb.WebAuthnBeginRegistrationResponse, error) {
+	status, options, err := us.controller.WebAuthnBeginRegistration(ctx, req.Token)
+
+	return &userpb.WebAuthnBeginRegistrationResponse{
+		Status:  us.makeStatus(status, err),
+		Options: options,
+	}, nil
+}
+
+func (us *UserServer) WebAuthnFinishRegistration(ctx context.Context,
+	req *userpb.WebAuthnFinishRegistrationRequest) (*userpb.WebAuthnFinishRegistrationResponse, error) {
+	status, id, err := us.controller.WebAuthnFinishRegistration(ctx, req.Token, req.CsrfToken, req.Password,
+		req.CodeForGa, req.Name, req.Response)
+
+	return &userpb.WebAuthnFinishRegistrationResponse{
+		Status: us.makeStatus(status, err),
+		Id:     id,
+	}, nil
+}
+
+func (us *UserServer) WebAuthnListCredentials(ctx context.Context,
+	req *userpb.WebAuthnListCredentialsRequest) (*userpb.WebAuthnListCredentialsResponse, error) {
+	status, credentials, err := us.controller.WebAuthnListCredentials(ctx, req.Token)
+
+	return &userpb.WebAuthnListCredentialsResponse{
+		Status:      us.makeStatus(status, err),
+		Credentials: credentials,
+	}, nil
+}
+
+func (us *UserServer) WebAuthnDeleteCredential(ctx context.Context,
+	req *userpb.WebAuthnDeleteCredentialRequest) (*userpb.WebAuthnDeleteCredentialResponse, error) {
+	status, err := us.controller.WebAuthnDeleteCredential(ctx, req.Token, req.CsrfToken, req.Password, req.CodeForGa,
+		req.Id)
+
+	return &userpb.WebAuthnDeleteCredentialResponse{
+		Status: us.makeStatus(status, err),
+	}, nil
+}
+
+func (us *UserServer) WebAuthnBeginLogin(ctx context.Context,
+	req *userpb.WebAuthnBeginLoginRequest) (*userpb.WebAuthnBeginLoginResponse, error) {
+	status, options, err := us.controller.WebAuthnBeginLogin(ctx, req.User)
+
+	return &userpb.WebAuthnBeginLoginResponse{
+		Status:  us.makeStatus(status, err),
+		Options: options,
 	}, nil
 }
 
 func (us *UserServer) Profile(ctx context.Context, req *userpb.ProfileRequest) (*userpb.ProfileResponse, error) {
-	status, userInfo, ssoToken, err := us.controller.Profile(ctx, req.Token, req.AttachSsoToken, req.SsoJumpUrl)
+	status, userInfo, ssoToken, expiry, err := us.controller.Profile(ctx, req.Token, req.AttachSsoToken, req.SsoJumpUrl)
 
 	return &userpb.ProfileResponse{
-		Status:   us.makeStatus(status, err),
-		Info:     userInfo,
-		SsoToken: ssoToken,
+		Status:        us.makeStatus(status, err),
+		Info:          userInfo,
+		SsoToken:      ssoToken,
+		SessionExpiry: expiry,
 	}, nil
 }
 
@@ -154,6 +242,66 @@ func (us *UserServer) GetCsrfToken(ctx context.Context, req *userpb.GetCsrfToken
 	}, nil
 }
 
+func (us *UserServer) ListPersonalAccessTokens(ctx context.Context,
+	req *userpb.ListPersonalAccessTokensRequest) (*userpb.ListPersonalAccessTokensResponse, error) {
+	status, tokens, err := us.controller.ListPersonalAccessTokens(ctx, req.Token)
+
+	return &userpb.ListPersonalAccessTokensResponse{
+		Status: us.makeStatus(status, err),
+		Tokens: tokens,
+	}, nil
+}
+
+func (us *UserServer) CreatePersonalAccessToken(ctx context.Context,
+	req *userpb.CreatePersonalAccessTokenRequest) (*userpb.CreatePersonalAccessTokenResponse, error) {
+	status, pat, key, err := us.controller.CreatePersonalAccessToken(ctx, req.Token, req.CsrfToken, req.Name,
+		req.Scopes, req.ExpireAt)
+
+	return &userpb.CreatePersonalAccessTokenResponse{
+		Status:              us.makeStatus(status, err),
+		Info:                pat,
+		PersonalAccessToken: key,
+	}, nil
+}
+
+func (us *UserServer) RevokePersonalAccessToken(ctx context.Context,
+	req *userpb.RevokePersonalAccessTokenRequest) (*userpb.RevokePersonalAccessTokenResponse, error) {
+	status, err := us.controller.RevokePersonalAccessToken(ctx, req.Token, req.CsrfToken, req.Id)
+
+	return &userpb.RevokePersonalAccessTokenResponse{
+		Status: us.makeStatus(status, err),
+	}, nil
+}
+
+func (us *UserServer) ListIdentities(ctx context.Context,
+	req *userpb.ListIdentitiesRequest) (*userpb.ListIdentitiesResponse, error) {
+	status, identities, err := us.controller.ListIdentities(ctx, req.Token)
+
+	return &userpb.ListIdentitiesResponse{
+		Status:     us.makeStatus(status, err),
+		Identities: identities,
+	}, nil
+}
+
+func (us *UserServer) LinkIdentity(ctx context.Context,
+	req *userpb.LinkIdentityRequest) (*userpb.LinkIdentityResponse, error) {
+	status, err := us.controller.LinkIdentity(ctx, req.Token, req.CsrfToken, req.Identity, req.CodeForVe,
+		req.Password, req.CodeForGa)
+
+	return &userpb.LinkIdentityResponse{
+		Status: us.makeStatus(status, err),
+	}, nil
+}
+
+func (us *UserServer) UnlinkIdentity(ctx context.Context,
+	req *userpb.UnlinkIdentityRequest) (*userpb.UnlinkIdentityResponse, error) {
+	status, err := us.controller.UnlinkIdentity(ctx, req.Token, req.CsrfToken, req.Identity)
+
+	return &userpb.UnlinkIdentityResponse{
+		Status: us.makeStatus(status, err),
+	}, nil
+}
+
 func (us *UserServer) GetDetailInfo(ctx context.Context, req *userpb.GetDetailInfoRequest) (*userpb.GetDetailInfoResponse, error) {
 	status, userInfo, err := us.controller.GetDetailInfo(ctx, req.Token)
 
@@ -180,13 +328,75 @@ func (us *UserServer) GetUserList(ctx context.Context, req *userpb.GetUserListRe
 }
 
 func (us *UserServer) ManagerUser(ctx context.Context, req *userpb.ManagerUserRequest) (*userpb.ManagerUserResponse, error) {
-	status, err := us.controller.ManagerUser(ctx, req)
+	status, sessions, err := us.controller.ManagerUser(ctx, req)
 
 	return &userpb.ManagerUserResponse{
+		Status:   us.makeStatus(status, err),
+		Sessions: sessions,
+	}, nil
+}
+
+func (us *UserServer) ListSessions(ctx context.Context, req *userpb.ListSessionsRequest) (*userpb.ListSessionsResponse, error) {
+	status, sessions, err := us.controller.ListSessions(ctx, req.Token)
+
+	return &userpb.ListSessionsResponse{
+		Status:   us.makeStatus(status, err),
+		Sessions: sessions,
+	}, nil
+}
+
+func (us *UserServer) RevokeSession(ctx context.Context, req *userpb.RevokeSessionRequest) (*userpb.RevokeSessionResponse, error) {
+	status, err := us.controller.RevokeSession(ctx, req.Token, req.CsrfToken, req.SessionId)
+
+	return &userpb.RevokeSessionResponse{
 		Status: us.makeStatus(status, err),
 	}, nil
 }
 
+func (us *UserServer) RevokeOtherSessions(ctx context.Context,
+	req *userpb.RevokeOtherSessionsRequest) (*userpb.RevokeOtherSessionsResponse, error) {
+	status, revoked, err := us.controller.RevokeOtherSessions(ctx, req.Token, req.CsrfToken)
+
+	return &userpb.RevokeOtherSessionsResponse{
+		Status:  us.makeStatus(status, err),
+		Revoked: revoked,
+	}, nil
+}
+
+func (us *UserServer) IntrospectToken(ctx context.Context,
+	req *userpb.IntrospectTokenRequest) (*userpb.IntrospectTokenResponse, error) {
+	tokens := req.Tokens
+	if req.Token != "" {
+		tokens = append([]string{req.Token}, tokens...)
+	}
+
+	status, results, err := us.controller.IntrospectTokens(ctx, req.CallerToken, tokens)
+
+	return &userpb.IntrospectTokenResponse{
+		Status:  us.makeStatus(status, err),
+		Results: results,
+	}, nil
+}
+
+func (us *UserServer) GetJWKS(ctx context.Context, _ *userpb.GetJWKSRequest) (*userpb.GetJWKSResponse, error) {
+	status, jwks, err := us.controller.GetJWKS(ctx)
+
+	return &userpb.GetJWKSResponse{
+		Status: us.makeStatus(status, err),
+		Jwks:   jwks,
+	}, nil
+}
+
+func (us *UserServer) GetRevokedSessions(ctx context.Context,
+	_ *userpb.GetRevokedSessionsRequest) (*userpb.GetRevokedSessionsResponse, error) {
+	status, sessions, err := us.controller.GetRevokedSessions(ctx)
+
+	return &userpb.GetRevokedSessionsResponse{
+		Status:   us.makeStatus(status, err),
+		Sessions: sessions,
+	}, nil
+}
+
 func (us *UserServer) AdminProfile(ctx context.Context, req *userpb.AdminProfileRequest) (*userpb.AdminProfileResponse, error) {
 	status, userInfo, err := us.controller.AdminProfile(ctx, req.Token)
 
@@ -195,3 +405,44 @@ func (us *UserServer) AdminProfile(ctx context.Context, req *userpb.AdminProfile
 		Info:   userInfo,
 	}, nil
 }
+
+func (us *UserServer) ListServiceAccounts(ctx context.Context,
+	req *userpb.ListServiceAccountsRequest) (*userpb.ListServiceAccountsResponse, error) {
+	status, accounts, err := us.controller.ListServiceAccounts(ctx, req.Token, req.CsrfToken)
+
+	return &userpb.ListServiceAccountsResponse{
+		Status:   us.makeStatus(status, err),
+		Accounts: accounts,
+	}, nil
+}
+
+func (us *UserServer) CreateServiceAccount(ctx context.Context,
+	req *userpb.CreateServiceAccountRequest) (*userpb.CreateServiceAccountResponse, error) {
+	status, account, apiKey, err := us.controller.CreateServiceAccount(ctx, req.Token, req.CsrfToken, req.Name,
+		req.OwnerUserId, req.Scopes, req.ExpireAt)
+
+	return &userpb.CreateServiceAccountResponse{
+		Status:  us.makeStatus(status, err),
+		Account: account,
+		ApiKey:  apiKey,
+	}, nil
+}
+
+func (us *UserServer) RotateServiceAccountKey(ctx context.Context,
+	req *userpb.RotateServiceAccountKeyRequest) (*userpb.RotateServiceAccountKeyResponse, error) {
+	status, apiKey, err := us.controller.RotateServiceAccountKey(ctx, req.Token, req.CsrfToken, req.Id)
+
+	return &userpb.RotateServiceAccountKeyResponse{
+		Status: us.makeStatus(status, err),
+		ApiKey: apiKey,
+	}, nil
+}
+
+func (us *UserServer) RevokeServiceAccount(ctx context.Context,
+	req *userpb.RevokeServiceAccountRequest) (*userpb.RevokeServiceAccountResponse, error) {
+	status, err := us.controller.RevokeServiceAccount(ctx, req.Token, req.CsrfToken, req.Id)
+
+	return &userpb.RevokeServiceAccountResponse{
+		Status: us.makeStatus(status, err),
+	}, nil
+}
@@ -1,5 +1,6 @@
 package user
 
 const (
-	SignCookieName = "token"
+	SignCookieName    = "token"
+	RefreshCookieName = "refresh_token"
 )